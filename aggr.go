@@ -4,49 +4,48 @@ import (
 	"strings"
 )
 
-var aggrFuncs = map[string]bool{
-	"any":            true,
-	"avg":            true,
-	"bottomk":        true,
-	"bottomk_avg":    true,
-	"bottomk_max":    true,
-	"bottomk_median": true,
-	"bottomk_last":   true,
-	"bottomk_min":    true,
-	"count":          true,
-	"count_values":   true,
-	"distinct":       true,
-	"geomean":        true,
-	"group":          true,
-	"histogram":      true,
-	"limitk":         true,
-	"mad":            true,
-	"max":            true,
-	"median":         true,
-	"min":            true,
-	"mode":           true,
-	"outliers_mad":   true,
-	"outliersk":      true,
-	"quantile":       true,
-	"quantiles":      true,
-	"share":          true,
-	"stddev":         true,
-	"stdvar":         true,
-	"sum":            true,
-	"sum2":           true,
-	"topk":           true,
-	"topk_avg":       true,
-	"topk_max":       true,
-	"topk_median":    true,
-	"topk_last":      true,
-	"topk_min":       true,
-	"zscore":         true,
-}
+var aggrFuncs = setFuncKind(FuncKindAggr, []*FuncSignature{
+	{Name: "any", MinArgs: 1, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector}, Description: "returns a single series per group"},
+	{Name: "avg", MinArgs: 1, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns the average value per group"},
+	{Name: "bottomk", MinArgs: 2, MaxArgs: 3, ArgKinds: []ArgKind{ArgKindScalar, ArgKindInstantVector, ArgKindLabelName}, KeepMetricNames: true, PromQL: true, Description: "returns up to k points with the smallest values across all the series"},
+	{Name: "bottomk_avg", MinArgs: 2, MaxArgs: 3, ArgKinds: []ArgKind{ArgKindScalar, ArgKindInstantVector, ArgKindLabelName}, KeepMetricNames: true, Description: "returns up to k series with the smallest averages"},
+	{Name: "bottomk_max", MinArgs: 2, MaxArgs: 3, ArgKinds: []ArgKind{ArgKindScalar, ArgKindInstantVector, ArgKindLabelName}, KeepMetricNames: true, Description: "returns up to k series with the smallest maximums"},
+	{Name: "bottomk_median", MinArgs: 2, MaxArgs: 3, ArgKinds: []ArgKind{ArgKindScalar, ArgKindInstantVector, ArgKindLabelName}, KeepMetricNames: true, Description: "returns up to k series with the smallest medians"},
+	{Name: "bottomk_last", MinArgs: 2, MaxArgs: 3, ArgKinds: []ArgKind{ArgKindScalar, ArgKindInstantVector, ArgKindLabelName}, KeepMetricNames: true, Description: "returns up to k series with the smallest last values"},
+	{Name: "bottomk_min", MinArgs: 2, MaxArgs: 3, ArgKinds: []ArgKind{ArgKindScalar, ArgKindInstantVector, ArgKindLabelName}, KeepMetricNames: true, Description: "returns up to k series with the smallest minimums"},
+	{Name: "count", MinArgs: 1, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns the number of non-empty points per group"},
+	{Name: "count_values", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindLabelName, ArgKindInstantVector}, ChangesLabels: true, PromQL: true, Description: "counts the number of points with the same value and stores the value in the given label"},
+	{Name: "distinct", MinArgs: 1, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector}, Description: "returns the number of unique values per group"},
+	{Name: "geomean", MinArgs: 1, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector}, Description: "returns the geometric mean per group"},
+	{Name: "group", MinArgs: 1, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns 1 per group"},
+	{Name: "histogram", MinArgs: 1, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector}, Description: "calculates VictoriaMetrics histogram per group"},
+	{Name: "limitk", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindScalar, ArgKindInstantVector}, KeepMetricNames: true, Description: "returns up to k series per group"},
+	{Name: "mad", MinArgs: 1, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector}, Description: "returns median absolute deviation per group"},
+	{Name: "max", MinArgs: 1, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns the maximum value per group"},
+	{Name: "median", MinArgs: 1, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector}, Description: "returns the median value per group"},
+	{Name: "min", MinArgs: 1, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns the minimum value per group"},
+	{Name: "mode", MinArgs: 1, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector}, Description: "returns the most frequently occurring value per group"},
+	{Name: "outliers_mad", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindScalar, ArgKindInstantVector}, KeepMetricNames: true, Description: "returns series with points deviating from the median by more than the given tolerance multiplied by mad"},
+	{Name: "outliersk", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindScalar, ArgKindInstantVector}, KeepMetricNames: true, Description: "returns up to k series with the biggest standard deviation from the median"},
+	{Name: "quantile", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindScalar, ArgKindInstantVector}, PromQL: true, Description: "returns the phi-quantile per group"},
+	{Name: "quantiles", MinArgs: 3, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindLabelName, ArgKindScalar, ArgKindInstantVector}, VariadicArgIdx: 1, Description: "returns the given phi-quantiles per group with the phi stored in the given label"},
+	{Name: "share", MinArgs: 1, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector}, Description: "returns shares in the range [0..1] for every non-negative point per group"},
+	{Name: "stddev", MinArgs: 1, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns standard deviation per group"},
+	{Name: "stdvar", MinArgs: 1, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns standard variance per group"},
+	{Name: "sum", MinArgs: 1, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns the sum per group"},
+	{Name: "sum2", MinArgs: 1, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector}, Description: "returns the sum of squares per group"},
+	{Name: "topk", MinArgs: 2, MaxArgs: 3, ArgKinds: []ArgKind{ArgKindScalar, ArgKindInstantVector, ArgKindLabelName}, KeepMetricNames: true, PromQL: true, Description: "returns up to k points with the biggest values across all the series"},
+	{Name: "topk_avg", MinArgs: 2, MaxArgs: 3, ArgKinds: []ArgKind{ArgKindScalar, ArgKindInstantVector, ArgKindLabelName}, KeepMetricNames: true, Description: "returns up to k series with the biggest averages"},
+	{Name: "topk_max", MinArgs: 2, MaxArgs: 3, ArgKinds: []ArgKind{ArgKindScalar, ArgKindInstantVector, ArgKindLabelName}, KeepMetricNames: true, Description: "returns up to k series with the biggest maximums"},
+	{Name: "topk_median", MinArgs: 2, MaxArgs: 3, ArgKinds: []ArgKind{ArgKindScalar, ArgKindInstantVector, ArgKindLabelName}, KeepMetricNames: true, Description: "returns up to k series with the biggest medians"},
+	{Name: "topk_last", MinArgs: 2, MaxArgs: 3, ArgKinds: []ArgKind{ArgKindScalar, ArgKindInstantVector, ArgKindLabelName}, KeepMetricNames: true, Description: "returns up to k series with the biggest last values"},
+	{Name: "topk_min", MinArgs: 2, MaxArgs: 3, ArgKinds: []ArgKind{ArgKindScalar, ArgKindInstantVector, ArgKindLabelName}, KeepMetricNames: true, Description: "returns up to k series with the biggest minimums"},
+	{Name: "zscore", MinArgs: 1, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector}, KeepMetricNames: true, Description: "returns z-score for every point per group"},
+})
 
 // IsAggrFunc returns whether funcName is a known aggregate function.
 func IsAggrFunc(s string) bool {
-	s = strings.ToLower(s)
-	return aggrFuncs[s]
+	return isFuncKind(s, FuncKindAggr)
 }
 
 func isAggrFuncModifier(s string) bool {
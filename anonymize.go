@@ -0,0 +1,441 @@
+package metricsql
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+)
+
+// AnonymizeOptions contains options for Anonymize.
+type AnonymizeOptions struct {
+	// Hash instructs using stable hashed tokens such as `metric_3f9a6c1de0b2a4f7` instead of sequential tokens such as `metric_1`.
+	//
+	// Hashed tokens don't depend on the order of queries, so the same names and values are mapped
+	// to the same tokens across independent runs with the same Salt.
+	Hash bool
+
+	// Salt is used as HMAC-SHA256 key for hashed tokens if Hash is set.
+	//
+	// Set Salt to a secret value in order to prevent from recovering the original names and values by brute force.
+	// Hashed tokens with empty Salt can be recovered by hashing candidate names and values.
+	Salt string
+}
+
+// Anonymize replaces metric names, label names and label values in e with tokens.
+//
+// See Anonymizer for details. Use Anonymizer for consistent anonymization across a batch of queries.
+func Anonymize(e Expr, opts *AnonymizeOptions) Expr {
+	return NewAnonymizer(opts).Anonymize(e)
+}
+
+// Anonymizer replaces metric names, label names and label values with tokens.
+//
+// Metric names are replaced with `metric_N` tokens, label names are replaced with `label_N` tokens,
+// while label values are replaced with `value_N` tokens. The same name or value is always replaced
+// with the same token by the given Anonymizer, so the anonymized queries keep the relations between their parts.
+//
+// The following parts are anonymized:
+//
+//   - label filters in series selectors. Literal fragments of regexp filters are replaced with tokens,
+//     while the regexp structure is preserved, e.g. `{job=~"api-.+|web"}` becomes `{label_1=~"value_1.+|value_2"}`
+//   - label names in modifiers such as `by (...)`, `on (...)` and `group_left (...)`
+//   - label names in string args of functions such as count_values(), sort_by_label() and topk()
+//   - label values, regexps and replacements in string args of label_* functions
+//
+// The `__name__` label name and empty label values are left as is, since they have special meaning.
+// Values for `__name__` label in label_* functions are replaced with the same `metric_N` tokens as metric names.
+//
+// Anonymizer cannot be used from concurrent goroutines.
+type Anonymizer struct {
+	hash bool
+	salt string
+
+	metrics *tokenMap
+	labels  *tokenMap
+	values  *tokenMap
+}
+
+// NewAnonymizer returns new Anonymizer for the given opts.
+//
+// opts may be nil.
+func NewAnonymizer(opts *AnonymizeOptions) *Anonymizer {
+	a := &Anonymizer{}
+	if opts != nil {
+		a.hash = opts.Hash
+		a.salt = opts.Salt
+	}
+	a.metrics = a.newTokenMap("metric")
+	a.labels = a.newTokenMap("label")
+	a.values = a.newTokenMap("value")
+	return a
+}
+
+// Anonymize returns anonymized copy of e.
+//
+// e isn't modified.
+func (a *Anonymizer) Anonymize(e Expr) Expr {
+	eCopy := Clone(e)
+	return Apply(eCopy, func(c *Cursor) bool {
+		switch t := c.Node().(type) {
+		case *LabelFilter:
+			a.anonymizeLabelFilter(t)
+		case *ModifierExpr:
+			for i, arg := range t.Args {
+				if arg != "*" && arg != "__name__" {
+					t.Args[i] = a.labels.get(arg)
+				}
+			}
+		case *FuncExpr:
+			a.anonymizeFuncArgs(t.Name, t.Args)
+		case *AggrFuncExpr:
+			a.anonymizeFuncArgs(t.Name, t.Args)
+		}
+		return true
+	}, nil)
+}
+
+func (a *Anonymizer) anonymizeLabelFilter(lf *LabelFilter) {
+	values := a.values
+	if lf.Label == "__name__" {
+		values = a.metrics
+	} else {
+		lf.Label = a.labels.get(lf.Label)
+	}
+	if lf.IsRegexp {
+		lf.Value = a.anonymizeRegexp(lf.Value, values)
+	} else {
+		lf.Value = values.get(lf.Value)
+	}
+}
+
+// anonymizeFuncArgs anonymizes string args of the function with the given name.
+//
+// Args with ArgKindLabelName kind are anonymized for all the functions, while label_* functions
+// have additional args with label values, regexps and replacements.
+func (a *Anonymizer) anonymizeFuncArgs(name string, args []Expr) {
+	funcName := strings.ToLower(name)
+	fs := getFuncSignature(funcName)
+	if fs == nil {
+		return
+	}
+	// Collect the original string args before anonymizing them, since value args depend on the paired label names.
+	strArgs := make([]string, len(args))
+	for i, arg := range args {
+		if se, ok := arg.(*StringExpr); ok {
+			strArgs[i] = se.S
+		}
+	}
+	for i, arg := range args {
+		se, ok := arg.(*StringExpr)
+		if !ok {
+			continue
+		}
+		values := a.values
+		if j := getLabelFuncValueLabelIdx(funcName, i); j > 0 && j < len(strArgs) && strArgs[j] == "__name__" {
+			values = a.metrics
+		}
+		switch getLabelFuncArgKind(funcName, fs, i, len(args)) {
+		case labelFuncArgName:
+			se.S = a.anonymizeLabelName(se.S)
+		case labelFuncArgValue:
+			se.S = values.get(se.S)
+		case labelFuncArgRegexp:
+			se.S = a.anonymizeRegexp(se.S, values)
+		case labelFuncArgReplacement:
+			se.S = a.anonymizeReplacement(se.S, values)
+		}
+	}
+}
+
+// anonymizeLabelName returns the token for the label name s.
+//
+// `__name__` is left as is. The `label=value` form used by topk_* functions for the "other" series
+// is anonymized as the label name and the label value.
+func (a *Anonymizer) anonymizeLabelName(s string) string {
+	if s == "__name__" {
+		return s
+	}
+	if n := strings.IndexByte(s, '='); n >= 0 {
+		return a.labels.get(s[:n]) + "=" + a.values.get(s[n+1:])
+	}
+	return a.labels.get(s)
+}
+
+// getLabelFuncValueLabelIdx returns the index of the label name arg for the value arg at index i of label_* function with the given funcName.
+//
+// -1 is returned if the arg at index i isn't related to a single label.
+func getLabelFuncValueLabelIdx(funcName string, i int) int {
+	switch funcName {
+	case "label_set":
+		// label_set(q, "name1", "value1", ..., "nameN", "valueN")
+		if i > 1 && i%2 == 0 {
+			return i - 1
+		}
+	case "label_replace":
+		// label_replace(q, "dst", "replacement", "src", "regex")
+		switch i {
+		case 2:
+			return 1
+		case 4:
+			return 3
+		}
+	case "label_map", "label_transform", "label_match", "label_mismatch":
+		// label_map(q, "label", "src1", "dst1", ..., "srcN", "dstN")
+		if i > 1 {
+			return 1
+		}
+	}
+	return -1
+}
+
+type labelFuncArgKind int
+
+const (
+	labelFuncArgOther labelFuncArgKind = iota
+	labelFuncArgName
+	labelFuncArgValue
+	labelFuncArgRegexp
+	labelFuncArgReplacement
+)
+
+// getLabelFuncArgKind returns the kind of the arg at index i for the function with the given funcName and fs.
+func getLabelFuncArgKind(funcName string, fs *FuncSignature, i, argsLen int) labelFuncArgKind {
+	switch funcName {
+	case "label_set":
+		// label_set(q, "name1", "value1", ..., "nameN", "valueN")
+		if i%2 == 1 {
+			return labelFuncArgName
+		}
+		return labelFuncArgValue
+	case "label_map":
+		// label_map(q, "label", "src1", "dst1", ..., "srcN", "dstN")
+		if i == 1 {
+			return labelFuncArgName
+		}
+		return labelFuncArgValue
+	case "label_replace":
+		// label_replace(q, "dst", "replacement", "src", "regex")
+		switch i {
+		case 2:
+			return labelFuncArgReplacement
+		case 4:
+			return labelFuncArgRegexp
+		}
+	case "label_transform":
+		// label_transform(q, "label", "regex", "replacement")
+		switch i {
+		case 2:
+			return labelFuncArgRegexp
+		case 3:
+			return labelFuncArgReplacement
+		}
+	case "label_match", "label_mismatch":
+		// label_match(q, "label", "regex")
+		if i == 2 {
+			return labelFuncArgRegexp
+		}
+	}
+	if k, _ := fs.ArgKind(i, argsLen); k == ArgKindLabelName {
+		return labelFuncArgName
+	}
+	return labelFuncArgOther
+}
+
+// anonymizeRegexp replaces literal fragments in the regexp re with tokens from tm.
+//
+// Top-level alternatives consisting only of literal chars are replaced with the same tokens as the equal label values,
+// so `{job=~"api|web"}` and `{job="api"}` refer to the same token for `api`.
+func (a *Anonymizer) anonymizeRegexp(re string, tm *tokenMap) string {
+	if _, err := regexp.Compile(re); err != nil {
+		// Invalid regexps cannot be anonymized while preserving their structure.
+		return tm.get(re)
+	}
+	alts := splitRegexpAlternatives(re)
+	for i, alt := range alts {
+		alts[i] = a.anonymizeRegexpAlternative(alt, tm)
+	}
+	return strings.Join(alts, "|")
+}
+
+func (a *Anonymizer) anonymizeRegexpAlternative(re string, tm *tokenMap) string {
+	if re == "" {
+		return ""
+	}
+	if s := regexp.QuoteMeta(re); s == re {
+		// Fast path - the alternative contains only literal chars.
+		return tm.get(re)
+	}
+	sre, err := syntax.Parse(re, syntax.Perl)
+	if err != nil {
+		return tm.get(re)
+	}
+	if sre.Op == syntax.OpLiteral && sre.Flags&syntax.FoldCase == 0 {
+		return tm.get(string(sre.Rune))
+	}
+	anonymizeRegexpLiterals(sre, tm)
+	return removeDefaultFlagGroups(sre.String())
+}
+
+// removeDefaultFlagGroups removes `(?-s:...)` groups from re, which are added by syntax.Regexp.String() around `.`.
+//
+// These groups are no-op, since `.` doesn't match `\n` by default.
+func removeDefaultFlagGroups(re string) string {
+	const prefix = "(?-s:"
+	for {
+		n := strings.Index(re, prefix)
+		if n < 0 {
+			return re
+		}
+		end := findClosingParen(re, n+len(prefix))
+		if end < 0 {
+			return re
+		}
+		re = re[:n] + re[n+len(prefix):end] + re[end+1:]
+	}
+}
+
+// findClosingParen returns the index of `)` closing the group, which starts at re[:start].
+//
+// -1 is returned if the closing paren cannot be found.
+func findClosingParen(re string, start int) int {
+	depth := 0
+	inClass := false
+	for i := start; i < len(re); i++ {
+		switch re[i] {
+		case '\\':
+			i++
+		case '[':
+			inClass = true
+		case ']':
+			inClass = false
+		case '(':
+			if !inClass {
+				depth++
+			}
+		case ')':
+			if inClass {
+				continue
+			}
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+func anonymizeRegexpLiterals(sre *syntax.Regexp, tm *tokenMap) {
+	if sre.Op == syntax.OpLiteral {
+		sre.Rune = []rune(tm.get(string(sre.Rune)))
+		return
+	}
+	for _, sub := range sre.Sub {
+		anonymizeRegexpLiterals(sub, tm)
+	}
+}
+
+// splitRegexpAlternatives splits re into top-level alternatives delimited by `|`.
+func splitRegexpAlternatives(re string) []string {
+	var alts []string
+	depth := 0
+	inClass := false
+	start := 0
+	for i := 0; i < len(re); i++ {
+		switch re[i] {
+		case '\\':
+			i++
+		case '[':
+			inClass = true
+		case ']':
+			inClass = false
+		case '(':
+			if !inClass {
+				depth++
+			}
+		case ')':
+			if !inClass {
+				depth--
+			}
+		case '|':
+			if !inClass && depth == 0 {
+				alts = append(alts, re[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(alts, re[start:])
+}
+
+var replacementRefRegexp = regexp.MustCompile(`\$(\d+|\{[^}]*\}|[a-zA-Z_][a-zA-Z_0-9]*)`)
+
+// anonymizeReplacement replaces literal fragments in the replacement template s with tokens from tm.
+//
+// References to capturing groups such as `$1` or `${name}` are left as is.
+func (a *Anonymizer) anonymizeReplacement(s string, tm *tokenMap) string {
+	var b strings.Builder
+	idxs := replacementRefRegexp.FindAllStringIndex(s, -1)
+	start := 0
+	for _, idx := range idxs {
+		if idx[0] > start {
+			b.WriteString(tm.get(s[start:idx[0]]))
+		}
+		// Wrap the reference into braces, so it isn't merged with the following token.
+		ref := s[idx[0]+1 : idx[1]]
+		if !strings.HasPrefix(ref, "{") {
+			ref = "{" + ref + "}"
+		}
+		b.WriteString("$" + ref)
+		start = idx[1]
+	}
+	if start < len(s) {
+		b.WriteString(tm.get(s[start:]))
+	}
+	return b.String()
+}
+
+// tokenMap maps the original strings to tokens.
+type tokenMap struct {
+	prefix string
+	hash   bool
+	salt   string
+	m      map[string]string
+}
+
+func (a *Anonymizer) newTokenMap(prefix string) *tokenMap {
+	return &tokenMap{
+		prefix: prefix,
+		hash:   a.hash,
+		salt:   a.salt,
+		m:      make(map[string]string),
+	}
+}
+
+// get returns the token for s.
+//
+// Empty s is returned as is.
+func (tm *tokenMap) get(s string) string {
+	if s == "" {
+		return ""
+	}
+	if token, ok := tm.m[s]; ok {
+		return token
+	}
+	var token string
+	if tm.hash {
+		h := hmac.New(sha256.New, []byte(tm.salt))
+		_, _ = h.Write([]byte(tm.prefix))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(s))
+		// Truncate the hash to 64 bits in order to keep tokens short.
+		token = tm.prefix + "_" + hex.EncodeToString(h.Sum(nil)[:8])
+	} else {
+		token = tm.prefix + "_" + strconv.Itoa(len(tm.m)+1)
+	}
+	tm.m[s] = token
+	return token
+}
@@ -0,0 +1,141 @@
+package metricsql
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestAnonymize(t *testing.T) {
+	f := func(s, resultExpected string) {
+		t.Helper()
+
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %s: %s", s, err)
+		}
+		sOrig := string(e.AppendString(nil))
+		result := string(Anonymize(e, nil).AppendString(nil))
+		if result != resultExpected {
+			t.Fatalf("unexpected result for Anonymize(%s);\ngot\n%s\nwant\n%s", s, result, resultExpected)
+		}
+		if s := string(e.AppendString(nil)); s != sOrig {
+			t.Fatalf("the original expression has been changed;\ngot\n%s\nwant\n%s", s, sOrig)
+		}
+		// The anonymized query must remain valid.
+		if _, err := Parse(result); err != nil {
+			t.Fatalf("cannot parse the anonymized query %s: %s", result, err)
+		}
+	}
+
+	// series selectors
+	f(`foo`, `metric_1`)
+	f(`foo{job="api",instance!="host1"}`, `metric_1{label_1="value_1",label_2!="value_2"}`)
+	f(`foo{job="api"} / bar{job="api",env=""}`, `metric_1{label_1="value_1"} / metric_2{label_1="value_1",label_2=""}`)
+	f(`{job="api" or env="prod"}`, `{label_1="value_1" or label_2="value_2"}`)
+	f(`{__name__=~"foo|bar_.+"}`, `{__name__=~"metric_1|metric_2.+"}`)
+	f(`foo + foo{job="foo"}`, `metric_1 + metric_1{label_1="value_1"}`)
+
+	// regexp filters
+	f(`foo{job=~"api|web"} + foo{job="web"}`, `metric_1{label_1=~"value_1|value_2"} + metric_1{label_1="value_2"}`)
+	f(`foo{job=~"api-.+",host!~".*"}`, `metric_1{label_1=~"value_1.+",label_2!~".*"}`)
+	f(`foo{job=~"(?i)api"}`, `metric_1{label_1=~"(?i:value_1)"}`)
+	f(`foo{job=~"(api|web)[0-9]+"}`, `metric_1{label_1=~"(value_1|value_2)[0-9]+"}`)
+	f(`foo{job=~"a\\.b|c"}`, `metric_1{label_1=~"value_1|value_2"}`)
+
+	// modifiers
+	f(`sum(foo) by (job, instance)`, `sum(metric_1) by(label_1,label_2)`)
+	f(`foo / on(job) group_left(env) bar{job="x"}`, `metric_1 / on(label_1) group_left(label_2) metric_2{label_1="value_1"}`)
+	f(`foo * on() group_left(*) bar`, `metric_1 * on() group_left(*) metric_2`)
+	f(`sum(foo) by (__name__, job)`, `sum(metric_1) by(__name__,label_1)`)
+	f(`foo / ignoring(__name__) bar`, `metric_1 / ignoring(__name__) metric_2`)
+
+	// label_* functions
+	f(`label_set(foo, "env", "prod", "job", "api")`, `label_set(metric_1, "label_1", "value_1", "label_2", "value_2")`)
+	f(`label_del(foo{job="x"}, "job", "env")`, `label_del(metric_1{label_1="value_1"}, "label_1", "label_2")`)
+	f(`label_replace(foo, "dst", "prefix-$1", "src", "(.+)-suffix")`, `label_replace(metric_1, "label_1", "value_1${1}", "label_2", "(.+)value_2")`)
+	f(`label_transform(foo, "job", "a+", "b")`, `label_transform(metric_1, "label_1", "(?:value_1)+", "value_2")`)
+	f(`label_join(foo, "dst", "-", "a", "b")`, `label_join(metric_1, "label_1", "-", "label_2", "label_3")`)
+	f(`label_map(foo, "job", "a", "b")`, `label_map(metric_1, "label_1", "value_1", "value_2")`)
+	f(`label_match(foo, "job", "api|web")`, `label_match(metric_1, "label_1", "value_1|value_2")`)
+
+	// values for __name__ label in label_* functions are replaced with metric tokens
+	f(`label_set(foo, "__name__", "bar") + bar`, `label_set(metric_2, "__name__", "metric_1") + metric_1`)
+	f(`label_set(foo, "job", "bar", "__name__", "foo")`, `label_set(metric_1, "label_1", "value_1", "__name__", "metric_1")`)
+	f(`label_replace(foo, "__name__", "bar", "job", "(.+)")`, `label_replace(metric_2, "__name__", "metric_1", "label_1", "(.+)")`)
+	f(`label_replace(foo, "job", "$1", "__name__", "foo_(.+)")`, `label_replace(metric_2, "label_1", "${1}", "__name__", "metric_1(.+)")`)
+	f(`label_del(foo, "__name__")`, `label_del(metric_1, "__name__")`)
+
+	// label names in args of other functions
+	f(`count_values("customer", foo)`, `count_values("label_1", metric_1)`)
+	f(`sort_by_label(foo{customer="x"}, "customer")`, `sort_by_label(metric_1{label_1="value_1"}, "label_1")`)
+	f(`topk(3, foo, "customer")`, `topk(3, metric_1, "label_1")`)
+	f(`topk_avg(3, foo, "customer=other")`, `topk_avg(3, metric_1, "label_1=value_1")`)
+	f(`histogram_quantile(0.9, foo, "customer")`, `histogram_quantile(0.9, metric_1, "label_1")`)
+	f(`quantiles("phi", 0.5, 0.9, foo)`, `quantiles("label_1", 0.5, 0.9, metric_1)`)
+
+	// other strings and numbers are left as is
+	f(`rate(foo[5m]) > 10`, `rate(metric_1[5m]) > 10`)
+	f(`aggr_over_time("min", foo[5m])`, `aggr_over_time("min", metric_1[5m])`)
+}
+
+func TestAnonymizerBatch(t *testing.T) {
+	a := NewAnonymizer(nil)
+	f := func(s, resultExpected string) {
+		t.Helper()
+
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %s: %s", s, err)
+		}
+		result := string(a.Anonymize(e).AppendString(nil))
+		if result != resultExpected {
+			t.Fatalf("unexpected result for Anonymize(%s);\ngot\n%s\nwant\n%s", s, result, resultExpected)
+		}
+	}
+
+	f(`foo{job="api"}`, `metric_1{label_1="value_1"}`)
+	f(`bar{job="web"}`, `metric_2{label_1="value_2"}`)
+	f(`sum(foo{job="web"}) by (job)`, `sum(metric_1{label_1="value_2"}) by(label_1)`)
+}
+
+func TestAnonymizeHash(t *testing.T) {
+	f := func(s string, opts *AnonymizeOptions) string {
+		t.Helper()
+
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %s: %s", s, err)
+		}
+		return string(Anonymize(e, opts).AppendString(nil))
+	}
+
+	opts := &AnonymizeOptions{
+		Hash: true,
+	}
+	result := f(`foo{job="api"}`, opts)
+	re := regexp.MustCompile(`^metric_[0-9a-f]{16}\{label_[0-9a-f]{16}="value_[0-9a-f]{16}"\}$`)
+	if !re.MatchString(result) {
+		t.Fatalf("unexpected result: %s", result)
+	}
+
+	// Hashed tokens don't depend on the order of anonymized names.
+	r1 := f(`foo + bar`, opts)
+	r2 := f(`bar + foo`, opts)
+	e1, _ := Parse(r1)
+	e2, _ := Parse(r2)
+	if r1 == r2 {
+		t.Fatalf("expecting different results; got %s", r1)
+	}
+	if l1, r2 := string(e1.(*BinaryOpExpr).Left.AppendString(nil)), string(e2.(*BinaryOpExpr).Right.AppendString(nil)); l1 != r2 {
+		t.Fatalf("expecting identical tokens for foo; got %s and %s", l1, r2)
+	}
+
+	// Different salts produce different tokens.
+	r3 := f(`foo + bar`, &AnonymizeOptions{
+		Hash: true,
+		Salt: "secret",
+	})
+	if r3 == r1 {
+		t.Fatalf("expecting different tokens for different salts; got %s", r3)
+	}
+}
@@ -0,0 +1,320 @@
+package metricsql
+
+import (
+	"fmt"
+)
+
+// ApplyFunc is called by Apply for every node in the traversed expression.
+//
+// See Apply for details.
+type ApplyFunc func(c *Cursor) bool
+
+// Apply traverses e recursively and calls pre and post for every node in e.
+//
+// pre is called for the node before its children are traversed, while post is called after that.
+// pre and post may be nil. If pre returns false, then children and post are skipped for the current node.
+// If post returns false, then the traversal is stopped.
+//
+// Unlike VisitAll, Apply descends into all the nodes of e, including RollupExpr durations, RollupExpr.At,
+// modifiers, BinaryOpExpr.JoinModifierPrefix and label filters. Optional fields such as RollupExpr.At are skipped when they are nil.
+// Modifiers are passed as *ModifierExpr and label filters are passed as *LabelFilter.
+//
+// The current node may be replaced or deleted via Cursor methods. The replaced node is traversed instead of the original node,
+// while the deleted node isn't traversed further.
+//
+// Apply modifies e in place and returns the resulting root node, which may differ from e if it has been replaced.
+// Use Clone if the original expression must be left unchanged.
+func Apply(e Expr, pre, post ApplyFunc) Expr {
+	a := &applier{
+		pre:  pre,
+		post: post,
+	}
+	c := &Cursor{
+		index:      -1,
+		groupIndex: -1,
+		node:       e,
+	}
+	c.replace = func(e Expr) Expr {
+		return e
+	}
+	a.apply(c)
+	return c.node
+}
+
+// Rewrite calls f for every node in e after its children are traversed and replaces the node with the result of f.
+//
+// f must return the node itself if it mustn't be replaced.
+//
+// Rewrite modifies e in place and returns the resulting root node. See Apply for details.
+func Rewrite(e Expr, f func(e Expr) Expr) Expr {
+	return Apply(e, nil, func(c *Cursor) bool {
+		if eNew := f(c.Node()); eNew != c.Node() {
+			c.Replace(eNew)
+		}
+		return true
+	})
+}
+
+// Cursor describes the node passed to ApplyFunc.
+//
+// Cursor is valid only during the ApplyFunc call.
+type Cursor struct {
+	parent     Expr
+	name       string
+	index      int
+	groupIndex int
+
+	node    Expr
+	deleted bool
+
+	// replace must set e in the parent and return the node stored in the parent.
+	replace func(e Expr) Expr
+	delete  func()
+}
+
+// Node returns the current node.
+func (c *Cursor) Node() Expr {
+	return c.node
+}
+
+// Parent returns the parent of the current node.
+//
+// nil is returned for the root node.
+func (c *Cursor) Parent() Expr {
+	return c.parent
+}
+
+// Name returns the name of the parent field containing the current node, e.g. "Args", "Left" or "LabelFilterss".
+//
+// An empty string is returned for the root node.
+func (c *Cursor) Name() string {
+	return c.name
+}
+
+// Index returns the index of the current node in the parent field if the field is a slice such as FuncExpr.Args.
+//
+// For label filters the index inside the or-delimited group of filters is returned. See GroupIndex.
+//
+// -1 is returned if the parent field isn't a slice.
+func (c *Cursor) Index() int {
+	return c.index
+}
+
+// GroupIndex returns the index of the or-delimited group in MetricExpr.LabelFilterss for the current label filter.
+//
+// -1 is returned if the current node isn't a label filter.
+func (c *Cursor) GroupIndex() int {
+	return c.groupIndex
+}
+
+// Replace replaces the current node with e.
+//
+// e must have the type suitable for the parent field. For example, RollupExpr.Window accepts only *DurationExpr,
+// while label filters accept only *LabelFilter. Replace panics if e has unsuitable type.
+func (c *Cursor) Replace(e Expr) {
+	if c.deleted {
+		panic(fmt.Errorf("BUG: cannot replace the deleted node"))
+	}
+	c.node = c.replace(e)
+}
+
+// Delete deletes the current node from the parent.
+//
+// Only nodes from slices such as FuncExpr.Args or MetricExpr.LabelFilterss and optional fields
+// such as RollupExpr.Offset, RollupExpr.At or BinaryOpExpr.JoinModifierPrefix may be deleted.
+// Deleted modifiers are reset to empty modifiers. Delete panics for other nodes.
+//
+// The `or` group of label filters is deleted from MetricExpr.LabelFilterss after all its filters are deleted,
+// since the empty group matches all the series.
+func (c *Cursor) Delete() {
+	if c.delete == nil {
+		panic(fmt.Errorf("BUG: cannot delete the node from %q field of %T", c.name, c.parent))
+	}
+	if c.deleted {
+		return
+	}
+	c.delete()
+	c.deleted = true
+}
+
+type applier struct {
+	pre  ApplyFunc
+	post ApplyFunc
+
+	// stopped is set to true when post returns false.
+	stopped bool
+}
+
+// apply traverses the node at c.
+func (a *applier) apply(c *Cursor) {
+	if a.stopped || c.node == nil {
+		return
+	}
+	if a.pre != nil && !a.pre(c) {
+		return
+	}
+	if c.deleted {
+		return
+	}
+	a.applyChildren(c.node)
+	if a.stopped || c.deleted {
+		return
+	}
+	if a.post != nil && !a.post(c) {
+		a.stopped = true
+	}
+}
+
+func (a *applier) applyChildren(e Expr) {
+	switch t := e.(type) {
+	case *MetricExpr:
+		a.applyLabelFilterss(t)
+	case *RollupExpr:
+		a.applyField(t, "Expr", t.Expr, func(e Expr) Expr {
+			t.Expr = e
+			return e
+		}, nil)
+		a.applyDuration(t, "Window", &t.Window)
+		a.applyDuration(t, "Step", &t.Step)
+		a.applyDuration(t, "Offset", &t.Offset)
+		a.applyField(t, "At", t.At, func(e Expr) Expr {
+			t.At = e
+			return e
+		}, func() {
+			t.At = nil
+		})
+	case *FuncExpr:
+		a.applyExprs(t, "Args", &t.Args)
+	case *AggrFuncExpr:
+		a.applyExprs(t, "Args", &t.Args)
+		a.applyModifier(t, "Modifier", &t.Modifier)
+	case *BinaryOpExpr:
+		a.applyField(t, "Left", t.Left, func(e Expr) Expr {
+			t.Left = e
+			return e
+		}, nil)
+		a.applyModifier(t, "GroupModifier", &t.GroupModifier)
+		a.applyModifier(t, "JoinModifier", &t.JoinModifier)
+		if t.JoinModifierPrefix != nil {
+			a.applyField(t, "JoinModifierPrefix", t.JoinModifierPrefix, func(e Expr) Expr {
+				se, ok := e.(*StringExpr)
+				if !ok {
+					panic(fmt.Errorf("BUG: JoinModifierPrefix field of %T cannot be replaced with %T; want *StringExpr", t, e))
+				}
+				t.JoinModifierPrefix = se
+				return se
+			}, func() {
+				t.JoinModifierPrefix = nil
+			})
+		}
+		a.applyField(t, "Right", t.Right, func(e Expr) Expr {
+			t.Right = e
+			return e
+		}, nil)
+	case *parensExpr:
+		a.applyExprs(t, "Args", &t.Args)
+	}
+}
+
+func (a *applier) applyField(parent Expr, name string, e Expr, replace func(e Expr) Expr, del func()) {
+	a.apply(&Cursor{
+		parent:     parent,
+		name:       name,
+		index:      -1,
+		groupIndex: -1,
+		node:       e,
+		replace:    replace,
+		delete:     del,
+	})
+}
+
+func (a *applier) applyDuration(parent Expr, name string, pde **DurationExpr) {
+	if *pde == nil {
+		return
+	}
+	a.applyField(parent, name, *pde, func(e Expr) Expr {
+		de, ok := e.(*DurationExpr)
+		if !ok {
+			panic(fmt.Errorf("BUG: %s field of %T cannot be replaced with %T; want *DurationExpr", name, parent, e))
+		}
+		*pde = de
+		return de
+	}, func() {
+		*pde = nil
+	})
+}
+
+func (a *applier) applyModifier(parent Expr, name string, me *ModifierExpr) {
+	a.applyField(parent, name, me, func(e Expr) Expr {
+		meNew, ok := e.(*ModifierExpr)
+		if !ok {
+			panic(fmt.Errorf("BUG: %s field of %T cannot be replaced with %T; want *ModifierExpr", name, parent, e))
+		}
+		*me = *meNew
+		return me
+	}, func() {
+		*me = ModifierExpr{}
+	})
+}
+
+func (a *applier) applyExprs(parent Expr, name string, args *[]Expr) {
+	for i := 0; i < len(*args) && !a.stopped; {
+		idx := i
+		c := &Cursor{
+			parent:     parent,
+			name:       name,
+			index:      idx,
+			groupIndex: -1,
+			node:       (*args)[idx],
+			replace: func(e Expr) Expr {
+				(*args)[idx] = e
+				return e
+			},
+			delete: func() {
+				*args = append((*args)[:idx], (*args)[idx+1:]...)
+			},
+		}
+		a.apply(c)
+		if !c.deleted {
+			i++
+		}
+	}
+}
+
+func (a *applier) applyLabelFilterss(me *MetricExpr) {
+	for i := 0; i < len(me.LabelFilterss) && !a.stopped; {
+		lfs := &me.LabelFilterss[i]
+		hadFilters := len(*lfs) > 0
+		for j := 0; j < len(*lfs) && !a.stopped; {
+			idx := j
+			c := &Cursor{
+				parent:     me,
+				name:       "LabelFilterss",
+				index:      idx,
+				groupIndex: i,
+				node:       &(*lfs)[idx],
+				replace: func(e Expr) Expr {
+					lf, ok := e.(*LabelFilter)
+					if !ok {
+						panic(fmt.Errorf("BUG: LabelFilterss field of %T cannot be replaced with %T; want *LabelFilter", me, e))
+					}
+					(*lfs)[idx] = *lf
+					return &(*lfs)[idx]
+				},
+				delete: func() {
+					*lfs = append((*lfs)[:idx], (*lfs)[idx+1:]...)
+				},
+			}
+			a.apply(c)
+			if !c.deleted {
+				j++
+			}
+		}
+		if hadFilters && len(*lfs) == 0 {
+			// The empty `or` group matches all the series, so it must be deleted together with its last filter.
+			me.LabelFilterss = append(me.LabelFilterss[:i], me.LabelFilterss[i+1:]...)
+			continue
+		}
+		i++
+	}
+}
@@ -0,0 +1,359 @@
+package metricsql
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyOrder(t *testing.T) {
+	f := func(s string, preExpected, postExpected []string) {
+		t.Helper()
+
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %s: %s", s, err)
+		}
+		var pre, post []string
+		Apply(e, func(c *Cursor) bool {
+			pre = append(pre, cursorString(c))
+			return true
+		}, func(c *Cursor) bool {
+			post = append(post, cursorString(c))
+			return true
+		})
+		if !reflect.DeepEqual(pre, preExpected) {
+			t.Fatalf("unexpected pre-order nodes for %s;\ngot\n%s\nwant\n%s", s, strings.Join(pre, "\n"), strings.Join(preExpected, "\n"))
+		}
+		if !reflect.DeepEqual(post, postExpected) {
+			t.Fatalf("unexpected post-order nodes for %s;\ngot\n%s\nwant\n%s", s, strings.Join(post, "\n"), strings.Join(postExpected, "\n"))
+		}
+	}
+
+	f(`foo`, []string{
+		`nil.: foo`,
+		`*metricsql.MetricExpr.LabelFilterss[0][0]: __name__="foo"`,
+	}, []string{
+		`*metricsql.MetricExpr.LabelFilterss[0][0]: __name__="foo"`,
+		`nil.: foo`,
+	})
+	f(`{a="b" or c!~"d"}`, []string{
+		`nil.: {a="b" or c!~"d"}`,
+		`*metricsql.MetricExpr.LabelFilterss[0][0]: a="b"`,
+		`*metricsql.MetricExpr.LabelFilterss[1][0]: c!~"d"`,
+	}, []string{
+		`*metricsql.MetricExpr.LabelFilterss[0][0]: a="b"`,
+		`*metricsql.MetricExpr.LabelFilterss[1][0]: c!~"d"`,
+		`nil.: {a="b" or c!~"d"}`,
+	})
+	f(`rate(x[5m:1m] offset 1h @ end())`, []string{
+		`nil.: rate(x[5m:1m] offset 1h @ end())`,
+		`*metricsql.FuncExpr.Args[0]: x[5m:1m] offset 1h @ end()`,
+		`*metricsql.RollupExpr.Expr: x`,
+		`*metricsql.MetricExpr.LabelFilterss[0][0]: __name__="x"`,
+		`*metricsql.RollupExpr.Window: 5m`,
+		`*metricsql.RollupExpr.Step: 1m`,
+		`*metricsql.RollupExpr.Offset: 1h`,
+		`*metricsql.RollupExpr.At: end()`,
+	}, []string{
+		`*metricsql.MetricExpr.LabelFilterss[0][0]: __name__="x"`,
+		`*metricsql.RollupExpr.Expr: x`,
+		`*metricsql.RollupExpr.Window: 5m`,
+		`*metricsql.RollupExpr.Step: 1m`,
+		`*metricsql.RollupExpr.Offset: 1h`,
+		`*metricsql.RollupExpr.At: end()`,
+		`*metricsql.FuncExpr.Args[0]: x[5m:1m] offset 1h @ end()`,
+		`nil.: rate(x[5m:1m] offset 1h @ end())`,
+	})
+	f(`sum(1, 2) by (x)`, []string{
+		`nil.: sum(1, 2) by(x)`,
+		`*metricsql.AggrFuncExpr.Args[0]: 1`,
+		`*metricsql.AggrFuncExpr.Args[1]: 2`,
+		`*metricsql.AggrFuncExpr.Modifier: by(x)`,
+	}, []string{
+		`*metricsql.AggrFuncExpr.Args[0]: 1`,
+		`*metricsql.AggrFuncExpr.Args[1]: 2`,
+		`*metricsql.AggrFuncExpr.Modifier: by(x)`,
+		`nil.: sum(1, 2) by(x)`,
+	})
+	f(`1 + on(x) group_left(y) prefix "p_" time()`, []string{
+		`nil.: 1 + on(x) group_left(y) prefix "p_" time()`,
+		`*metricsql.BinaryOpExpr.Left: 1`,
+		`*metricsql.BinaryOpExpr.GroupModifier: on(x)`,
+		`*metricsql.BinaryOpExpr.JoinModifier: group_left(y)`,
+		`*metricsql.BinaryOpExpr.JoinModifierPrefix: "p_"`,
+		`*metricsql.BinaryOpExpr.Right: time()`,
+	}, []string{
+		`*metricsql.BinaryOpExpr.Left: 1`,
+		`*metricsql.BinaryOpExpr.GroupModifier: on(x)`,
+		`*metricsql.BinaryOpExpr.JoinModifier: group_left(y)`,
+		`*metricsql.BinaryOpExpr.JoinModifierPrefix: "p_"`,
+		`*metricsql.BinaryOpExpr.Right: time()`,
+		`nil.: 1 + on(x) group_left(y) prefix "p_" time()`,
+	})
+}
+
+func TestApplySkipChildren(t *testing.T) {
+	e, err := Parse(`sum(rate(foo[5m])) + bar`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var visited []string
+	Apply(e, func(c *Cursor) bool {
+		visited = append(visited, string(c.Node().AppendString(nil)))
+		_, ok := c.Node().(*AggrFuncExpr)
+		return !ok
+	}, func(c *Cursor) bool {
+		if _, ok := c.Node().(*AggrFuncExpr); ok {
+			t.Fatalf("post mustn't be called for skipped nodes")
+		}
+		return true
+	})
+	visitedExpected := []string{
+		`sum(rate(foo[5m])) + bar`,
+		`sum(rate(foo[5m]))`,
+		`()`,
+		`()`,
+		`bar`,
+		`__name__="bar"`,
+	}
+	if !reflect.DeepEqual(visited, visitedExpected) {
+		t.Fatalf("unexpected visited nodes;\ngot\n%q\nwant\n%q", visited, visitedExpected)
+	}
+}
+
+func TestApplyStop(t *testing.T) {
+	e, err := Parse(`foo + bar + baz`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var visited []string
+	Apply(e, nil, func(c *Cursor) bool {
+		me, ok := c.Node().(*MetricExpr)
+		if !ok {
+			return true
+		}
+		s := string(me.AppendString(nil))
+		visited = append(visited, s)
+		return s != "bar"
+	})
+	visitedExpected := []string{"foo", "bar"}
+	if !reflect.DeepEqual(visited, visitedExpected) {
+		t.Fatalf("unexpected visited nodes; got %q; want %q", visited, visitedExpected)
+	}
+}
+
+func TestApplyReplaceDelete(t *testing.T) {
+	f := func(s string, pre, post ApplyFunc, resultExpected string) {
+		t.Helper()
+
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %s: %s", s, err)
+		}
+		e = Apply(e, pre, post)
+		result := string(e.AppendString(nil))
+		if result != resultExpected {
+			t.Fatalf("unexpected result for %s;\ngot\n%s\nwant\n%s", s, result, resultExpected)
+		}
+	}
+
+	// inject tenant filter into every series selector
+	injectTenant := func(c *Cursor) bool {
+		if me, ok := c.Node().(*MetricExpr); ok {
+			for i, lfs := range me.LabelFilterss {
+				me.LabelFilterss[i] = append(lfs, LabelFilter{
+					Label: "tenant",
+					Value: "x",
+				})
+			}
+		}
+		return true
+	}
+	f(`sum(rate(foo{a="b" or c="d"}[5m])) / bar`, injectTenant, nil, `sum(rate(foo{a="b",tenant="x" or c="d",tenant="x"}[5m])) / bar{tenant="x"}`)
+
+	// rename metrics
+	renameMetric := func(c *Cursor) bool {
+		if lf, ok := c.Node().(*LabelFilter); ok && lf.Label == "__name__" && lf.Value == "foo" {
+			c.Replace(&LabelFilter{
+				Label: "__name__",
+				Value: "foo_total",
+			})
+		}
+		return true
+	}
+	f(`rate(foo[5m]) + foo + bar`, renameMetric, nil, `(rate(foo_total[5m]) + foo_total) + bar`)
+
+	// delete label filters
+	deleteFilter := func(c *Cursor) bool {
+		if lf, ok := c.Node().(*LabelFilter); ok && lf.Label == "a" {
+			c.Delete()
+		}
+		return true
+	}
+	f(`foo{a="b",c="d",a!="e"}`, deleteFilter, nil, `foo{c="d"}`)
+
+	// delete all the filters in `or` group
+	f(`{a="b" or c="d",e="f"}`, deleteFilter, nil, `{c="d",e="f"}`)
+	f(`{c="d" or a="b",a="e" or e="f"}`, deleteFilter, nil, `{c="d" or e="f"}`)
+
+	// delete offset and @ modifiers
+	deleteModifiers := func(c *Cursor) bool {
+		if c.Name() == "Offset" || c.Name() == "At" {
+			c.Delete()
+		}
+		return true
+	}
+	f(`rate(foo[5m] offset 1h @ 123) + bar offset 5m`, deleteModifiers, nil, `rate(foo[5m]) + bar`)
+
+	// delete args
+	deleteArgs := func(c *Cursor) bool {
+		if c.Name() == "Args" && c.Index() >= 0 {
+			if ne, ok := c.Node().(*NumberExpr); ok && ne.N == 2 {
+				c.Delete()
+			}
+		}
+		return true
+	}
+	f(`union(1, 2, 2, foo, 2)`, deleteArgs, nil, `union(1, foo)`)
+
+	// delete modifiers
+	deleteGrouping := func(c *Cursor) bool {
+		if _, ok := c.Node().(*ModifierExpr); ok {
+			c.Delete()
+		}
+		return true
+	}
+	f(`sum(foo) by (x) + on(y) group_left() bar`, deleteGrouping, nil, `sum(foo) + bar`)
+
+	// replace durations
+	replaceWindow := func(c *Cursor) bool {
+		if c.Name() == "Window" {
+			c.Replace(&DurationExpr{
+				s: "1h",
+			})
+		}
+		return true
+	}
+	f(`rate(foo[5m]) + rate(bar[1m:10s])`, replaceWindow, nil, `rate(foo[1h]) + rate(bar[1h:10s])`)
+
+	// replace the root node
+	replaceRoot := func(c *Cursor) bool {
+		if c.Parent() == nil {
+			c.Replace(&FuncExpr{
+				Name: "abs",
+				Args: []Expr{c.Node()},
+			})
+		}
+		return true
+	}
+	f(`foo + bar`, nil, replaceRoot, `abs(foo + bar)`)
+
+	// replaced nodes are traversed instead of the original nodes
+	replaceAndInject := func(c *Cursor) bool {
+		if _, ok := c.Parent().(*FuncExpr); !ok {
+			return injectTenant(c)
+		}
+		if me, ok := c.Node().(*MetricExpr); ok {
+			c.Replace(&RollupExpr{
+				Expr: me,
+				Window: &DurationExpr{
+					s: "5m",
+				},
+			})
+		}
+		return true
+	}
+	f(`rate(foo)`, replaceAndInject, nil, `rate(foo{tenant="x"}[5m])`)
+}
+
+func TestApplyInvalidReplace(t *testing.T) {
+	f := func(s, name string, eNew Expr) {
+		t.Helper()
+
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %s: %s", s, err)
+		}
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatalf("expecting panic when replacing %s in %s with %T", name, s, eNew)
+			}
+		}()
+		Apply(e, func(c *Cursor) bool {
+			if c.Name() == name {
+				c.Replace(eNew)
+			}
+			return true
+		}, nil)
+	}
+
+	f(`rate(foo[5m])`, "Window", &NumberExpr{N: 1})
+	f(`foo`, "LabelFilterss", &NumberExpr{N: 1})
+	f(`sum(foo) by (x)`, "Modifier", &NumberExpr{N: 1})
+	f(`a + on(x) group_left() prefix "p" b`, "JoinModifierPrefix", &NumberExpr{N: 1})
+}
+
+func TestApplyInvalidDelete(t *testing.T) {
+	f := func(s, name string) {
+		t.Helper()
+
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %s: %s", s, err)
+		}
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatalf("expecting panic when deleting %q in %s", name, s)
+			}
+		}()
+		Apply(e, func(c *Cursor) bool {
+			if c.Name() == name {
+				c.Delete()
+			}
+			return true
+		}, nil)
+	}
+
+	f(`foo`, "")
+	f(`a + b`, "Left")
+	f(`a + b`, "Right")
+	f(`rate(foo[5m])`, "Expr")
+}
+
+func TestRewrite(t *testing.T) {
+	e, err := Parse(`sum(rate(foo[5m])) by (job) / count(bar)`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	e = Rewrite(e, func(e Expr) Expr {
+		if ae, ok := e.(*AggrFuncExpr); ok && ae.Name == "sum" {
+			return &FuncExpr{
+				Name: "abs",
+				Args: []Expr{ae},
+			}
+		}
+		return e
+	})
+	result := string(e.AppendString(nil))
+	resultExpected := `abs(sum(rate(foo[5m])) by(job)) / count(bar)`
+	if result != resultExpected {
+		t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+}
+
+func cursorString(c *Cursor) string {
+	parent := "nil"
+	if c.Parent() != nil {
+		parent = fmt.Sprintf("%T", c.Parent())
+	}
+	idx := ""
+	if c.GroupIndex() >= 0 {
+		idx = fmt.Sprintf("[%d]", c.GroupIndex())
+	}
+	if c.Index() >= 0 {
+		idx += fmt.Sprintf("[%d]", c.Index())
+	}
+	return fmt.Sprintf("%s.%s%s: %s", parent, c.Name(), idx, c.Node().AppendString(nil))
+}
@@ -0,0 +1,181 @@
+package metricsql
+
+import (
+	"hash/fnv"
+	"sort"
+	"strings"
+)
+
+// CanonicalizeOptions contains options for CanonicalizeWithOptions and FingerprintWithOptions.
+type CanonicalizeOptions struct {
+	// ReplaceLiterals instructs replacing literal values with `?` placeholders.
+	//
+	// This allows grouping queries by their shape. For example, `foo{job="a"} > 10` and `foo{job="b"} > 20`
+	// are both converted to `foo{job="?"} > ?`.
+	//
+	// The following literals are replaced: numbers, strings and label filter values except of metric names.
+	// Durations are left as is, since they usually define the query shape, e.g. `rate(foo[5m])`.
+	//
+	// The expression with placeholders cannot be parsed back, so it must be used only for grouping.
+	ReplaceLiterals bool
+}
+
+// Canonicalize returns the canonical form of e.
+//
+// Semantically identical expressions, which differ only in the following ways, have identical canonical forms:
+//
+//   - keyword case, e.g. `SUM(foo) BY (job)` and `sum(foo) by (job)`
+//   - the order of label filters, e.g. `foo{a="b",c="d"}` and `foo{c="d",a="b"}`
+//   - duplicate label filters, e.g. `foo{a="b",a="b"}` and `foo{a="b"}`
+//   - the order of or-delimited groups of label filters, e.g. `{a="b" or c="d"}` and `{c="d" or a="b"}`
+//   - the order and duplicates of labels in modifiers, e.g. `sum(foo) by (b,a)` and `sum(foo) by (a,b)`
+//   - duration spelling, e.g. `rate(foo[60s])` and `rate(foo[1m])`
+//   - number spelling, e.g. `1e3`, `0x3e8`, `1000` and `1Ki`
+//   - the order of operands for commutative operators, e.g. `a + b` and `b + a`
+//
+// Whitespace differences are eliminated by Parse. Canonicalize doesn't modify e.
+func Canonicalize(e Expr) Expr {
+	return CanonicalizeWithOptions(e, nil)
+}
+
+// CanonicalizeWithOptions returns the canonical form of e according to opts.
+//
+// See Canonicalize for details.
+func CanonicalizeWithOptions(e Expr, opts *CanonicalizeOptions) Expr {
+	replaceLiterals := opts != nil && opts.ReplaceLiterals
+	eCopy := Clone(e)
+	return Apply(eCopy, nil, func(c *Cursor) bool {
+		switch t := c.Node().(type) {
+		case *MetricExpr:
+			if replaceLiterals {
+				replaceLabelFilterValues(t)
+			}
+			canonicalizeLabelFilterss(t)
+		case *DurationExpr:
+			canonicalizeDuration(t)
+		case *FuncExpr:
+			t.Name = strings.ToLower(t.Name)
+		case *AggrFuncExpr:
+			t.Name = strings.ToLower(t.Name)
+		case *ModifierExpr:
+			canonicalizeModifier(t)
+		case *BinaryOpExpr:
+			t.Op = strings.ToLower(t.Op)
+			if isCommutativeBinaryOp(t) && string(t.Right.AppendString(nil)) < string(t.Left.AppendString(nil)) {
+				t.Left, t.Right = t.Right, t.Left
+			}
+		case *NumberExpr:
+			if replaceLiterals {
+				c.Replace(&NumberExpr{
+					s:   "?",
+					Pos: t.Pos,
+				})
+			} else {
+				// Format the number from its value.
+				t.s = ""
+			}
+		case *StringExpr:
+			if replaceLiterals {
+				t.S = "?"
+			}
+		}
+		return true
+	})
+}
+
+// Fingerprint returns a hash of the canonical form of e.
+//
+// Semantically identical expressions have identical fingerprints. See Canonicalize for details.
+func Fingerprint(e Expr) uint64 {
+	return FingerprintWithOptions(e, nil)
+}
+
+// FingerprintWithOptions returns a hash of the canonical form of e according to opts.
+//
+// See Canonicalize and CanonicalizeOptions for details.
+func FingerprintWithOptions(e Expr, opts *CanonicalizeOptions) uint64 {
+	ec := CanonicalizeWithOptions(e, opts)
+	h := fnv.New64a()
+	_, _ = h.Write(ec.AppendString(nil))
+	return h.Sum64()
+}
+
+func canonicalizeLabelFilterss(me *MetricExpr) {
+	lfss := me.LabelFilterss
+	for i, lfs := range lfss {
+		lfs = removeDuplicateLabelFilters(lfs)
+		sortLabelFilters(lfs)
+		lfss[i] = lfs
+	}
+	sort.Slice(lfss, func(i, j int) bool {
+		return string(appendLabelFilters(nil, lfss[i])) < string(appendLabelFilters(nil, lfss[j]))
+	})
+	// Remove duplicate groups.
+	dst := lfss[:0]
+	var prev string
+	for i, lfs := range lfss {
+		s := string(appendLabelFilters(nil, lfs))
+		if i > 0 && s == prev {
+			continue
+		}
+		prev = s
+		dst = append(dst, lfs)
+	}
+	me.LabelFilterss = dst
+}
+
+func replaceLabelFilterValues(me *MetricExpr) {
+	for _, lfs := range me.LabelFilterss {
+		for i := range lfs {
+			if !lfs[i].isMetricNameFilter() {
+				lfs[i].Value = "?"
+			}
+		}
+	}
+}
+
+func canonicalizeDuration(de *DurationExpr) {
+	if de.needsParsing || strings.ContainsAny(de.s, "iI") {
+		// Durations relative to step cannot be converted to the canonical form without the step value.
+		return
+	}
+	d, err := DurationValue(de.s, 0)
+	if err != nil {
+		return
+	}
+	de.s = formatPromQLDuration(d)
+}
+
+func canonicalizeModifier(me *ModifierExpr) {
+	me.Op = strings.ToLower(me.Op)
+	if len(me.Args) == 0 {
+		return
+	}
+	args := append([]string{}, me.Args...)
+	sort.Strings(args)
+	dst := args[:0]
+	for i, arg := range args {
+		if i > 0 && arg == args[i-1] {
+			continue
+		}
+		dst = append(dst, arg)
+	}
+	me.Args = dst
+}
+
+// isCommutativeBinaryOp returns true if operands in be can be swapped without changing the result.
+func isCommutativeBinaryOp(be *BinaryOpExpr) bool {
+	if be.GroupModifier.Op != "" || be.JoinModifier.Op != "" || be.KeepMetricNames {
+		// The result labels may depend on the operands order.
+		return false
+	}
+	switch be.Op {
+	case "+", "*":
+		return true
+	case "==", "!=":
+		// Comparison without bool returns values from the left side.
+		return be.Bool
+	default:
+		return false
+	}
+}
@@ -0,0 +1,150 @@
+package metricsql
+
+import (
+	"testing"
+)
+
+func TestCanonicalize(t *testing.T) {
+	f := func(s, resultExpected string) {
+		t.Helper()
+
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %s: %s", s, err)
+		}
+		sOrig := string(e.AppendString(nil))
+		result := string(Canonicalize(e).AppendString(nil))
+		if result != resultExpected {
+			t.Fatalf("unexpected result for Canonicalize(%s);\ngot\n%s\nwant\n%s", s, result, resultExpected)
+		}
+		// Verify that the original expression didn't change
+		if s := string(e.AppendString(nil)); s != sOrig {
+			t.Fatalf("the original expression has been changed;\ngot\n%s\nwant\n%s", s, sOrig)
+		}
+		// Verify that the canonical form is stable
+		eResult, err := Parse(result)
+		if err != nil {
+			t.Fatalf("cannot parse the canonical form %s: %s", result, err)
+		}
+		result2 := string(Canonicalize(eResult).AppendString(nil))
+		if result2 != result {
+			t.Fatalf("unstable canonical form for %s;\ngot\n%s\nwant\n%s", s, result2, result)
+		}
+	}
+
+	// keyword case
+	f(`SUM(RATE(foo[5m])) BY (job)`, `sum(rate(foo[5m])) by(job)`)
+	f(`foo AND bar`, `foo and bar`)
+	f(`foo + IGNORING(x) GROUP_LEFT(y) bar`, `foo + ignoring(x) group_left(y) bar`)
+
+	// label filters
+	f(`foo{c="d",a="b"}`, `foo{a="b",c="d"}`)
+	f(`foo{a="b",a="b",c!~"x"}`, `foo{a="b",c!~"x"}`)
+	f(`foo{a!="b",a="b"}`, `foo{a="b",a!="b"}`)
+	f(`{c="d" or a="b"}`, `{a="b" or c="d"}`)
+	f(`{c="d" or a="b" or c="d"}`, `{a="b" or c="d"}`)
+	f(`{a="b",__name__="foo"}`, `foo{a="b"}`)
+
+	// modifiers
+	f(`sum(foo) by (b, a, b)`, `sum(foo) by(a,b)`)
+	f(`foo / on(z, y) group_left(c, b) bar`, `foo / on(y,z) group_left(b,c) bar`)
+
+	// durations
+	f(`rate(foo[60s])`, `rate(foo[1m])`)
+	f(`rate(foo[90s] offset 3600s)`, `rate(foo[1m30s] offset 1h)`)
+	f(`rate(foo[1.5h:120s] offset -7d)`, `rate(foo[1h30m:2m] offset -1w)`)
+	f(`rate(foo[300])`, `rate(foo[5m])`)
+	f(`rate(foo[5i])`, `rate(foo[5i])`)
+
+	// numbers
+	f(`foo > 1e3`, `foo > 1000`)
+	f(`foo + 0x10 + 1Ki`, `1024 + (16 + foo)`)
+	f(`foo @ 073`, `foo @ 59`)
+
+	// commutative operators
+	f(`b + a`, `a + b`)
+	f(`b * a`, `a * b`)
+	f(`b == bool a`, `a ==bool b`)
+	f(`(d + c) * (b + a)`, `(a + b) * (c + d)`)
+	f(`rate(foo[1m]) + 1`, `1 + rate(foo[1m])`)
+
+	// non-commutative operators
+	f(`b - a`, `b - a`)
+	f(`b / a`, `b / a`)
+	f(`b == a`, `b == a`)
+	f(`b or a`, `b or a`)
+	f(`b and a`, `b and a`)
+	f(`b + on(x) a`, `b + on(x) a`)
+	f(`b + ignoring(x) group_left() a`, `b + ignoring(x) group_left() a`)
+	f(`(b + a) keep_metric_names`, `(b + a) keep_metric_names`)
+}
+
+func TestCanonicalizeReplaceLiterals(t *testing.T) {
+	f := func(s, resultExpected string) {
+		t.Helper()
+
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %s: %s", s, err)
+		}
+		opts := &CanonicalizeOptions{
+			ReplaceLiterals: true,
+		}
+		result := string(CanonicalizeWithOptions(e, opts).AppendString(nil))
+		if result != resultExpected {
+			t.Fatalf("unexpected result for CanonicalizeWithOptions(%s);\ngot\n%s\nwant\n%s", s, result, resultExpected)
+		}
+	}
+
+	f(`foo{job="a"} > 10`, `foo{job="?"} > ?`)
+	f(`rate(foo{job=~"a.+"}[5m] @ 123)`, `rate(foo{job=~"?"}[5m] @ ?)`)
+	f(`label_set(foo, "a", "b")`, `label_set(foo, "?", "?")`)
+	f(`topk(5, foo) * 2`, `? * topk(?, foo)`)
+}
+
+func TestFingerprint(t *testing.T) {
+	f := func(a, b string, opts *CanonicalizeOptions, equal bool) {
+		t.Helper()
+
+		ea, err := Parse(a)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %s: %s", a, err)
+		}
+		eb, err := Parse(b)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %s: %s", b, err)
+		}
+		fpa := FingerprintWithOptions(ea, opts)
+		fpb := FingerprintWithOptions(eb, opts)
+		if (fpa == fpb) != equal {
+			t.Fatalf("unexpected fingerprints comparison for %s and %s; got %d and %d; want equal=%v", a, b, fpa, fpb, equal)
+		}
+	}
+
+	f(`foo`, `foo`, nil, true)
+	f(`sum(rate(foo{a="b",c="d"}[60s])) by (x, y)`, `SUM  BY (y,x) (RATE(foo{c="d", a="b"}[1m]))`, nil, true)
+	f(`a + b`, `b + a`, nil, true)
+	f(`a - b`, `b - a`, nil, false)
+	f(`foo{a="b"}`, `foo{a="c"}`, nil, false)
+	f(`rate(foo[5m])`, `rate(foo[1h])`, nil, false)
+	f(`foo > 1e3`, `foo > 1000`, nil, true)
+	f(`foo > 0x10`, `foo > 16`, nil, true)
+	f(`foo > 1Ki`, `foo > 1024`, nil, true)
+	f(`foo > 1Ki`, `foo > 1000`, nil, false)
+
+	opts := &CanonicalizeOptions{
+		ReplaceLiterals: true,
+	}
+	f(`foo{a="b"} > 10`, `foo{a="c"} > 20`, opts, true)
+	f(`foo{a="b"} > 10`, `bar{a="c"} > 20`, opts, false)
+	f(`rate(foo[5m])`, `rate(foo[1h])`, opts, false)
+
+	// Fingerprint must match FingerprintWithOptions with nil opts
+	e, err := Parse(`foo + bar`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if Fingerprint(e) != FingerprintWithOptions(e, nil) {
+		t.Fatalf("Fingerprint must match FingerprintWithOptions with nil opts")
+	}
+}
@@ -0,0 +1,183 @@
+package metricsql
+
+import (
+	"fmt"
+)
+
+// Clone returns a deep copy of e.
+//
+// The returned copy doesn't share any mutable state with e, so it may be modified without affecting e.
+// Unlike printing and parsing e again, Clone preserves all the fields of e, including positions
+// and the original spelling of numbers and durations.
+func Clone(e Expr) Expr {
+	if e == nil {
+		return nil
+	}
+	switch t := e.(type) {
+	case *MetricExpr:
+		return cloneMetricExpr(t)
+	case *RollupExpr:
+		return cloneRollupExpr(t)
+	case *FuncExpr:
+		return cloneFuncExpr(t)
+	case *AggrFuncExpr:
+		return cloneAggrFuncExpr(t)
+	case *BinaryOpExpr:
+		return cloneBinaryOpExpr(t)
+	case *NumberExpr:
+		neCopy := *t
+		return &neCopy
+	case *StringExpr:
+		return cloneStringExpr(t)
+	case *DurationExpr:
+		return cloneDurationExpr(t)
+	case *BadExpr:
+		badCopy := *t
+		return &badCopy
+	case *parensExpr:
+		return &parensExpr{
+			Args: cloneExprs(t.Args),
+			Pos:  t.Pos,
+		}
+	case *withExpr:
+		return cloneWithExpr(t)
+	default:
+		panic(fmt.Errorf("BUG: unexpected Expr type %T", e))
+	}
+}
+
+func cloneExprs(es []Expr) []Expr {
+	if es == nil {
+		return nil
+	}
+	esCopy := make([]Expr, len(es))
+	for i, e := range es {
+		esCopy[i] = Clone(e)
+	}
+	return esCopy
+}
+
+func cloneStrings(a []string) []string {
+	if a == nil {
+		return nil
+	}
+	return append([]string{}, a...)
+}
+
+func cloneMetricExpr(me *MetricExpr) *MetricExpr {
+	meCopy := &MetricExpr{
+		Pos: me.Pos,
+	}
+	if me.LabelFilterss != nil {
+		meCopy.LabelFilterss = make([][]LabelFilter, len(me.LabelFilterss))
+		for i, lfs := range me.LabelFilterss {
+			meCopy.LabelFilterss[i] = append([]LabelFilter(nil), lfs...)
+		}
+	}
+	if me.labelFilterss != nil {
+		meCopy.labelFilterss = make([][]*labelFilterExpr, len(me.labelFilterss))
+		for i, lfes := range me.labelFilterss {
+			lfesCopy := make([]*labelFilterExpr, len(lfes))
+			for j, lfe := range lfes {
+				lfeCopy := *lfe
+				if lfe.Value != nil {
+					lfeCopy.Value = cloneStringExpr(lfe.Value)
+				}
+				lfesCopy[j] = &lfeCopy
+			}
+			meCopy.labelFilterss[i] = lfesCopy
+		}
+	}
+	return meCopy
+}
+
+func cloneRollupExpr(re *RollupExpr) *RollupExpr {
+	return &RollupExpr{
+		Expr:        Clone(re.Expr),
+		Window:      cloneDurationExpr(re.Window),
+		Offset:      cloneDurationExpr(re.Offset),
+		Step:        cloneDurationExpr(re.Step),
+		InheritStep: re.InheritStep,
+		At:          Clone(re.At),
+		Pos:         re.Pos,
+	}
+}
+
+func cloneFuncExpr(fe *FuncExpr) *FuncExpr {
+	return &FuncExpr{
+		Name:            fe.Name,
+		Args:            cloneExprs(fe.Args),
+		KeepMetricNames: fe.KeepMetricNames,
+		Pos:             fe.Pos,
+	}
+}
+
+func cloneAggrFuncExpr(ae *AggrFuncExpr) *AggrFuncExpr {
+	return &AggrFuncExpr{
+		Name:     ae.Name,
+		Args:     cloneExprs(ae.Args),
+		Modifier: cloneModifierExpr(ae.Modifier),
+		Limit:    ae.Limit,
+		Pos:      ae.Pos,
+	}
+}
+
+func cloneBinaryOpExpr(be *BinaryOpExpr) *BinaryOpExpr {
+	beCopy := &BinaryOpExpr{
+		Op:              be.Op,
+		Bool:            be.Bool,
+		GroupModifier:   cloneModifierExpr(be.GroupModifier),
+		JoinModifier:    cloneModifierExpr(be.JoinModifier),
+		KeepMetricNames: be.KeepMetricNames,
+		Left:            Clone(be.Left),
+		Right:           Clone(be.Right),
+		Pos:             be.Pos,
+	}
+	if be.JoinModifierPrefix != nil {
+		beCopy.JoinModifierPrefix = cloneStringExpr(be.JoinModifierPrefix)
+	}
+	return beCopy
+}
+
+func cloneModifierExpr(me ModifierExpr) ModifierExpr {
+	return ModifierExpr{
+		Op:   me.Op,
+		Args: cloneStrings(me.Args),
+		Pos:  me.Pos,
+	}
+}
+
+func cloneStringExpr(se *StringExpr) *StringExpr {
+	return &StringExpr{
+		S:      se.S,
+		tokens: cloneStrings(se.tokens),
+		Pos:    se.Pos,
+	}
+}
+
+func cloneDurationExpr(de *DurationExpr) *DurationExpr {
+	if de == nil {
+		return nil
+	}
+	deCopy := *de
+	return &deCopy
+}
+
+func cloneWithExpr(we *withExpr) *withExpr {
+	weCopy := &withExpr{
+		Expr: Clone(we.Expr),
+		Pos:  we.Pos,
+	}
+	if we.Was != nil {
+		weCopy.Was = make([]*withArgExpr, len(we.Was))
+		for i, wa := range we.Was {
+			weCopy.Was[i] = &withArgExpr{
+				Name: wa.Name,
+				Args: cloneStrings(wa.Args),
+				Expr: Clone(wa.Expr),
+				Pos:  wa.Pos,
+			}
+		}
+	}
+	return weCopy
+}
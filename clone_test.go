@@ -0,0 +1,150 @@
+package metricsql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClone(t *testing.T) {
+	f := func(s string) {
+		t.Helper()
+
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %s: %s", s, err)
+		}
+		sOrig := string(e.AppendString(nil))
+		eCopy := Clone(e)
+		if !reflect.DeepEqual(eCopy, e) {
+			t.Fatalf("the cloned expression doesn't match the original expression for %s", s)
+		}
+
+		// Verify that the original expression doesn't change after modifying the copy.
+		mutateExpr(eCopy)
+		sCopy := string(eCopy.AppendString(nil))
+		if sCopy == sOrig {
+			t.Fatalf("the cloned expression must change after the modification for %s", s)
+		}
+		result := string(e.AppendString(nil))
+		if result != sOrig {
+			t.Fatalf("the original expression has been changed;\ngot\n%s\nwant\n%s", result, sOrig)
+		}
+	}
+
+	f(`foo`)
+	f(`{foo="bar",baz=~"x.+"}`)
+	f(`{foo="bar" or baz!~"x.+"}`)
+	f(`0x10 + 1.5e3`)
+	f(`"foo"`)
+	f(`rate(foo[5m:1m] offset 1h @ 123)`)
+	f(`rate(foo[5i] offset -1i @ end())`)
+	f(`foo[5m:]`)
+	f(`sum(rate(foo[5m])) by (job, instance) limit 10`)
+	f(`sum(foo) without (job)`)
+	f(`foo + on(job) group_left(instance) prefix "x_" bar`)
+	f(`foo > bool ignoring(job) bar`)
+	f(`(foo + bar) keep_metric_names`)
+	f(`abs(foo) keep_metric_names`)
+	f(`label_set(foo, "a", "b")`)
+	f(`(foo, bar)`)
+	f(`histogram_quantile(0.9, sum(rate(foo[5m])) by (le))`)
+}
+
+func TestCloneNil(t *testing.T) {
+	if e := Clone(nil); e != nil {
+		t.Fatalf("expecting nil; got %v", e)
+	}
+}
+
+func TestClonePreservesFields(t *testing.T) {
+	s := `rate(foo{bar="baz"}[5m:1m] offset 1h) + 0x10 + on(job) group_left() prefix "x_" bar`
+	e, err := Parse(s)
+	if err != nil {
+		t.Fatalf("unexpected error when parsing %s: %s", s, err)
+	}
+	eCopy := Clone(e)
+	var poss, possCopy []Pos
+	VisitAll(e, func(expr Expr) {
+		poss = append(poss, ExprPos(expr))
+	})
+	VisitAll(eCopy, func(expr Expr) {
+		possCopy = append(possCopy, ExprPos(expr))
+	})
+	if !reflect.DeepEqual(possCopy, poss) {
+		t.Fatalf("unexpected positions;\ngot\n%v\nwant\n%v", possCopy, poss)
+	}
+	result := string(eCopy.AppendString(nil))
+	resultExpected := string(e.AppendString(nil))
+	if result != resultExpected {
+		t.Fatalf("unexpected string representation;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+}
+
+func TestCloneTolerant(t *testing.T) {
+	s := `sum(foo{bar="baz"}) + )`
+	e, errs := ParseTolerant(s)
+	if len(errs) == 0 {
+		t.Fatalf("expecting non-empty errors when parsing %s", s)
+	}
+	eCopy := Clone(e)
+	if !reflect.DeepEqual(eCopy, e) {
+		t.Fatalf("the cloned expression doesn't match the original expression for %s", s)
+	}
+}
+
+// mutateExpr modifies all the fields in e, which can be shared with the original expression after Clone.
+func mutateExpr(e Expr) {
+	switch t := e.(type) {
+	case *MetricExpr:
+		for _, lfs := range t.LabelFilterss {
+			for i := range lfs {
+				lfs[i].Value += "_mutated"
+			}
+		}
+	case *RollupExpr:
+		mutateExpr(t.Expr)
+		mutateDurationExpr(t.Window)
+		mutateDurationExpr(t.Offset)
+		mutateDurationExpr(t.Step)
+		if t.At != nil {
+			mutateExpr(t.At)
+		}
+	case *FuncExpr:
+		t.Name += "_mutated"
+		for _, arg := range t.Args {
+			mutateExpr(arg)
+		}
+	case *AggrFuncExpr:
+		t.Name += "_mutated"
+		t.Limit++
+		mutateModifierExpr(&t.Modifier)
+		for _, arg := range t.Args {
+			mutateExpr(arg)
+		}
+	case *BinaryOpExpr:
+		mutateModifierExpr(&t.GroupModifier)
+		mutateModifierExpr(&t.JoinModifier)
+		if t.JoinModifierPrefix != nil {
+			mutateExpr(t.JoinModifierPrefix)
+		}
+		mutateExpr(t.Left)
+		mutateExpr(t.Right)
+	case *NumberExpr:
+		t.N++
+		t.s = ""
+	case *StringExpr:
+		t.S += "_mutated"
+	}
+}
+
+func mutateDurationExpr(de *DurationExpr) {
+	if de != nil {
+		de.s += "1s"
+	}
+}
+
+func mutateModifierExpr(me *ModifierExpr) {
+	for i := range me.Args {
+		me.Args[i] += "_mutated"
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// document is a text document opened in the editor.
+type document struct {
+	uri     string
+	version int
+	text    string
+
+	// lineStarts contains byte offsets for the start of every line in text.
+	lineStarts []int
+
+	// queries contains MetricsQL queries found in the document.
+	queries []*query
+}
+
+// query is MetricsQL query in a document.
+type query struct {
+	// s is the query text.
+	s string
+
+	// segments map byte ranges in s to byte ranges in the document text.
+	//
+	// Segments are sorted by queryOffset.
+	segments []segment
+}
+
+// segment maps s[queryOffset:queryOffset+n] to text[docOffset:docOffset+n].
+type segment struct {
+	queryOffset int
+	docOffset   int
+	n           int
+}
+
+func newDocument(uri string, version int, text string) *document {
+	d := &document{
+		uri:     uri,
+		version: version,
+		text:    text,
+	}
+	d.lineStarts = append(d.lineStarts, 0)
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			d.lineStarts = append(d.lineStarts, i+1)
+		}
+	}
+	if isRuleFile(uri) {
+		d.queries = getRuleFileQueries(text)
+	} else {
+		d.queries = []*query{{
+			s: text,
+			segments: []segment{{
+				n: len(text),
+			}},
+		}}
+	}
+	return d
+}
+
+// isRuleFile returns true if uri points to YAML file with alerting or recording rules.
+func isRuleFile(uri string) bool {
+	uri = strings.ToLower(uri)
+	return strings.HasSuffix(uri, ".yml") || strings.HasSuffix(uri, ".yaml")
+}
+
+// offsetToPosition returns LSP position for the given byte offset in d.
+func (d *document) offsetToPosition(offset int) Position {
+	if offset > len(d.text) {
+		offset = len(d.text)
+	}
+	line := sort.Search(len(d.lineStarts), func(i int) bool {
+		return d.lineStarts[i] > offset
+	}) - 1
+	start := d.lineStarts[line]
+	return Position{
+		Line:      line,
+		Character: utf16Len(d.text[start:offset]),
+	}
+}
+
+// positionToOffset returns byte offset in d for the given LSP position.
+func (d *document) positionToOffset(pos Position) int {
+	if pos.Line < 0 {
+		return 0
+	}
+	if pos.Line >= len(d.lineStarts) {
+		return len(d.text)
+	}
+	start := d.lineStarts[pos.Line]
+	end := len(d.text)
+	if pos.Line+1 < len(d.lineStarts) {
+		end = d.lineStarts[pos.Line+1] - 1
+	}
+	n := 0
+	for i, r := range d.text[start:end] {
+		if n >= pos.Character {
+			return start + i
+		}
+		n += utf16RuneLen(r)
+	}
+	return end
+}
+
+// rangeForOffsets returns LSP range for the [start:end) byte range in d.
+func (d *document) rangeForOffsets(start, end int) Range {
+	return Range{
+		Start: d.offsetToPosition(start),
+		End:   d.offsetToPosition(end),
+	}
+}
+
+// findQuery returns the query containing the given byte offset in d together with the offset in the query.
+//
+// nil is returned if there is no query at the given offset.
+func (d *document) findQuery(offset int) (*query, int) {
+	for _, q := range d.queries {
+		for _, seg := range q.segments {
+			if offset >= seg.docOffset && offset <= seg.docOffset+seg.n {
+				return q, seg.queryOffset + offset - seg.docOffset
+			}
+		}
+	}
+	return nil, 0
+}
+
+// queryRange returns LSP range in d for the [start:end) byte range in q.
+func (d *document) queryRange(q *query, start, end int) Range {
+	return d.rangeForOffsets(q.docOffset(start), q.docOffset(end))
+}
+
+// docOffset returns the byte offset in the document for the given byte offset in q.
+func (q *query) docOffset(offset int) int {
+	n := sort.Search(len(q.segments), func(i int) bool {
+		return q.segments[i].queryOffset > offset
+	}) - 1
+	if n < 0 {
+		n = 0
+	}
+	seg := q.segments[n]
+	if offset > seg.queryOffset+seg.n {
+		offset = seg.queryOffset + seg.n
+	}
+	return seg.docOffset + offset - seg.queryOffset
+}
+
+// exprLineRe matches `expr:` field in rule files.
+var exprLineRe = regexp.MustCompile(`^(\s*(?:-\s+)?)expr:[ \t]*(.*?)\s*$`)
+
+// getRuleFileQueries returns queries from `expr:` fields in YAML rule file text.
+//
+// The following YAML scalars are supported: plain, single-quoted, double-quoted, literal block (`|`),
+// folded block (`>`) and plain multi-line scalars. Escape sequences in quoted scalars aren't supported.
+func getRuleFileQueries(text string) []*query {
+	lines := strings.SplitAfter(text, "\n")
+	var qs []*query
+	lineStart := 0
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		offset := lineStart
+		lineStart += len(line)
+		m := exprLineRe.FindStringSubmatchIndex(strings.TrimSuffix(line, "\n"))
+		if m == nil {
+			continue
+		}
+		keyIndent := m[3] - m[2]
+		value := line[m[4]:m[5]]
+		valueOffset := offset + m[4]
+		switch {
+		case value == "" || value[0] == '|' || value[0] == '>':
+			// Block scalar or plain multi-line scalar at the following lines.
+			q := &query{}
+			blockIndent := -1
+			for i+1 < len(lines) {
+				next := lines[i+1]
+				if next == "" {
+					// The end of text
+					break
+				}
+				content := strings.TrimRight(next, "\r\n")
+				indent := len(content) - len(strings.TrimLeft(content, " \t"))
+				if strings.TrimSpace(content) != "" {
+					if indent <= keyIndent {
+						break
+					}
+					if blockIndent < 0 {
+						blockIndent = indent
+					}
+				}
+				i++
+				nextOffset := lineStart
+				lineStart += len(next)
+				if blockIndent < 0 || len(content) < blockIndent {
+					// Empty line
+					q.addSegment("\n", nextOffset+len(content))
+					continue
+				}
+				q.addSegment(next[blockIndent:], nextOffset+blockIndent)
+			}
+			if q.s != "" {
+				qs = append(qs, q)
+			}
+		case value[0] == '"' || value[0] == '\'':
+			n := strings.LastIndexByte(value, value[0])
+			if n <= 0 {
+				continue
+			}
+			q := &query{}
+			q.addSegment(value[1:n], valueOffset+1)
+			qs = append(qs, q)
+		default:
+			if n := strings.Index(value, " #"); n >= 0 {
+				// Strip YAML comment
+				value = strings.TrimRight(value[:n], " \t")
+			}
+			q := &query{}
+			q.addSegment(value, valueOffset)
+			qs = append(qs, q)
+		}
+	}
+	return qs
+}
+
+// addSegment appends s located at the given docOffset in the document to q.
+func (q *query) addSegment(s string, docOffset int) {
+	q.segments = append(q.segments, segment{
+		queryOffset: len(q.s),
+		docOffset:   docOffset,
+		n:           len(s),
+	})
+	q.s += s
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16RuneLen(r)
+	}
+	return n
+}
+
+// utf16RuneLen returns the number of UTF-16 code units for r.
+func utf16RuneLen(r rune) int {
+	if r >= 0x10000 {
+		// Surrogate pair
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDocumentPosition(t *testing.T) {
+	text := "foo{a=\"ж😀\"}\n\nbar"
+	d := newDocument("file:///a.metricsql", 1, text)
+
+	f := func(offset int, posExpected Position) {
+		t.Helper()
+
+		pos := d.offsetToPosition(offset)
+		if pos != posExpected {
+			t.Fatalf("unexpected position for offset %d; got %+v; want %+v", offset, pos, posExpected)
+		}
+		offsetGot := d.positionToOffset(pos)
+		if offsetGot != offset {
+			t.Fatalf("unexpected offset for position %+v; got %d; want %d", pos, offsetGot, offset)
+		}
+	}
+
+	f(0, Position{Line: 0, Character: 0})
+	f(7, Position{Line: 0, Character: 7})
+
+	// `ж` occupies 2 bytes and 1 UTF-16 code unit.
+	f(9, Position{Line: 0, Character: 8})
+
+	// `😀` occupies 4 bytes and 2 UTF-16 code units.
+	f(13, Position{Line: 0, Character: 10})
+	f(15, Position{Line: 0, Character: 12})
+	f(16, Position{Line: 1, Character: 0})
+	f(17, Position{Line: 2, Character: 0})
+	f(20, Position{Line: 2, Character: 3})
+
+	// Positions outside the document are clamped.
+	if n := d.positionToOffset(Position{Line: 0, Character: 100}); n != 15 {
+		t.Fatalf("unexpected offset for the position after the end of line; got %d; want 15", n)
+	}
+	if n := d.positionToOffset(Position{Line: 100, Character: 0}); n != len(text) {
+		t.Fatalf("unexpected offset for the position after the end of document; got %d; want %d", n, len(text))
+	}
+}
+
+func TestGetRuleFileQueries(t *testing.T) {
+	f := func(text string, resultExpected []string) {
+		t.Helper()
+
+		qs := getRuleFileQueries(text)
+		var result []string
+		for _, q := range qs {
+			result = append(result, q.s)
+
+			// Verify that segments point to the query text in the document.
+			for _, seg := range q.segments {
+				s := q.s[seg.queryOffset : seg.queryOffset+seg.n]
+				if s == "\n" {
+					continue
+				}
+				if docS := text[seg.docOffset : seg.docOffset+seg.n]; docS != s {
+					t.Fatalf("unexpected document text for the segment %+v; got %q; want %q", seg, docS, s)
+				}
+			}
+		}
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected queries;\ngot\n%q\nwant\n%q", result, resultExpected)
+		}
+	}
+
+	f("", nil)
+	f("groups:\n  - name: foo\n", nil)
+
+	// Plain scalar
+	f("- expr: sum(foo) by (job)\n", []string{"sum(foo) by (job)"})
+	f("  - alert: foo\n    expr: rate(foo[5m]) > 1  # comment\n", []string{"rate(foo[5m]) > 1"})
+	f("expr: foo{a=\"#b\"}", []string{`foo{a="#b"}`})
+
+	// Quoted scalars
+	f(`  expr: "foo{a='b'} > 1"`+"\n", []string{`foo{a='b'} > 1`})
+	f(`  expr: 'foo{a="b"}'`+"\n", []string{`foo{a="b"}`})
+
+	// Block scalars
+	f("    expr: |\n      sum(\n        foo\n      )\n    for: 5m\n", []string{"sum(\n  foo\n)\n"})
+	f("    expr: >-\n      foo\n\n      + bar\n  - record: baz\n", []string{"foo\n\n+ bar\n"})
+
+	// Plain multi-line scalar
+	f("expr:\n  foo\n  + bar\nlabels: {}\n", []string{"foo\n+ bar\n"})
+
+	// Multiple queries
+	f(`groups:
+  - name: test
+    rules:
+      - alert: foo
+        expr: foo > 1
+      - record: bar
+        expr: |
+          sum(bar)
+`, []string{"foo > 1", "sum(bar)\n"})
+}
+
+func TestQueryDocOffset(t *testing.T) {
+	text := "- expr: |\n    foo\n\n    + bar\n"
+	d := newDocument("file:///rules.yaml", 1, text)
+	if len(d.queries) != 1 {
+		t.Fatalf("unexpected number of queries; got %d; want 1", len(d.queries))
+	}
+	q := d.queries[0]
+
+	f := func(queryOffset, docOffsetExpected int) {
+		t.Helper()
+
+		docOffset := q.docOffset(queryOffset)
+		if docOffset != docOffsetExpected {
+			t.Fatalf("unexpected document offset for query offset %d; got %d; want %d", queryOffset, docOffset, docOffsetExpected)
+		}
+		qFound, n := d.findQuery(docOffset)
+		if qFound != q || n != queryOffset {
+			t.Fatalf("unexpected query offset for document offset %d; got %d; want %d", docOffset, n, queryOffset)
+		}
+	}
+
+	f(0, 14)
+	f(2, 16)
+	f(4, 18)
+	f(5, 23)
+	f(10, 28)
+
+	if qFound, _ := d.findQuery(3); qFound != nil {
+		t.Fatalf("expecting nil query outside expr field")
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// JSON-RPC error codes.
+//
+// See https://www.jsonrpc.org/specification#error_object
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+)
+
+// message is JSON-RPC 2.0 request, notification or response.
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *responseError   `json:"error,omitempty"`
+}
+
+// isRequest returns true if msg is a request, which needs a response.
+func (msg *message) isRequest() bool {
+	return msg.Method != "" && msg.ID != nil
+}
+
+// response is JSON-RPC 2.0 successful response.
+//
+// Result is always marshaled, since null result is a valid result.
+type response struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result"`
+}
+
+// errorResponse is JSON-RPC 2.0 error response.
+type errorResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Error   *responseError   `json:"error"`
+}
+
+// notification is JSON-RPC 2.0 notification.
+type notification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// responseError is the error in JSON-RPC 2.0 response.
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error implements error interface.
+func (re *responseError) Error() string {
+	return fmt.Sprintf("code %d: %s", re.Code, re.Message)
+}
+
+func newResponseError(code int, format string, args ...interface{}) *responseError {
+	return &responseError{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// conn reads and writes JSON-RPC 2.0 messages with `Content-Length` headers as required by LSP.
+//
+// See https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification/#baseProtocol
+type conn struct {
+	r *textproto.Reader
+
+	wLock sync.Mutex
+	w     io.Writer
+}
+
+func newConn(r io.Reader, w io.Writer) *conn {
+	return &conn{
+		r: textproto.NewReader(bufio.NewReader(r)),
+		w: w,
+	}
+}
+
+// readMessage reads the next message from c.
+//
+// io.EOF is returned if there are no more messages.
+func (c *conn) readMessage() (*message, error) {
+	contentLength := -1
+	for {
+		line, err := c.r.ReadLine()
+		if err != nil {
+			if err == io.EOF && contentLength < 0 {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("cannot read message header: %w", err)
+		}
+		if line == "" {
+			break
+		}
+		n := strings.IndexByte(line, ':')
+		if n < 0 {
+			return nil, fmt.Errorf("invalid message header %q", line)
+		}
+		name := strings.TrimSpace(line[:n])
+		value := strings.TrimSpace(line[n+1:])
+		if strings.EqualFold(name, "Content-Length") {
+			contentLength, err = strconv.Atoi(value)
+			if err != nil || contentLength < 0 {
+				return nil, fmt.Errorf("invalid Content-Length header %q", value)
+			}
+		}
+	}
+	if contentLength < 0 {
+		return nil, fmt.Errorf("missing Content-Length header")
+	}
+	body := make([]byte, contentLength)
+	if _, err := io.ReadFull(c.r.R, body); err != nil {
+		return nil, fmt.Errorf("cannot read message body: %w", err)
+	}
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, newResponseError(codeParseError, "cannot parse message %q: %s", body, err)
+	}
+	return &msg, nil
+}
+
+// writeMessage writes v as a message to c.
+//
+// It is safe to call writeMessage from concurrently running goroutines.
+func (c *conn) writeMessage(v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("cannot marshal message: %w", err)
+	}
+	c.wLock.Lock()
+	defer c.wLock.Unlock()
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(body), body); err != nil {
+		return fmt.Errorf("cannot write message: %w", err)
+	}
+	return nil
+}
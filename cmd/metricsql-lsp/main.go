@@ -0,0 +1,32 @@
+// Command metricsql-lsp is Language Server Protocol server for MetricsQL.
+//
+// The server communicates over stdin and stdout. It supports `.metricsql` and `.promql` files
+// and `expr` fields in YAML files with alerting and recording rules.
+//
+// The following features are supported:
+//
+//   - diagnostics for parse errors
+//   - document formatting
+//   - hover docs for functions and WITH templates
+//   - completion for functions, modifiers, durations and label filters
+//   - go-to-definition for WITH template names and WITH template args
+//   - rename of WITH template names and WITH template args
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+)
+
+var _ = flag.Bool("stdio", true, "Communicate over stdin and stdout. This is the only supported mode; the flag is accepted for compatibility with LSP clients")
+
+func main() {
+	flag.Parse()
+	log.SetPrefix("metricsql-lsp: ")
+
+	s := newServer(os.Stdin, os.Stdout, nil)
+	if err := s.run(); err != nil {
+		log.Fatalf("FATAL: %s", err)
+	}
+}
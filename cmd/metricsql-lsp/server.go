@@ -0,0 +1,468 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"runtime/debug"
+	"strings"
+
+	"github.com/Abhinav1299/metricsql"
+)
+
+// errExitWithoutShutdown is returned from server.run if the client sent `exit` notification without `shutdown` request.
+var errExitWithoutShutdown = errors.New("exit notification received without shutdown request")
+
+// server is LSP server for MetricsQL.
+type server struct {
+	conn *conn
+
+	// mp is used for metric names, label names and label values completion. It may be nil.
+	mp metricsql.MetadataProvider
+
+	docs map[string]*document
+
+	shutdown bool
+}
+
+func newServer(r io.Reader, w io.Writer, mp metricsql.MetadataProvider) *server {
+	return &server{
+		conn: newConn(r, w),
+		mp:   mp,
+		docs: make(map[string]*document),
+	}
+}
+
+// run processes messages until `exit` notification or the end of input.
+func (s *server) run() error {
+	for {
+		msg, err := s.conn.readMessage()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			var re *responseError
+			if errors.As(err, &re) {
+				// Invalid JSON. The request id is unknown, so respond with null id.
+				if err := s.conn.writeMessage(&errorResponse{JSONRPC: "2.0", Error: re}); err != nil {
+					return err
+				}
+				continue
+			}
+			return err
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return errExitWithoutShutdown
+			}
+			return nil
+		}
+		resp, err := s.handleMessage(msg)
+		if err != nil {
+			return err
+		}
+		if resp == nil {
+			continue
+		}
+		if err := s.conn.writeMessage(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// handleMessage handles msg and returns the response, which must be sent to the client.
+//
+// nil response is returned for notifications. Panics are logged and converted to internal errors,
+// so a bug triggered by a single document doesn't stop the server.
+func (s *server) handleMessage(msg *message) (resp interface{}, err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		log.Printf("ERROR: panic when handling %q: %v\n%s", msg.Method, r, debug.Stack())
+		resp, err = nil, nil
+		if msg.isRequest() {
+			re := newResponseError(codeInternalError, "internal error when handling %q: %v", msg.Method, r)
+			resp = &errorResponse{JSONRPC: "2.0", ID: msg.ID, Error: re}
+		}
+	}()
+
+	if !msg.isRequest() {
+		return nil, s.handleNotification(msg.Method, msg.Params)
+	}
+	result, re := s.handleRequest(msg.Method, msg.Params)
+	if re != nil {
+		return &errorResponse{JSONRPC: "2.0", ID: msg.ID, Error: re}, nil
+	}
+	return &response{JSONRPC: "2.0", ID: msg.ID, Result: result}, nil
+}
+
+func (s *server) handleRequest(method string, params json.RawMessage) (interface{}, *responseError) {
+	if s.shutdown {
+		return nil, newResponseError(codeInvalidRequest, "the server is shut down")
+	}
+	switch method {
+	case "initialize":
+		return s.initialize(), nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/hover":
+		var p TextDocumentPositionParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, invalidParamsError(method, err)
+		}
+		return s.hover(&p), nil
+	case "textDocument/completion":
+		var p TextDocumentPositionParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, invalidParamsError(method, err)
+		}
+		return s.completion(&p)
+	case "textDocument/definition":
+		var p TextDocumentPositionParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, invalidParamsError(method, err)
+		}
+		return s.definition(&p), nil
+	case "textDocument/rename":
+		var p RenameParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, invalidParamsError(method, err)
+		}
+		return s.rename(&p)
+	case "textDocument/formatting":
+		var p DocumentFormattingParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, invalidParamsError(method, err)
+		}
+		return s.formatting(&p), nil
+	default:
+		return nil, newResponseError(codeMethodNotFound, "unsupported method %q", method)
+	}
+}
+
+func (s *server) handleNotification(method string, params json.RawMessage) error {
+	switch method {
+	case "textDocument/didOpen":
+		var p DidOpenTextDocumentParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil
+		}
+		d := newDocument(p.TextDocument.URI, p.TextDocument.Version, p.TextDocument.Text)
+		s.docs[d.uri] = d
+		return s.publishDiagnostics(d)
+	case "textDocument/didChange":
+		var p DidChangeTextDocumentParams
+		if err := json.Unmarshal(params, &p); err != nil || len(p.ContentChanges) == 0 {
+			return nil
+		}
+		text := p.ContentChanges[len(p.ContentChanges)-1].Text
+		d := newDocument(p.TextDocument.URI, p.TextDocument.Version, text)
+		s.docs[d.uri] = d
+		return s.publishDiagnostics(d)
+	case "textDocument/didClose":
+		var p DidCloseTextDocumentParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil
+		}
+		delete(s.docs, p.TextDocument.URI)
+		return s.conn.writeMessage(&notification{
+			JSONRPC: "2.0",
+			Method:  "textDocument/publishDiagnostics",
+			Params: &PublishDiagnosticsParams{
+				URI:         p.TextDocument.URI,
+				Diagnostics: []Diagnostic{},
+			},
+		})
+	default:
+		// Unknown notifications such as `initialized` or `$/cancelRequest` must be ignored.
+		return nil
+	}
+}
+
+func invalidParamsError(method string, err error) *responseError {
+	return newResponseError(codeInvalidParams, "cannot parse params for %q: %s", method, err)
+}
+
+func (s *server) initialize() *InitializeResult {
+	return &InitializeResult{
+		Capabilities: ServerCapabilities{
+			TextDocumentSync: textDocumentSyncKindFull,
+			HoverProvider:    true,
+			CompletionProvider: &CompletionOptions{
+				TriggerCharacters: []string{"{", ",", "=", "~", "[", "(", " "},
+			},
+			DefinitionProvider:         true,
+			RenameProvider:             true,
+			DocumentFormattingProvider: true,
+		},
+		ServerInfo: ServerInfo{
+			Name: "metricsql-lsp",
+		},
+	}
+}
+
+func (s *server) publishDiagnostics(d *document) error {
+	diagnostics := []Diagnostic{}
+	for _, q := range d.queries {
+		if isBlankQuery(q.s) {
+			continue
+		}
+		_, errs := metricsql.ParseTolerant(q.s)
+		for _, err := range errs {
+			start, end := 0, len(q.s)
+			if err.Pos.IsValid() {
+				start, end = err.Pos.Offset, err.Pos.End
+			}
+			diagnostics = append(diagnostics, Diagnostic{
+				Range:    d.queryRange(q, start, end),
+				Severity: severityError,
+				Code:     err.Kind.String(),
+				Source:   "metricsql",
+				Message:  err.Msg,
+			})
+		}
+	}
+	return s.conn.writeMessage(&notification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params: &PublishDiagnosticsParams{
+			URI:         d.uri,
+			Version:     d.version,
+			Diagnostics: diagnostics,
+		},
+	})
+}
+
+// isBlankQuery returns true if q contains only whitespace and comments.
+func isBlankQuery(q string) bool {
+	tokens, _ := metricsql.Tokenize(q)
+	for _, t := range tokens {
+		if t.Kind != metricsql.TokenWhitespace && t.Kind != metricsql.TokenComment {
+			return false
+		}
+	}
+	return true
+}
+
+// findToken returns the query, its tokens and the index of the token at the given position in the document with the given uri.
+//
+// The token just before the position is returned if the position is at the end of the token
+// and the token at the position isn't a name, so `f|(` refers to `f`.
+// nil query is returned if there is no query at the given position.
+func (s *server) findToken(uri string, pos Position) (*document, *query, []metricsql.Token, int) {
+	d := s.docs[uri]
+	if d == nil {
+		return nil, nil, nil, -1
+	}
+	q, offset := d.findQuery(d.positionToOffset(pos))
+	if q == nil {
+		return d, nil, nil, -1
+	}
+	tokens, _ := metricsql.Tokenize(q.s)
+	idx := -1
+	for i := range tokens {
+		t := &tokens[i]
+		if offset < t.Pos.Offset || offset > t.Pos.End {
+			continue
+		}
+		if t.Kind == metricsql.TokenWhitespace || t.Kind == metricsql.TokenComment {
+			continue
+		}
+		if idx >= 0 && isNameToken(&tokens[idx]) && !isNameToken(t) {
+			break
+		}
+		idx = i
+		if offset < t.Pos.End {
+			break
+		}
+	}
+	return d, q, tokens, idx
+}
+
+// isNameToken returns true if t is a name, which may be hovered, completed or renamed.
+func isNameToken(t *metricsql.Token) bool {
+	switch t.Kind {
+	case metricsql.TokenIdent, metricsql.TokenFunc, metricsql.TokenKeyword, metricsql.TokenLabel:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *server) hover(p *TextDocumentPositionParams) *Hover {
+	d, q, tokens, idx := s.findToken(p.TextDocument.URI, p.Position)
+	if idx < 0 {
+		return nil
+	}
+	t := &tokens[idx]
+	r := d.queryRange(q, t.Pos.Offset, t.Pos.End)
+	ws := newWithSymbols(tokens)
+	if sym := ws.find(idx); sym != nil {
+		start := tokens[sym.def].Pos.Offset
+		end := tokens[sym.defEnd-1].Pos.End
+		return &Hover{
+			Contents: MarkupContent{
+				Kind:  "markdown",
+				Value: fmt.Sprintf("WITH template\n\n```\n%s\n```", strings.TrimSpace(q.s[start:end])),
+			},
+			Range: &r,
+		}
+	}
+	if t.Kind != metricsql.TokenFunc {
+		return nil
+	}
+	fs := metricsql.GetFuncSignature(t.S)
+	if fs == nil {
+		return nil
+	}
+	return &Hover{
+		Contents: MarkupContent{
+			Kind:  "markdown",
+			Value: fmt.Sprintf("```\n%s\n```\n\n%s", fs, fs.Description),
+		},
+		Range: &r,
+	}
+}
+
+func (s *server) completion(p *TextDocumentPositionParams) (*CompletionList, *responseError) {
+	list := &CompletionList{
+		Items: []CompletionItem{},
+	}
+	d := s.docs[p.TextDocument.URI]
+	if d == nil {
+		return list, nil
+	}
+	q, offset := d.findQuery(d.positionToOffset(p.Position))
+	if q == nil {
+		return list, nil
+	}
+	cs, err := metricsql.Complete(q.s, offset, s.mp)
+	if err != nil {
+		return nil, newResponseError(codeInternalError, "cannot obtain completions: %s", err)
+	}
+	r := d.queryRange(q, cs.Pos.Offset, cs.Pos.End)
+	for i, c := range cs.Items {
+		item := CompletionItem{
+			Label:    c.Text,
+			Kind:     getCompletionItemKind(c.Kind),
+			Detail:   c.Detail,
+			SortText: fmt.Sprintf("%05d", i),
+			TextEdit: &TextEdit{
+				Range:   r,
+				NewText: c.Text,
+			},
+		}
+		if c.Description != "" {
+			item.Documentation = &MarkupContent{
+				Kind:  "plaintext",
+				Value: c.Description,
+			}
+		}
+		list.Items = append(list.Items, item)
+	}
+	return list, nil
+}
+
+func getCompletionItemKind(k metricsql.CompletionKind) int {
+	switch k {
+	case metricsql.CompletionLabelValue:
+		return completionItemKindValue
+	case metricsql.CompletionLabelName:
+		return completionItemKindField
+	case metricsql.CompletionDuration:
+		return completionItemKindUnit
+	case metricsql.CompletionModifier:
+		return completionItemKindKeyword
+	case metricsql.CompletionMetricName:
+		return completionItemKindVariable
+	default:
+		return completionItemKindFunction
+	}
+}
+
+func (s *server) definition(p *TextDocumentPositionParams) *Location {
+	d, q, tokens, idx := s.findToken(p.TextDocument.URI, p.Position)
+	if idx < 0 {
+		return nil
+	}
+	sym := newWithSymbols(tokens).find(idx)
+	if sym == nil {
+		return nil
+	}
+	t := &tokens[sym.def]
+	return &Location{
+		URI:   d.uri,
+		Range: d.queryRange(q, t.Pos.Offset, t.Pos.End),
+	}
+}
+
+func (s *server) rename(p *RenameParams) (*WorkspaceEdit, *responseError) {
+	d, q, tokens, idx := s.findToken(p.TextDocument.URI, p.Position)
+	if idx < 0 {
+		return nil, nil
+	}
+	ws := newWithSymbols(tokens)
+	sym := ws.find(idx)
+	if sym == nil {
+		return nil, newResponseError(codeInvalidRequest, "%q isn't a WITH template name or WITH template arg", tokens[idx].S)
+	}
+	if !isValidIdent(p.NewName) {
+		return nil, newResponseError(codeInvalidParams, "%q isn't a valid identifier", p.NewName)
+	}
+	var edits []TextEdit
+	for _, i := range ws.refs(sym) {
+		t := &tokens[i]
+		edits = append(edits, TextEdit{
+			Range:   d.queryRange(q, t.Pos.Offset, t.Pos.End),
+			NewText: p.NewName,
+		})
+	}
+	return &WorkspaceEdit{
+		Changes: map[string][]TextEdit{
+			d.uri: edits,
+		},
+	}, nil
+}
+
+// isValidIdent returns true if s is a single MetricsQL identifier.
+func isValidIdent(s string) bool {
+	tokens, errs := metricsql.Tokenize(s)
+	return len(errs) == 0 && len(tokens) == 1 && tokens[0].Kind == metricsql.TokenIdent
+}
+
+// formatting returns edits for prettifying the document from p.
+//
+// Only standalone query files are formatted. Queries with comments or WITH expressions aren't formatted,
+// since metricsql.Format drops comments and expands WITH expressions.
+func (s *server) formatting(p *DocumentFormattingParams) []TextEdit {
+	edits := []TextEdit{}
+	d := s.docs[p.TextDocument.URI]
+	if d == nil || isRuleFile(d.uri) || isBlankQuery(d.text) {
+		return edits
+	}
+	tokens, _ := metricsql.Tokenize(d.text)
+	for _, t := range tokens {
+		if t.Kind == metricsql.TokenComment || t.Kind == metricsql.TokenKeyword && strings.ToLower(t.S) == "with" {
+			return edits
+		}
+	}
+	text, err := metricsql.Format(d.text)
+	if err != nil {
+		return edits
+	}
+	if strings.HasSuffix(d.text, "\n") {
+		text += "\n"
+	}
+	if text == d.text {
+		return edits
+	}
+	return append(edits, TextEdit{
+		Range:   d.rangeForOffsets(0, len(d.text)),
+		NewText: text,
+	})
+}
@@ -0,0 +1,569 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Abhinav1299/metricsql"
+)
+
+// testClient is in-process LSP client for the server.
+type testClient struct {
+	t    *testing.T
+	conn *conn
+
+	// msgs contains messages received from the server.
+	msgs chan *message
+
+	// notifications contains notifications received from the server while waiting for responses.
+	notifications []*message
+
+	nextID int
+
+	// serverErr receives the error returned from server.run.
+	serverErr chan error
+}
+
+func newTestClient(t *testing.T) *testClient {
+	t.Helper()
+
+	mp := metricsql.NewStaticMetadataProvider([]map[string]string{
+		{"__name__": "http_requests_total", "job": "api"},
+		{"__name__": "http_requests_total", "job": "web"},
+	})
+	return newTestClientWithMetadata(t, mp)
+}
+
+func newTestClientWithMetadata(t *testing.T, mp metricsql.MetadataProvider) *testClient {
+	t.Helper()
+
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+	s := newServer(serverR, serverW, mp)
+	c := &testClient{
+		t:         t,
+		conn:      newConn(clientR, clientW),
+		msgs:      make(chan *message, 100),
+		serverErr: make(chan error, 1),
+	}
+	go func() {
+		err := s.run()
+		serverW.Close()
+		c.serverErr <- err
+	}()
+	go func() {
+		for {
+			msg, err := c.conn.readMessage()
+			if err != nil {
+				close(c.msgs)
+				return
+			}
+			c.msgs <- msg
+		}
+	}()
+	t.Cleanup(func() {
+		clientW.Close()
+		clientR.Close()
+	})
+	return c
+}
+
+// readMessage returns the next message from the server.
+func (c *testClient) readMessage() *message {
+	c.t.Helper()
+
+	select {
+	case msg, ok := <-c.msgs:
+		if !ok {
+			c.t.Fatalf("the server closed the connection")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		c.t.Fatalf("timeout when waiting for a message from the server")
+	}
+	return nil
+}
+
+// call sends request with the given method and params to the server and stores the response result in result.
+//
+// The response error is returned if the server responded with an error.
+func (c *testClient) call(method string, params, result interface{}) *responseError {
+	c.t.Helper()
+
+	c.nextID++
+	id := json.RawMessage(fmt.Sprintf("%d", c.nextID))
+	if err := c.conn.writeMessage(&requestMessage{JSONRPC: "2.0", ID: c.nextID, Method: method, Params: params}); err != nil {
+		c.t.Fatalf("cannot send %q request: %s", method, err)
+	}
+	for {
+		msg := c.readMessage()
+		if msg.Method != "" {
+			c.notifications = append(c.notifications, msg)
+			continue
+		}
+		if msg.ID == nil || string(*msg.ID) != string(id) {
+			c.t.Fatalf("unexpected response id for %q request; got %s; want %s", method, msg.ID, id)
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if result != nil {
+			if err := json.Unmarshal(msg.Result, result); err != nil {
+				c.t.Fatalf("cannot unmarshal result for %q request: %s; result: %s", method, err, msg.Result)
+			}
+		}
+		return nil
+	}
+}
+
+// notify sends notification with the given method and params to the server.
+func (c *testClient) notify(method string, params interface{}) {
+	c.t.Helper()
+
+	if err := c.conn.writeMessage(&notification{JSONRPC: "2.0", Method: method, Params: params}); err != nil {
+		c.t.Fatalf("cannot send %q notification: %s", method, err)
+	}
+}
+
+// waitDiagnostics returns the next diagnostics published by the server.
+func (c *testClient) waitDiagnostics() *PublishDiagnosticsParams {
+	c.t.Helper()
+
+	var msg *message
+	if len(c.notifications) > 0 {
+		msg = c.notifications[0]
+		c.notifications = c.notifications[1:]
+	} else {
+		msg = c.readMessage()
+	}
+	if msg.Method != "textDocument/publishDiagnostics" {
+		c.t.Fatalf("unexpected message from the server; got %q; want %q", msg.Method, "textDocument/publishDiagnostics")
+	}
+	var p PublishDiagnosticsParams
+	if err := json.Unmarshal(msg.Params, &p); err != nil {
+		c.t.Fatalf("cannot unmarshal diagnostics: %s", err)
+	}
+	return &p
+}
+
+// open opens the document with the given uri and text and returns the published diagnostics.
+func (c *testClient) open(uri, text string) *PublishDiagnosticsParams {
+	c.t.Helper()
+
+	c.notify("textDocument/didOpen", &DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{
+			URI:        uri,
+			LanguageID: "metricsql",
+			Version:    1,
+			Text:       text,
+		},
+	})
+	return c.waitDiagnostics()
+}
+
+// requestMessage is JSON-RPC 2.0 request sent by testClient.
+type requestMessage struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      int         `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// textPos returns the position of the first occurrence of substr in text.
+func textPos(t *testing.T, text, substr string) Position {
+	t.Helper()
+
+	n := strings.Index(text, substr)
+	if n < 0 {
+		t.Fatalf("cannot find %q in %q", substr, text)
+	}
+	d := newDocument("", 0, text)
+	return d.offsetToPosition(n)
+}
+
+func TestServerLifecycle(t *testing.T) {
+	c := newTestClient(t)
+
+	var result InitializeResult
+	if err := c.call("initialize", map[string]interface{}{"capabilities": map[string]interface{}{}}, &result); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	caps := result.Capabilities
+	if caps.TextDocumentSync != textDocumentSyncKindFull || !caps.HoverProvider || caps.CompletionProvider == nil ||
+		!caps.DefinitionProvider || !caps.RenameProvider || !caps.DocumentFormattingProvider {
+		t.Fatalf("unexpected capabilities: %+v", caps)
+	}
+	c.notify("initialized", map[string]interface{}{})
+
+	if err := c.call("unknown/method", nil, nil); err == nil || err.Code != codeMethodNotFound {
+		t.Fatalf("unexpected error for unknown method; got %v; want code %d", err, codeMethodNotFound)
+	}
+
+	if err := c.call("shutdown", nil, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := c.call("textDocument/hover", &TextDocumentPositionParams{}, nil); err == nil || err.Code != codeInvalidRequest {
+		t.Fatalf("unexpected error for request after shutdown; got %v; want code %d", err, codeInvalidRequest)
+	}
+	c.notify("exit", nil)
+	if err := <-c.serverErr; err != nil {
+		t.Fatalf("unexpected error from the server: %s", err)
+	}
+}
+
+func TestServerExitWithoutShutdown(t *testing.T) {
+	c := newTestClient(t)
+	c.notify("exit", nil)
+	if err := <-c.serverErr; err != errExitWithoutShutdown {
+		t.Fatalf("unexpected error from the server; got %v; want %v", err, errExitWithoutShutdown)
+	}
+}
+
+func TestServerDiagnostics(t *testing.T) {
+	c := newTestClient(t)
+
+	p := c.open("file:///a.metricsql", "sum(rate(foo[5m]))\n")
+	if p.URI != "file:///a.metricsql" || len(p.Diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics: %+v", p)
+	}
+
+	c.notify("textDocument/didChange", &DidChangeTextDocumentParams{
+		TextDocument: VersionedTextDocumentIdentifier{
+			URI:     "file:///a.metricsql",
+			Version: 2,
+		},
+		ContentChanges: []TextDocumentContentChangeEvent{{
+			Text: "sum(rate(foo[5m]))\n  + unknown_func(bar)\n",
+		}},
+	})
+	p = c.waitDiagnostics()
+	diagnosticsExpected := []Diagnostic{{
+		Range: Range{
+			Start: Position{Line: 1, Character: 4},
+			End:   Position{Line: 1, Character: 21},
+		},
+		Severity: severityError,
+		Code:     "unknown_func",
+		Source:   "metricsql",
+		Message:  `unsupported function "unknown_func"`,
+	}}
+	if p.Version != 2 || !reflect.DeepEqual(p.Diagnostics, diagnosticsExpected) {
+		t.Fatalf("unexpected diagnostics;\ngot\n%+v\nwant\n%+v", p.Diagnostics, diagnosticsExpected)
+	}
+
+	c.notify("textDocument/didClose", &DidCloseTextDocumentParams{
+		TextDocument: TextDocumentIdentifier{
+			URI: "file:///a.metricsql",
+		},
+	})
+	p = c.waitDiagnostics()
+	if len(p.Diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics after closing the document: %+v", p.Diagnostics)
+	}
+}
+
+func TestServerDiagnosticsWithTemplateInModifier(t *testing.T) {
+	c := newTestClient(t)
+
+	c.open("file:///a.metricsql", "sum(foo) by (x)\n")
+	c.notify("textDocument/didChange", &DidChangeTextDocumentParams{
+		TextDocument: VersionedTextDocumentIdentifier{
+			URI:     "file:///a.metricsql",
+			Version: 2,
+		},
+		ContentChanges: []TextDocumentContentChangeEvent{{
+			Text: `with (x="a") sum(foo) by (x)` + "\n",
+		}},
+	})
+	p := c.waitDiagnostics()
+	if p.Version != 2 || len(p.Diagnostics) != 1 || p.Diagnostics[0].Code != metricsql.ParseErrorBadWithTemplate.String() {
+		t.Fatalf("unexpected diagnostics: %+v", p.Diagnostics)
+	}
+}
+
+// panicMetadataProvider panics on every call.
+type panicMetadataProvider struct{}
+
+func (panicMetadataProvider) MetricNames(_ string) ([]string, error) {
+	panic("BUG: MetricNames")
+}
+
+func (panicMetadataProvider) LabelNames(_ []metricsql.LabelFilter) ([]string, error) {
+	panic("BUG: LabelNames")
+}
+
+func (panicMetadataProvider) LabelValues(_ string, _ []metricsql.LabelFilter) ([]string, error) {
+	panic("BUG: LabelValues")
+}
+
+func TestServerPanic(t *testing.T) {
+	c := newTestClientWithMetadata(t, panicMetadataProvider{})
+
+	text := "foo{job="
+	c.open("file:///a.metricsql", text)
+	params := &TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: "file:///a.metricsql"},
+		Position:     Position{Line: 0, Character: len(text)},
+	}
+	err := c.call("textDocument/completion", params, nil)
+	if err == nil || err.Code != codeInternalError || !strings.Contains(err.Message, "BUG: LabelValues") {
+		t.Fatalf("unexpected error for panicking request; got %v; want code %d", err, codeInternalError)
+	}
+
+	// The server continues processing messages after the panic.
+	var hover *Hover
+	if err := c.call("textDocument/hover", params, &hover); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestServerDiagnosticsRuleFile(t *testing.T) {
+	c := newTestClient(t)
+
+	text := `groups:
+  - name: test
+    rules:
+      - alert: HighErrorRate
+        expr: sum(rate(foo[5m])) > 1
+      - record: job:bar:sum
+        expr: |
+          sum(
+            bar{job="a"
+          )
+      - alert: Quoted
+        expr: "rate(baz[5m]) >"
+`
+	p := c.open("file:///rules.yml", text)
+	var lines []int
+	for _, d := range p.Diagnostics {
+		lines = append(lines, d.Range.Start.Line)
+	}
+	linesExpected := []int{9, 11}
+	if !reflect.DeepEqual(lines, linesExpected) {
+		t.Fatalf("unexpected diagnostic lines; got %v; want %v; diagnostics: %+v", lines, linesExpected, p.Diagnostics)
+	}
+	if pos := p.Diagnostics[1].Range.Start; pos.Character != 30 {
+		t.Fatalf("unexpected diagnostic position for quoted query; got %+v; want character 30", pos)
+	}
+}
+
+func TestServerHover(t *testing.T) {
+	c := newTestClient(t)
+
+	text := "with (f(x) = rate(x[5m]))\nf(foo)"
+	c.open("file:///a.metricsql", text)
+
+	f := func(substr, resultExpected string) {
+		t.Helper()
+
+		var h *Hover
+		params := &TextDocumentPositionParams{
+			TextDocument: TextDocumentIdentifier{URI: "file:///a.metricsql"},
+			Position:     textPos(t, text, substr),
+		}
+		if err := c.call("textDocument/hover", params, &h); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		result := ""
+		if h != nil {
+			result = h.Contents.Value
+		}
+		if result != resultExpected {
+			t.Fatalf("unexpected hover for %q;\ngot\n%s\nwant\n%s", substr, result, resultExpected)
+		}
+	}
+
+	f("rate", "```\nrate(range_vector) instant_vector\n```\n\nreturns the average per-second increase rate over the lookbehind window for counters")
+	f("f(foo)", "WITH template\n\n```\nf(x) = rate(x[5m])\n```")
+	f("foo", "")
+	f("5m", "")
+}
+
+func TestServerCompletion(t *testing.T) {
+	c := newTestClient(t)
+
+	text := `sum(rate(http_requests_total{job=~"w`
+	c.open("file:///a.metricsql", text)
+
+	var list CompletionList
+	params := &TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: "file:///a.metricsql"},
+		Position:     Position{Line: 0, Character: len(text)},
+	}
+	if err := c.call("textDocument/completion", params, &list); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	itemsExpected := []CompletionItem{{
+		Label:    `"web"`,
+		Kind:     completionItemKindValue,
+		SortText: "00000",
+		TextEdit: &TextEdit{
+			Range: Range{
+				Start: Position{Line: 0, Character: 34},
+				End:   Position{Line: 0, Character: 36},
+			},
+			NewText: `"web"`,
+		},
+	}}
+	if !reflect.DeepEqual(list.Items, itemsExpected) {
+		t.Fatalf("unexpected completion items;\ngot\n%+v\nwant\n%+v", list.Items, itemsExpected)
+	}
+
+	// Function completions contain signature and description.
+	c.open("file:///b.metricsql", "histogram_quantil")
+	params = &TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: "file:///b.metricsql"},
+		Position:     Position{Line: 0, Character: 17},
+	}
+	if err := c.call("textDocument/completion", params, &list); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(list.Items) != 2 || list.Items[0].Label != "histogram_quantile" || list.Items[0].Kind != completionItemKindFunction ||
+		list.Items[0].Detail == "" || list.Items[0].Documentation == nil {
+		t.Fatalf("unexpected completion items: %+v", list.Items)
+	}
+}
+
+func TestServerDefinition(t *testing.T) {
+	c := newTestClient(t)
+
+	text := "with (\n  cf = {job=\"a\"},\n  f(x) = rate(x{cf}[5m]),\n)\nf(foo) + f(bar)"
+	c.open("file:///a.metricsql", text)
+
+	f := func(pos Position, rangeExpected *Range) {
+		t.Helper()
+
+		var loc *Location
+		params := &TextDocumentPositionParams{
+			TextDocument: TextDocumentIdentifier{URI: "file:///a.metricsql"},
+			Position:     pos,
+		}
+		if err := c.call("textDocument/definition", params, &loc); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var r *Range
+		if loc != nil {
+			r = &loc.Range
+		}
+		if !reflect.DeepEqual(r, rangeExpected) {
+			t.Fatalf("unexpected definition at %+v; got %+v; want %+v", pos, r, rangeExpected)
+		}
+	}
+
+	cfDef := &Range{Start: Position{Line: 1, Character: 2}, End: Position{Line: 1, Character: 4}}
+	fDef := &Range{Start: Position{Line: 2, Character: 2}, End: Position{Line: 2, Character: 3}}
+	xDef := &Range{Start: Position{Line: 2, Character: 4}, End: Position{Line: 2, Character: 5}}
+
+	f(Position{Line: 4, Character: 0}, fDef)
+	f(Position{Line: 4, Character: 10}, fDef)
+	f(Position{Line: 2, Character: 17}, cfDef)
+	f(Position{Line: 2, Character: 14}, xDef)
+	f(Position{Line: 2, Character: 2}, fDef)
+	f(Position{Line: 4, Character: 3}, nil)
+	f(Position{Line: 2, Character: 9}, nil)
+}
+
+func TestServerRename(t *testing.T) {
+	c := newTestClient(t)
+
+	text := "with (x = foo, f(x) = x + 1)\nf(x) + x"
+	c.open("file:///a.metricsql", text)
+
+	rename := func(pos Position, newName string) (*WorkspaceEdit, *responseError) {
+		t.Helper()
+
+		var we *WorkspaceEdit
+		params := &RenameParams{
+			TextDocument: TextDocumentIdentifier{URI: "file:///a.metricsql"},
+			Position:     pos,
+			NewName:      newName,
+		}
+		err := c.call("textDocument/rename", params, &we)
+		return we, err
+	}
+	f := func(pos Position, newName, resultExpected string) {
+		t.Helper()
+
+		we, err := rename(pos, newName)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		result := applyEdits(t, text, we.Changes["file:///a.metricsql"])
+		if result != resultExpected {
+			t.Fatalf("unexpected result after renaming;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	// Rename WITH template name
+	f(Position{Line: 0, Character: 6}, "y", "with (y = foo, f(x) = x + 1)\nf(y) + y")
+	f(Position{Line: 1, Character: 7}, "y", "with (y = foo, f(x) = x + 1)\nf(y) + y")
+
+	// Rename WITH template arg
+	f(Position{Line: 0, Character: 17}, "arg", "with (x = foo, f(arg) = arg + 1)\nf(x) + x")
+
+	// Rename WITH template function
+	f(Position{Line: 1, Character: 0}, "g", "with (x = foo, g(x) = x + 1)\ng(x) + x")
+
+	// Invalid rename
+	if _, err := rename(Position{Line: 0, Character: 10}, "bar"); err == nil {
+		t.Fatalf("expecting non-nil error when renaming metric name")
+	}
+	if _, err := rename(Position{Line: 0, Character: 6}, "1abc"); err == nil || err.Code != codeInvalidParams {
+		t.Fatalf("unexpected error when renaming to invalid identifier; got %v; want code %d", err, codeInvalidParams)
+	}
+}
+
+// applyEdits applies non-overlapping edits to text.
+func applyEdits(t *testing.T, text string, edits []TextEdit) string {
+	t.Helper()
+
+	d := newDocument("", 0, text)
+	for i := len(edits) - 1; i >= 0; i-- {
+		e := edits[i]
+		start := d.positionToOffset(e.Range.Start)
+		end := d.positionToOffset(e.Range.End)
+		text = text[:start] + e.NewText + text[end:]
+	}
+	return text
+}
+
+func TestServerFormatting(t *testing.T) {
+	c := newTestClient(t)
+
+	f := func(uri, text, resultExpected string) {
+		t.Helper()
+
+		c.open(uri, text)
+		var edits []TextEdit
+		params := &DocumentFormattingParams{
+			TextDocument: TextDocumentIdentifier{URI: uri},
+		}
+		if err := c.call("textDocument/formatting", params, &edits); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		result := applyEdits(t, text, edits)
+		if result != resultExpected {
+			t.Fatalf("unexpected result after formatting;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	f("file:///a.promql", "sum(rate(foo[5m]))by(job)\n", "sum(rate(foo[5m])) by(job)\n")
+	f("file:///a.promql", "sum(rate(foo[5m])) by(job)", "sum(rate(foo[5m])) by(job)")
+
+	// Queries with parse errors, comments and WITH expressions aren't formatted.
+	f("file:///a.promql", "sum(rate(foo[5m])", "sum(rate(foo[5m])")
+	f("file:///a.promql", "sum(foo)by(job) # comment", "sum(foo)by(job) # comment")
+	f("file:///a.promql", "with (x = foo) x+1", "with (x = foo) x+1")
+
+	// Built-in templates and constant expressions are kept as is.
+	f("file:///a.metricsql", "ru(free,max)", "ru(free, max)")
+	f("file:///a.metricsql", "foo > 365*24*3600", "foo > ((365 * 24) * 3600)")
+
+	// Rule files aren't formatted.
+	f("file:///a.yml", "expr: sum(foo)by(job)\n", "expr: sum(foo)by(job)\n")
+}
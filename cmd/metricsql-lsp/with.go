@@ -0,0 +1,185 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/Abhinav1299/metricsql"
+)
+
+// withSymbol is a name defined in WITH expression - either WITH template name or WITH template arg.
+//
+// For example, `f` and `x` are WITH symbols in `WITH (f(x) = x + 1) f(foo)`.
+type withSymbol struct {
+	// def is the index of the token with the symbol definition.
+	def int
+
+	// The symbol is visible at tokens in the range [scopeStart:scopeEnd).
+	scopeStart int
+	scopeEnd   int
+
+	// The symbol definition occupies tokens in the range [def:defEnd).
+	defEnd int
+}
+
+// withSymbols contains WITH symbols for tokens returned from metricsql.Tokenize.
+type withSymbols struct {
+	tokens  []metricsql.Token
+	symbols []*withSymbol
+}
+
+func newWithSymbols(tokens []metricsql.Token) *withSymbols {
+	ws := &withSymbols{
+		tokens: tokens,
+	}
+	for i := range tokens {
+		t := &tokens[i]
+		if t.Kind == metricsql.TokenKeyword && strings.ToLower(t.S) == "with" {
+			ws.addWithExpr(i)
+		}
+	}
+	return ws
+}
+
+// addWithExpr registers symbols for WITH expression starting at tokens[i].
+func (ws *withSymbols) addWithExpr(i int) {
+	tokens := ws.tokens
+	open := ws.nextToken(i)
+	if open >= len(tokens) || tokens[open].S != "(" {
+		return
+	}
+	withEnd := ws.exprEnd(ws.closingToken(open) + 1)
+	start := open + 1
+	for start < len(tokens) {
+		argEnd := ws.exprEnd(start)
+		name := ws.nextToken(start - 1)
+		if name < argEnd && isIdentToken(&tokens[name]) {
+			eq := ws.nextToken(name)
+			var args []int
+			if eq < argEnd && tokens[eq].S == "(" {
+				argsEnd := ws.closingToken(eq)
+				for j := eq + 1; j < argsEnd; j++ {
+					if isIdentToken(&tokens[j]) {
+						args = append(args, j)
+					}
+				}
+				eq = ws.nextToken(argsEnd)
+			}
+			if eq < argEnd && tokens[eq].S == "=" {
+				ws.symbols = append(ws.symbols, &withSymbol{
+					def:        name,
+					scopeStart: argEnd,
+					scopeEnd:   withEnd,
+					defEnd:     argEnd,
+				})
+				for _, arg := range args {
+					ws.symbols = append(ws.symbols, &withSymbol{
+						def:        arg,
+						scopeStart: eq + 1,
+						scopeEnd:   argEnd,
+						defEnd:     arg + 1,
+					})
+				}
+			}
+		}
+		if argEnd >= len(tokens) || tokens[argEnd].S != "," {
+			return
+		}
+		start = argEnd + 1
+	}
+}
+
+// find returns the symbol for tokens[i].
+//
+// nil is returned if tokens[i] doesn't refer to WITH symbol.
+func (ws *withSymbols) find(i int) *withSymbol {
+	if i < 0 || i >= len(ws.tokens) || !isIdentToken(&ws.tokens[i]) {
+		return nil
+	}
+	name := ws.tokens[i].S
+	var result *withSymbol
+	for _, sym := range ws.symbols {
+		if sym.def == i {
+			return sym
+		}
+		if ws.tokens[sym.def].S != name || i < sym.scopeStart || i >= sym.scopeEnd {
+			continue
+		}
+		// Prefer the innermost symbol, since it shadows outer symbols with the same name.
+		if result == nil || sym.def > result.def {
+			result = sym
+		}
+	}
+	return result
+}
+
+// refs returns indexes for tokens referring to sym including its definition.
+func (ws *withSymbols) refs(sym *withSymbol) []int {
+	var a []int
+	for i := range ws.tokens {
+		if ws.find(i) == sym {
+			a = append(a, i)
+		}
+	}
+	return a
+}
+
+// nextToken returns the index of the next significant token after tokens[i].
+//
+// len(tokens) is returned if there are no significant tokens after tokens[i].
+func (ws *withSymbols) nextToken(i int) int {
+	for i++; i < len(ws.tokens); i++ {
+		switch ws.tokens[i].Kind {
+		case metricsql.TokenWhitespace, metricsql.TokenComment, metricsql.TokenInvalid:
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// closingToken returns the index of the closing bracket for the opening bracket at tokens[i].
+//
+// len(tokens) is returned if the closing bracket is missing.
+func (ws *withSymbols) closingToken(i int) int {
+	depth := 0
+	for ; i < len(ws.tokens); i++ {
+		switch ws.tokens[i].S {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return i
+}
+
+// exprEnd returns the index of the token just after the expression starting at tokens[i].
+//
+// The expression ends at `,` or at unmatched closing bracket.
+func (ws *withSymbols) exprEnd(i int) int {
+	depth := 0
+	for ; i < len(ws.tokens); i++ {
+		switch ws.tokens[i].S {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			if depth == 0 {
+				return i
+			}
+			depth--
+		case ",":
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return i
+}
+
+// isIdentToken returns true if t may refer to WITH symbol.
+func isIdentToken(t *metricsql.Token) bool {
+	return t.Kind == metricsql.TokenIdent || t.Kind == metricsql.TokenFunc
+}
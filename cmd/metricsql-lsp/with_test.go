@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Abhinav1299/metricsql"
+)
+
+func TestWithSymbols(t *testing.T) {
+	f := func(s string, tokenIdx int, refsExpected []int) {
+		t.Helper()
+
+		tokens, _ := metricsql.Tokenize(s)
+		ws := newWithSymbols(tokens)
+		var refs []int
+		if sym := ws.find(tokenIdx); sym != nil {
+			for _, i := range ws.refs(sym) {
+				refs = append(refs, tokens[i].Pos.Offset)
+			}
+		}
+		if !reflect.DeepEqual(refs, refsExpected) {
+			t.Fatalf("unexpected refs for token %d in %q; got %v; want %v", tokenIdx, s, refs, refsExpected)
+		}
+	}
+
+	// tokenAt returns the index of the token at the first occurrence of substr in s.
+	tokenAt := func(s, substr string) int {
+		t.Helper()
+
+		n := strings.Index(s, substr)
+		tokens, _ := metricsql.Tokenize(s)
+		for i := range tokens {
+			if tokens[i].Pos.Offset == n {
+				return i
+			}
+		}
+		t.Fatalf("cannot find token for %q in %q", substr, s)
+		return -1
+	}
+
+	s := "with (x = foo, f(x) = x + 1) f(x) + x"
+	f(s, tokenAt(s, "x = foo"), []int{6, 31, 36})
+	f(s, tokenAt(s, "x) = x"), []int{17, 22})
+	f(s, tokenAt(s, "x + 1"), []int{17, 22})
+	f(s, tokenAt(s, "f(x) +"), []int{15, 29})
+	f(s, tokenAt(s, "foo"), nil)
+	f(s, tokenAt(s, "with"), nil)
+
+	// Nested WITH shadows outer symbols.
+	s = "with (x = foo) with (x = bar) x + x"
+	f(s, tokenAt(s, "x = foo"), []int{6})
+	f(s, tokenAt(s, "x + x"), []int{21, 30, 34})
+
+	// Symbols are visible only after their definition.
+	s = "with (a = b, b = c) a + b"
+	f(s, tokenAt(s, "b, b"), nil)
+	f(s, tokenAt(s, "b = c"), []int{13, 24})
+
+	// Incomplete WITH
+	s = "with (f(x) = x + "
+	f(s, tokenAt(s, "f"), []int{6})
+	f(s, tokenAt(s, "x)"), []int{8, 13})
+}
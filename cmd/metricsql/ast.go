@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Abhinav1299/metricsql"
+)
+
+func runAST(e *env, args []string) int {
+	fs, qf := e.newFlagSet("ast", "[-json] [-e query]... [file ...]",
+		"Dumps the parsed tree for queries with expanded WITH templates.\n\n"+
+			"Every line contains the node position in the query, the node type, the node details\n"+
+			"and the inferred kind of the node result in parens.")
+	jsonOutput := fs.Bool("json", false, "Print the tree in JSON format as returned from metricsql.ExprToJSON, one query per line")
+	inputs, code := e.parseFlagsAndReadInputs(fs, qf, args)
+	if inputs == nil {
+		return code
+	}
+
+	code = exitOK
+	for _, in := range inputs {
+		expr, err := metricsql.Parse(in.s)
+		if err != nil {
+			fmt.Fprintf(e.stderr, "%s: %s\n", in.name, err)
+			code = exitQueryError
+			continue
+		}
+		if *jsonOutput {
+			data, err := metricsql.ExprToJSON(expr)
+			if err != nil {
+				fmt.Fprintf(e.stderr, "%s: %s\n", in.name, err)
+				code = exitQueryError
+				continue
+			}
+			printLine(e.stdout, string(data))
+			continue
+		}
+		printLine(e.stdout, dumpTree(expr))
+	}
+	return code
+}
+
+// treeNode is a line in the tree returned from dumpTree.
+type treeNode struct {
+	pos   string
+	depth int
+	s     string
+}
+
+// dumpTree returns human-readable tree for expr.
+func dumpTree(expr metricsql.Expr) string {
+	var nodes []*treeNode
+	appendTreeNodes(&nodes, expr, 0, "")
+	posWidth := 0
+	for _, n := range nodes {
+		if len(n.pos) > posWidth {
+			posWidth = len(n.pos)
+		}
+	}
+	var sb strings.Builder
+	for _, n := range nodes {
+		fmt.Fprintf(&sb, "%-*s  %s%s\n", posWidth, n.pos, strings.Repeat("  ", n.depth), n.s)
+	}
+	return sb.String()
+}
+
+// appendTreeNodes appends nodes for expr and its children to dst.
+//
+// The label is put in front of the node description.
+func appendTreeNodes(dst *[]*treeNode, expr metricsql.Expr, depth int, label string) {
+	var children []metricsql.Expr
+	var details []string
+	var atExpr metricsql.Expr
+	switch t := expr.(type) {
+	case *metricsql.MetricExpr:
+		details = append(details, string(t.AppendString(nil)))
+	case *metricsql.FuncExpr:
+		details = append(details, t.Name)
+		if t.KeepMetricNames {
+			details = append(details, "keep_metric_names")
+		}
+		children = t.Args
+	case *metricsql.AggrFuncExpr:
+		details = append(details, t.Name)
+		if t.Modifier.Op != "" {
+			details = append(details, string(t.Modifier.AppendString(nil)))
+		}
+		if t.Limit > 0 {
+			details = append(details, fmt.Sprintf("limit %d", t.Limit))
+		}
+		children = t.Args
+	case *metricsql.BinaryOpExpr:
+		details = append(details, t.Op)
+		if t.Bool {
+			details = append(details, "bool")
+		}
+		if t.GroupModifier.Op != "" {
+			details = append(details, string(t.GroupModifier.AppendString(nil)))
+		}
+		if t.JoinModifier.Op != "" {
+			details = append(details, string(t.JoinModifier.AppendString(nil)))
+		}
+		if t.JoinModifierPrefix != nil {
+			details = append(details, "prefix "+string(t.JoinModifierPrefix.AppendString(nil)))
+		}
+		if t.KeepMetricNames {
+			details = append(details, "keep_metric_names")
+		}
+		children = []metricsql.Expr{t.Left, t.Right}
+	case *metricsql.RollupExpr:
+		if t.Window != nil || t.Step != nil || t.InheritStep {
+			var sb strings.Builder
+			sb.WriteByte('[')
+			sb.Write(t.Window.AppendString(nil))
+			if t.Step != nil || t.InheritStep {
+				sb.WriteByte(':')
+				sb.Write(t.Step.AppendString(nil))
+			}
+			sb.WriteByte(']')
+			details = append(details, sb.String())
+		}
+		if t.Offset != nil {
+			details = append(details, "offset "+string(t.Offset.AppendString(nil)))
+		}
+		children = []metricsql.Expr{t.Expr}
+		atExpr = t.At
+	case *metricsql.NumberExpr:
+		details = append(details, string(t.AppendString(nil)))
+	case *metricsql.StringExpr:
+		details = append(details, string(t.AppendString(nil)))
+	case *metricsql.DurationExpr:
+		details = append(details, string(t.AppendString(nil)))
+	}
+
+	s := label + strings.TrimPrefix(fmt.Sprintf("%T", expr), "*metricsql.")
+	if len(details) > 0 {
+		s += " " + strings.Join(details, " ")
+	}
+	if kind, ok := metricsql.ExprType(expr); ok {
+		s += " (" + kind.String() + ")"
+	}
+	*dst = append(*dst, &treeNode{
+		pos:   metricsql.ExprPos(expr).String(),
+		depth: depth,
+		s:     s,
+	})
+	for _, child := range children {
+		appendTreeNodes(dst, child, depth+1, "")
+	}
+	if atExpr != nil {
+		appendTreeNodes(dst, atExpr, depth+1, "@ ")
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAST(t *testing.T) {
+	f := func(args []string, codeExpected int, stdoutExpected, stderrContains string) {
+		t.Helper()
+
+		stdout, stderr, code := runTest(append([]string{"ast"}, args...), "")
+		if code != codeExpected {
+			t.Fatalf("unexpected exit code; got %d; want %d; stderr: %s", code, codeExpected, stderr)
+		}
+		if stdout != stdoutExpected {
+			t.Fatalf("unexpected stdout;\ngot\n%s\nwant\n%s", stdout, stdoutExpected)
+		}
+		if !strings.Contains(stderr, stderrContains) {
+			t.Fatalf("stderr must contain %q; got\n%s", stderrContains, stderr)
+		}
+	}
+
+	f([]string{"-e", "1.5"}, exitOK, "1:1  NumberExpr 1.5 (scalar)\n", "")
+	f([]string{"-e", `sum(rate(foo{job="a"}[5m] offset 1h @ end())) by (job)
+  > bool on(job) group_left(x) prefix "p_" bar`}, exitOK, `1:1   BinaryOpExpr > bool on(job) group_left(x) prefix "p_" (instant_vector)
+1:1     AggrFuncExpr sum by(job) (instant_vector)
+1:5       FuncExpr rate (instant_vector)
+1:10        RollupExpr [5m] offset 1h (range_vector)
+1:10          MetricExpr foo{job="a"} (instant_vector)
+1:39          @ FuncExpr end (scalar)
+2:44    MetricExpr bar (instant_vector)
+`, "")
+	f([]string{"-e", `label_set(foo[1h:], "a", "b") keep_metric_names`}, exitOK, `1:1   FuncExpr label_set keep_metric_names (instant_vector)
+1:11    RollupExpr [1h:] (range_vector)
+1:11      MetricExpr foo (instant_vector)
+1:21    StringExpr "a" (string)
+1:26    StringExpr "b" (string)
+`, "")
+
+	// Nodes from WITH templates point to the template body.
+	f([]string{"-e", "with (f(x) = x * 2) f(foo)"}, exitOK, `1:14  BinaryOpExpr * (instant_vector)
+1:23    MetricExpr foo (instant_vector)
+1:18    NumberExpr 2 (scalar)
+`, "")
+
+	// JSON output
+	f([]string{"-json", "-e", "foo"}, exitOK,
+		`{"version":1,"expr":{"type":"metric","label_filters":[[{"label":"__name__","value":"foo"}]]}}`+"\n", "")
+
+	// Invalid query
+	f([]string{"-e", "foo{"}, exitQueryError, "", "<arg #1>: ")
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Abhinav1299/metricsql"
+)
+
+// diagnostic is an error found by `check` command.
+type diagnostic struct {
+	// Source is the input name - file path, `<stdin>` or `<arg #N>` for queries from `-e` flags.
+	Source string `json:"source"`
+
+	// Category is either "parse" for parse errors or "type" for type errors.
+	Category string `json:"category"`
+
+	// Kind is the machine-readable error kind such as "unexpected_eof" or "bad_arg".
+	Kind string `json:"kind"`
+
+	// Line and Column point to the error location in the query starting from 1.
+	//
+	// They are 0 if the error isn't related to a particular place in the query.
+	Line   int `json:"line"`
+	Column int `json:"column"`
+
+	// Offset and End are byte offsets for the error location in the query.
+	Offset int `json:"offset"`
+	End    int `json:"end"`
+
+	Message string `json:"message"`
+
+	// Expected contains tokens expected by the parser at the error location.
+	Expected []string `json:"expected,omitempty"`
+}
+
+// String returns human-readable representation of d.
+func (d *diagnostic) String() string {
+	if d.Line == 0 {
+		return fmt.Sprintf("%s: %s (%s)", d.Source, d.Message, d.Kind)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s (%s)", d.Source, d.Line, d.Column, d.Message, d.Kind)
+}
+
+func runCheck(e *env, args []string) int {
+	fs, qf := e.newFlagSet("check", "[-json] [-e query]... [file ...]",
+		"Checks queries for parse errors and type errors.\n\n"+
+			"The exit code is 1 if errors are found.")
+	jsonOutput := fs.Bool("json", false, "Print errors as JSON array. Every item contains source, category, kind, line, column, "+
+		"offset, end, message and optional expected fields")
+	inputs, code := e.parseFlagsAndReadInputs(fs, qf, args)
+	if inputs == nil {
+		return code
+	}
+
+	ds := []*diagnostic{}
+	for _, in := range inputs {
+		ds = append(ds, checkQuery(in)...)
+	}
+	if *jsonOutput {
+		// Do not escape <, > and & in source names such as `<stdin>`.
+		enc := json.NewEncoder(e.stdout)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(ds); err != nil {
+			fmt.Fprintf(e.stderr, "metricsql check: cannot write errors: %s\n", err)
+			return exitUsageError
+		}
+	} else {
+		for _, d := range ds {
+			printLine(e.stdout, d.String())
+		}
+	}
+	if len(ds) > 0 {
+		return exitQueryError
+	}
+	return exitOK
+}
+
+// checkQuery returns parse errors and type errors for in.
+//
+// Type errors are returned only if in has no parse errors.
+func checkQuery(in *input) []*diagnostic {
+	var ds []*diagnostic
+	expr, errs := metricsql.ParseTolerant(in.s)
+	for _, pe := range errs {
+		ds = append(ds, &diagnostic{
+			Source:   in.name,
+			Category: "parse",
+			Kind:     pe.Kind.String(),
+			Line:     pe.Pos.Line,
+			Column:   pe.Pos.Column,
+			Offset:   pe.Pos.Offset,
+			End:      pe.Pos.End,
+			Message:  pe.Msg,
+			Expected: pe.Expected,
+		})
+	}
+	if len(ds) > 0 {
+		return ds
+	}
+	for _, te := range metricsql.TypeCheck(expr) {
+		ds = append(ds, &diagnostic{
+			Source:   in.name,
+			Category: "type",
+			Kind:     te.Kind.String(),
+			Line:     te.Pos.Line,
+			Column:   te.Pos.Column,
+			Offset:   te.Pos.Offset,
+			End:      te.Pos.End,
+			Message:  te.Msg,
+		})
+	}
+	return ds
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	f := func(args []string, codeExpected int, stdoutExpected string) {
+		t.Helper()
+
+		stdout, stderr, code := runTest(append([]string{"check"}, args...), "")
+		if code != codeExpected {
+			t.Fatalf("unexpected exit code; got %d; want %d; stderr: %s", code, codeExpected, stderr)
+		}
+		if stdout != stdoutExpected {
+			t.Fatalf("unexpected stdout;\ngot\n%s\nwant\n%s", stdout, stdoutExpected)
+		}
+	}
+
+	// Valid queries
+	f([]string{"-e", "sum(rate(foo[5m])) by (job)"}, exitOK, "")
+	f([]string{"-json", "-e", "with (x = foo) x"}, exitOK, "[]\n")
+
+	// Parse errors
+	f([]string{"-e", "foo{", "-e", "bar", "-e", "unknown_func(baz)"}, exitQueryError,
+		`<arg #1>:1:5: labelFilterExpr: unexpected token ""; want "ident" (unexpected_eof)`+"\n"+
+			`<arg #3>:1:1: unsupported function "unknown_func" (unknown_func)`+"\n")
+	f([]string{"-json", "-e", "foo{"}, exitQueryError,
+		`[{"source":"<arg #1>","category":"parse","kind":"unexpected_eof","line":1,"column":5,"offset":4,"end":4,`+
+			`"message":"labelFilterExpr: unexpected token \"\"; want \"ident\"","expected":["ident"]}]`+"\n")
+
+	// Type errors
+	f([]string{"-e", "rate(sum(foo))"}, exitQueryError,
+		"<arg #1>:1:6: arg #1 of rate() must be range_vector; got instant_vector sum(foo); use subquery such as sum(foo)[5m:] (bad_arg)\n")
+	f([]string{"-json", "-e", "histogram_quantile()"}, exitQueryError,
+		`[{"source":"<arg #1>","category":"type","kind":"bad_arity","line":1,"column":1,"offset":0,"end":20,`+
+			`"message":"invalid number of args for histogram_quantile(); got 0; want from 2 to 3"}]`+"\n")
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContextLines is the number of unchanged lines shown around changes in unified diff.
+const diffContextLines = 3
+
+// diffOp is a single line operation in the diff.
+type diffOp struct {
+	// kind is ' ' for unchanged line, '-' for deleted line and '+' for inserted line.
+	kind byte
+
+	// aIdx and bIdx are the indexes of the line in the old and the new text.
+	//
+	// For inserted lines aIdx points to the next old line, while for deleted lines bIdx points to the next new line.
+	aIdx int
+	bIdx int
+
+	line string
+}
+
+// unifiedDiff returns unified diff between a and b with the given names.
+//
+// nil is returned if a and b are equal.
+func unifiedDiff(aName, bName, a, b string) []byte {
+	if a == b {
+		return nil
+	}
+	ops := getDiffOps(splitLines(a), splitLines(b))
+
+	dst := []byte(fmt.Sprintf("--- %s\n+++ %s\n", aName, bName))
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		// Find the end of the hunk, which contains all the changes separated by less than 2*diffContextLines unchanged lines.
+		start := i - diffContextLines
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j + 1
+				continue
+			}
+			if j-end >= 2*diffContextLines {
+				break
+			}
+		}
+		i = end
+		end += diffContextLines
+		if end > len(ops) {
+			end = len(ops)
+		}
+		dst = appendHunk(dst, ops[start:end])
+	}
+	return dst
+}
+
+func appendHunk(dst []byte, ops []diffOp) []byte {
+	aLen := 0
+	bLen := 0
+	for _, op := range ops {
+		if op.kind != '+' {
+			aLen++
+		}
+		if op.kind != '-' {
+			bLen++
+		}
+	}
+	aStart := ops[0].aIdx
+	if aLen > 0 {
+		aStart++
+	}
+	bStart := ops[0].bIdx
+	if bLen > 0 {
+		bStart++
+	}
+	dst = append(dst, fmt.Sprintf("@@ -%d,%d +%d,%d @@\n", aStart, aLen, bStart, bLen)...)
+	for _, op := range ops {
+		dst = append(dst, op.kind)
+		dst = append(dst, op.line...)
+		if !strings.HasSuffix(op.line, "\n") {
+			dst = append(dst, "\n\\ No newline at end of file\n"...)
+		}
+	}
+	return dst
+}
+
+// getDiffOps returns line operations for converting a lines to b lines based on the longest common subsequence.
+func getDiffOps(a, b []string) []diffOp {
+	// lcs[i][j] contains the length of the longest common subsequence for a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{kind: ' ', aIdx: i, bIdx: j, line: a[i]})
+			i++
+			j++
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{kind: '-', aIdx: i, bIdx: j, line: a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{kind: '+', aIdx: i, bIdx: j, line: b[j]})
+			j++
+		}
+	}
+	return ops
+}
+
+// splitLines splits s into lines including the trailing newlines.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
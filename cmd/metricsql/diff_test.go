@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	f := func(a, b, resultExpected string) {
+		t.Helper()
+
+		result := string(unifiedDiff("a", "b", a, b))
+		if result != resultExpected {
+			t.Fatalf("unexpected diff;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	f("", "", "")
+	f("foo\n", "foo\n", "")
+
+	f("", "foo\n", `--- a
++++ b
+@@ -0,0 +1,1 @@
++foo
+`)
+	f("foo\nbar\n", "", `--- a
++++ b
+@@ -1,2 +0,0 @@
+-foo
+-bar
+`)
+	f("foo", "foo\n", `--- a
++++ b
+@@ -1,1 +1,1 @@
+-foo
+\ No newline at end of file
++foo
+`)
+
+	// Changes separated by more than 6 unchanged lines are put into distinct hunks.
+	f("1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", "1\nx\n3\n4\n5\n6\n7\n8\n9\n10\ny\n", `--- a
++++ b
+@@ -1,5 +1,5 @@
+ 1
+-2
++x
+ 3
+ 4
+ 5
+@@ -8,3 +8,4 @@
+ 8
+ 9
+ 10
++y
+`)
+
+	// Changes separated by up to 6 unchanged lines are put into a single hunk.
+	f("1\n2\n3\n4\n5\n6\n7\n8\n9\n", "x\n2\n3\n4\n5\n6\n7\ny\n9\n", `--- a
++++ b
+@@ -1,9 +1,9 @@
+-1
++x
+ 2
+ 3
+ 4
+ 5
+ 6
+ 7
+-8
++y
+ 9
+`)
+}
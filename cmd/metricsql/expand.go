@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Abhinav1299/metricsql"
+)
+
+func runExpand(e *env, args []string) int {
+	fs, qf := e.newFlagSet("expand", "[-e query]... [file ...]",
+		"Inlines WITH templates in queries and prints the results to stdout, one query per line.")
+	inputs, code := e.parseFlagsAndReadInputs(fs, qf, args)
+	if inputs == nil {
+		return code
+	}
+
+	code = exitOK
+	for _, in := range inputs {
+		s, err := metricsql.ExpandWithExprs(in.s)
+		if err != nil {
+			fmt.Fprintf(e.stderr, "%s: %s\n", in.name, err)
+			code = exitQueryError
+			continue
+		}
+		printLine(e.stdout, s)
+	}
+	return code
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	f := func(args []string, codeExpected int, stdoutExpected, stderrContains string) {
+		t.Helper()
+
+		stdout, stderr, code := runTest(append([]string{"expand"}, args...), "")
+		if code != codeExpected {
+			t.Fatalf("unexpected exit code; got %d; want %d; stderr: %s", code, codeExpected, stderr)
+		}
+		if stdout != stdoutExpected {
+			t.Fatalf("unexpected stdout;\ngot\n%s\nwant\n%s", stdout, stdoutExpected)
+		}
+		if !strings.Contains(stderr, stderrContains) {
+			t.Fatalf("stderr must contain %q; got\n%s", stderrContains, stderr)
+		}
+	}
+
+	f([]string{"-e", "with (f(x) = rate(x[5m])) f(foo) + f(bar)"}, exitOK, "rate(foo[5m]) + rate(bar[5m])\n", "")
+	f([]string{"-e", "foo # comment\n + bar"}, exitOK, "foo + bar\n", "")
+	f([]string{"-e", "with (f(x) = x) g(foo)", "-e", "foo"}, exitQueryError, "foo\n", `<arg #1>: `)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/Abhinav1299/metricsql"
+)
+
+func runFmt(e *env, args []string) int {
+	fs, qf := e.newFlagSet("fmt", "[-w | -d] [-e query]... [file ...]",
+		"Prettifies queries and prints the results to stdout.\n\n"+
+			"Queries with comments and WITH templates are left as is, since prettifying would drop them.")
+	write := fs.Bool("w", false, "Write the results to the source files instead of stdout")
+	diff := fs.Bool("d", false, "Print unified diffs for the changed queries instead of the results")
+	inputs, code := e.parseFlagsAndReadInputs(fs, qf, args)
+	if inputs == nil {
+		return code
+	}
+	if *write && *diff {
+		fmt.Fprintf(e.stderr, "metricsql fmt: -w and -d flags cannot be set simultaneously\n")
+		return exitUsageError
+	}
+
+	code = exitOK
+	for _, in := range inputs {
+		if *write && in.path == "" {
+			fmt.Fprintf(e.stderr, "%s: cannot write the result, since the query isn't read from file\n", in.name)
+			code = exitUsageError
+			continue
+		}
+		s, err := formatQuery(in.s)
+		if err != nil {
+			fmt.Fprintf(e.stderr, "%s: %s\n", in.name, err)
+			if !errors.Is(err, errUnformattable) {
+				code = exitQueryError
+				continue
+			}
+			s = in.s
+		}
+		switch {
+		case *write:
+			if s == in.s {
+				continue
+			}
+			if err := writeFile(in.path, s); err != nil {
+				fmt.Fprintf(e.stderr, "%s: %s\n", in.name, err)
+				code = exitUsageError
+			}
+		case *diff:
+			e.stdout.Write(unifiedDiff(in.name+".orig", in.name, in.s, s))
+		default:
+			printLine(e.stdout, s)
+		}
+	}
+	return code
+}
+
+// errUnformattable is returned from formatQuery for queries, which cannot be prettified without losing their parts.
+var errUnformattable = errors.New("the query is left as is")
+
+// formatQuery returns prettified q.
+//
+// The trailing newline in q is preserved.
+func formatQuery(q string) (string, error) {
+	tokens, _ := metricsql.Tokenize(q)
+	for _, t := range tokens {
+		if t.Kind == metricsql.TokenComment {
+			return "", fmt.Errorf("%w, since it contains comments", errUnformattable)
+		}
+		if t.Kind == metricsql.TokenKeyword && strings.ToLower(t.S) == "with" {
+			return "", fmt.Errorf("%w, since it contains WITH templates", errUnformattable)
+		}
+	}
+	// Use metricsql.Format instead of metricsql.Prettify, since the latter expands built-in templates such as ru()
+	// and simplifies constant expressions.
+	s, err := metricsql.Format(q)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasSuffix(q, "\n") {
+		s += "\n"
+	}
+	return s, nil
+}
+
+// writeFile overwrites the file at the given path with s preserving file permissions.
+func writeFile(path, s string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("cannot write query: %w", err)
+	}
+	if err := ioutil.WriteFile(path, []byte(s), fi.Mode().Perm()); err != nil {
+		return fmt.Errorf("cannot write query: %w", err)
+	}
+	return nil
+}
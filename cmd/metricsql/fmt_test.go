@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFmt(t *testing.T) {
+	f := func(args []string, stdin string, codeExpected int, stdoutExpected, stderrContains string) {
+		t.Helper()
+
+		stdout, stderr, code := runTest(append([]string{"fmt"}, args...), stdin)
+		if code != codeExpected {
+			t.Fatalf("unexpected exit code; got %d; want %d; stderr: %s", code, codeExpected, stderr)
+		}
+		if stdout != stdoutExpected {
+			t.Fatalf("unexpected stdout;\ngot\n%s\nwant\n%s", stdout, stdoutExpected)
+		}
+		if !strings.Contains(stderr, stderrContains) {
+			t.Fatalf("stderr must contain %q; got\n%s", stderrContains, stderr)
+		}
+	}
+
+	f(nil, "sum(rate(foo[5m]))by(job)\n", exitOK, "sum(rate(foo[5m])) by(job)\n", "")
+	f([]string{"-e", "foo+bar", "-e", "baz"}, "", exitOK, "foo + bar\nbaz\n", "")
+
+	// Built-in templates and constant expressions are kept as is
+	f([]string{"-e", "ru(free,max)"}, "", exitOK, "ru(free, max)\n", "")
+	f([]string{"-e", "ttf(foo)"}, "", exitOK, "ttf(foo)\n", "")
+	f([]string{"-e", "foo > 365*24*3600"}, "", exitOK, "foo > ((365 * 24) * 3600)\n", "")
+
+	// Invalid query
+	f([]string{"-e", "foo{", "-e", "bar"}, "", exitQueryError, "bar\n", `<arg #1>: `)
+
+	// Queries with comments and WITH templates are left as is
+	f([]string{"-e", "foo+bar # comment"}, "", exitOK, "foo+bar # comment\n", "<arg #1>: the query is left as is, since it contains comments")
+	f([]string{"-e", "with (x = foo) x+1"}, "", exitOK, "with (x = foo) x+1\n", "since it contains WITH templates")
+
+	// Diff mode
+	f([]string{"-d"}, "foo+bar\n", exitOK, "--- <stdin>.orig\n+++ <stdin>\n@@ -1,1 +1,1 @@\n-foo+bar\n+foo + bar\n", "")
+	f([]string{"-d"}, "foo + bar\n", exitOK, "", "")
+
+	// Invalid flags
+	f([]string{"-d", "-w"}, "foo", exitUsageError, "", "-w and -d flags cannot be set simultaneously")
+	f([]string{"-w"}, "foo", exitUsageError, "", "<stdin>: cannot write the result, since the query isn't read from file")
+}
+
+func TestFmtWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metricsql")
+	if err != nil {
+		t.Fatalf("cannot create temporary dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	pathUnformatted := filepath.Join(dir, "a.metricsql")
+	if err := ioutil.WriteFile(pathUnformatted, []byte("sum(foo)by(job)\n"), 0640); err != nil {
+		t.Fatalf("cannot write file: %s", err)
+	}
+	pathInvalid := filepath.Join(dir, "b.metricsql")
+	if err := ioutil.WriteFile(pathInvalid, []byte("sum(foo"), 0640); err != nil {
+		t.Fatalf("cannot write file: %s", err)
+	}
+
+	stdout, stderr, code := runTest([]string{"fmt", "-w", pathUnformatted, pathInvalid}, "")
+	if code != exitQueryError {
+		t.Fatalf("unexpected exit code; got %d; want %d; stderr: %s", code, exitQueryError, stderr)
+	}
+	if stdout != "" {
+		t.Fatalf("unexpected stdout; got %q; want empty", stdout)
+	}
+	if !strings.Contains(stderr, pathInvalid+": ") || strings.Contains(stderr, pathUnformatted) {
+		t.Fatalf("stderr must contain only the error for %s; got\n%s", pathInvalid, stderr)
+	}
+
+	f := func(path, dataExpected string) {
+		t.Helper()
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("cannot read file: %s", err)
+		}
+		if string(data) != dataExpected {
+			t.Fatalf("unexpected contents for %s; got %q; want %q", path, data, dataExpected)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("cannot stat file: %s", err)
+		}
+		if fi.Mode().Perm() != 0640 {
+			t.Fatalf("unexpected permissions for %s; got %o; want %o", path, fi.Mode().Perm(), 0640)
+		}
+	}
+	f(pathUnformatted, "sum(foo) by(job)\n")
+	f(pathInvalid, "sum(foo")
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// input is a query read from command-line args, stdin or file.
+type input struct {
+	// name is the input name for messages.
+	name string
+
+	// path is the file path. It is empty for queries from command-line args and stdin.
+	path string
+
+	// s is the query.
+	s string
+}
+
+// queriesFlag holds queries passed via repeated `-e` flags.
+type queriesFlag []string
+
+// String implements flag.Value interface.
+func (qf *queriesFlag) String() string {
+	return strings.Join(*qf, ", ")
+}
+
+// Set implements flag.Value interface.
+func (qf *queriesFlag) Set(s string) error {
+	*qf = append(*qf, s)
+	return nil
+}
+
+// newFlagSet returns flag set for the command with the given name and args usage.
+//
+// The returned queriesFlag holds queries passed via `-e` flags.
+func (e *env) newFlagSet(name, argsUsage, description string) (*flag.FlagSet, *queriesFlag) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(e.stderr, "Usage: metricsql %s %s\n\n%s\n\nFlags:\n", name, argsUsage, description)
+		fs.PrintDefaults()
+	}
+	var qf queriesFlag
+	fs.Var(&qf, "e", "Query to process. The flag may be repeated. Queries are read from files or stdin if the flag isn't set")
+	return fs, &qf
+}
+
+// readInputs returns inputs for the given queries from `-e` flags and the given files.
+//
+// Queries are read from stdin if both queries and files are empty.
+func (e *env) readInputs(queries, files []string) ([]*input, error) {
+	var inputs []*input
+	for i, q := range queries {
+		inputs = append(inputs, &input{
+			name: fmt.Sprintf("<arg #%d>", i+1),
+			s:    q,
+		})
+	}
+	if len(queries) == 0 && len(files) == 0 {
+		files = []string{"-"}
+	}
+	stdinRead := false
+	for _, path := range files {
+		if path == "-" {
+			if stdinRead {
+				return nil, fmt.Errorf("stdin cannot be read multiple times")
+			}
+			stdinRead = true
+			data, err := ioutil.ReadAll(e.stdin)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read stdin: %w", err)
+			}
+			inputs = append(inputs, &input{
+				name: "<stdin>",
+				s:    string(data),
+			})
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read query: %w", err)
+		}
+		inputs = append(inputs, &input{
+			name: path,
+			path: path,
+			s:    string(data),
+		})
+	}
+	return inputs, nil
+}
+
+// parseFlagsAndReadInputs parses args with fs and returns inputs for the parsed flags and args.
+//
+// Non-zero exit code is returned on error.
+func (e *env) parseFlagsAndReadInputs(fs *flag.FlagSet, qf *queriesFlag, args []string) ([]*input, int) {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil, exitOK
+		}
+		return nil, exitUsageError
+	}
+	inputs, err := e.readInputs(*qf, fs.Args())
+	if err != nil {
+		fmt.Fprintf(e.stderr, "metricsql %s: %s\n", fs.Name(), err)
+		return nil, exitUsageError
+	}
+	return inputs, exitOK
+}
+
+// printLine prints s to w and terminates it with newline if needed.
+func printLine(w io.Writer, s string) {
+	if !strings.HasSuffix(s, "\n") {
+		s += "\n"
+	}
+	io.WriteString(w, s)
+}
@@ -0,0 +1,93 @@
+// Command metricsql is a command-line tool for MetricsQL queries.
+//
+// Usage:
+//
+//	metricsql <command> [flags] [file ...]
+//
+// The following commands are supported:
+//
+//   - fmt prettifies queries
+//   - check reports parse errors and type errors in queries
+//   - expand inlines WITH templates
+//   - optimize optimizes queries and shows the label filters pushed down into series selectors
+//   - ast dumps the parsed tree for queries
+//
+// Every command reads queries from `-e` flags and from the given files. Every file must contain a single query.
+// Queries are read from stdin if neither `-e` flags nor files are given. The `-` file also means stdin.
+//
+// The exit code is 0 on success, 1 if some queries cannot be processed and 2 on invalid usage or I/O errors.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Exit codes
+const (
+	exitOK         = 0
+	exitQueryError = 1
+	exitUsageError = 2
+)
+
+// command is a metricsql subcommand.
+type command struct {
+	name  string
+	usage string
+	run   func(env *env, args []string) int
+}
+
+var commands = []*command{
+	{"fmt", "prettify queries", runFmt},
+	{"check", "report parse errors and type errors in queries", runCheck},
+	{"expand", "inline WITH templates", runExpand},
+	{"optimize", "optimize queries and show the pushed-down label filters", runOptimize},
+	{"ast", "dump the parsed tree for queries", runAST},
+}
+
+// env contains standard streams for the running command.
+type env struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func main() {
+	e := &env{
+		stdin:  os.Stdin,
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+	}
+	os.Exit(run(e, os.Args[1:]))
+}
+
+// run runs the command from args and returns the exit code.
+func run(e *env, args []string) int {
+	if len(args) == 0 {
+		printUsage(e.stderr)
+		return exitUsageError
+	}
+	name := args[0]
+	switch name {
+	case "help", "-h", "-help", "--help":
+		printUsage(e.stdout)
+		return exitOK
+	}
+	for _, c := range commands {
+		if c.name == name {
+			return c.run(e, args[1:])
+		}
+	}
+	fmt.Fprintf(e.stderr, "metricsql: unknown command %q\n", name)
+	printUsage(e.stderr)
+	return exitUsageError
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: metricsql <command> [flags] [file ...]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintf(w, "\nRun `metricsql <command> -h` for command flags.\n")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// runTest runs metricsql with the given args and stdin and returns stdout, stderr and the exit code.
+func runTest(args []string, stdin string) (string, string, int) {
+	var stdout, stderr bytes.Buffer
+	e := &env{
+		stdin:  strings.NewReader(stdin),
+		stdout: &stdout,
+		stderr: &stderr,
+	}
+	code := run(e, args)
+	return stdout.String(), stderr.String(), code
+}
+
+func TestRun(t *testing.T) {
+	f := func(args []string, codeExpected int, stdoutContains, stderrContains string) {
+		t.Helper()
+
+		stdout, stderr, code := runTest(args, "")
+		if code != codeExpected {
+			t.Fatalf("unexpected exit code for %q; got %d; want %d; stderr: %s", args, code, codeExpected, stderr)
+		}
+		if !strings.Contains(stdout, stdoutContains) {
+			t.Fatalf("stdout for %q must contain %q; got\n%s", args, stdoutContains, stdout)
+		}
+		if !strings.Contains(stderr, stderrContains) {
+			t.Fatalf("stderr for %q must contain %q; got\n%s", args, stderrContains, stderr)
+		}
+	}
+
+	f(nil, exitUsageError, "", "Usage: metricsql <command>")
+	f([]string{"help"}, exitOK, "Usage: metricsql <command>", "")
+	f([]string{"unknown"}, exitUsageError, "", `unknown command "unknown"`)
+	f([]string{"fmt", "-h"}, exitOK, "", "Usage: metricsql fmt")
+	f([]string{"fmt", "-unknown"}, exitUsageError, "", "flag provided but not defined: -unknown")
+	f([]string{"expand", "/non/existing/file"}, exitUsageError, "", "cannot read query")
+}
+
+func TestReadInputs(t *testing.T) {
+	stdout, stderr, code := runTest([]string{"expand", "-e", "foo", "-e", "bar", "-"}, "baz")
+	if code != exitOK {
+		t.Fatalf("unexpected exit code; got %d; want %d; stderr: %s", code, exitOK, stderr)
+	}
+	if stdout != "foo\nbar\nbaz\n" {
+		t.Fatalf("unexpected stdout; got %q; want %q", stdout, "foo\nbar\nbaz\n")
+	}
+
+	_, stderr, code = runTest([]string{"expand", "-", "-"}, "baz")
+	if code != exitUsageError || !strings.Contains(stderr, "stdin cannot be read multiple times") {
+		t.Fatalf("unexpected result when reading stdin twice; code: %d; stderr: %s", code, stderr)
+	}
+}
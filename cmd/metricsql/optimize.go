@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Abhinav1299/metricsql"
+)
+
+func runOptimize(e *env, args []string) int {
+	fs, qf := e.newFlagSet("optimize", "[-e query]... [file ...]",
+		"Optimizes queries and prints the results to stdout, one query per line.\n\n"+
+			"Every optimized query is preceded by comments with the changed series selectors, e.g.\n\n"+
+			"\t# 1:1: foo{job=\"a\"} -> foo{job=\"a\",instance=\"b\"}\n\n"+
+			"The comments contain the position of the selector in the original query, the original selector\n"+
+			"and the selector with the label filters pushed down from other parts of the query.")
+	inputs, code := e.parseFlagsAndReadInputs(fs, qf, args)
+	if inputs == nil {
+		return code
+	}
+
+	code = exitOK
+	for _, in := range inputs {
+		expr, err := metricsql.Parse(in.s)
+		if err != nil {
+			fmt.Fprintf(e.stderr, "%s: %s\n", in.name, err)
+			code = exitQueryError
+			continue
+		}
+		exprOptimized := metricsql.Optimize(expr)
+		for _, sc := range getSelectorChanges(expr, exprOptimized) {
+			fmt.Fprintf(e.stdout, "# %s: %s -> %s\n", sc.pos, sc.before, sc.after)
+		}
+		printLine(e.stdout, string(exprOptimized.AppendString(nil)))
+	}
+	return code
+}
+
+// selectorChange is a series selector changed by metricsql.Optimize.
+type selectorChange struct {
+	pos    metricsql.Pos
+	before string
+	after  string
+}
+
+// getSelectorChanges returns series selectors in exprOptimized, which differ from the selectors at the same positions in expr.
+func getSelectorChanges(expr, exprOptimized metricsql.Expr) []*selectorChange {
+	// Selectors expanded from WITH templates may share the same position, so keep them in the order of appearance.
+	m := make(map[metricsql.Pos][]string)
+	metricsql.VisitAll(expr, func(e metricsql.Expr) {
+		if me, ok := e.(*metricsql.MetricExpr); ok && me.Pos.IsValid() {
+			m[me.Pos] = append(m[me.Pos], string(me.AppendString(nil)))
+		}
+	})
+	var scs []*selectorChange
+	metricsql.VisitAll(exprOptimized, func(e metricsql.Expr) {
+		me, ok := e.(*metricsql.MetricExpr)
+		if !ok || len(m[me.Pos]) == 0 {
+			return
+		}
+		before := m[me.Pos][0]
+		m[me.Pos] = m[me.Pos][1:]
+		after := string(me.AppendString(nil))
+		if after != before {
+			scs = append(scs, &selectorChange{
+				pos:    me.Pos,
+				before: before,
+				after:  after,
+			})
+		}
+	})
+	return scs
+}
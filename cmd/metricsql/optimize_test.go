@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOptimize(t *testing.T) {
+	f := func(args []string, codeExpected int, stdoutExpected, stderrContains string) {
+		t.Helper()
+
+		stdout, stderr, code := runTest(append([]string{"optimize"}, args...), "")
+		if code != codeExpected {
+			t.Fatalf("unexpected exit code; got %d; want %d; stderr: %s", code, codeExpected, stderr)
+		}
+		if stdout != stdoutExpected {
+			t.Fatalf("unexpected stdout;\ngot\n%s\nwant\n%s", stdout, stdoutExpected)
+		}
+		if !strings.Contains(stderr, stderrContains) {
+			t.Fatalf("stderr must contain %q; got\n%s", stderrContains, stderr)
+		}
+	}
+
+	// Nothing to optimize
+	f([]string{"-e", "sum(rate(foo[5m])) by (job)"}, exitOK, "sum(rate(foo[5m])) by(job)\n", "")
+
+	// Pushed-down filters
+	f([]string{"-e", `foo{job="a"} + on(job) bar{instance="b"}`}, exitOK,
+		`# 1:24: bar{instance="b"} -> bar{instance="b",job="a"}`+"\n"+
+			`foo{job="a"} + on(job) bar{instance="b",job="a"}`+"\n", "")
+
+	// Simplified filters
+	f([]string{"-e", "foo{job=~\"a\"}\n  / bar"}, exitOK,
+		`# 1:1: foo{job=~"a"} -> foo{job="a"}`+"\n"+
+			`# 2:5: bar -> bar{job="a"}`+"\n"+
+			`foo{job="a"} / bar{job="a"}`+"\n", "")
+
+	// Selectors from WITH templates
+	f([]string{"-e", `with (f(x) = foo{job=x} + bar) f("a") or f("b")`}, exitOK,
+		`# 1:27: bar -> bar{job="a"}`+"\n"+
+			`# 1:27: bar -> bar{job="b"}`+"\n"+
+			`(foo{job="a"} + bar{job="a"}) or (foo{job="b"} + bar{job="b"})`+"\n", "")
+
+	// Invalid query
+	f([]string{"-e", "foo{"}, exitQueryError, "", "<arg #1>: ")
+}
@@ -0,0 +1,599 @@
+package metricsql
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MetadataProvider provides metric names, label names and label values for Complete.
+type MetadataProvider interface {
+	// MetricNames returns metric names starting with the given prefix.
+	MetricNames(prefix string) ([]string, error)
+
+	// LabelNames returns label names for series matching all the given lfs.
+	//
+	// lfs may be empty. In this case label names for all the series must be returned.
+	LabelNames(lfs []LabelFilter) ([]string, error)
+
+	// LabelValues returns values for the given label for series matching all the given lfs.
+	//
+	// lfs may be empty. In this case label values for all the series must be returned.
+	LabelValues(label string, lfs []LabelFilter) ([]string, error)
+}
+
+// CompletionKind is the kind of Completion.
+type CompletionKind int
+
+const (
+	// CompletionLabelValue is a label value in label filter.
+	CompletionLabelValue CompletionKind = iota
+
+	// CompletionLabelName is a label name in label filter or in `by`, `without`, `on`, `ignoring`, `group_left` and `group_right` modifiers.
+	CompletionLabelName
+
+	// CompletionDuration is a duration for lookbehind window, subquery step or `offset`.
+	CompletionDuration
+
+	// CompletionModifier is a modifier such as `by`, `without`, `on`, `ignoring`, `group_left`, `bool`,
+	// `keep_metric_names`, `offset` or `limit`.
+	CompletionModifier
+
+	// CompletionMetricName is a metric name.
+	CompletionMetricName
+
+	// CompletionFunc is a function name.
+	CompletionFunc
+)
+
+var completionKindNames = [...]string{
+	CompletionLabelValue: "label_value",
+	CompletionLabelName:  "label_name",
+	CompletionDuration:   "duration",
+	CompletionModifier:   "modifier",
+	CompletionMetricName: "metric_name",
+	CompletionFunc:       "func",
+}
+
+// String returns string representation for k.
+func (k CompletionKind) String() string {
+	if k < 0 || int(k) >= len(completionKindNames) {
+		return fmt.Sprintf("CompletionKind(%d)", int(k))
+	}
+	return completionKindNames[k]
+}
+
+// Completion is a completion candidate returned from Complete.
+type Completion struct {
+	// Kind is the kind of the completion.
+	Kind CompletionKind
+
+	// Text is the text to be inserted instead of Completions.Pos.
+	Text string
+
+	// Detail contains additional information for the completion such as function signature.
+	//
+	// It may be empty.
+	Detail string
+
+	// Description is a human-readable description for the completion.
+	//
+	// It may be empty.
+	Description string
+}
+
+// Completions contains completion candidates returned from Complete.
+type Completions struct {
+	// Pos is the position of the text before the cursor, which must be replaced by the selected completion.
+	//
+	// Pos is empty and points to the cursor if there is no partially typed token before the cursor.
+	Pos Pos
+
+	// Items contains completion candidates ordered by their rank.
+	Items []*Completion
+}
+
+// Complete returns completion candidates for MetricsQL query s at the given cursor byte offset.
+//
+// Only the part of s before the cursor is taken into account. Completion candidates are detected
+// by the parser, so the selected completion results in syntactically valid query prefix:
+//
+//   - Function names and metric names are suggested at the places where the parser expects an expression.
+//   - Modifiers are suggested only at the places where the parser accepts them, e.g. `offset` after series selector
+//     or `by` after aggregate function.
+//   - Durations are suggested for lookbehind windows, subquery steps and `offset`.
+//   - Label names are suggested inside `{...}` and in `by(...)`, `on(...)` and similar modifiers.
+//   - Label values are suggested after label filter operators such as `=` or `=~`.
+//     Values for regexp filters are escaped with regexp.QuoteMeta.
+//
+// Metric names, label names and label values are obtained from mp. mp may be nil.
+// In this case only functions, modifiers and durations are suggested.
+//
+// Candidates are filtered by the partially typed token before the cursor and are ordered by their rank.
+func Complete(s string, cursor int, mp MetadataProvider) (*Completions, error) {
+	if cursor < 0 || cursor > len(s) {
+		return nil, fmt.Errorf("cursor must be in the range [0..%d]; got %d", len(s), cursor)
+	}
+	s = s[:cursor]
+	c := &completer{
+		mp: mp,
+	}
+	c.init(s)
+	if err := c.complete(); err != nil {
+		return nil, err
+	}
+	c.sortItems()
+	return &Completions{
+		Pos:   c.pos,
+		Items: c.items,
+	}, nil
+}
+
+// completer holds the state for Complete.
+type completer struct {
+	mp MetadataProvider
+
+	// head is the query part before the partially typed token.
+	head string
+
+	// headTokens contains tokens for head.
+	headTokens []Token
+
+	// prefix is the partially typed token before the cursor.
+	//
+	// It doesn't contain the opening quote for partially typed strings.
+	prefix string
+
+	// pos is the position of the partially typed token including the opening quote for strings.
+	pos Pos
+
+	items []*Completion
+}
+
+func (c *completer) init(s string) {
+	tokens, _ := Tokenize(s)
+	n := len(s)
+	if len(tokens) > 0 {
+		t := &tokens[len(tokens)-1]
+		switch t.Kind {
+		case TokenIdent, TokenKeyword, TokenFunc, TokenLabel, TokenNumber, TokenDuration:
+			n = t.Pos.Offset
+			c.prefix = t.S
+		case TokenInvalid:
+			if isStringPrefix(t.S) {
+				// Partially typed string
+				n = t.Pos.Offset
+				c.prefix = t.S[1:]
+			}
+		}
+	}
+	c.head = s[:n]
+	c.headTokens, _ = Tokenize(c.head)
+	var lex lexer
+	lex.Init(s)
+	c.pos = lex.Pos(n, len(s))
+}
+
+func (c *completer) complete() error {
+	expected := make(map[string]bool)
+	for _, token := range c.getExpected() {
+		expected[token] = true
+	}
+	if expected["("] && expected["{"] {
+		// The parser expects an expression.
+		c.addFuncs()
+		if err := c.addMetricNames(); err != nil {
+			return err
+		}
+	}
+	if expected["ident"] {
+		if err := c.addLabelNames(); err != nil {
+			return err
+		}
+	}
+	if expected["string"] {
+		if err := c.addLabelValues(); err != nil {
+			return err
+		}
+	}
+	if expected["duration"] {
+		c.addDurations()
+	}
+	c.addModifiers()
+	return nil
+}
+
+// getExpected returns the tokens expected by the parser at the end of c.head.
+func (c *completer) getExpected() []string {
+	_, errs := ParseTolerant(c.head)
+	for _, err := range errs {
+		if err.Token == "" && err.Pos.Offset == len(c.head) {
+			return err.Expected
+		}
+	}
+	return nil
+}
+
+// isAccepted returns true if the parser accepts the given token at the end of c.head.
+//
+// The token is accepted if the parser doesn't return errors for it. Errors after the token
+// are ignored, since the query may be incomplete.
+func (c *completer) isAccepted(token string) bool {
+	_, _, ok := c.parseProbe(token)
+	return ok
+}
+
+// parseProbe parses the given token appended to c.head.
+//
+// It returns the parsed expression, the offset of the token in the parsed query and true if the parser accepts the token.
+// See isAccepted for details.
+func (c *completer) parseProbe(token string) (Expr, int, bool) {
+	head := c.head
+	if len(head) > 0 && !isSpaceChar(head[len(head)-1]) {
+		head += " "
+	}
+	s := head + token
+	e, errs := ParseTolerant(s)
+	for _, err := range errs {
+		if err.Pos.Offset >= len(head) && err.Pos.Offset < len(s) {
+			return nil, 0, false
+		}
+	}
+	return e, len(head), true
+}
+
+// completionModifiers contains modifiers for completion.
+//
+// continuation is appended to the modifier when verifying whether the parser accepts it,
+// so the modifier isn't confused with a metric name or a function name.
+var completionModifiers = []struct {
+	name         string
+	suffix       string
+	continuation string
+}{
+	{"by", "(", "x)"},
+	{"without", "(", "x)"},
+	{"on", "(", "x)"},
+	{"ignoring", "(", "x)"},
+	{"group_left", "", "(x)"},
+	{"group_right", "", "(x)"},
+	{"bool", "", ""},
+	{"keep_metric_names", "", ""},
+	{"offset", "", " 5m"},
+	{"limit", "", " 1"},
+}
+
+func (c *completer) addModifiers() {
+	for _, m := range completionModifiers {
+		if !c.hasPrefix(m.name) {
+			continue
+		}
+		probe := m.name + m.suffix
+		e, offset, ok := c.parseProbe(probe + m.continuation)
+		if !ok || hasOperandAt(e, offset) {
+			continue
+		}
+		// Make sure the probe is recognized as a modifier and not as a metric name or a function name.
+		tokens, _ := Tokenize(c.head + " " + probe)
+		t := getPrevSignificantToken(tokens, len(tokens)-len(m.suffix))
+		if t == nil || t.Kind != TokenKeyword {
+			continue
+		}
+		c.addItem(CompletionModifier, m.name, "", "")
+	}
+}
+
+// hasOperandAt returns true if e contains series selector or function call starting at the given offset.
+//
+// This means the parser reads the token at the given offset as an operand instead of a modifier.
+func hasOperandAt(e Expr, offset int) bool {
+	found := false
+	VisitAll(e, func(expr Expr) {
+		switch expr.(type) {
+		case *MetricExpr, *FuncExpr:
+			if ExprPos(expr).Offset == offset {
+				found = true
+			}
+		}
+	})
+	return found
+}
+
+func (c *completer) addFuncs() {
+	for _, fs := range FuncSignatures() {
+		if fs.Name == "" {
+			// Skip the entry for functions without name such as `(a, b)` union.
+			continue
+		}
+		if c.hasPrefix(fs.Name) {
+			c.addItem(CompletionFunc, fs.Name, fs.String(), fs.Description)
+		}
+	}
+}
+
+func (c *completer) addMetricNames() error {
+	if c.mp == nil {
+		return nil
+	}
+	names, err := c.mp.MetricNames(c.prefix)
+	if err != nil {
+		return fmt.Errorf("cannot obtain metric names: %w", err)
+	}
+	for _, name := range names {
+		if c.hasPrefix(name) {
+			c.addItem(CompletionMetricName, string(appendEscapedIdent(nil, name)), "", "")
+		}
+	}
+	return nil
+}
+
+func (c *completer) addLabelNames() error {
+	if c.mp == nil {
+		return nil
+	}
+	// Verify whether the parser expects label name. It may expect WITH template name instead.
+	tokens, _ := Tokenize(c.head + `x=""`)
+	t := getNextSignificantToken(tokens, len(c.headTokens)-1)
+	if t == nil || t.Kind != TokenLabel {
+		return nil
+	}
+	lfs, _ := c.getLabelFilters()
+	names, err := c.mp.LabelNames(lfs)
+	if err != nil {
+		return fmt.Errorf("cannot obtain label names: %w", err)
+	}
+	for _, name := range names {
+		if c.hasPrefix(name) {
+			c.addItem(CompletionLabelName, string(appendEscapedIdent(nil, name)), "", "")
+		}
+	}
+	return nil
+}
+
+func (c *completer) addLabelValues() error {
+	if c.mp == nil {
+		return nil
+	}
+	lfs, lf := c.getLabelFilters()
+	if lf == nil {
+		// The parser expects string, which isn't a label value.
+		return nil
+	}
+	values, err := c.mp.LabelValues(lf.Label, lfs)
+	if err != nil {
+		return fmt.Errorf("cannot obtain values for label %q: %w", lf.Label, err)
+	}
+	for _, v := range values {
+		if lf.IsRegexp {
+			v = regexp.QuoteMeta(v)
+		}
+		if c.hasPrefix(v) {
+			c.addItem(CompletionLabelValue, strconv.Quote(v), "", "")
+		}
+	}
+	return nil
+}
+
+var completionDurations = []string{"1m", "5m", "10m", "15m", "30m", "1h", "3h", "6h", "12h", "1d", "7d", "30d"}
+
+func (c *completer) addDurations() {
+	for _, d := range completionDurations {
+		if c.hasPrefix(d) {
+			c.addItem(CompletionDuration, d, "", "")
+		}
+	}
+}
+
+// getLabelFilters returns label filters for the series selector at the end of c.head.
+//
+// The returned lfs contain the metric name and the complete label filters from the current or-delimited group.
+// If c.head ends with label filter operator, then the returned lf contains the filter with this operator,
+// while lfs contain the remaining filters. nil lfs are returned if c.head doesn't end inside series selector.
+func (c *completer) getLabelFilters() ([]LabelFilter, *LabelFilter) {
+	tokens := significantTokens(c.headTokens)
+
+	// Search for the opening brace of the current series selector.
+	start := -1
+	depth := 0
+	for i := len(tokens) - 1; i >= 0 && start < 0; i-- {
+		switch tokens[i].S {
+		case ")", "]", "}":
+			depth++
+		case "(", "[":
+			if depth == 0 {
+				return nil, nil
+			}
+			depth--
+		case "{":
+			if depth == 0 {
+				start = i
+			}
+			depth--
+		}
+	}
+	if start < 0 {
+		return nil, nil
+	}
+
+	var lfs []LabelFilter
+	if start > 0 && tokens[start-1].Kind == TokenIdent {
+		lfs = append(lfs, LabelFilter{
+			Label: "__name__",
+			Value: unescapeIdent(tokens[start-1].S),
+		})
+	}
+	tail := tokens[start+1:]
+	for i := 0; i < len(tail); i++ {
+		t := &tail[i]
+		if t.Kind == TokenOperator && strings.ToLower(t.S) == "or" {
+			// Start new or-delimited group.
+			lfs = lfs[:0]
+			if start > 0 && tokens[start-1].Kind == TokenIdent {
+				lfs = lfs[:1]
+			}
+			continue
+		}
+		if t.Kind != TokenLabel || i+1 >= len(tail) || tail[i+1].Kind != TokenOperator {
+			continue
+		}
+		op := tail[i+1].S
+		lf := LabelFilter{
+			Label:      unescapeIdent(t.S),
+			IsRegexp:   op == "=~" || op == "!~",
+			IsNegative: op == "!=" || op == "!~",
+		}
+		if i+2 == len(tail) {
+			// c.head ends with label filter operator.
+			return lfs, &lf
+		}
+		if tail[i+2].Kind != TokenString {
+			continue
+		}
+		v, err := extractStringValue(tail[i+2].S)
+		if err != nil {
+			continue
+		}
+		lf.Value = v
+		lfs = append(lfs, lf)
+		i += 2
+	}
+	return lfs, nil
+}
+
+func (c *completer) hasPrefix(s string) bool {
+	return strings.HasPrefix(strings.ToLower(s), strings.ToLower(c.prefix))
+}
+
+func (c *completer) addItem(kind CompletionKind, text, detail, description string) {
+	for _, item := range c.items {
+		if item.Kind == kind && item.Text == text {
+			return
+		}
+	}
+	c.items = append(c.items, &Completion{
+		Kind:        kind,
+		Text:        text,
+		Detail:      detail,
+		Description: description,
+	})
+}
+
+// sortItems orders c.items by their rank.
+//
+// Items matching the prefix with the same case go first. Then items are ordered by kind
+// and then alphabetically. Durations are ordered by their values.
+func (c *completer) sortItems() {
+	isExactPrefix := func(item *Completion) bool {
+		text := item.Text
+		if item.Kind == CompletionLabelValue {
+			text, _ = strconv.Unquote(text)
+		}
+		return strings.HasPrefix(text, c.prefix)
+	}
+	sort.SliceStable(c.items, func(i, j int) bool {
+		a, b := c.items[i], c.items[j]
+		if ea, eb := isExactPrefix(a), isExactPrefix(b); ea != eb {
+			return ea
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Kind == CompletionDuration {
+			da, _ := DurationValue(a.Text, 0)
+			db, _ := DurationValue(b.Text, 0)
+			return da < db
+		}
+		return a.Text < b.Text
+	})
+}
+
+func significantTokens(tokens []Token) []Token {
+	var dst []Token
+	for _, t := range tokens {
+		if isSignificantToken(&t) {
+			dst = append(dst, t)
+		}
+	}
+	return dst
+}
+
+// StaticMetadataProvider is MetadataProvider for the static set of series.
+//
+// It is intended for tests.
+type StaticMetadataProvider struct {
+	series []map[string]string
+}
+
+// NewStaticMetadataProvider returns StaticMetadataProvider for the given series.
+//
+// Metric name must be passed in `__name__` label.
+func NewStaticMetadataProvider(series []map[string]string) *StaticMetadataProvider {
+	return &StaticMetadataProvider{
+		series: series,
+	}
+}
+
+// MetricNames implements MetadataProvider interface.
+func (smp *StaticMetadataProvider) MetricNames(prefix string) ([]string, error) {
+	m := make(map[string]bool)
+	for _, labels := range smp.series {
+		if name := labels["__name__"]; name != "" && strings.HasPrefix(name, prefix) {
+			m[name] = true
+		}
+	}
+	return sortedKeys(m), nil
+}
+
+// LabelNames implements MetadataProvider interface.
+func (smp *StaticMetadataProvider) LabelNames(lfs []LabelFilter) ([]string, error) {
+	m := make(map[string]bool)
+	err := smp.forEachMatchingSeries(lfs, func(labels map[string]string) {
+		for name := range labels {
+			if name != "__name__" {
+				m[name] = true
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sortedKeys(m), nil
+}
+
+// LabelValues implements MetadataProvider interface.
+func (smp *StaticMetadataProvider) LabelValues(label string, lfs []LabelFilter) ([]string, error) {
+	m := make(map[string]bool)
+	err := smp.forEachMatchingSeries(lfs, func(labels map[string]string) {
+		if v, ok := labels[label]; ok {
+			m[v] = true
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sortedKeys(m), nil
+}
+
+func (smp *StaticMetadataProvider) forEachMatchingSeries(lfs []LabelFilter, f func(labels map[string]string)) error {
+	for _, labels := range smp.series {
+		ok, err := matchLabelFilters(lfs, labels)
+		if err != nil {
+			return err
+		}
+		if ok {
+			f(labels)
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	a := make([]string, 0, len(m))
+	for k := range m {
+		a = append(a, k)
+	}
+	sort.Strings(a)
+	return a
+}
@@ -0,0 +1,213 @@
+package metricsql
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestComplete(t *testing.T) {
+	mp := NewStaticMetadataProvider([]map[string]string{
+		{"__name__": "http_requests_total", "job": "api", "instance": "host1", "code": "200"},
+		{"__name__": "http_requests_total", "job": "api", "instance": "host2", "code": "500"},
+		{"__name__": "http_request_duration_seconds", "job": "web", "instance": "host1"},
+		{"__name__": "process_cpu_seconds_total", "job": "db", "instance": "host3", "path": "/a.b"},
+		{"__name__": "Summary_total"},
+	})
+	f := func(s string, resultExpected string) {
+		t.Helper()
+
+		cursor := strings.Index(s, "|")
+		if cursor < 0 {
+			t.Fatalf("missing cursor in %q", s)
+		}
+		s = s[:cursor] + s[cursor+1:]
+		cs, err := Complete(s, cursor, mp)
+		if err != nil {
+			t.Fatalf("unexpected error in Complete(%q, %d): %s", s, cursor, err)
+		}
+		var a []string
+		for _, item := range cs.Items {
+			a = append(a, fmt.Sprintf("%s:%s", item.Kind, item.Text))
+		}
+		result := strings.Join(a, " ")
+		if result != resultExpected {
+			t.Fatalf("unexpected completions for %q at %d;\ngot\n%s\nwant\n%s", s, cursor, result, resultExpected)
+		}
+	}
+
+	// functions and metric names
+	f(`http|`, `metric_name:http_request_duration_seconds metric_name:http_requests_total`)
+	f(`rate(http_req|`, `metric_name:http_request_duration_seconds metric_name:http_requests_total`)
+	f(`histogram_q|`, `func:histogram_quantile func:histogram_quantiles`)
+	f(`sum(rate(proc|[5m]))`, `metric_name:process_cpu_seconds_total`)
+	f(`foo + proc|`, `metric_name:process_cpu_seconds_total`)
+	f(`sum(foo) by (job) (pro|`, ``)
+	f(`foo pro|`, ``)
+
+	// modifiers
+	f(`sum(foo) |`, `modifier:by modifier:limit modifier:offset modifier:without`)
+	f(`sum |`, `modifier:by modifier:offset modifier:without`)
+	f(`sum(foo) b|`, `modifier:by`)
+	f(`foo o|`, `modifier:offset`)
+	f(`foo / o|`, `modifier:on func:outliers_mad func:outliersk`)
+	f(`rate(foo[5m]) k|`, `modifier:keep_metric_names`)
+	f(`foo k|`, ``)
+	f(`foo + on(job) g|`, `modifier:group_left modifier:group_right func:geomean func:geomean_over_time func:group`)
+	f(`foo + g|`, `func:geomean func:geomean_over_time func:group`)
+	f(`foo > b|`, `modifier:bool func:bitmap_and func:bitmap_or func:bitmap_xor func:bottomk func:bottomk_avg func:bottomk_last func:bottomk_max func:bottomk_median func:bottomk_min func:buckets_limit`)
+	f(`foo + b|`, `func:bitmap_and func:bitmap_or func:bitmap_xor func:bottomk func:bottomk_avg func:bottomk_last func:bottomk_max func:bottomk_median func:bottomk_min func:buckets_limit`)
+
+	// durations
+	f(`rate(foo[|`, `duration:1m duration:5m duration:10m duration:15m duration:30m duration:1h duration:3h duration:6h duration:12h duration:1d duration:7d duration:30d`)
+	f(`rate(foo[1|`, `duration:1m duration:10m duration:15m duration:1h duration:12h duration:1d`)
+	f(`rate(foo[5m:3|`, `duration:30m duration:3h duration:30d`)
+	f(`foo offset 1|`, `duration:1m duration:10m duration:15m duration:1h duration:12h duration:1d`)
+	f(`foo[5m] offset 7|`, `duration:7d`)
+
+	// label names
+	f(`http_requests_total{|`, `label_name:code label_name:instance label_name:job`)
+	f(`http_requests_total{c|`, `label_name:code`)
+	f(`{j|`, `label_name:job`)
+	f(`process_cpu_seconds_total{job="db", |`, `label_name:instance label_name:job label_name:path`)
+	f(`sum(foo) by (|`, `label_name:code label_name:instance label_name:job label_name:path`)
+	f(`sum(foo) by (job, in|`, `label_name:instance`)
+	f(`foo / on(in|`, `label_name:instance`)
+	f(`with (|`, ``)
+
+	// label values
+	f(`http_requests_total{job=|`, `label_value:"api"`)
+	f(`foo{job=|`, ``)
+	f(`{job=|`, `label_value:"api" label_value:"db" label_value:"web"`)
+	f(`{job!="api", job=~"w|`, `label_value:"web"`)
+	f(`{job="api", instance=|`, `label_value:"host1" label_value:"host2"`)
+	f(`{job="api" or instance=|`, `label_value:"host1" label_value:"host2" label_value:"host3"`)
+	f(`{path=~|`, `label_value:"/a\\.b"`)
+	f(`{job="|`, `label_value:"api" label_value:"db" label_value:"web"`)
+
+	// ranking
+	f(`{job=~"A|`, `label_value:"api"`)
+	f(`Sum|`, `metric_name:Summary_total func:sum func:sum2 func:sum2_over_time func:sum_over_time`)
+}
+
+func TestCompleteOperandPositions(t *testing.T) {
+	f := func(s string, modifiersExpected string) {
+		t.Helper()
+
+		cs, err := Complete(s, len(s), nil)
+		if err != nil {
+			t.Fatalf("unexpected error in Complete(%q): %s", s, err)
+		}
+		var modifiers []string
+		funcs := 0
+		for _, item := range cs.Items {
+			if item.Text == "" {
+				t.Fatalf("unexpected empty completion for %q: %+v", s, item)
+			}
+			switch item.Kind {
+			case CompletionModifier:
+				modifiers = append(modifiers, item.Text)
+			case CompletionFunc:
+				funcs++
+			}
+		}
+		if funcs == 0 {
+			t.Fatalf("expecting function completions for %q", s)
+		}
+		result := strings.Join(modifiers, " ")
+		if result != modifiersExpected {
+			t.Fatalf("unexpected modifiers for %q; got %q; want %q", s, result, modifiersExpected)
+		}
+	}
+
+	// The parser expects an operand at these positions, so modifiers, which would be read as metric names, aren't suggested.
+	f(``, ``)
+	f(`with (x = up) `, ``)
+	f(`foo + `, `ignoring on`)
+
+	// group_left and group_right may follow on(...), while offset, limit and keep_metric_names may not.
+	f(`foo + on(a) `, `group_left group_right`)
+}
+
+func TestCompleteAllOffsets(t *testing.T) {
+	f := func(s string) {
+		t.Helper()
+
+		for i := 0; i <= len(s); i++ {
+			if _, err := Complete(s, i, nil); err != nil {
+				t.Fatalf("unexpected error in Complete(%q, %d): %s", s, i, err)
+			}
+			if _, err := Complete(s[:i], i, nil); err != nil {
+				t.Fatalf("unexpected error in Complete(%q, %d): %s", s[:i], i, err)
+			}
+		}
+	}
+
+	// String templates cannot be used in modifiers, but Complete must not crash on them.
+	f(`with (x="a", y=x) y+"bc"`)
+	f(`with (x="a") sum(foo) by (x) + on(x) bar`)
+}
+
+func TestCompletePos(t *testing.T) {
+	f := func(s string, cursor int, posExpected Pos) {
+		t.Helper()
+
+		cs, err := Complete(s, cursor, nil)
+		if err != nil {
+			t.Fatalf("unexpected error in Complete(%q, %d): %s", s, cursor, err)
+		}
+		if cs.Pos != posExpected {
+			t.Fatalf("unexpected position for Complete(%q, %d); got %+v; want %+v", s, cursor, cs.Pos, posExpected)
+		}
+	}
+
+	f(``, 0, Pos{Offset: 0, End: 0, Line: 1, Column: 1})
+	f(`rate(foo[5m])`, 2, Pos{Offset: 0, End: 2, Line: 1, Column: 1})
+	f("foo +\n  rat", 11, Pos{Offset: 8, End: 11, Line: 2, Column: 3})
+	f(`{job="ap`, 8, Pos{Offset: 5, End: 8, Line: 1, Column: 6})
+	f(`sum(foo) `, 9, Pos{Offset: 9, End: 9, Line: 1, Column: 10})
+}
+
+func TestCompleteError(t *testing.T) {
+	f := func(s string, cursor int) {
+		t.Helper()
+
+		cs, err := Complete(s, cursor, nil)
+		if err == nil {
+			t.Fatalf("expecting non-nil error for Complete(%q, %d); got %+v", s, cursor, cs)
+		}
+	}
+
+	f(`foo`, -1)
+	f(`foo`, 4)
+}
+
+func TestStaticMetadataProvider(t *testing.T) {
+	mp := NewStaticMetadataProvider([]map[string]string{
+		{"__name__": "foo", "job": "a"},
+		{"__name__": "foo", "job": "b", "x": "y"},
+		{"__name__": "bar", "job": "c"},
+	})
+	names, err := mp.MetricNames("f")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if s := strings.Join(names, ","); s != "foo" {
+		t.Fatalf("unexpected metric names; got %q; want %q", s, "foo")
+	}
+	lfs := []LabelFilter{{Label: "__name__", Value: "foo"}}
+	names, err = mp.LabelNames(lfs)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if s := strings.Join(names, ","); s != "job,x" {
+		t.Fatalf("unexpected label names; got %q; want %q", s, "job,x")
+	}
+	values, err := mp.LabelValues("job", lfs)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if s := strings.Join(values, ","); s != "a,b" {
+		t.Fatalf("unexpected label values; got %q; want %q", s, "a,b")
+	}
+}
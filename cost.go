@@ -0,0 +1,366 @@
+package metricsql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CardinalityProvider provides series statistics for EstimateCost.
+type CardinalityProvider interface {
+	// SeriesCount returns the number of series matching all the given lfs with samples on the time range [start, end].
+	//
+	// start and end are in milliseconds.
+	SeriesCount(lfs []LabelFilter, start, end int64) (int64, error)
+
+	// SamplesInterval returns the average interval in milliseconds between raw samples for series matching lfs.
+	//
+	// This is usually the scrape interval for the matching series.
+	SamplesInterval(lfs []LabelFilter) int64
+}
+
+// Cost contains the estimated cost for the query returned by EstimateCost.
+type Cost struct {
+	// Series is the number of series touched by all the series selectors in the query.
+	Series int64
+
+	// Samples is the number of raw samples read by all the series selectors in the query.
+	Samples int64
+
+	// OutputSeries is the estimated number of series returned by the query.
+	//
+	// It is an upper bound for aggregations with non-empty `by` and `without` modifiers,
+	// since the number of groups cannot be determined without reading the series.
+	OutputSeries int64
+
+	// Selectors contains the cost for every series selector in the query in the order of their appearance.
+	Selectors []*SelectorCost
+}
+
+// SelectorCost contains the estimated cost for a single series selector.
+type SelectorCost struct {
+	// Expr is the series selector.
+	Expr *MetricExpr
+
+	// Start and End is the time range in milliseconds for the raw samples read by the selector.
+	//
+	// The range includes the lookbehind window and takes into account `offset` and `@` modifiers.
+	Start int64
+	End   int64
+
+	// Window is the lookbehind window in milliseconds for the selector.
+	Window int64
+
+	// Points is the number of points the selector is evaluated at.
+	Points int64
+
+	// Series is the number of series matching the selector.
+	Series int64
+
+	// Samples is the number of raw samples read by the selector.
+	Samples int64
+}
+
+// EstimateCost estimates the cost for evaluating e on the time range [start, end] with the given step.
+//
+// start, end and step must be in milliseconds. The number of series for every series selector in e
+// is obtained from cp. The number of raw samples is estimated from the number of series,
+// the samples interval, the lookbehind window and the number of points the selector is evaluated at.
+// Subqueries evaluate the inner query on the time range extended by the subquery window with the subquery step,
+// while `offset` and `@` modifiers shift the time range.
+//
+// Series selectors without window such as `foo` use 5 minutes lookbehind window,
+// while rollup functions without window such as `rate(foo)` use step as the window.
+func EstimateCost(e Expr, start, end, step int64, cp CardinalityProvider) (*Cost, error) {
+	if step <= 0 {
+		return nil, fmt.Errorf("step must be positive; got %dms", step)
+	}
+	if start > end {
+		return nil, fmt.Errorf("start cannot exceed end; got start=%d, end=%d", start, end)
+	}
+	ce := &costEstimator{
+		cp:         cp,
+		queryStart: start,
+		queryEnd:   end,
+		cost:       &Cost{},
+	}
+	tr := evalTimeRange{
+		start: start,
+		end:   end,
+		step:  step,
+	}
+	n, err := ce.estimate(e, tr)
+	if err != nil {
+		return nil, err
+	}
+	ce.cost.OutputSeries = n
+	return ce.cost, nil
+}
+
+type costEstimator struct {
+	cp CardinalityProvider
+
+	// queryStart and queryEnd contain the original time range for the query.
+	//
+	// They are used for `@ start()` and `@ end()` modifiers.
+	queryStart int64
+	queryEnd   int64
+
+	cost *Cost
+}
+
+// estimate registers the cost for e evaluated on tr and returns the estimated number of output series for e.
+func (ce *costEstimator) estimate(e Expr, tr evalTimeRange) (int64, error) {
+	switch t := e.(type) {
+	case *MetricExpr:
+		return ce.estimateSelector(t, tr, defaultLookbackDelta)
+	case *RollupExpr:
+		return ce.estimateRollupExpr(t, tr, defaultLookbackDelta)
+	case *FuncExpr:
+		return ce.estimateFuncExpr(t, tr)
+	case *AggrFuncExpr:
+		return ce.estimateAggrFuncExpr(t, tr)
+	case *BinaryOpExpr:
+		return ce.estimateBinaryOpExpr(t, tr)
+	case *NumberExpr, *DurationExpr:
+		return 1, nil
+	case *StringExpr:
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("cannot estimate cost for %T: %s", e, e.AppendString(nil))
+	}
+}
+
+func (ce *costEstimator) estimateSelector(me *MetricExpr, tr evalTimeRange, window int64) (int64, error) {
+	sc := &SelectorCost{
+		Expr:   me,
+		Start:  tr.start - window,
+		End:    tr.end,
+		Window: window,
+		Points: tr.pointsLen(),
+	}
+	// Raw samples are read once for overlapping windows, while gaps between non-overlapping windows are skipped.
+	covered := tr.end - tr.start + window
+	if n := sc.Points * window; n < covered {
+		covered = n
+	}
+	lfss := me.LabelFilterss
+	if len(lfss) == 0 {
+		lfss = [][]LabelFilter{nil}
+	}
+	for _, lfs := range lfss {
+		n, err := ce.cp.SeriesCount(lfs, sc.Start, sc.End)
+		if err != nil {
+			return 0, fmt.Errorf("cannot obtain the number of series for %s: %w", me.AppendString(nil), err)
+		}
+		interval := ce.cp.SamplesInterval(lfs)
+		if interval <= 0 {
+			return 0, fmt.Errorf("samples interval for %s must be positive; got %dms", me.AppendString(nil), interval)
+		}
+		sc.Series += n
+		sc.Samples += n * (covered / interval)
+	}
+	ce.cost.Selectors = append(ce.cost.Selectors, sc)
+	ce.cost.Series += sc.Series
+	ce.cost.Samples += sc.Samples
+	return sc.Series, nil
+}
+
+// estimateRollupExpr estimates the cost for re, which uses defaultWindow if re has no explicit window.
+func (ce *costEstimator) estimateRollupExpr(re *RollupExpr, tr evalTimeRange, defaultWindow int64) (int64, error) {
+	if re.At != nil {
+		if _, err := ce.estimate(re.At, tr); err != nil {
+			return 0, err
+		}
+	}
+	rtr := getRollupTimeRange(re, tr, defaultWindow, ce.queryStart, ce.queryEnd)
+	if me, ok := re.Expr.(*MetricExpr); ok && !re.ForSubquery() {
+		return ce.estimateSelector(me, rtr.tr, rtr.window)
+	}
+	return ce.estimate(re.Expr, rtr.innerTR)
+}
+
+func (ce *costEstimator) estimateFuncExpr(fe *FuncExpr, tr evalTimeRange) (int64, error) {
+	rollupArgIdx := GetRollupArgIdx(fe)
+	var result int64
+	for i, arg := range fe.Args {
+		var n int64
+		var err error
+		if i == rollupArgIdx {
+			// Rollup functions without explicit window use step as the window.
+			switch t := arg.(type) {
+			case *MetricExpr:
+				n, err = ce.estimateSelector(t, tr, tr.step)
+			case *RollupExpr:
+				n, err = ce.estimateRollupExpr(t, tr, tr.step)
+			default:
+				n, err = ce.estimate(arg, tr)
+			}
+			if err != nil {
+				return 0, err
+			}
+			result = n
+			continue
+		}
+		n, err = ce.estimate(arg, tr)
+		if err != nil {
+			return 0, err
+		}
+		if rollupArgIdx < 0 && isVectorExpr(arg) && n > result {
+			result = n
+		}
+	}
+	if !isVectorExpr(fe) {
+		return 1, nil
+	}
+	return result, nil
+}
+
+func (ce *costEstimator) estimateAggrFuncExpr(ae *AggrFuncExpr, tr evalTimeRange) (int64, error) {
+	var input int64
+	for _, arg := range ae.Args {
+		n, err := ce.estimate(arg, tr)
+		if err != nil {
+			return 0, err
+		}
+		if isVectorExpr(arg) {
+			input += n
+		}
+	}
+	groups := input
+	if ae.Modifier.Op == "" || strings.EqualFold(ae.Modifier.Op, "by") && len(ae.Modifier.Args) == 0 {
+		// All the input series are aggregated into a single group.
+		groups = 1
+	}
+	if ae.Limit > 0 && int64(ae.Limit) < groups {
+		groups = int64(ae.Limit)
+	}
+	result := groups
+	if k, ok := getAggrFuncK(ae); ok {
+		result = groups * k
+	}
+	if result > input {
+		result = input
+	}
+	return result, nil
+}
+
+// getAggrFuncK returns k for aggregate functions returning up to k series per group such as `topk(k, ...)`.
+func getAggrFuncK(ae *AggrFuncExpr) (int64, bool) {
+	name := strings.ToLower(ae.Name)
+	if !strings.HasPrefix(name, "topk") && !strings.HasPrefix(name, "bottomk") && name != "limitk" && name != "outliersk" {
+		return 0, false
+	}
+	if len(ae.Args) == 0 {
+		return 0, false
+	}
+	ne, ok := ae.Args[0].(*NumberExpr)
+	if !ok || ne.N < 0 {
+		return 0, false
+	}
+	return int64(ne.N), true
+}
+
+func (ce *costEstimator) estimateBinaryOpExpr(be *BinaryOpExpr, tr evalTimeRange) (int64, error) {
+	left, err := ce.estimate(be.Left, tr)
+	if err != nil {
+		return 0, err
+	}
+	right, err := ce.estimate(be.Right, tr)
+	if err != nil {
+		return 0, err
+	}
+	leftIsVector := isVectorExpr(be.Left)
+	rightIsVector := isVectorExpr(be.Right)
+	switch {
+	case !leftIsVector && !rightIsVector:
+		return 1, nil
+	case !rightIsVector:
+		return left, nil
+	case !leftIsVector:
+		return right, nil
+	}
+	switch strings.ToLower(be.Op) {
+	case "or":
+		return left + right, nil
+	case "and", "unless", "default", "if", "ifnot":
+		return left, nil
+	}
+	switch strings.ToLower(be.JoinModifier.Op) {
+	case "group_left":
+		return left, nil
+	case "group_right":
+		return right, nil
+	}
+	if left < right {
+		return left, nil
+	}
+	return right, nil
+}
+
+// isVectorExpr returns true if e returns instant vector or range vector.
+func isVectorExpr(e Expr) bool {
+	k, ok := ExprType(e)
+	return !ok || k.IsVector()
+}
+
+// StaticCardinalityProvider is CardinalityProvider for the static set of series.
+//
+// It is intended for tests. All the series are assumed to have samples on any time range.
+type StaticCardinalityProvider struct {
+	series          []map[string]string
+	samplesInterval int64
+}
+
+// NewStaticCardinalityProvider returns StaticCardinalityProvider for the given series with the given samplesInterval in milliseconds.
+//
+// Metric name must be passed in `__name__` label.
+func NewStaticCardinalityProvider(series []map[string]string, samplesInterval int64) *StaticCardinalityProvider {
+	return &StaticCardinalityProvider{
+		series:          series,
+		samplesInterval: samplesInterval,
+	}
+}
+
+// SeriesCount implements CardinalityProvider interface.
+func (scp *StaticCardinalityProvider) SeriesCount(lfs []LabelFilter, start, end int64) (int64, error) {
+	var n int64
+	for _, labels := range scp.series {
+		ok, err := matchLabelFilters(lfs, labels)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// SamplesInterval implements CardinalityProvider interface.
+func (scp *StaticCardinalityProvider) SamplesInterval(lfs []LabelFilter) int64 {
+	return scp.samplesInterval
+}
+
+// matchLabelFilters returns true if labels match all the lfs.
+//
+// Missing labels are equivalent to labels with empty values.
+func matchLabelFilters(lfs []LabelFilter, labels map[string]string) (bool, error) {
+	for i := range lfs {
+		lf := &lfs[i]
+		v := labels[lf.Label]
+		var ok bool
+		if lf.IsRegexp {
+			re, err := CompileRegexpAnchored(lf.Value)
+			if err != nil {
+				return false, fmt.Errorf("cannot compile regexp for %s: %w", lf.AppendString(nil), err)
+			}
+			ok = re.MatchString(v)
+		} else {
+			ok = v == lf.Value
+		}
+		if ok == lf.IsNegative {
+			return false, nil
+		}
+	}
+	return true, nil
+}
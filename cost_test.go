@@ -0,0 +1,165 @@
+package metricsql
+
+import (
+	"testing"
+)
+
+func newTestCardinalityProvider() *StaticCardinalityProvider {
+	var series []map[string]string
+	for _, job := range []string{"api", "web"} {
+		for _, instance := range []string{"a", "b", "c", "d", "e"} {
+			series = append(series, map[string]string{
+				"__name__": "requests_total",
+				"job":      job,
+				"instance": instance,
+			})
+		}
+	}
+	for _, instance := range []string{"a", "b"} {
+		series = append(series, map[string]string{
+			"__name__": "up",
+			"instance": instance,
+		})
+	}
+	// 15 seconds scrape interval
+	return NewStaticCardinalityProvider(series, 15e3)
+}
+
+func TestEstimateCost(t *testing.T) {
+	cp := newTestCardinalityProvider()
+	f := func(s string, start, end, step, seriesExpected, samplesExpected, outputSeriesExpected int64) {
+		t.Helper()
+
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %s: %s", s, err)
+		}
+		c, err := EstimateCost(e, start, end, step, cp)
+		if err != nil {
+			t.Fatalf("unexpected error in EstimateCost(%s): %s", s, err)
+		}
+		if c.Series != seriesExpected {
+			t.Fatalf("unexpected series for %s; got %d; want %d", s, c.Series, seriesExpected)
+		}
+		if c.Samples != samplesExpected {
+			t.Fatalf("unexpected samples for %s; got %d; want %d", s, c.Samples, samplesExpected)
+		}
+		if c.OutputSeries != outputSeriesExpected {
+			t.Fatalf("unexpected output series for %s; got %d; want %d", s, c.OutputSeries, outputSeriesExpected)
+		}
+	}
+
+	const hour = 3600e3
+
+	// instant queries
+	f(`1`, 0, 0, 60e3, 0, 0, 1)
+	f(`up`, hour, hour, 60e3, 2, 2*20, 2)
+	f(`requests_total{job="api"}`, hour, hour, 60e3, 5, 5*20, 5)
+	f(`requests_total{job=~"api|web",instance!="a"}`, hour, hour, 60e3, 8, 8*20, 8)
+	f(`{job="api" or __name__="up"}`, hour, hour, 60e3, 7, 7*20, 7)
+	f(`rate(requests_total[1h])`, hour, hour, 60e3, 10, 10*240, 10)
+	f(`rate(requests_total)`, hour, hour, 60e3, 10, 10*4, 10)
+
+	// range queries - overlapping windows read raw samples only once
+	f(`rate(requests_total[5m])`, 0, hour, 60e3, 10, 10*260, 10)
+	f(`rate(requests_total[1h])`, 0, hour, 60e3, 10, 10*480, 10)
+
+	// range queries - gaps between non-overlapping windows aren't read
+	f(`rate(requests_total[1m])`, 0, hour, 10*60e3, 10, 10*7*4, 10)
+
+	// subqueries
+	f(`max_over_time(rate(requests_total[5m])[1h:5m])`, hour, hour, 60e3, 10, 10*260, 10)
+	f(`max_over_time(rate(requests_total[1m])[1h:10m])`, hour, hour, 60e3, 10, 10*7*4, 10)
+	f(`max_over_time(rate(requests_total[1m])[1h:])`, hour, hour, 10*60e3, 10, 10*7*4, 10)
+
+	// offset and @ modifiers
+	f(`up offset 1h`, 2*hour, 2*hour, 60e3, 2, 2*20, 2)
+	f(`rate(requests_total[1h] @ 3600)`, 0, 10*hour, 60e3, 10, 10*240, 10)
+	f(`rate(requests_total[1h] @ end())`, 0, 10*hour, 60e3, 10, 10*240, 10)
+
+	// aggregations
+	f(`sum(rate(requests_total[5m]))`, hour, hour, 60e3, 10, 10*20, 1)
+	f(`sum(rate(requests_total[5m])) by ()`, hour, hour, 60e3, 10, 10*20, 1)
+	f(`sum(rate(requests_total[5m])) by (job)`, hour, hour, 60e3, 10, 10*20, 10)
+	f(`sum(rate(requests_total[5m])) by (job) limit 2`, hour, hour, 60e3, 10, 10*20, 2)
+	f(`sum(rate(requests_total[5m])) without (instance)`, hour, hour, 60e3, 10, 10*20, 10)
+	f(`topk(3, requests_total)`, hour, hour, 60e3, 10, 10*20, 3)
+	f(`topk(3, requests_total) by (job) limit 2`, hour, hour, 60e3, 10, 10*20, 6)
+	f(`topk(30, requests_total)`, hour, hour, 60e3, 10, 10*20, 10)
+
+	// transform functions
+	f(`abs(up)`, hour, hour, 60e3, 2, 2*20, 2)
+	f(`scalar(up)`, hour, hour, 60e3, 2, 2*20, 1)
+	f(`time()`, 0, hour, 60e3, 0, 0, 1)
+	f(`histogram_quantile(0.9, sum(rate(requests_total[5m])) by (le))`, hour, hour, 60e3, 10, 10*20, 10)
+
+	// binary operations
+	f(`up * 2`, hour, hour, 60e3, 2, 2*20, 2)
+	f(`2 * up`, hour, hour, 60e3, 2, 2*20, 2)
+	f(`requests_total / up`, hour, hour, 60e3, 12, 12*20, 2)
+	f(`requests_total / on(instance) group_left() up`, hour, hour, 60e3, 12, 12*20, 10)
+	f(`up / on(instance) group_right() requests_total`, hour, hour, 60e3, 12, 12*20, 10)
+	f(`requests_total or up`, hour, hour, 60e3, 12, 12*20, 12)
+	f(`requests_total unless up`, hour, hour, 60e3, 12, 12*20, 10)
+}
+
+func TestEstimateCostSelectors(t *testing.T) {
+	cp := newTestCardinalityProvider()
+	e, err := Parse(`rate(requests_total{job="api"}[10m] offset 1h) / up`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c, err := EstimateCost(e, 5*3600e3, 6*3600e3, 60e3, cp)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(c.Selectors) != 2 {
+		t.Fatalf("unexpected number of selectors; got %d; want 2", len(c.Selectors))
+	}
+
+	sc := c.Selectors[0]
+	if s := string(sc.Expr.AppendString(nil)); s != `requests_total{job="api"}` {
+		t.Fatalf("unexpected selector; got %s", s)
+	}
+	if sc.Start != 4*3600e3-600e3 || sc.End != 5*3600e3 {
+		t.Fatalf("unexpected time range; got [%d, %d]", sc.Start, sc.End)
+	}
+	if sc.Window != 600e3 || sc.Points != 61 || sc.Series != 5 || sc.Samples != 5*280 {
+		t.Fatalf("unexpected cost; got window=%d, points=%d, series=%d, samples=%d", sc.Window, sc.Points, sc.Series, sc.Samples)
+	}
+
+	sc = c.Selectors[1]
+	if s := string(sc.Expr.AppendString(nil)); s != `up` {
+		t.Fatalf("unexpected selector; got %s", s)
+	}
+	if sc.Start != 6*3600e3-defaultLookbackDelta-3600e3 || sc.End != 6*3600e3 {
+		t.Fatalf("unexpected time range; got [%d, %d]", sc.Start, sc.End)
+	}
+	if sc.Window != defaultLookbackDelta || sc.Series != 2 {
+		t.Fatalf("unexpected cost; got window=%d, series=%d", sc.Window, sc.Series)
+	}
+}
+
+func TestEstimateCostError(t *testing.T) {
+	f := func(s string, start, end, step int64, cp CardinalityProvider) {
+		t.Helper()
+
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %s: %s", s, err)
+		}
+		c, err := EstimateCost(e, start, end, step, cp)
+		if err == nil {
+			t.Fatalf("expecting non-nil error for %s; got %+v", s, c)
+		}
+	}
+
+	cp := newTestCardinalityProvider()
+
+	// invalid time range
+	f(`up`, 0, 0, 0, cp)
+	f(`up`, 10, 0, 1, cp)
+
+	// invalid samples interval
+	f(`up`, 0, 0, 1, NewStaticCardinalityProvider(nil, 0))
+}
@@ -0,0 +1,370 @@
+package metricsql
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Confidence is the confidence for the answer returned by Covers.
+type Confidence int
+
+const (
+	// ConfidenceCertain means that the answer is proved.
+	ConfidenceCertain Confidence = iota
+
+	// ConfidenceUncertain means that the answer cannot be proved, so the conservative answer is returned.
+	//
+	// For example, Covers returns false with ConfidenceUncertain for `{x=~"a.+b"}` and `{x=~"a.*b"}`,
+	// since the inclusion for arbitrary regexps isn't checked.
+	ConfidenceUncertain
+)
+
+// String returns string representation for c.
+func (c Confidence) String() string {
+	switch c {
+	case ConfidenceCertain:
+		return "certain"
+	case ConfidenceUncertain:
+		return "uncertain"
+	default:
+		return fmt.Sprintf("Confidence(%d)", int(c))
+	}
+}
+
+// Covers returns true if the series selector a matches all the series matched by the series selector b.
+//
+// For example, `foo{job=~"api|web"}` covers `foo{job="api",instance="x"}`, while `foo{job="api"}` doesn't cover `foo`.
+//
+// Equality, negative and regexp filters are supported. The inclusion for regexp filters is checked if they match
+// finite sets of literal values such as `api|web`, or literal prefixes and suffixes such as `10\.0\..*` and `.*\.example\.com`.
+// Otherwise, the conservative answer false is returned with ConfidenceUncertain.
+//
+// Missing labels are equivalent to labels with empty values. Selectors with or-delimited groups of filters
+// are supported, but b may be reported as not covered with ConfidenceUncertain if it is covered only by a union
+// of multiple groups from a.
+func Covers(a, b *MetricExpr) (bool, Confidence) {
+	aGroups := getLabelFilterGroups(a)
+	confidence := ConfidenceCertain
+	for _, bGroup := range getLabelFilterGroups(b) {
+		ok, c := groupsCoverGroup(aGroups, bGroup)
+		if ok {
+			continue
+		}
+		if c == ConfidenceCertain {
+			return false, ConfidenceCertain
+		}
+		confidence = ConfidenceUncertain
+	}
+	if confidence != ConfidenceCertain {
+		return false, confidence
+	}
+	return true, ConfidenceCertain
+}
+
+// Intersect returns the series selector, which matches series matched by both a and b.
+//
+// Redundant filters and or-delimited groups of filters, which cannot match any series, are removed
+// from the result. See SimplifyLabelFilters. Nil is returned if the result cannot match any series.
+func Intersect(a, b *MetricExpr) *MetricExpr {
+	var lfss [][]LabelFilter
+	for _, aGroup := range getLabelFilterGroups(a) {
+		for _, bGroup := range getLabelFilterGroups(b) {
+			lfs := append(append([]LabelFilter{}, aGroup...), bGroup...)
+			if len(lfs) == 0 {
+				continue
+			}
+			lfs, reason := simplifyLabelFilters(lfs)
+			if reason != "" {
+				continue
+			}
+			lfss = append(lfss, lfs)
+		}
+	}
+	lfss = removeCoveredLabelFilterGroups(lfss)
+	if len(lfss) == 0 {
+		return nil
+	}
+	return &MetricExpr{
+		LabelFilterss: lfss,
+	}
+}
+
+// Union returns the series selector, which matches series matched by either a or b.
+//
+// Or-delimited groups of filters, which are covered by other groups, are removed from the result.
+func Union(a, b *MetricExpr) *MetricExpr {
+	var lfss [][]LabelFilter
+	for _, lfs := range append(append([][]LabelFilter{}, a.LabelFilterss...), b.LabelFilterss...) {
+		lfss = append(lfss, append([]LabelFilter{}, lfs...))
+	}
+	return &MetricExpr{
+		LabelFilterss: removeCoveredLabelFilterGroups(lfss),
+	}
+}
+
+// getLabelFilterGroups returns or-delimited groups of filters for me.
+//
+// A single empty group matching all the series is returned for me without filters.
+func getLabelFilterGroups(me *MetricExpr) [][]LabelFilter {
+	if len(me.LabelFilterss) == 0 {
+		return [][]LabelFilter{nil}
+	}
+	return me.LabelFilterss
+}
+
+// removeCoveredLabelFilterGroups removes groups, which are covered by other groups in lfss.
+func removeCoveredLabelFilterGroups(lfss [][]LabelFilter) [][]LabelFilter {
+	removed := make([]bool, len(lfss))
+	for i, lfs := range lfss {
+		for j, other := range lfss {
+			if i == j || removed[j] {
+				continue
+			}
+			if ok, c := groupCoversGroup(other, lfs); ok && c == ConfidenceCertain {
+				removed[i] = true
+				break
+			}
+		}
+	}
+	var dst [][]LabelFilter
+	for i, lfs := range lfss {
+		if !removed[i] {
+			dst = append(dst, lfs)
+		}
+	}
+	return dst
+}
+
+// groupsCoverGroup returns true if the union of aGroups matches all the series matched by bGroup.
+func groupsCoverGroup(aGroups [][]LabelFilter, bGroup []LabelFilter) (bool, Confidence) {
+	if _, reason := simplifyLabelFilters(bGroup); reason != "" {
+		// bGroup cannot match any series.
+		return true, ConfidenceCertain
+	}
+	confidence := ConfidenceCertain
+	for _, aGroup := range aGroups {
+		ok, c := groupCoversGroup(aGroup, bGroup)
+		if ok {
+			return true, ConfidenceCertain
+		}
+		if c != ConfidenceCertain {
+			confidence = ConfidenceUncertain
+		}
+	}
+	if len(aGroups) > 1 {
+		// bGroup may be covered by the union of aGroups.
+		confidence = ConfidenceUncertain
+	}
+	return false, confidence
+}
+
+// groupCoversGroup returns true if aGroup matches all the series matched by bGroup.
+func groupCoversGroup(aGroup, bGroup []LabelFilter) (bool, Confidence) {
+	if _, reason := simplifyLabelFilters(bGroup); reason != "" {
+		return true, ConfidenceCertain
+	}
+	bInfos := make([]*labelFilterInfo, len(bGroup))
+	for i := range bGroup {
+		bInfos[i] = newLabelFilterInfo(&bGroup[i])
+	}
+	confidence := ConfidenceCertain
+	for i := range aGroup {
+		fa := newLabelFilterInfo(&aGroup[i])
+		var bLabelInfos []*labelFilterInfo
+		for _, fb := range bInfos {
+			if fb.lf.Label == fa.lf.Label {
+				bLabelInfos = append(bLabelInfos, fb)
+			}
+		}
+		ok, c := filterCoversFilters(fa, bLabelInfos)
+		if ok {
+			continue
+		}
+		if c == ConfidenceCertain {
+			return false, ConfidenceCertain
+		}
+		confidence = ConfidenceUncertain
+	}
+	if confidence != ConfidenceCertain {
+		return false, confidence
+	}
+	return true, ConfidenceCertain
+}
+
+// filterCoversFilters returns true if fa matches all the values matched by all the fbs for the same label.
+//
+// fbs must be satisfiable.
+func filterCoversFilters(fa *labelFilterInfo, fbs []*labelFilterInfo) (bool, Confidence) {
+	if fa.matchesAll {
+		return true, ConfidenceCertain
+	}
+	hasOpaque := false
+	for _, fb := range fbs {
+		if sameFilter(fb.lf, fa.lf) {
+			return true, ConfidenceCertain
+		}
+		if fb.opaque {
+			hasOpaque = true
+		}
+	}
+	if fa.opaque {
+		return false, ConfidenceUncertain
+	}
+	// notCovered is returned if a value matching fbs and not matching fa is found.
+	// The value may not match opaque filters, so the answer cannot be proved in this case.
+	notCovered := ConfidenceCertain
+	if hasOpaque {
+		notCovered = ConfidenceUncertain
+	}
+
+	// fbs match a finite set of values - check all of them.
+	for _, fb := range fbs {
+		if fb.values == nil || fb.opaque {
+			continue
+		}
+		for _, v := range fb.values {
+			if matchLabelFilterInfos(v, fbs) && !fa.match(v) {
+				return false, notCovered
+			}
+		}
+		return true, ConfidenceCertain
+	}
+
+	// fbs match an infinite set of values.
+	for _, fb := range fbs {
+		if fb.implies(fa) {
+			return true, ConfidenceCertain
+		}
+	}
+	if matchLabelFilterInfos("", fbs) && !fa.match("") {
+		return false, notCovered
+	}
+	if fa.rejected != nil {
+		// fa matches all the values except of a finite set of values.
+		for _, v := range fa.rejected {
+			if matchLabelFilterInfos(v, fbs) {
+				return false, notCovered
+			}
+		}
+		return true, ConfidenceCertain
+	}
+
+	if fa.lf.IsNegative {
+		// fa rejects an infinite set of values. fbs are covered if they cannot match any value rejected by fa.
+		ra, err := AnalyzeRegexp(fa.lf.Value)
+		if err != nil {
+			return false, ConfidenceUncertain
+		}
+		for _, fb := range fbs {
+			if !fb.lf.IsNegative && !fb.opaque &&
+				(!strings.HasPrefix(fb.prefix, ra.Prefix) && !strings.HasPrefix(ra.Prefix, fb.prefix) ||
+					!strings.HasSuffix(fb.suffix, ra.Suffix) && !strings.HasSuffix(ra.Suffix, fb.suffix)) {
+				return true, ConfidenceCertain
+			}
+		}
+		return false, ConfidenceUncertain
+	}
+
+	var positive []*labelFilterInfo
+	for _, fb := range fbs {
+		if !fb.lf.IsNegative {
+			positive = append(positive, fb)
+		}
+	}
+	if len(positive) > 1 {
+		// The intersection of regexps may be finite, so it cannot be compared with fa.
+		return false, ConfidenceUncertain
+	}
+	if fa.values != nil {
+		// fa matches a finite set of values, while fbs match an infinite set of values.
+		return false, notCovered
+	}
+	if len(positive) == 0 {
+		// fbs match all the values except of a finite set of values, while fa matches an infinite set of values.
+		// Check whether fa matches the value, which isn't rejected by fbs.
+		if v, ok := getUnrejectedValue(fbs); ok && !fa.match(v) {
+			return false, notCovered
+		}
+		return false, ConfidenceUncertain
+	}
+
+	fb := positive[0]
+	if isPrefixRegexp(fa.lf.Value) && strings.HasPrefix(fb.prefix, fa.prefix) {
+		// fa=~"prefix.*" matches all the values starting with prefix.
+		return true, ConfidenceCertain
+	}
+	if isSuffixRegexp(fa.lf.Value) && strings.HasSuffix(fb.suffix, fa.suffix) {
+		// fa=~".*suffix" matches all the values ending with suffix.
+		return true, ConfidenceCertain
+	}
+	if !strings.HasPrefix(fb.prefix, fa.prefix) && !strings.HasPrefix(fa.prefix, fb.prefix) ||
+		!strings.HasSuffix(fb.suffix, fa.suffix) && !strings.HasSuffix(fa.suffix, fb.suffix) {
+		// fa and fb cannot match the same value.
+		return false, notCovered
+	}
+	return false, ConfidenceUncertain
+}
+
+// sameFilter returns true if a and b are identical label filters.
+//
+// Positions of the filters in the query are ignored.
+func sameFilter(a, b *LabelFilter) bool {
+	return a.Label == b.Label && a.Value == b.Value && a.IsNegative == b.IsNegative && a.IsRegexp == b.IsRegexp
+}
+
+// matchLabelFilterInfos returns true if v matches all the non-opaque lfis.
+func matchLabelFilterInfos(v string, lfis []*labelFilterInfo) bool {
+	for _, lfi := range lfis {
+		if !lfi.opaque && !lfi.match(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// getUnrejectedValue returns a value, which isn't rejected by the given negative filters.
+//
+// false is returned if some of lfis reject an infinite set of values.
+func getUnrejectedValue(lfis []*labelFilterInfo) (string, bool) {
+	n := 0
+	for _, lfi := range lfis {
+		if lfi.rejected == nil {
+			return "", false
+		}
+		n += len(lfi.rejected)
+	}
+	// At least a single value out of n+1 distinct values isn't rejected.
+	v := "x"
+	for i := 0; i <= n; i++ {
+		if matchLabelFilterInfos(v, lfis) {
+			return v, true
+		}
+		v += "x"
+	}
+	return "", false
+}
+
+// isPrefixRegexp returns true if re matches all the values starting with a literal prefix, e.g. `foo.*`.
+func isPrefixRegexp(re string) bool {
+	prefix := strings.TrimSuffix(re, ".*")
+	return len(prefix) < len(re) && regexp.QuoteMeta(unquoteRegexpLiteral(prefix)) == prefix
+}
+
+// isSuffixRegexp returns true if re matches all the values ending with a literal suffix, e.g. `.*foo`.
+func isSuffixRegexp(re string) bool {
+	suffix := strings.TrimPrefix(re, ".*")
+	return len(suffix) < len(re) && regexp.QuoteMeta(unquoteRegexpLiteral(suffix)) == suffix
+}
+
+// unquoteRegexpLiteral removes backslashes from the literal s quoted with regexp.QuoteMeta.
+func unquoteRegexpLiteral(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,156 @@
+package metricsql
+
+import (
+	"testing"
+)
+
+func TestCovers(t *testing.T) {
+	f := func(a, b string, resultExpected bool, confidenceExpected Confidence) {
+		t.Helper()
+
+		meA := mustParseMetricExpr(t, a)
+		meB := mustParseMetricExpr(t, b)
+		result, confidence := Covers(meA, meB)
+		if result != resultExpected || confidence != confidenceExpected {
+			t.Fatalf("unexpected result for Covers(%s, %s); got %v, %s; want %v, %s", a, b, result, confidence, resultExpected, confidenceExpected)
+		}
+	}
+
+	// identical selectors
+	f(`foo`, `foo`, true, ConfidenceCertain)
+	f(`foo{job="api"}`, `foo{job="api"}`, true, ConfidenceCertain)
+	f(`foo{x=~"a.+b"}`, `foo{x=~"a.+b"}`, true, ConfidenceCertain)
+
+	// identical selectors at different positions in the query
+	f(`foo{x=~"a.+b"}`, `   foo{x=~"a.+b"}`, true, ConfidenceCertain)
+	f(`foo{y="z",x=~"a.+b"}`, "\n foo{x=~\"a.+b\", y=\"z\"}", true, ConfidenceCertain)
+
+	// equality filters
+	f(`foo`, `foo{job="api"}`, true, ConfidenceCertain)
+	f(`foo{job="api"}`, `foo`, false, ConfidenceCertain)
+	f(`foo{job="api"}`, `foo{job="web"}`, false, ConfidenceCertain)
+	f(`foo`, `bar`, false, ConfidenceCertain)
+	f(`{job="api"}`, `foo{job="api",instance="x"}`, true, ConfidenceCertain)
+	f(`foo{job=""}`, `foo`, false, ConfidenceCertain)
+	f(`foo{job=""}`, `foo{instance="x"}`, false, ConfidenceCertain)
+
+	// negative filters
+	f(`foo{job!="api"}`, `foo{job="web"}`, true, ConfidenceCertain)
+	f(`foo{job!="api"}`, `foo{job="api"}`, false, ConfidenceCertain)
+	f(`foo{job!="api"}`, `foo`, false, ConfidenceCertain)
+	f(`foo{job!="api"}`, `foo{job!="api",x="y"}`, true, ConfidenceCertain)
+	f(`foo{job!="api"}`, `foo{job!~"api|web"}`, true, ConfidenceCertain)
+	f(`foo{job!~"api|web"}`, `foo{job!="api"}`, false, ConfidenceCertain)
+	f(`foo{job!=""}`, `foo{job=~"a.+"}`, true, ConfidenceCertain)
+	f(`foo{job!=""}`, `foo{job=~".*"}`, false, ConfidenceCertain)
+	f(`foo{job!~"a.*"}`, `foo{job=~"b.*"}`, true, ConfidenceCertain)
+	f(`foo{job!~"a.*"}`, `foo{job=~"ab"}`, false, ConfidenceCertain)
+	f(`foo{job!~"a.*"}`, `foo{job=~"x.*y"}`, true, ConfidenceCertain)
+	f(`foo{job!~"a.*b"}`, `foo{job=~"a.+"}`, false, ConfidenceUncertain)
+
+	// literal sets
+	f(`foo{job=~"api|web"}`, `foo{job="api"}`, true, ConfidenceCertain)
+	f(`foo{job=~"api|web"}`, `foo{job=~"web|api"}`, true, ConfidenceCertain)
+	f(`foo{job=~"api|web|db"}`, `foo{job=~"api|web"}`, true, ConfidenceCertain)
+	f(`foo{job=~"api|web"}`, `foo{job=~"api|web|db"}`, false, ConfidenceCertain)
+	f(`foo{job=~"api|web"}`, `foo{job=~"api|web|db",job!="db"}`, true, ConfidenceCertain)
+	f(`foo{job="api"}`, `foo{job=~"api|web"}`, false, ConfidenceCertain)
+	f(`foo{job=~"api|web"}`, `foo{job=~"a.*"}`, false, ConfidenceCertain)
+
+	// prefixes and suffixes
+	f(`foo{instance=~"10\\.0\\..*"}`, `foo{instance="10.0.1.1"}`, true, ConfidenceCertain)
+	f(`foo{instance=~"10\\.0\\..*"}`, `foo{instance=~"10\\.0\\.1\\..*"}`, true, ConfidenceCertain)
+	f(`foo{instance=~"10\\.0\\..*"}`, `foo{instance=~"10\\.0\\.1\\.[0-9]+"}`, true, ConfidenceCertain)
+	f(`foo{instance=~"10\\.0\\..*"}`, `foo{instance=~"10\\..*"}`, false, ConfidenceUncertain)
+	f(`foo{instance=~"10\\.0\\..*"}`, `foo{instance=~"192\\..*"}`, false, ConfidenceCertain)
+	f(`foo{instance=~"10\\.0\\..*"}`, `foo`, false, ConfidenceCertain)
+	f(`foo{host=~".*\\.example\\.com"}`, `foo{host=~"db.*\\.example\\.com"}`, true, ConfidenceCertain)
+	f(`foo{host=~".*\\.example\\.com"}`, `foo{host=~".*\\.example\\.org"}`, false, ConfidenceCertain)
+	f(`foo{x=~"a.*"}`, `foo{x!="b"}`, false, ConfidenceCertain)
+	f(`foo{x=~"|a.*"}`, `foo{x!="b"}`, false, ConfidenceCertain)
+
+	// regexps, which cannot be compared
+	f(`foo{x=~"a.+b"}`, `foo{x=~"a.*b"}`, false, ConfidenceUncertain)
+	f(`foo{x=~"[a-z]+"}`, `foo{x=~"a.+"}`, false, ConfidenceUncertain)
+	f(`foo{x=~"[a-z]+"}`, `foo{x=~"a.+",x=~".+b"}`, false, ConfidenceUncertain)
+
+	// or-delimited groups
+	f(`{job="api" or job="web"}`, `{job="api"}`, true, ConfidenceCertain)
+	f(`{job="api" or job="web"}`, `{job="api" or job="web",x="y"}`, true, ConfidenceCertain)
+	f(`{job="api"}`, `{job="api" or job="web"}`, false, ConfidenceCertain)
+	f(`{job="api" or job="web"}`, `{job=~"api|web"}`, false, ConfidenceUncertain)
+
+	// selectors without matching series are covered by any selector
+	f(`foo`, `bar{x="a",x="b"}`, true, ConfidenceCertain)
+	f(`{job="api"}`, `{job="web" or x="a",x="b"}`, false, ConfidenceCertain)
+	f(`{job="web"}`, `{job="web" or x="a",x="b"}`, true, ConfidenceCertain)
+}
+
+func TestIntersect(t *testing.T) {
+	f := func(a, b, resultExpected string) {
+		t.Helper()
+
+		meA := mustParseMetricExpr(t, a)
+		meB := mustParseMetricExpr(t, b)
+		me := Intersect(meA, meB)
+		result := "<nil>"
+		if me != nil {
+			result = string(me.AppendString(nil))
+		}
+		if result != resultExpected {
+			t.Fatalf("unexpected result for Intersect(%s, %s);\ngot\n%s\nwant\n%s", a, b, result, resultExpected)
+		}
+	}
+
+	f(`foo`, `foo`, `foo`)
+	f(`foo`, `{job="api"}`, `foo{job="api"}`)
+	f(`foo{job=~"api|web"}`, `foo{job="api"}`, `foo{job="api"}`)
+	f(`foo{job!="db"}`, `{job=~"api|web"}`, `foo{job=~"api|web"}`)
+	f(`foo`, `bar`, `<nil>`)
+	f(`foo{job="api"}`, `foo{job="web"}`, `<nil>`)
+	f(`{job="api" or job="web"}`, `{job="web" or job="db"}`, `{job="web"}`)
+	f(`{job="api" or job="web"}`, `{x="y"}`, `{job="api",x="y" or job="web",x="y"}`)
+}
+
+func TestUnion(t *testing.T) {
+	f := func(a, b, resultExpected string) {
+		t.Helper()
+
+		meA := mustParseMetricExpr(t, a)
+		meB := mustParseMetricExpr(t, b)
+		sOrigA := string(meA.AppendString(nil))
+		sOrigB := string(meB.AppendString(nil))
+		result := string(Union(meA, meB).AppendString(nil))
+		if result != resultExpected {
+			t.Fatalf("unexpected result for Union(%s, %s);\ngot\n%s\nwant\n%s", a, b, result, resultExpected)
+		}
+		if s := string(meA.AppendString(nil)); s != sOrigA {
+			t.Fatalf("the original expression has been changed;\ngot\n%s\nwant\n%s", s, sOrigA)
+		}
+		if s := string(meB.AppendString(nil)); s != sOrigB {
+			t.Fatalf("the original expression has been changed;\ngot\n%s\nwant\n%s", s, sOrigB)
+		}
+	}
+
+	f(`foo`, `foo`, `foo`)
+	f(`foo`, `bar`, `{__name__="foo" or __name__="bar"}`)
+	f(`foo`, `foo{job="api"}`, `foo`)
+	f(`foo{job="api"}`, `foo`, `foo`)
+	f(`foo{job="api"}`, `foo{job="web"}`, `foo{job="api" or job="web"}`)
+	f(`foo{job=~"api|web"}`, `foo{job="web"}`, `foo{job=~"api|web"}`)
+	f(`{job="api" or job="web"}`, `{job="web",x="y"}`, `{job="api" or job="web"}`)
+}
+
+func mustParseMetricExpr(t *testing.T, s string) *MetricExpr {
+	t.Helper()
+
+	e, err := Parse(s)
+	if err != nil {
+		t.Fatalf("unexpected error when parsing %s: %s", s, err)
+	}
+	me, ok := e.(*MetricExpr)
+	if !ok {
+		t.Fatalf("expecting MetricExpr for %s; got %T", s, e)
+	}
+	return me
+}
@@ -0,0 +1,254 @@
+package eval
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/Abhinav1299/metricsql"
+)
+
+// aggrFunc must return aggregated values for the series in the group.
+//
+// params contains values for the scalar args of the aggregate function.
+type aggrFunc func(tss []*timeseries, params [][]float64, pointsLen int) []*timeseries
+
+var aggrFuncs = map[string]aggrFunc{
+	"avg":      newAggrFunc(aggrAvg),
+	"bottomk":  newAggrFuncTopK(func(a, b float64) bool { return a < b }),
+	"count":    newAggrFunc(aggrCount),
+	"group":    newAggrFunc(aggrGroup),
+	"max":      newAggrFunc(aggrMax),
+	"min":      newAggrFunc(aggrMin),
+	"quantile": aggrQuantile,
+	"stddev":   newAggrFunc(aggrStddev),
+	"stdvar":   newAggrFunc(aggrStdvar),
+	"sum":      newAggrFunc(aggrSum),
+	"topk":     newAggrFuncTopK(func(a, b float64) bool { return a > b }),
+}
+
+func evalAggrFuncExpr(ec *evalConfig, ae *metricsql.AggrFuncExpr) ([]*timeseries, error) {
+	fs := metricsql.GetFuncSignature(ae.Name)
+	if fs == nil {
+		return nil, fmt.Errorf("unknown aggregate function %q", ae.Name)
+	}
+	af := aggrFuncs[fs.Name]
+	if af == nil {
+		return nil, fmt.Errorf("unsupported aggregate function %q", ae.Name)
+	}
+	if len(ae.Args) < fs.MinArgs || !fs.IsVariadic() && len(ae.Args) > fs.MaxArgs {
+		return nil, fmt.Errorf("invalid number of args for %s(); got %d", ae.Name, len(ae.Args))
+	}
+
+	// Scalar args go before series args. Series from all the series args are aggregated together,
+	// e.g. sum(foo, bar) aggregates both foo and bar.
+	seriesArgIdx := fs.SeriesArgIdx(len(ae.Args))
+	var params [][]float64
+	for _, arg := range ae.Args[:seriesArgIdx] {
+		values, err := evalScalarArg(ec, arg)
+		if err != nil {
+			return nil, fmt.Errorf("cannot evaluate %s: %w", ae.AppendString(nil), err)
+		}
+		params = append(params, values)
+	}
+	var tss []*timeseries
+	for i, arg := range ae.Args[seriesArgIdx:] {
+		if k, _ := fs.ArgKind(seriesArgIdx+i, len(ae.Args)); !k.IsVector() {
+			// Skip optional non-series args such as `others_label` for topk().
+			continue
+		}
+		tssArg, err := evalExpr(ec, arg)
+		if err != nil {
+			return nil, err
+		}
+		tss = append(tss, tssArg...)
+	}
+
+	groups := make(map[string][]*timeseries)
+	for _, ts := range tss {
+		labels := groupLabels(ts.labels, &ae.Modifier)
+		key := labelsString(labels)
+		groups[key] = append(groups[key], ts)
+	}
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if ae.Limit > 0 && len(keys) > ae.Limit {
+		keys = keys[:ae.Limit]
+	}
+	var result []*timeseries
+	for _, key := range keys {
+		group := groups[key]
+		for _, ts := range af(group, params, ec.pointsLen()) {
+			if ts.labels == nil {
+				ts.labels = groupLabels(group[0].labels, &ae.Modifier)
+			}
+			result = append(result, ts)
+		}
+	}
+	return result, nil
+}
+
+// groupLabels returns labels for the aggregation group for the given labels and the given `by` or `without` modifier.
+func groupLabels(labels map[string]string, modifier *metricsql.ModifierExpr) map[string]string {
+	m := make(map[string]string)
+	switch strings.ToLower(modifier.Op) {
+	case "by":
+		for _, name := range modifier.Args {
+			if v, ok := labels[name]; ok {
+				m[name] = v
+			}
+		}
+	case "without":
+		for name, v := range labels {
+			m[name] = v
+		}
+		delete(m, "__name__")
+		for _, name := range modifier.Args {
+			delete(m, name)
+		}
+	}
+	return m
+}
+
+// newAggrFunc returns aggrFunc, which calculates f over non-NaN values at every point.
+//
+// The returned series have nil labels, so they are filled by the caller with group labels.
+func newAggrFunc(f func(values []float64) float64) aggrFunc {
+	return func(tss []*timeseries, _ [][]float64, pointsLen int) []*timeseries {
+		return aggregateValues(tss, pointsLen, func(values []float64, _ int) float64 {
+			return f(values)
+		})
+	}
+}
+
+// aggregateValues returns a series with nil labels and values obtained by applying f to non-NaN values in tss at every point.
+//
+// f accepts non-empty values and point index.
+func aggregateValues(tss []*timeseries, pointsLen int, f func(values []float64, i int) float64) []*timeseries {
+	values := make([]float64, pointsLen)
+	var a []float64
+	for i := range values {
+		a = a[:0]
+		for _, ts := range tss {
+			if v := ts.values[i]; !math.IsNaN(v) {
+				a = append(a, v)
+			}
+		}
+		if len(a) == 0 {
+			values[i] = nan
+			continue
+		}
+		values[i] = f(a, i)
+	}
+	return []*timeseries{{
+		values: values,
+	}}
+}
+
+func aggrSum(values []float64) float64 {
+	sum := float64(0)
+	for _, v := range values {
+		sum += v
+	}
+	return sum
+}
+
+func aggrAvg(values []float64) float64 {
+	return aggrSum(values) / float64(len(values))
+}
+
+func aggrCount(values []float64) float64 {
+	return float64(len(values))
+}
+
+func aggrGroup(values []float64) float64 {
+	return 1
+}
+
+func aggrMin(values []float64) float64 {
+	min := values[0]
+	for _, v := range values[1:] {
+		if v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+func aggrMax(values []float64) float64 {
+	max := values[0]
+	for _, v := range values[1:] {
+		if v > max {
+			max = v
+		}
+	}
+	return max
+}
+
+func aggrStdvar(values []float64) float64 {
+	avg := aggrAvg(values)
+	sum := float64(0)
+	for _, v := range values {
+		d := v - avg
+		sum += d * d
+	}
+	return sum / float64(len(values))
+}
+
+func aggrStddev(values []float64) float64 {
+	return math.Sqrt(aggrStdvar(values))
+}
+
+func aggrQuantile(tss []*timeseries, params [][]float64, pointsLen int) []*timeseries {
+	phis := params[0]
+	return aggregateValues(tss, pointsLen, func(values []float64, i int) float64 {
+		return quantile(phis[i], values)
+	})
+}
+
+// newAggrFuncTopK returns aggrFunc for topk() or bottomk().
+//
+// less must return true if a must be selected before b. The returned series keep their labels.
+func newAggrFuncTopK(less func(a, b float64) bool) aggrFunc {
+	return func(tss []*timeseries, params [][]float64, pointsLen int) []*timeseries {
+		ks := params[0]
+		result := make([]*timeseries, len(tss))
+		for i, ts := range tss {
+			values := make([]float64, pointsLen)
+			for j := range values {
+				values[j] = nan
+			}
+			result[i] = &timeseries{
+				labels: ts.labels,
+				values: values,
+			}
+		}
+		idxs := make([]int, 0, len(tss))
+		for i := 0; i < pointsLen; i++ {
+			idxs = idxs[:0]
+			for j, ts := range tss {
+				if !math.IsNaN(ts.values[i]) {
+					idxs = append(idxs, j)
+				}
+			}
+			sort.SliceStable(idxs, func(a, b int) bool {
+				return less(tss[idxs[a]].values[i], tss[idxs[b]].values[i])
+			})
+			k := ks[i]
+			if math.IsNaN(k) || k < 0 {
+				k = 0
+			}
+			for n, j := range idxs {
+				if float64(n) >= k {
+					break
+				}
+				result[j].values[i] = tss[j].values[i]
+			}
+		}
+		return result
+	}
+}
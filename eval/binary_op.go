@@ -0,0 +1,395 @@
+package eval
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/Abhinav1299/metricsql"
+	"github.com/Abhinav1299/metricsql/binaryop"
+)
+
+var arithmeticFuncs = map[string]func(left, right float64) float64{
+	"+":     binaryop.Plus,
+	"-":     binaryop.Minus,
+	"*":     binaryop.Mul,
+	"/":     binaryop.Div,
+	"%":     binaryop.Mod,
+	"^":     binaryop.Pow,
+	"atan2": math.Atan2,
+}
+
+var comparisonFuncs = map[string]func(left, right float64) bool{
+	"==": binaryop.Eq,
+	"!=": binaryop.Neq,
+	">":  binaryop.Gt,
+	"<":  binaryop.Lt,
+	">=": binaryop.Gte,
+	"<=": binaryop.Lte,
+}
+
+func evalBinaryOpExpr(ec *evalConfig, be *metricsql.BinaryOpExpr) ([]*timeseries, error) {
+	left, err := evalExpr(ec, be.Left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := evalExpr(ec, be.Right)
+	if err != nil {
+		return nil, err
+	}
+	bo := &binaryOp{
+		be:            be,
+		op:            strings.ToLower(be.Op),
+		isLeftScalar:  isScalar(be.Left),
+		isRightScalar: isScalar(be.Right),
+	}
+	var tss []*timeseries
+	switch bo.op {
+	case "and", "or", "unless", "if", "ifnot", "default":
+		tss, err = bo.evalSetOp(left, right)
+	default:
+		tss, err = bo.evalArithmeticOp(left, right)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot evaluate %s: %w", be.AppendString(nil), err)
+	}
+	return tss, nil
+}
+
+func isScalar(e metricsql.Expr) bool {
+	k, ok := metricsql.ExprType(e)
+	return ok && k == metricsql.ArgKindScalar
+}
+
+type binaryOp struct {
+	be *metricsql.BinaryOpExpr
+	op string
+
+	isLeftScalar  bool
+	isRightScalar bool
+}
+
+// isFilter returns true if bo is a comparison without `bool` modifier, which filters out points.
+func (bo *binaryOp) isFilter() bool {
+	return comparisonFuncs[bo.op] != nil && !bo.be.Bool
+}
+
+// apply applies bo to left and right values.
+//
+// For comparisons without `bool` modifier the value from the non-scalar side is returned if the comparison is true.
+func (bo *binaryOp) apply(left, right float64) float64 {
+	if f := arithmeticFuncs[bo.op]; f != nil {
+		return f(left, right)
+	}
+	f := comparisonFuncs[bo.op]
+	ok := f(left, right)
+	if bo.be.Bool {
+		if math.IsNaN(left) || math.IsNaN(right) {
+			return nan
+		}
+		if ok {
+			return 1
+		}
+		return 0
+	}
+	if !ok {
+		return nan
+	}
+	if bo.isLeftScalar && !bo.isRightScalar {
+		return right
+	}
+	return left
+}
+
+// resultLabels returns labels for the result of bo for the series with the given labels.
+func (bo *binaryOp) resultLabels(labels map[string]string) map[string]string {
+	if bo.isFilter() || bo.be.KeepMetricNames {
+		return labels
+	}
+	if _, ok := labels["__name__"]; !ok {
+		return labels
+	}
+	labels = copyLabels(labels)
+	delete(labels, "__name__")
+	return labels
+}
+
+// matchingKey returns the key for matching series with the given labels according to `on` or `ignoring` modifier.
+func (bo *binaryOp) matchingKey(labels map[string]string) string {
+	return labelsString(bo.matchingLabels(labels))
+}
+
+func (bo *binaryOp) matchingLabels(labels map[string]string) map[string]string {
+	gm := &bo.be.GroupModifier
+	if strings.ToLower(gm.Op) == "on" {
+		return groupLabels(labels, &metricsql.ModifierExpr{Op: "by", Args: gm.Args})
+	}
+	return groupLabels(labels, &metricsql.ModifierExpr{Op: "without", Args: gm.Args})
+}
+
+func (bo *binaryOp) evalArithmeticOp(left, right []*timeseries) ([]*timeseries, error) {
+	if arithmeticFuncs[bo.op] == nil && comparisonFuncs[bo.op] == nil {
+		return nil, fmt.Errorf("unsupported binary operation %q", bo.be.Op)
+	}
+	if bo.be.Bool && comparisonFuncs[bo.op] == nil {
+		return nil, fmt.Errorf("bool modifier cannot be applied to %q", bo.be.Op)
+	}
+	switch {
+	case bo.isLeftScalar && bo.isRightScalar:
+		return bo.evalScalarScalar(left, right), nil
+	case bo.isRightScalar:
+		return bo.evalVectorScalar(left, right, func(v, s float64) float64 { return bo.apply(v, s) }), nil
+	case bo.isLeftScalar:
+		return bo.evalVectorScalar(right, left, func(v, s float64) float64 { return bo.apply(s, v) }), nil
+	}
+	switch strings.ToLower(bo.be.JoinModifier.Op) {
+	case "group_left":
+		return bo.evalManyToOne(left, right, false)
+	case "group_right":
+		return bo.evalManyToOne(right, left, true)
+	default:
+		return bo.evalOneToOne(left, right)
+	}
+}
+
+func (bo *binaryOp) evalScalarScalar(left, right []*timeseries) []*timeseries {
+	if len(left) == 0 || len(right) == 0 {
+		return nil
+	}
+	values := make([]float64, len(left[0].values))
+	for i := range values {
+		values[i] = bo.apply(left[0].values[i], right[0].values[i])
+	}
+	return []*timeseries{{
+		labels: map[string]string{},
+		values: values,
+	}}
+}
+
+func (bo *binaryOp) evalVectorScalar(vector, scalar []*timeseries, f func(v, s float64) float64) []*timeseries {
+	if len(scalar) == 0 {
+		return nil
+	}
+	ss := scalar[0].values
+	result := make([]*timeseries, 0, len(vector))
+	for _, ts := range vector {
+		values := make([]float64, len(ts.values))
+		for i, v := range ts.values {
+			values[i] = f(v, ss[i])
+		}
+		result = append(result, &timeseries{
+			labels: bo.resultLabels(ts.labels),
+			values: values,
+		})
+	}
+	return result
+}
+
+// indexByMatchingKey returns tss indexed by matching key.
+//
+// An error is returned if tss contain multiple series with the same key.
+func (bo *binaryOp) indexByMatchingKey(tss []*timeseries, side string) (map[string]*timeseries, error) {
+	m := make(map[string]*timeseries, len(tss))
+	for _, ts := range tss {
+		key := bo.matchingKey(ts.labels)
+		if tsPrev := m[key]; tsPrev != nil {
+			return nil, fmt.Errorf("duplicate series on the %s side for matching labels %s: %s and %s; "+
+				"use on(...), ignoring(...), group_left(...) or group_right(...) modifiers", side, key, labelsString(tsPrev.labels), labelsString(ts.labels))
+		}
+		m[key] = ts
+	}
+	return m, nil
+}
+
+func (bo *binaryOp) evalOneToOne(left, right []*timeseries) ([]*timeseries, error) {
+	if _, err := bo.indexByMatchingKey(left, "left"); err != nil {
+		return nil, err
+	}
+	rightByKey, err := bo.indexByMatchingKey(right, "right")
+	if err != nil {
+		return nil, err
+	}
+	var result []*timeseries
+	for _, tsLeft := range left {
+		tsRight := rightByKey[bo.matchingKey(tsLeft.labels)]
+		if tsRight == nil {
+			continue
+		}
+		values := make([]float64, len(tsLeft.values))
+		for i, v := range tsLeft.values {
+			values[i] = bo.apply(v, tsRight.values[i])
+		}
+		// One-to-one matching returns only matching labels for `on` modifier and drops labels from `ignoring` modifier.
+		labels := bo.resultLabels(tsLeft.labels)
+		gm := &bo.be.GroupModifier
+		switch strings.ToLower(gm.Op) {
+		case "on":
+			labels = bo.matchingLabels(labels)
+		case "ignoring":
+			labels = copyLabels(labels)
+			for _, name := range gm.Args {
+				delete(labels, name)
+			}
+		}
+		result = append(result, &timeseries{
+			labels: labels,
+			values: values,
+		})
+	}
+	return result, nil
+}
+
+// evalManyToOne evaluates bo with `group_left` or `group_right` modifier.
+//
+// many contains series from the `many` side, while one contains series from the `one` side.
+// isRight must be set to true if many is the right operand of bo, i.e. for `group_right`.
+func (bo *binaryOp) evalManyToOne(many, one []*timeseries, isRight bool) ([]*timeseries, error) {
+	side, manySide := "right", "left"
+	if isRight {
+		side, manySide = "left", "right"
+	}
+	oneByKey, err := bo.indexByMatchingKey(one, side)
+	if err != nil {
+		return nil, err
+	}
+	jm := &bo.be.JoinModifier
+	prefix := ""
+	if bo.be.JoinModifierPrefix != nil {
+		prefix = bo.be.JoinModifierPrefix.S
+	}
+	var result []*timeseries
+	resultByKey := make(map[string]*timeseries)
+	for _, tsMany := range many {
+		tsOne := oneByKey[bo.matchingKey(tsMany.labels)]
+		if tsOne == nil {
+			continue
+		}
+		values := make([]float64, len(tsMany.values))
+		for i, v := range tsMany.values {
+			if isRight {
+				values[i] = bo.apply(tsOne.values[i], v)
+			} else {
+				values[i] = bo.apply(v, tsOne.values[i])
+			}
+		}
+		labels := copyLabels(bo.resultLabels(tsMany.labels))
+		for _, name := range jm.Args {
+			if v, ok := tsOne.labels[name]; ok {
+				labels[prefix+name] = v
+			} else {
+				delete(labels, prefix+name)
+			}
+		}
+		ts := &timeseries{
+			labels: labels,
+			values: values,
+		}
+		key := labelsString(labels)
+		if tsPrev := resultByKey[key]; tsPrev != nil && hasCommonPoints(tsPrev, ts) {
+			return nil, fmt.Errorf("duplicate time series %s for %s modifier; the matching series on the %s side must have unique labels in the result",
+				key, jm.Op, manySide)
+		}
+		resultByKey[key] = ts
+		result = append(result, ts)
+	}
+	return result, nil
+}
+
+// evalSetOp evaluates `and`, `or`, `unless`, `if`, `ifnot` and `default` operations.
+//
+// The series are matched by labels without metric name at every point, unless `on` or `ignoring` modifier is set.
+func (bo *binaryOp) evalSetOp(left, right []*timeseries) ([]*timeseries, error) {
+	if bo.be.JoinModifier.Op != "" {
+		return nil, fmt.Errorf("%s modifier cannot be applied to %q", bo.be.JoinModifier.Op, bo.be.Op)
+	}
+	switch bo.op {
+	case "and", "or", "unless":
+		if bo.isLeftScalar || bo.isRightScalar {
+			return nil, fmt.Errorf("set operation %q cannot be applied to scalars", bo.be.Op)
+		}
+	}
+
+	// Scalar on the right side matches all the series on the left side.
+	rightByKey := make(map[string][]*timeseries)
+	for _, ts := range right {
+		key := bo.matchingKey(ts.labels)
+		rightByKey[key] = append(rightByKey[key], ts)
+	}
+	getRight := func(key string) []*timeseries {
+		if bo.isRightScalar {
+			return right
+		}
+		return rightByKey[key]
+	}
+	// rightValue returns the first non-NaN value at point i in tss.
+	rightValue := func(tss []*timeseries, i int) float64 {
+		for _, ts := range tss {
+			if v := ts.values[i]; !math.IsNaN(v) {
+				return v
+			}
+		}
+		return nan
+	}
+
+	var result []*timeseries
+	switch bo.op {
+	case "and", "if":
+		for _, ts := range left {
+			tssRight := getRight(bo.matchingKey(ts.labels))
+			result = append(result, filterValues(ts, func(i int) bool {
+				return !math.IsNaN(rightValue(tssRight, i))
+			}))
+		}
+	case "unless", "ifnot":
+		for _, ts := range left {
+			tssRight := getRight(bo.matchingKey(ts.labels))
+			result = append(result, filterValues(ts, func(i int) bool {
+				return math.IsNaN(rightValue(tssRight, i))
+			}))
+		}
+	case "default":
+		for _, ts := range left {
+			tssRight := getRight(bo.matchingKey(ts.labels))
+			values := make([]float64, len(ts.values))
+			for i, v := range ts.values {
+				if math.IsNaN(v) {
+					v = rightValue(tssRight, i)
+				}
+				values[i] = v
+			}
+			result = append(result, &timeseries{
+				labels: ts.labels,
+				values: values,
+			})
+		}
+	case "or":
+		result = append(result, left...)
+		leftByKey := make(map[string][]*timeseries)
+		for _, ts := range left {
+			key := bo.matchingKey(ts.labels)
+			leftByKey[key] = append(leftByKey[key], ts)
+		}
+		for _, ts := range right {
+			tssLeft := leftByKey[bo.matchingKey(ts.labels)]
+			result = append(result, filterValues(ts, func(i int) bool {
+				return math.IsNaN(rightValue(tssLeft, i))
+			}))
+		}
+	}
+	return result, nil
+}
+
+// filterValues returns a copy of ts with values at points, for which keep returns false, replaced with NaN.
+func filterValues(ts *timeseries, keep func(i int) bool) *timeseries {
+	values := make([]float64, len(ts.values))
+	for i, v := range ts.values {
+		if !keep(i) {
+			v = nan
+		}
+		values[i] = v
+	}
+	return &timeseries{
+		labels: ts.labels,
+		values: values,
+	}
+}
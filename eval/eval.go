@@ -0,0 +1,444 @@
+// Package eval provides reference evaluator for MetricsQL queries.
+//
+// The evaluator is intended for testing queries, alerting rules and dashboards against small in-memory datasets.
+// It isn't optimized for speed and supports only a subset of MetricsQL functions.
+package eval
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/Abhinav1299/metricsql"
+)
+
+// lookbackDelta is the lookbehind window in milliseconds for series selectors without explicit window.
+const lookbackDelta = 5 * 60 * 1000
+
+// maxPointsPerSeries is the maximum number of points per series, which can be evaluated.
+const maxPointsPerSeries = 1e6
+
+var nan = math.NaN()
+
+// Eval evaluates e on the time range [start, end] with the given step against the given storage.
+//
+// start, end and step must be in milliseconds. Results are returned at timestamps start, start+step, ..., end.
+// Points with missing values are omitted from the returned series, while series without points aren't returned.
+// The returned series are sorted by labels.
+//
+// Series selectors without window return the last raw sample on the 5 minutes lookbehind window.
+// Rollup functions without window such as `rate(foo)` use step as the window.
+func Eval(s Storage, e metricsql.Expr, start, end, step int64) ([]*Series, error) {
+	if step <= 0 {
+		return nil, fmt.Errorf("step must be positive; got %dms", step)
+	}
+	if start > end {
+		return nil, fmt.Errorf("start cannot exceed end; got start=%d, end=%d", start, end)
+	}
+	if (end-start)/step >= maxPointsPerSeries {
+		return nil, fmt.Errorf("too many points per series for start=%d, end=%d, step=%d; the limit is %d", start, end, step, int(maxPointsPerSeries))
+	}
+	ec := &evalConfig{
+		storage:    s,
+		start:      start,
+		end:        end,
+		step:       step,
+		queryStart: start,
+		queryEnd:   end,
+	}
+	tss, err := evalExpr(ec, e)
+	if err != nil {
+		return nil, err
+	}
+	tss, err = mergeSeries(tss)
+	if err != nil {
+		return nil, err
+	}
+	timestamps := ec.timestamps()
+	var result []*Series
+	for _, ts := range tss {
+		s := &Series{
+			Labels: ts.labels,
+		}
+		for i, v := range ts.values {
+			if math.IsNaN(v) {
+				continue
+			}
+			s.Timestamps = append(s.Timestamps, timestamps[i])
+			s.Values = append(s.Values, v)
+		}
+		if len(s.Values) > 0 {
+			result = append(result, s)
+		}
+	}
+	sortSeries(result)
+	return result, nil
+}
+
+// mergeSeries merges series with identical labels into a single series, e.g. left and right sides of `or`.
+//
+// An error is returned if series with identical labels have values at the same point,
+// e.g. after dropping metric names from distinct series in binary operations or functions.
+func mergeSeries(tss []*timeseries) ([]*timeseries, error) {
+	var result []*timeseries
+	m := make(map[string]*timeseries, len(tss))
+	for _, ts := range tss {
+		key := labelsString(ts.labels)
+		dst := m[key]
+		if dst == nil {
+			dst = &timeseries{
+				labels: ts.labels,
+				values: append([]float64{}, ts.values...),
+			}
+			m[key] = dst
+			result = append(result, dst)
+			continue
+		}
+		for i, v := range ts.values {
+			if math.IsNaN(v) {
+				continue
+			}
+			if !math.IsNaN(dst.values[i]) {
+				return nil, fmt.Errorf("duplicate time series %s; make sure the query returns series with unique labels", key)
+			}
+			dst.values[i] = v
+		}
+	}
+	return result, nil
+}
+
+// hasCommonPoints returns true if a and b have values at the same point.
+func hasCommonPoints(a, b *timeseries) bool {
+	for i, v := range a.values {
+		if !math.IsNaN(v) && !math.IsNaN(b.values[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// timeseries is a series with values aligned to evalConfig.timestamps.
+//
+// Missing values are represented as NaN.
+type timeseries struct {
+	labels map[string]string
+	values []float64
+}
+
+type evalConfig struct {
+	storage Storage
+
+	start int64
+	end   int64
+	step  int64
+
+	// queryStart and queryEnd contain the original time range for the query.
+	//
+	// They are used by start() and end() functions and by `@ start()` and `@ end()` modifiers.
+	queryStart int64
+	queryEnd   int64
+}
+
+func (ec *evalConfig) timestamps() []int64 {
+	var timestamps []int64
+	for t := ec.start; t <= ec.end; t += ec.step {
+		timestamps = append(timestamps, t)
+	}
+	return timestamps
+}
+
+func (ec *evalConfig) pointsLen() int {
+	return int((ec.end-ec.start)/ec.step) + 1
+}
+
+func (ec *evalConfig) newScalar(f func(t int64) float64) []*timeseries {
+	values := make([]float64, ec.pointsLen())
+	for i, t := range ec.timestamps() {
+		values[i] = f(t)
+	}
+	return []*timeseries{{
+		labels: map[string]string{},
+		values: values,
+	}}
+}
+
+func evalExpr(ec *evalConfig, e metricsql.Expr) ([]*timeseries, error) {
+	switch t := e.(type) {
+	case *metricsql.NumberExpr:
+		return ec.newScalar(func(int64) float64 { return t.N }), nil
+	case *metricsql.DurationExpr:
+		d := float64(t.Duration(ec.step)) / 1e3
+		return ec.newScalar(func(int64) float64 { return d }), nil
+	case *metricsql.StringExpr:
+		return nil, fmt.Errorf("string %q cannot be evaluated as a series", t.S)
+	case *metricsql.MetricExpr:
+		re := &metricsql.RollupExpr{
+			Expr: t,
+		}
+		return evalRollupExpr(ec, "default_rollup", nil, re, true)
+	case *metricsql.RollupExpr:
+		return evalRollupExpr(ec, "default_rollup", nil, t, true)
+	case *metricsql.FuncExpr:
+		if t.Name == "" {
+			return evalUnion(ec, t.Args)
+		}
+		if metricsql.IsRollupFunc(t.Name) {
+			return evalRollupFuncExpr(ec, t)
+		}
+		return evalTransformFuncExpr(ec, t)
+	case *metricsql.AggrFuncExpr:
+		return evalAggrFuncExpr(ec, t)
+	case *metricsql.BinaryOpExpr:
+		return evalBinaryOpExpr(ec, t)
+	default:
+		return nil, fmt.Errorf("unsupported expression %s", e.AppendString(nil))
+	}
+}
+
+// evalUnion evaluates `(q1, ..., qN)`.
+//
+// Series with duplicate labels are taken from the first query containing them.
+func evalUnion(ec *evalConfig, args []metricsql.Expr) ([]*timeseries, error) {
+	var result []*timeseries
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		tss, err := evalExpr(ec, arg)
+		if err != nil {
+			return nil, err
+		}
+		for _, ts := range tss {
+			key := labelsString(ts.labels)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			result = append(result, ts)
+		}
+	}
+	return result, nil
+}
+
+// evalScalarArg evaluates e, which must return a single series, e.g. scalar.
+func evalScalarArg(ec *evalConfig, e metricsql.Expr) ([]float64, error) {
+	tss, err := evalExpr(ec, e)
+	if err != nil {
+		return nil, err
+	}
+	if len(tss) != 1 {
+		return nil, fmt.Errorf("%s must return a single series; got %d series", e.AppendString(nil), len(tss))
+	}
+	return tss[0].values, nil
+}
+
+// evalStringArg returns the string value for e.
+func evalStringArg(e metricsql.Expr) (string, error) {
+	se, ok := e.(*metricsql.StringExpr)
+	if !ok {
+		return "", fmt.Errorf("expecting string; got %s", e.AppendString(nil))
+	}
+	return se.S, nil
+}
+
+// evalRollupExpr evaluates the rollup function with the given name and the given params over the series from re.
+//
+// The metric name is kept in the returned series if keepMetricName is set.
+func evalRollupExpr(ec *evalConfig, funcName string, params [][]float64, re *metricsql.RollupExpr, keepMetricName bool) ([]*timeseries, error) {
+	rf := rollupFuncs[funcName]
+	if rf == nil {
+		return nil, fmt.Errorf("unsupported rollup function %q", funcName)
+	}
+	window := ec.step
+	if re.Window != nil {
+		window = re.Window.Duration(ec.step)
+	} else if funcName == "default_rollup" && !re.ForSubquery() {
+		window = lookbackDelta
+	}
+	if window <= 0 {
+		return nil, fmt.Errorf("window must be positive in %s", re.AppendString(nil))
+	}
+	offset := re.Offset.Duration(ec.step)
+
+	// Calculate timestamps for the evaluation of rollup function.
+	timestamps := ec.timestamps()
+	if re.At != nil {
+		at, err := evalAt(ec, re.At)
+		if err != nil {
+			return nil, err
+		}
+		for i := range timestamps {
+			timestamps[i] = at
+		}
+	}
+	for i := range timestamps {
+		timestamps[i] -= offset
+	}
+	minTimestamp := timestamps[0]
+	maxTimestamp := timestamps[len(timestamps)-1]
+
+	// Obtain samples for the rollup.
+	var series []*Series
+	if me, ok := re.Expr.(*metricsql.MetricExpr); ok && !re.ForSubquery() {
+		ss, err := ec.storage.Select(me.LabelFilterss, minTimestamp-window-lookbackDelta, maxTimestamp)
+		if err != nil {
+			return nil, fmt.Errorf("cannot select series for %s: %w", me.AppendString(nil), err)
+		}
+		series = ss
+	} else {
+		step := ec.step
+		if re.Step != nil {
+			step = re.Step.Duration(ec.step)
+		}
+		if step <= 0 {
+			return nil, fmt.Errorf("step must be positive in %s", re.AppendString(nil))
+		}
+		ss, err := evalSubquery(ec, re.Expr, minTimestamp-window, maxTimestamp, step)
+		if err != nil {
+			return nil, err
+		}
+		series = ss
+	}
+
+	result := make([]*timeseries, 0, len(series))
+	for _, s := range series {
+		labels := s.Labels
+		if !keepMetricName {
+			labels = copyLabels(labels)
+			delete(labels, "__name__")
+		}
+		values := make([]float64, len(timestamps))
+		for i, t := range timestamps {
+			rfa := newRollupFuncArg(s, t, window)
+			for _, p := range params {
+				rfa.params = append(rfa.params, p[i])
+			}
+			values[i] = rf(rfa)
+		}
+		result = append(result, &timeseries{
+			labels: labels,
+			values: values,
+		})
+	}
+	return result, nil
+}
+
+// evalSubquery evaluates e on the time range [start, end] with the given step and returns the results as raw samples.
+//
+// Timestamps for the returned samples are aligned to step.
+func evalSubquery(ec *evalConfig, e metricsql.Expr, start, end, step int64) ([]*Series, error) {
+	start = alignUp(start, step)
+	end = alignDown(end, step)
+	if start > end {
+		return nil, nil
+	}
+	if (end-start)/step >= maxPointsPerSeries {
+		return nil, fmt.Errorf("too many points per series in subquery %s; the limit is %d", e.AppendString(nil), int(maxPointsPerSeries))
+	}
+	ecSub := *ec
+	ecSub.start = start
+	ecSub.end = end
+	ecSub.step = step
+	tss, err := evalExpr(&ecSub, e)
+	if err != nil {
+		return nil, err
+	}
+	timestamps := ecSub.timestamps()
+	series := make([]*Series, 0, len(tss))
+	for _, ts := range tss {
+		s := &Series{
+			Labels: ts.labels,
+		}
+		for i, v := range ts.values {
+			if !math.IsNaN(v) {
+				s.Timestamps = append(s.Timestamps, timestamps[i])
+				s.Values = append(s.Values, v)
+			}
+		}
+		series = append(series, s)
+	}
+	return series, nil
+}
+
+// evalAt returns the timestamp in milliseconds for `@` modifier.
+func evalAt(ec *evalConfig, e metricsql.Expr) (int64, error) {
+	if fe, ok := e.(*metricsql.FuncExpr); ok && len(fe.Args) == 0 {
+		// start() and end() must return the query time range, since `@` must be evaluated only once per query.
+		switch strings.ToLower(fe.Name) {
+		case "start":
+			return ec.queryStart, nil
+		case "end":
+			return ec.queryEnd, nil
+		}
+	}
+	values, err := evalScalarArg(ec, e)
+	if err != nil {
+		return 0, fmt.Errorf("cannot evaluate `@` modifier: %w", err)
+	}
+	at := values[0]
+	if math.IsNaN(at) {
+		return 0, fmt.Errorf("`@` modifier cannot be NaN")
+	}
+	return int64(at * 1e3), nil
+}
+
+func evalRollupFuncExpr(ec *evalConfig, fe *metricsql.FuncExpr) ([]*timeseries, error) {
+	fs := metricsql.GetFuncSignature(fe.Name)
+	if len(fe.Args) < fs.MinArgs || !fs.IsVariadic() && len(fe.Args) > fs.MaxArgs {
+		return nil, fmt.Errorf("invalid number of args for %s(); got %d", fe.Name, len(fe.Args))
+	}
+	rollupArgIdx := metricsql.GetRollupArgIdx(fe)
+	if rollupArgIdx < 0 {
+		return nil, fmt.Errorf("cannot find rollup arg for %s", fe.AppendString(nil))
+	}
+	var params [][]float64
+	for i, arg := range fe.Args {
+		if i == rollupArgIdx {
+			continue
+		}
+		values, err := evalScalarArg(ec, arg)
+		if err != nil {
+			return nil, fmt.Errorf("cannot evaluate arg #%d for %s(): %w", i+1, fe.Name, err)
+		}
+		params = append(params, values)
+	}
+	var re *metricsql.RollupExpr
+	switch t := fe.Args[rollupArgIdx].(type) {
+	case *metricsql.RollupExpr:
+		re = t
+	default:
+		// Implicit subquery such as rate(sum(foo)) or rate(foo).
+		re = &metricsql.RollupExpr{
+			Expr: t,
+		}
+		if _, ok := t.(*metricsql.MetricExpr); !ok {
+			re.InheritStep = true
+		}
+	}
+	keepMetricName := fs.KeepMetricNames || fe.KeepMetricNames
+	return evalRollupExpr(ec, fs.Name, params, re, keepMetricName)
+}
+
+func alignUp(t, step int64) int64 {
+	n := alignDown(t, step)
+	if n < t {
+		n += step
+	}
+	return n
+}
+
+func alignDown(t, step int64) int64 {
+	n := t - t%step
+	if n > t {
+		n -= step
+	}
+	return n
+}
+
+// removeMetricNames removes metric names from tss.
+func removeMetricNames(tss []*timeseries) {
+	for _, ts := range tss {
+		if _, ok := ts.labels["__name__"]; ok {
+			ts.labels = copyLabels(ts.labels)
+			delete(ts.labels, "__name__")
+		}
+	}
+}
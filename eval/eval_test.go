@@ -0,0 +1,256 @@
+package eval
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Abhinav1299/metricsql"
+)
+
+// newTestStorage returns storage with the following series:
+//
+//   - counter{job="a",instance="1"} and counter{job="a",instance="2"} increasing by 10 and 20 every 10 seconds
+//   - counter{job="b",instance="1"} increasing by 30 every 10 seconds
+//   - gauge{job="a"} and gauge{job="b"} with values 1, 2, 3, ... and 10, 20, 30, ... every minute
+//   - info{job="a",version="v1"} and info{job="b",version="v2"} with value 1 every minute
+//
+// All the series have samples on the time range [0, 10m].
+func newTestStorage() *MemoryStorage {
+	ms := NewMemoryStorage()
+	for t := int64(0); t <= 600e3; t += 10e3 {
+		n := float64(t / 10e3)
+		ms.Add(map[string]string{"__name__": "counter", "job": "a", "instance": "1"}, t, 10*n)
+		ms.Add(map[string]string{"__name__": "counter", "job": "a", "instance": "2"}, t, 20*n)
+		ms.Add(map[string]string{"__name__": "counter", "job": "b", "instance": "1"}, t, 30*n)
+	}
+	for t := int64(0); t <= 600e3; t += 60e3 {
+		n := float64(t/60e3) + 1
+		ms.Add(map[string]string{"__name__": "gauge", "job": "a"}, t, n)
+		ms.Add(map[string]string{"__name__": "gauge", "job": "b"}, t, 10*n)
+		ms.Add(map[string]string{"__name__": "info", "job": "a", "version": "v1"}, t, 1)
+		ms.Add(map[string]string{"__name__": "info", "job": "b", "version": "v2"}, t, 1)
+	}
+	return ms
+}
+
+// formatSeries returns string representation for ss in the form `labels: t1=v1 ... tN=vN` per line.
+//
+// Timestamps are formatted in seconds.
+func formatSeries(ss []*Series) string {
+	var a []string
+	for _, s := range ss {
+		var points []string
+		for i, t := range s.Timestamps {
+			points = append(points, fmt.Sprintf("%d=%g", t/1e3, s.Values[i]))
+		}
+		a = append(a, fmt.Sprintf("%s: %s", s, strings.Join(points, " ")))
+	}
+	return strings.Join(a, "\n")
+}
+
+func TestEvalSuccess(t *testing.T) {
+	ms := newTestStorage()
+	f := func(q string, start, end, step int64, resultExpected string) {
+		t.Helper()
+
+		e, err := metricsql.Parse(q)
+		if err != nil {
+			t.Fatalf("cannot parse %s: %s", q, err)
+		}
+		ss, err := Eval(ms, e, start*1e3, end*1e3, step*1e3)
+		if err != nil {
+			t.Fatalf("unexpected error when evaluating %s: %s", q, err)
+		}
+		result := formatSeries(ss)
+		if result != resultExpected {
+			t.Fatalf("unexpected result for %s;\ngot\n%s\nwant\n%s", q, result, resultExpected)
+		}
+	}
+
+	// scalars
+	f(`1 + 2`, 0, 120, 60, `{}: 0=3 60=3 120=3`)
+	f(`time()`, 0, 120, 60, `{}: 0=0 60=60 120=120`)
+	f(`5m`, 0, 0, 60, `{}: 0=300`)
+	f(`1 > 2`, 0, 0, 60, ``)
+	f(`1 < bool 2`, 0, 0, 60, `{}: 0=1`)
+	f(`vector(time()) > 60`, 0, 120, 60, `{}: 120=120`)
+
+	// series selectors
+	f(`gauge`, 60, 180, 60, "gauge{job=\"a\"}: 60=2 120=3 180=4\ngauge{job=\"b\"}: 60=20 120=30 180=40")
+	f(`gauge{job="b"}`, 90, 150, 30, `gauge{job="b"}: 90=20 120=30 150=30`)
+	f(`gauge{job=~"a|c"}`, 0, 0, 60, `gauge{job="a"}: 0=1`)
+	f(`gauge{job!="a"}`, 0, 0, 60, `gauge{job="b"}: 0=10`)
+	f(`{__name__="gauge",job="a" or __name__="info",version="v2"}`, 0, 0, 60, "gauge{job=\"a\"}: 0=1\ninfo{job=\"b\",version=\"v2\"}: 0=1")
+	f(`gauge{job="a"}`, 890, 950, 60, `gauge{job="a"}: 890=11`)
+	f(`missing`, 0, 60, 60, ``)
+
+	// offset and @
+	f(`gauge{job="a"} offset 2m`, 120, 240, 60, `gauge{job="a"}: 120=1 180=2 240=3`)
+	f(`gauge{job="a"} @ 300`, 0, 120, 60, `gauge{job="a"}: 0=6 60=6 120=6`)
+	f(`gauge{job="a"} @ end()`, 0, 120, 60, `gauge{job="a"}: 0=3 60=3 120=3`)
+	f(`gauge{job="a"} @ start() offset 1m`, 60, 120, 60, `gauge{job="a"}: 60=1 120=1`)
+	f(`gauge{job="a"} @ END()`, 0, 120, 60, `gauge{job="a"}: 0=3 60=3 120=3`)
+	f(`gauge{job="a"} @ Start()`, 0, 120, 60, `gauge{job="a"}: 0=1 60=1 120=1`)
+
+	// rollup functions
+	f(`rate(counter{job="a"}[1m])`, 120, 180, 60, "{instance=\"1\",job=\"a\"}: 120=1 180=1\n{instance=\"2\",job=\"a\"}: 120=2 180=2")
+	f(`increase(counter{job="b"}[2m])`, 300, 300, 60, `{instance="1",job="b"}: 300=360`)
+	f(`irate(counter{job="b"}[1m])`, 300, 300, 60, `{instance="1",job="b"}: 300=3`)
+	f(`delta(gauge{job="b"}[2m])`, 300, 300, 60, `{job="b"}: 300=20`)
+	f(`idelta(gauge{job="b"}[5m])`, 300, 300, 60, `{job="b"}: 300=10`)
+	f(`deriv(gauge{job="a"}[5m])`, 300, 300, 60, `{job="a"}: 300=0.016666666666666666`)
+	f(`changes(gauge{job="a"}[3m])`, 300, 300, 60, `{job="a"}: 300=3`)
+	f(`avg_over_time(gauge{job="a"}[3m])`, 300, 300, 60, `gauge{job="a"}: 300=5`)
+	f(`min_over_time(gauge{job="a"}[3m])`, 300, 300, 60, `gauge{job="a"}: 300=4`)
+	f(`max_over_time(gauge{job="a"}[3m])`, 300, 300, 60, `gauge{job="a"}: 300=6`)
+	f(`sum_over_time(gauge{job="a"}[3m])`, 300, 300, 60, `{job="a"}: 300=15`)
+	f(`count_over_time(counter{instance="2"}[1m])`, 300, 300, 60, `{instance="2",job="a"}: 300=6`)
+	f(`quantile_over_time(0.5, gauge{job="a"}[4m])`, 300, 300, 60, `gauge{job="a"}: 300=4.5`)
+	f(`stddev_over_time(gauge{job="a"}[3m])`, 300, 300, 60, `{job="a"}: 300=0.816496580927726`)
+	f(`last_over_time(gauge{job="a"}[3m])`, 330, 330, 60, `gauge{job="a"}: 330=6`)
+	f(`timestamp(gauge{job="a"})`, 330, 330, 60, `{job="a"}: 330=300`)
+	f(`default_rollup(gauge{job="a"}[3m])`, 330, 330, 60, `gauge{job="a"}: 330=6`)
+	f(`range_over_time(gauge{job="a"}[3m])`, 300, 300, 60, `{job="a"}: 300=2`)
+	f(`rate(counter{job="b"})`, 300, 300, 60, `{instance="1",job="b"}: 300=3`)
+	f(`rate(counter{job="b"}[1m] offset 5m)`, 600, 600, 60, `{instance="1",job="b"}: 600=3`)
+	f(`resets(label_set(time() % 120, "a", "b")[5m:30s])`, 300, 300, 60, `{a="b"}: 300=2`)
+	f(`max_over_time(gauge{job="a"}[10m] @ 120)`, 600, 600, 60, `gauge{job="a"}: 600=3`)
+
+	// subqueries
+	f(`max_over_time(rate(counter{job="b"}[1m])[5m:1m])`, 300, 300, 60, `{instance="1",job="b"}: 300=3`)
+	f(`sum_over_time(gauge{job="a"}[3m:1m])`, 300, 300, 60, `{job="a"}: 300=15`)
+	f(`count_over_time(gauge{job="a"}[3m:30s])`, 300, 300, 60, `{job="a"}: 300=6`)
+	f(`min_over_time(sum(gauge)[2m:])`, 300, 300, 60, `{}: 300=55`)
+	f(`max_over_time((gauge{job="a"} * 2)[3m:1m] offset 1m)`, 300, 300, 60, `{job="a"}: 300=10`)
+
+	// aggregate functions
+	f(`sum(gauge)`, 0, 60, 60, `{}: 0=11 60=22`)
+	f(`sum(counter) by (job)`, 60, 60, 60, "{job=\"a\"}: 60=180\n{job=\"b\"}: 60=180")
+	f(`sum(counter) without (instance)`, 60, 60, 60, "{job=\"a\"}: 60=180\n{job=\"b\"}: 60=180")
+	f(`sum(counter) by (job) limit 1`, 60, 60, 60, `{job="a"}: 60=180`)
+	f(`count(counter) by (instance)`, 60, 60, 60, "{instance=\"1\"}: 60=2\n{instance=\"2\"}: 60=1")
+	f(`avg(gauge)`, 0, 0, 60, `{}: 0=5.5`)
+	f(`min(gauge)`, 0, 0, 60, `{}: 0=1`)
+	f(`max(gauge)`, 0, 0, 60, `{}: 0=10`)
+	f(`group(counter) by (job)`, 0, 0, 60, "{job=\"a\"}: 0=1\n{job=\"b\"}: 0=1")
+	f(`stddev(gauge)`, 0, 0, 60, `{}: 0=4.5`)
+	f(`stdvar(gauge)`, 0, 0, 60, `{}: 0=20.25`)
+	f(`quantile(0.5, gauge)`, 0, 0, 60, `{}: 0=5.5`)
+	f(`topk(1, counter)`, 60, 60, 60, `counter{instance="1",job="b"}: 60=180`)
+	f(`bottomk(2, counter)`, 60, 60, 60, "counter{instance=\"1\",job=\"a\"}: 60=60\ncounter{instance=\"2\",job=\"a\"}: 60=120")
+	f(`topk(1, counter) by (job)`, 60, 60, 60, "counter{instance=\"1\",job=\"b\"}: 60=180\ncounter{instance=\"2\",job=\"a\"}: 60=120")
+	f(`sum(gauge{job="a"}, gauge{job="b"})`, 0, 0, 60, `{}: 0=11`)
+
+	// transform functions
+	f(`abs(-gauge{job="a"})`, 0, 0, 60, `{job="a"}: 0=1`)
+	f(`clamp_max(gauge, 5)`, 0, 0, 60, "gauge{job=\"a\"}: 0=1\ngauge{job=\"b\"}: 0=5")
+	f(`clamp(gauge, 2, 5)`, 0, 0, 60, "gauge{job=\"a\"}: 0=2\ngauge{job=\"b\"}: 0=5")
+	f(`round(gauge{job="b"} / 3, 0.5)`, 0, 0, 60, `{job="b"}: 0=3.5`)
+	f(`scalar(gauge{job="a"}) * gauge{job="b"}`, 0, 0, 60, `{job="b"}: 0=10`)
+	f(`scalar(gauge)`, 0, 0, 60, ``)
+	f(`absent(gauge{job="c"})`, 0, 0, 60, `{job="c"}: 0=1`)
+	f(`absent(gauge{job="a"})`, 0, 0, 60, ``)
+	f(`label_set(gauge{job="a"}, "env", "prod")`, 0, 0, 60, `gauge{env="prod",job="a"}: 0=1`)
+	f(`label_del(info{job="a"}, "version")`, 0, 0, 60, `info{job="a"}: 0=1`)
+	f(`label_keep(info{job="a"}, "version")`, 0, 0, 60, `{version="v1"}: 0=1`)
+	f(`label_replace(info{job="a"}, "v", "$1", "version", "v(.+)")`, 0, 0, 60, `info{job="a",v="1",version="v1"}: 0=1`)
+	f(`abs(gauge{job="a"}) keep_metric_names`, 0, 0, 60, `gauge{job="a"}: 0=1`)
+	f(`(gauge{job="a"}, gauge{job="a"} + 1 keep_metric_names, info{job="b"})`, 0, 0, 60, "gauge{job=\"a\"}: 0=1\ninfo{job=\"b\",version=\"v2\"}: 0=1")
+
+	// binary operations with scalars
+	f(`gauge * 2`, 0, 0, 60, "{job=\"a\"}: 0=2\n{job=\"b\"}: 0=20")
+	f(`100 - gauge`, 0, 0, 60, "{job=\"a\"}: 0=99\n{job=\"b\"}: 0=90")
+	f(`gauge > 5`, 0, 60, 60, `gauge{job="b"}: 0=10 60=20`)
+	f(`5 < gauge`, 0, 0, 60, `gauge{job="b"}: 0=10`)
+	f(`gauge > bool 5`, 0, 0, 60, "{job=\"a\"}: 0=0\n{job=\"b\"}: 0=1")
+	f(`(gauge + 1) keep_metric_names`, 0, 0, 60, "gauge{job=\"a\"}: 0=2\ngauge{job=\"b\"}: 0=11")
+
+	// one-to-one matching
+	f(`gauge + gauge`, 0, 0, 60, "{job=\"a\"}: 0=2\n{job=\"b\"}: 0=20")
+	f(`gauge / on (job) info`, 0, 0, 60, "{job=\"a\"}: 0=1\n{job=\"b\"}: 0=10")
+	f(`gauge / ignoring (version) info`, 0, 0, 60, "{job=\"a\"}: 0=1\n{job=\"b\"}: 0=10")
+	f(`gauge > on (job) (info * 5)`, 0, 0, 60, `{job="b"}: 0=10`)
+	f(`gauge == ignoring (version) info`, 0, 0, 60, `gauge{job="a"}: 0=1`)
+	f(`gauge + on (job) sum(counter) by (job)`, 60, 60, 60, "{job=\"a\"}: 60=182\n{job=\"b\"}: 60=200")
+
+	// many-to-one matching
+	f(`counter / on (job) group_left gauge`, 60, 60, 60, "{instance=\"1\",job=\"a\"}: 60=30\n{instance=\"1\",job=\"b\"}: 60=9\n{instance=\"2\",job=\"a\"}: 60=60")
+	f(`counter * on (job) group_left (version) info`, 60, 60, 60, "{instance=\"1\",job=\"a\",version=\"v1\"}: 60=60\n{instance=\"1\",job=\"b\",version=\"v2\"}: 60=180\n{instance=\"2\",job=\"a\",version=\"v1\"}: 60=120")
+	f(`counter * on (job) group_left (version) prefix "info_" info`, 60, 60, 60, "{info_version=\"v1\",instance=\"1\",job=\"a\"}: 60=60\n{info_version=\"v1\",instance=\"2\",job=\"a\"}: 60=120\n{info_version=\"v2\",instance=\"1\",job=\"b\"}: 60=180")
+	f(`info * on (job) group_right (version) counter`, 60, 60, 60, "{instance=\"1\",job=\"a\",version=\"v1\"}: 60=60\n{instance=\"1\",job=\"b\",version=\"v2\"}: 60=180\n{instance=\"2\",job=\"a\",version=\"v1\"}: 60=120")
+	f(`gauge - ignoring (instance) group_right counter`, 60, 60, 60, "{instance=\"1\",job=\"a\"}: 60=-58\n{instance=\"1\",job=\"b\"}: 60=-160\n{instance=\"2\",job=\"a\"}: 60=-118")
+
+	// set operations
+	f(`gauge and on (job) info{version="v1"}`, 0, 0, 60, `gauge{job="a"}: 0=1`)
+	f(`gauge unless on (job) info{version="v1"}`, 0, 0, 60, `gauge{job="b"}: 0=10`)
+	f(`gauge{job="a"} or gauge`, 0, 0, 60, "gauge{job=\"a\"}: 0=1\ngauge{job=\"b\"}: 0=10")
+	f(`gauge{job="a"} or info`, 0, 0, 60, "gauge{job=\"a\"}: 0=1\ninfo{job=\"a\",version=\"v1\"}: 0=1\ninfo{job=\"b\",version=\"v2\"}: 0=1")
+	f(`gauge{job="a"} or on (job) info`, 0, 0, 60, "gauge{job=\"a\"}: 0=1\ninfo{job=\"b\",version=\"v2\"}: 0=1")
+	f(`(gauge{job="a"} > 2) or on (job) gauge`, 0, 120, 60, "gauge{job=\"a\"}: 0=1 60=2 120=3\ngauge{job=\"b\"}: 0=10 60=20 120=30")
+	f(`gauge if gauge > 5`, 0, 0, 60, `gauge{job="b"}: 0=10`)
+	f(`gauge ifnot gauge > 5`, 0, 0, 60, `gauge{job="a"}: 0=1`)
+	f(`(gauge > 5) default 0`, 0, 0, 60, "gauge{job=\"a\"}: 0=0\ngauge{job=\"b\"}: 0=10")
+	f(`gauge{job="a"} if time() > 60`, 0, 120, 60, `gauge{job="a"}: 120=3`)
+
+	// series with identical labels without common points
+	f(`label_del((gauge > 25) or (gauge{job="a"} < 3), "job")`, 0, 120, 60, `gauge{}: 0=1 60=2 120=30`)
+}
+
+func TestEvalError(t *testing.T) {
+	ms := newTestStorage()
+	f := func(q string) {
+		t.Helper()
+
+		e, err := metricsql.Parse(q)
+		if err != nil {
+			t.Fatalf("cannot parse %s: %s", q, err)
+		}
+		ss, err := Eval(ms, e, 0, 600e3, 60e3)
+		if err == nil {
+			t.Fatalf("expecting non-nil error when evaluating %s; got\n%s", q, formatSeries(ss))
+		}
+	}
+
+	// unsupported functions
+	f(`hoeffding_bound_lower(0.5, gauge[5m])`)
+	f(`histogram_quantile(0.5, gauge)`)
+	f(`mode(gauge)`)
+
+	// strings
+	f(`"foo"`)
+
+	// duplicate series
+	f(`gauge{job="a"} + on () counter`)
+	f(`counter + on (job) gauge`)
+	f(`gauge * on (job) group_left counter`)
+	f(`label_del(counter, "instance")`)
+	f(`counter * ignoring (instance) group_left (instance) gauge`)
+	f(`gauge * ignoring (instance) group_right (instance) counter`)
+
+	// non-scalar args
+	f(`clamp_max(gauge, gauge)`)
+	f(`quantile_over_time(gauge, gauge[5m])`)
+	f(`gauge @ gauge`)
+
+	// set operations with scalars
+	f(`gauge and 1`)
+}
+
+func TestEvalInvalidRange(t *testing.T) {
+	ms := newTestStorage()
+	e, err := metricsql.Parse(`gauge`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	f := func(start, end, step int64) {
+		t.Helper()
+		if _, err := Eval(ms, e, start, end, step); err == nil {
+			t.Fatalf("expecting non-nil error for start=%d, end=%d, step=%d", start, end, step)
+		}
+	}
+	f(0, 100, 0)
+	f(0, 100, -1)
+	f(100, 0, 10)
+	f(0, 1e12, 1)
+}
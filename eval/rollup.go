@@ -0,0 +1,318 @@
+package eval
+
+import (
+	"math"
+	"sort"
+)
+
+// rollupFuncArg contains raw samples on the lookbehind window for rollupFunc.
+type rollupFuncArg struct {
+	// prevValue is the value for the last sample before the window. It is NaN if there is no such sample.
+	prevValue float64
+
+	// prevTimestamp is the timestamp for prevValue.
+	prevTimestamp int64
+
+	// values and timestamps contain samples on the window (currTimestamp-window, currTimestamp].
+	values     []float64
+	timestamps []int64
+
+	currTimestamp int64
+	window        int64
+
+	// params contains values for the scalar args of the rollup function at currTimestamp.
+	params []float64
+}
+
+func newRollupFuncArg(s *Series, currTimestamp, window int64) *rollupFuncArg {
+	start := currTimestamp - window
+	n := sort.Search(len(s.Timestamps), func(i int) bool {
+		return s.Timestamps[i] > start
+	})
+	m := sort.Search(len(s.Timestamps), func(i int) bool {
+		return s.Timestamps[i] > currTimestamp
+	})
+	rfa := &rollupFuncArg{
+		prevValue:     nan,
+		values:        s.Values[n:m],
+		timestamps:    s.Timestamps[n:m],
+		currTimestamp: currTimestamp,
+		window:        window,
+	}
+	if n > 0 && start-s.Timestamps[n-1] <= lookbackDelta {
+		rfa.prevValue = s.Values[n-1]
+		rfa.prevTimestamp = s.Timestamps[n-1]
+	}
+	return rfa
+}
+
+// rollupFunc must return rollup value for rfa. NaN must be returned if the value cannot be calculated.
+type rollupFunc func(rfa *rollupFuncArg) float64
+
+var rollupFuncs = map[string]rollupFunc{
+	"avg_over_time":      rollupAvg,
+	"changes":            rollupChanges,
+	"count_over_time":    rollupCount,
+	"default_rollup":     rollupLast,
+	"delta":              rollupDelta,
+	"deriv":              rollupDeriv,
+	"first_over_time":    rollupFirst,
+	"idelta":             rollupIdelta,
+	"increase":           rollupIncrease,
+	"irate":              rollupIrate,
+	"last_over_time":     rollupLast,
+	"max_over_time":      rollupMax,
+	"min_over_time":      rollupMin,
+	"present_over_time":  rollupPresent,
+	"quantile_over_time": rollupQuantile,
+	"range_over_time":    rollupRange,
+	"rate":               rollupRate,
+	"resets":             rollupResets,
+	"stddev_over_time":   rollupStddev,
+	"stdvar_over_time":   rollupStdvar,
+	"sum_over_time":      rollupSum,
+	"timestamp":          rollupTimestamp,
+}
+
+func rollupAvg(rfa *rollupFuncArg) float64 {
+	if len(rfa.values) == 0 {
+		return nan
+	}
+	return rollupSum(rfa) / float64(len(rfa.values))
+}
+
+func rollupSum(rfa *rollupFuncArg) float64 {
+	if len(rfa.values) == 0 {
+		return nan
+	}
+	sum := float64(0)
+	for _, v := range rfa.values {
+		sum += v
+	}
+	return sum
+}
+
+func rollupCount(rfa *rollupFuncArg) float64 {
+	if len(rfa.values) == 0 {
+		return nan
+	}
+	return float64(len(rfa.values))
+}
+
+func rollupPresent(rfa *rollupFuncArg) float64 {
+	if len(rfa.values) == 0 {
+		return nan
+	}
+	return 1
+}
+
+func rollupFirst(rfa *rollupFuncArg) float64 {
+	if len(rfa.values) == 0 {
+		return nan
+	}
+	return rfa.values[0]
+}
+
+func rollupLast(rfa *rollupFuncArg) float64 {
+	if len(rfa.values) == 0 {
+		return nan
+	}
+	return rfa.values[len(rfa.values)-1]
+}
+
+func rollupTimestamp(rfa *rollupFuncArg) float64 {
+	if len(rfa.timestamps) == 0 {
+		return nan
+	}
+	return float64(rfa.timestamps[len(rfa.timestamps)-1]) / 1e3
+}
+
+func rollupMin(rfa *rollupFuncArg) float64 {
+	if len(rfa.values) == 0 {
+		return nan
+	}
+	min := rfa.values[0]
+	for _, v := range rfa.values[1:] {
+		if v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+func rollupMax(rfa *rollupFuncArg) float64 {
+	if len(rfa.values) == 0 {
+		return nan
+	}
+	max := rfa.values[0]
+	for _, v := range rfa.values[1:] {
+		if v > max {
+			max = v
+		}
+	}
+	return max
+}
+
+func rollupRange(rfa *rollupFuncArg) float64 {
+	return rollupMax(rfa) - rollupMin(rfa)
+}
+
+func rollupStdvar(rfa *rollupFuncArg) float64 {
+	if len(rfa.values) == 0 {
+		return nan
+	}
+	avg := rollupAvg(rfa)
+	sum := float64(0)
+	for _, v := range rfa.values {
+		d := v - avg
+		sum += d * d
+	}
+	return sum / float64(len(rfa.values))
+}
+
+func rollupStddev(rfa *rollupFuncArg) float64 {
+	return math.Sqrt(rollupStdvar(rfa))
+}
+
+func rollupQuantile(rfa *rollupFuncArg) float64 {
+	if len(rfa.values) == 0 || len(rfa.params) == 0 {
+		return nan
+	}
+	return quantile(rfa.params[0], rfa.values)
+}
+
+// quantile returns phi-quantile over values using linear interpolation between the closest ranks.
+func quantile(phi float64, values []float64) float64 {
+	if len(values) == 0 || math.IsNaN(phi) {
+		return nan
+	}
+	if phi < 0 {
+		return math.Inf(-1)
+	}
+	if phi > 1 {
+		return math.Inf(1)
+	}
+	a := append([]float64{}, values...)
+	sort.Float64s(a)
+	rank := phi * float64(len(a)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	weight := rank - float64(lower)
+	return a[lower]*(1-weight) + a[upper]*weight
+}
+
+// valuesWithPrev returns values on the window prepended with the value before the window if it exists.
+func valuesWithPrev(rfa *rollupFuncArg) ([]float64, []int64) {
+	if math.IsNaN(rfa.prevValue) {
+		return rfa.values, rfa.timestamps
+	}
+	values := append([]float64{rfa.prevValue}, rfa.values...)
+	timestamps := append([]int64{rfa.prevTimestamp}, rfa.timestamps...)
+	return values, timestamps
+}
+
+func rollupChanges(rfa *rollupFuncArg) float64 {
+	if len(rfa.values) == 0 {
+		return nan
+	}
+	values, _ := valuesWithPrev(rfa)
+	n := 0
+	for i := 1; i < len(values); i++ {
+		if values[i] != values[i-1] {
+			n++
+		}
+	}
+	return float64(n)
+}
+
+func rollupResets(rfa *rollupFuncArg) float64 {
+	if len(rfa.values) == 0 {
+		return nan
+	}
+	values, _ := valuesWithPrev(rfa)
+	n := 0
+	for i := 1; i < len(values); i++ {
+		if values[i] < values[i-1] {
+			n++
+		}
+	}
+	return float64(n)
+}
+
+func rollupDelta(rfa *rollupFuncArg) float64 {
+	if len(rfa.values) == 0 {
+		return nan
+	}
+	values, _ := valuesWithPrev(rfa)
+	return values[len(values)-1] - values[0]
+}
+
+// rollupIncrease returns the increase for counter on the window starting from the last sample before the window.
+//
+// Counter resets are detected by value decrease.
+func rollupIncrease(rfa *rollupFuncArg) float64 {
+	if len(rfa.values) == 0 {
+		return nan
+	}
+	values, _ := valuesWithPrev(rfa)
+	increase := float64(0)
+	for i := 1; i < len(values); i++ {
+		d := values[i] - values[i-1]
+		if d < 0 {
+			// Counter reset.
+			d = values[i]
+		}
+		increase += d
+	}
+	return increase
+}
+
+func rollupRate(rfa *rollupFuncArg) float64 {
+	return rollupIncrease(rfa) / (float64(rfa.window) / 1e3)
+}
+
+func rollupIdelta(rfa *rollupFuncArg) float64 {
+	values, _ := valuesWithPrev(rfa)
+	if len(rfa.values) == 0 || len(values) < 2 {
+		return nan
+	}
+	return values[len(values)-1] - values[len(values)-2]
+}
+
+func rollupIrate(rfa *rollupFuncArg) float64 {
+	values, timestamps := valuesWithPrev(rfa)
+	if len(rfa.values) == 0 || len(values) < 2 {
+		return nan
+	}
+	n := len(values) - 1
+	d := values[n] - values[n-1]
+	if d < 0 {
+		// Counter reset.
+		d = values[n]
+	}
+	dt := float64(timestamps[n]-timestamps[n-1]) / 1e3
+	return d / dt
+}
+
+// rollupDeriv returns per-second derivative using linear regression over samples on the window.
+func rollupDeriv(rfa *rollupFuncArg) float64 {
+	if len(rfa.values) < 2 {
+		return nan
+	}
+	// Use relative timestamps in order to reduce precision loss.
+	t0 := rfa.timestamps[0]
+	var sumX, sumY, sumXY, sumX2 float64
+	for i, v := range rfa.values {
+		x := float64(rfa.timestamps[i]-t0) / 1e3
+		sumX += x
+		sumY += v
+		sumXY += x * v
+		sumX2 += x * x
+	}
+	n := float64(len(rfa.values))
+	d := n*sumX2 - sumX*sumX
+	if d == 0 {
+		return nan
+	}
+	return (n*sumXY - sumX*sumY) / d
+}
@@ -0,0 +1,232 @@
+package eval
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Abhinav1299/metricsql"
+)
+
+// Series is a time series with labels and samples.
+type Series struct {
+	// Labels contains series labels. Metric name is stored in `__name__` label.
+	Labels map[string]string
+
+	// Timestamps contains sample timestamps in milliseconds sorted in ascending order.
+	Timestamps []int64
+
+	// Values contains sample values for the corresponding Timestamps.
+	Values []float64
+}
+
+// String returns string representation of labels for s, e.g. `foo{bar="baz"}`.
+func (s *Series) String() string {
+	return labelsString(s.Labels)
+}
+
+// Storage returns raw samples for series selectors.
+type Storage interface {
+	// Select returns series matching at least one of the given groups of label filters.
+	//
+	// lfss has the same format as metricsql.MetricExpr.LabelFilterss. Series must contain only samples
+	// with timestamps in the range [start, end] milliseconds.
+	//
+	// The returned series mustn't be modified by the caller.
+	Select(lfss [][]metricsql.LabelFilter, start, end int64) ([]*Series, error)
+}
+
+// MemoryStorage is in-memory Storage.
+//
+// MemoryStorage is safe for concurrent use.
+type MemoryStorage struct {
+	mu     sync.Mutex
+	series map[string]*Series
+}
+
+// NewMemoryStorage returns an empty MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		series: make(map[string]*Series),
+	}
+}
+
+// Add adds a sample with the given timestamp in milliseconds and the given value to the series with the given labels.
+//
+// Metric name must be passed in `__name__` label. Labels with empty values are ignored.
+func (ms *MemoryStorage) Add(labels map[string]string, timestamp int64, value float64) {
+	labels = copyLabels(labels)
+	for name, value := range labels {
+		if value == "" {
+			delete(labels, name)
+		}
+	}
+	key := labelsString(labels)
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	s := ms.series[key]
+	if s == nil {
+		s = &Series{
+			Labels: labels,
+		}
+		ms.series[key] = s
+	}
+	n := sort.Search(len(s.Timestamps), func(i int) bool {
+		return s.Timestamps[i] >= timestamp
+	})
+	if n < len(s.Timestamps) && s.Timestamps[n] == timestamp {
+		s.Values[n] = value
+		return
+	}
+	s.Timestamps = append(s.Timestamps, 0)
+	copy(s.Timestamps[n+1:], s.Timestamps[n:])
+	s.Timestamps[n] = timestamp
+	s.Values = append(s.Values, 0)
+	copy(s.Values[n+1:], s.Values[n:])
+	s.Values[n] = value
+}
+
+// AddSeries adds samples with the given timestamps in milliseconds and the given values to the series with the given labels.
+//
+// See Add for details.
+func (ms *MemoryStorage) AddSeries(labels map[string]string, timestamps []int64, values []float64) error {
+	if len(timestamps) != len(values) {
+		return fmt.Errorf("the number of timestamps must match the number of values; got %d vs %d", len(timestamps), len(values))
+	}
+	for i, timestamp := range timestamps {
+		ms.Add(labels, timestamp, values[i])
+	}
+	return nil
+}
+
+// Select implements Storage interface.
+func (ms *MemoryStorage) Select(lfss [][]metricsql.LabelFilter, start, end int64) ([]*Series, error) {
+	matchers := make([][]*labelMatcher, len(lfss))
+	for i, lfs := range lfss {
+		lms, err := newLabelMatchers(lfs)
+		if err != nil {
+			return nil, err
+		}
+		matchers[i] = lms
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var result []*Series
+	for _, s := range ms.series {
+		if !matchAny(matchers, s.Labels) {
+			continue
+		}
+		n := sort.Search(len(s.Timestamps), func(i int) bool {
+			return s.Timestamps[i] >= start
+		})
+		m := sort.Search(len(s.Timestamps), func(i int) bool {
+			return s.Timestamps[i] > end
+		})
+		if n >= m {
+			continue
+		}
+		result = append(result, &Series{
+			Labels:     s.Labels,
+			Timestamps: append([]int64{}, s.Timestamps[n:m]...),
+			Values:     append([]float64{}, s.Values[n:m]...),
+		})
+	}
+	sortSeries(result)
+	return result, nil
+}
+
+type labelMatcher struct {
+	lf metricsql.LabelFilter
+	re interface {
+		MatchString(s string) bool
+	}
+}
+
+func newLabelMatchers(lfs []metricsql.LabelFilter) ([]*labelMatcher, error) {
+	lms := make([]*labelMatcher, len(lfs))
+	for i, lf := range lfs {
+		lm := &labelMatcher{
+			lf: lf,
+		}
+		if lf.IsRegexp {
+			re, err := metricsql.CompileRegexpAnchored(lf.Value)
+			if err != nil {
+				return nil, fmt.Errorf("cannot compile regexp for %s: %w", lf.AppendString(nil), err)
+			}
+			lm.re = re
+		}
+		lms[i] = lm
+	}
+	return lms, nil
+}
+
+func (lm *labelMatcher) match(labels map[string]string) bool {
+	// Missing label is equivalent to label with empty value.
+	v := labels[lm.lf.Label]
+	var ok bool
+	if lm.re != nil {
+		ok = lm.re.MatchString(v)
+	} else {
+		ok = v == lm.lf.Value
+	}
+	return ok != lm.lf.IsNegative
+}
+
+func matchAny(matchers [][]*labelMatcher, labels map[string]string) bool {
+	for _, lms := range matchers {
+		matched := true
+		for _, lm := range lms {
+			if !lm.match(labels) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	m := make(map[string]string, len(labels))
+	for name, value := range labels {
+		m[name] = value
+	}
+	return m
+}
+
+// labelsString returns string representation of labels in the form `name{label1="value1",...,labelN="valueN"}`.
+//
+// Labels are sorted by name, so the result may be used as a unique key for labels.
+func labelsString(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		if name != "__name__" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	sb.WriteString(labels["__name__"])
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, "%s=%q", name, labels[name])
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func sortSeries(ss []*Series) {
+	sort.Slice(ss, func(i, j int) bool {
+		return labelsString(ss[i].Labels) < labelsString(ss[j].Labels)
+	})
+}
@@ -0,0 +1,357 @@
+package eval
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/Abhinav1299/metricsql"
+)
+
+// transformFuncArg contains args for transformFunc.
+type transformFuncArg struct {
+	ec *evalConfig
+	fe *metricsql.FuncExpr
+
+	// args contains evaluated args for fe. It is nil for string args.
+	args [][]*timeseries
+}
+
+// transformFunc must return the result of transform function for tfa.
+type transformFunc func(tfa *transformFuncArg) ([]*timeseries, error)
+
+var transformFuncs = map[string]transformFunc{
+	"abs":           newTransformFuncOneArg(math.Abs),
+	"absent":        transformAbsent,
+	"ceil":          newTransformFuncOneArg(math.Ceil),
+	"clamp":         transformClamp,
+	"clamp_max":     transformClampMax,
+	"clamp_min":     transformClampMin,
+	"end":           newTransformFuncTime(func(ec *evalConfig, _ int64) float64 { return float64(ec.queryEnd) / 1e3 }),
+	"exp":           newTransformFuncOneArg(math.Exp),
+	"floor":         newTransformFuncOneArg(math.Floor),
+	"label_del":     transformLabelDel,
+	"label_keep":    transformLabelKeep,
+	"label_replace": transformLabelReplace,
+	"label_set":     transformLabelSet,
+	"ln":            newTransformFuncOneArg(math.Log),
+	"log10":         newTransformFuncOneArg(math.Log10),
+	"log2":          newTransformFuncOneArg(math.Log2),
+	"round":         transformRound,
+	"scalar":        transformScalar,
+	"sgn":           newTransformFuncOneArg(sgn),
+	"sqrt":          newTransformFuncOneArg(math.Sqrt),
+	"start":         newTransformFuncTime(func(ec *evalConfig, _ int64) float64 { return float64(ec.queryStart) / 1e3 }),
+	"step":          newTransformFuncTime(func(ec *evalConfig, _ int64) float64 { return float64(ec.step) / 1e3 }),
+	"time":          newTransformFuncTime(func(_ *evalConfig, t int64) float64 { return float64(t) / 1e3 }),
+	"vector":        transformVector,
+}
+
+func evalTransformFuncExpr(ec *evalConfig, fe *metricsql.FuncExpr) ([]*timeseries, error) {
+	fs := metricsql.GetFuncSignature(fe.Name)
+	if fs == nil {
+		return nil, fmt.Errorf("unknown function %q", fe.Name)
+	}
+	tf := transformFuncs[fs.Name]
+	if tf == nil {
+		return nil, fmt.Errorf("unsupported function %q", fe.Name)
+	}
+	if len(fe.Args) < fs.MinArgs || !fs.IsVariadic() && len(fe.Args) > fs.MaxArgs {
+		return nil, fmt.Errorf("invalid number of args for %s(); got %d", fe.Name, len(fe.Args))
+	}
+	tfa := &transformFuncArg{
+		ec:   ec,
+		fe:   fe,
+		args: make([][]*timeseries, len(fe.Args)),
+	}
+	for i, arg := range fe.Args {
+		if k, _ := fs.ArgKind(i, len(fe.Args)); k == metricsql.ArgKindString || k == metricsql.ArgKindLabelName {
+			continue
+		}
+		tss, err := evalExpr(ec, arg)
+		if err != nil {
+			return nil, err
+		}
+		tfa.args[i] = tss
+	}
+	tss, err := tf(tfa)
+	if err != nil {
+		return nil, fmt.Errorf("cannot evaluate %s: %w", fe.AppendString(nil), err)
+	}
+	if !fs.KeepMetricNames && !fe.KeepMetricNames {
+		removeMetricNames(tss)
+	}
+	return tss, nil
+}
+
+func newTransformFuncOneArg(f func(v float64) float64) transformFunc {
+	return func(tfa *transformFuncArg) ([]*timeseries, error) {
+		return mapValues(tfa.args[0], func(v float64, _ int) float64 {
+			return f(v)
+		}), nil
+	}
+}
+
+func newTransformFuncTime(f func(ec *evalConfig, t int64) float64) transformFunc {
+	return func(tfa *transformFuncArg) ([]*timeseries, error) {
+		return tfa.ec.newScalar(func(t int64) float64 {
+			return f(tfa.ec, t)
+		}), nil
+	}
+}
+
+// mapValues returns new series with values obtained by applying f to every value in tss.
+//
+// f accepts value and its index.
+func mapValues(tss []*timeseries, f func(v float64, i int) float64) []*timeseries {
+	result := make([]*timeseries, len(tss))
+	for i, ts := range tss {
+		values := make([]float64, len(ts.values))
+		for j, v := range ts.values {
+			values[j] = f(v, j)
+		}
+		result[i] = &timeseries{
+			labels: ts.labels,
+			values: values,
+		}
+	}
+	return result
+}
+
+// getScalarArg returns values for the scalar arg at index argIdx.
+func (tfa *transformFuncArg) getScalarArg(argIdx int) ([]float64, error) {
+	tss := tfa.args[argIdx]
+	if len(tss) != 1 {
+		return nil, fmt.Errorf("arg #%d must be scalar; got %d series", argIdx+1, len(tss))
+	}
+	return tss[0].values, nil
+}
+
+// getStringArgs returns string values for args starting from argIdx.
+func (tfa *transformFuncArg) getStringArgs(argIdx int) ([]string, error) {
+	var a []string
+	for _, arg := range tfa.fe.Args[argIdx:] {
+		s, err := evalStringArg(arg)
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, s)
+	}
+	return a, nil
+}
+
+func sgn(v float64) float64 {
+	switch {
+	case v < 0:
+		return -1
+	case v > 0:
+		return 1
+	default:
+		return v
+	}
+}
+
+func transformClamp(tfa *transformFuncArg) ([]*timeseries, error) {
+	mins, err := tfa.getScalarArg(1)
+	if err != nil {
+		return nil, err
+	}
+	maxs, err := tfa.getScalarArg(2)
+	if err != nil {
+		return nil, err
+	}
+	return mapValues(tfa.args[0], func(v float64, i int) float64 {
+		if mins[i] > maxs[i] {
+			return nan
+		}
+		return math.Max(mins[i], math.Min(maxs[i], v))
+	}), nil
+}
+
+func transformClampMin(tfa *transformFuncArg) ([]*timeseries, error) {
+	mins, err := tfa.getScalarArg(1)
+	if err != nil {
+		return nil, err
+	}
+	return mapValues(tfa.args[0], func(v float64, i int) float64 {
+		return math.Max(mins[i], v)
+	}), nil
+}
+
+func transformClampMax(tfa *transformFuncArg) ([]*timeseries, error) {
+	maxs, err := tfa.getScalarArg(1)
+	if err != nil {
+		return nil, err
+	}
+	return mapValues(tfa.args[0], func(v float64, i int) float64 {
+		return math.Min(maxs[i], v)
+	}), nil
+}
+
+func transformRound(tfa *transformFuncArg) ([]*timeseries, error) {
+	if len(tfa.args) == 1 {
+		return mapValues(tfa.args[0], func(v float64, _ int) float64 {
+			return math.Floor(v + 0.5)
+		}), nil
+	}
+	nearests, err := tfa.getScalarArg(1)
+	if err != nil {
+		return nil, err
+	}
+	return mapValues(tfa.args[0], func(v float64, i int) float64 {
+		return math.Floor(v/nearests[i]+0.5) * nearests[i]
+	}), nil
+}
+
+func transformVector(tfa *transformFuncArg) ([]*timeseries, error) {
+	values, err := tfa.getScalarArg(0)
+	if err != nil {
+		return nil, err
+	}
+	return []*timeseries{{
+		labels: map[string]string{},
+		values: values,
+	}}, nil
+}
+
+func transformScalar(tfa *transformFuncArg) ([]*timeseries, error) {
+	tss := tfa.args[0]
+	values := make([]float64, tfa.ec.pointsLen())
+	for i := range values {
+		// scalar() returns NaN at points with the number of series other than one.
+		values[i] = nan
+		n := 0
+		for _, ts := range tss {
+			if !math.IsNaN(ts.values[i]) {
+				values[i] = ts.values[i]
+				n++
+			}
+		}
+		if n != 1 {
+			values[i] = nan
+		}
+	}
+	return []*timeseries{{
+		labels: map[string]string{},
+		values: values,
+	}}, nil
+}
+
+// transformAbsent returns 1 at points without values in the arg.
+//
+// Labels for the returned series are obtained from equality filters if the arg is series selector.
+func transformAbsent(tfa *transformFuncArg) ([]*timeseries, error) {
+	tss := tfa.args[0]
+	values := make([]float64, tfa.ec.pointsLen())
+	for i := range values {
+		values[i] = 1
+		for _, ts := range tss {
+			if !math.IsNaN(ts.values[i]) {
+				values[i] = nan
+				break
+			}
+		}
+	}
+	labels := map[string]string{}
+	if me, ok := tfa.fe.Args[0].(*metricsql.MetricExpr); ok && len(me.LabelFilterss) == 1 {
+		for _, lf := range me.LabelFilterss[0] {
+			if !lf.IsRegexp && !lf.IsNegative && lf.Label != "__name__" {
+				labels[lf.Label] = lf.Value
+			}
+		}
+	}
+	return []*timeseries{{
+		labels: labels,
+		values: values,
+	}}, nil
+}
+
+func transformLabelSet(tfa *transformFuncArg) ([]*timeseries, error) {
+	a, err := tfa.getStringArgs(1)
+	if err != nil {
+		return nil, err
+	}
+	if len(a)%2 != 0 {
+		return nil, fmt.Errorf("label_set() must have even number of string args; got %d", len(a))
+	}
+	return mapLabels(tfa.args[0], func(labels map[string]string) {
+		for i := 0; i < len(a); i += 2 {
+			setLabel(labels, a[i], a[i+1])
+		}
+	}), nil
+}
+
+func transformLabelDel(tfa *transformFuncArg) ([]*timeseries, error) {
+	names, err := tfa.getStringArgs(1)
+	if err != nil {
+		return nil, err
+	}
+	return mapLabels(tfa.args[0], func(labels map[string]string) {
+		for _, name := range names {
+			delete(labels, name)
+		}
+	}), nil
+}
+
+func transformLabelKeep(tfa *transformFuncArg) ([]*timeseries, error) {
+	names, err := tfa.getStringArgs(1)
+	if err != nil {
+		return nil, err
+	}
+	keep := make(map[string]bool, len(names))
+	for _, name := range names {
+		keep[name] = true
+	}
+	return mapLabels(tfa.args[0], func(labels map[string]string) {
+		for name := range labels {
+			if !keep[name] {
+				delete(labels, name)
+			}
+		}
+	}), nil
+}
+
+func transformLabelReplace(tfa *transformFuncArg) ([]*timeseries, error) {
+	a, err := tfa.getStringArgs(1)
+	if err != nil {
+		return nil, err
+	}
+	dstLabel, replacement, srcLabel, regex := a[0], a[1], a[2], a[3]
+	re, err := metricsql.CompileRegexpAnchored(regex)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compile regexp %q: %w", regex, err)
+	}
+	return mapLabels(tfa.args[0], func(labels map[string]string) {
+		v := labels[srcLabel]
+		match := re.FindStringSubmatchIndex(v)
+		if match == nil {
+			return
+		}
+		dst := re.ExpandString(nil, replacement, v, match)
+		setLabel(labels, dstLabel, string(dst))
+	}), nil
+}
+
+// mapLabels returns tss with labels modified by f.
+//
+// f receives a copy of labels, so it may modify them.
+func mapLabels(tss []*timeseries, f func(labels map[string]string)) []*timeseries {
+	result := make([]*timeseries, len(tss))
+	for i, ts := range tss {
+		labels := copyLabels(ts.labels)
+		f(labels)
+		result[i] = &timeseries{
+			labels: labels,
+			values: ts.values,
+		}
+	}
+	return result
+}
+
+// setLabel sets label with the given name to value. Label is removed if value is empty.
+func setLabel(labels map[string]string, name, value string) {
+	if value == "" {
+		delete(labels, name)
+		return
+	}
+	labels[name] = value
+}
@@ -0,0 +1,283 @@
+package metricsql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FuncKind is the kind of MetricsQL function.
+type FuncKind int
+
+const (
+	// FuncKindRollup is the kind for rollup functions such as rate() or avg_over_time().
+	//
+	// Rollup functions are calculated over raw samples on the lookbehind window.
+	FuncKindRollup FuncKind = iota
+
+	// FuncKindTransform is the kind for transform functions such as abs() or label_set().
+	//
+	// Transform functions are calculated over the results of other expressions.
+	FuncKindTransform
+
+	// FuncKindAggr is the kind for aggregate functions such as sum() or topk().
+	FuncKindAggr
+)
+
+// String returns string representation for k.
+func (k FuncKind) String() string {
+	switch k {
+	case FuncKindRollup:
+		return "rollup"
+	case FuncKindTransform:
+		return "transform"
+	case FuncKindAggr:
+		return "aggregate"
+	default:
+		return fmt.Sprintf("FuncKind(%d)", int(k))
+	}
+}
+
+// ArgKind is the kind of function arg or function result.
+type ArgKind int
+
+const (
+	// ArgKindInstantVector is the kind for instant vectors such as `foo{bar="baz"}` or `sum(rate(foo[5m]))`.
+	ArgKindInstantVector ArgKind = iota
+
+	// ArgKindRangeVector is the kind for range vectors such as `foo[5m]`.
+	//
+	// MetricsQL allows passing instant vectors to args of this kind. In this case the lookbehind window
+	// is selected automatically.
+	ArgKindRangeVector
+
+	// ArgKindScalar is the kind for scalars such as `0.5` or `time()`.
+	ArgKindScalar
+
+	// ArgKindString is the kind for string literals such as `"foo"`.
+	ArgKindString
+
+	// ArgKindLabelName is the kind for string literals containing label names.
+	ArgKindLabelName
+)
+
+// String returns string representation for k.
+func (k ArgKind) String() string {
+	switch k {
+	case ArgKindInstantVector:
+		return "instant_vector"
+	case ArgKindRangeVector:
+		return "range_vector"
+	case ArgKindScalar:
+		return "scalar"
+	case ArgKindString:
+		return "string"
+	case ArgKindLabelName:
+		return "label_name"
+	default:
+		return fmt.Sprintf("ArgKind(%d)", int(k))
+	}
+}
+
+// IsVector returns true if k is instant or range vector.
+func (k ArgKind) IsVector() bool {
+	return k == ArgKindInstantVector || k == ArgKindRangeVector
+}
+
+// FuncSignature describes MetricsQL function.
+//
+// Use GetFuncSignature for obtaining the signature for the given function name.
+type FuncSignature struct {
+	// Name is the lowercase function name.
+	Name string
+
+	// Kind is the function kind.
+	Kind FuncKind
+
+	// MinArgs is the minimum number of args for the function.
+	MinArgs int
+
+	// MaxArgs is the maximum number of args for the function.
+	//
+	// MaxArgs is negative if the function accepts unlimited number of args.
+	// In this case the arg at VariadicArgIdx may be repeated any number of times.
+	MaxArgs int
+
+	// ArgKinds contains kinds for function args.
+	//
+	// Use ArgKind for obtaining the kind for the arg at the given index.
+	ArgKinds []ArgKind
+
+	// VariadicArgIdx is the index of the repeated arg in ArgKinds for functions with unlimited number of args.
+	VariadicArgIdx int
+
+	// ReturnKind is the kind of the function result.
+	ReturnKind ArgKind
+
+	// KeepMetricNames is set to true if the function keeps metric names for the returned series.
+	KeepMetricNames bool
+
+	// ChangesLabels is set to true if the function may change labels of the input series.
+	//
+	// Label filters applied to the function results cannot be pushed down to the function args in this case.
+	ChangesLabels bool
+
+	// PromQL is set to true if the function is supported by PromQL.
+	PromQL bool
+
+	// Description is a short human-readable description of the function.
+	Description string
+}
+
+// String returns human-readable signature for fs, e.g. `topk(scalar, instant_vector, [label_name]) instant_vector`.
+//
+// Optional args are enclosed in square brackets, while the repeated arg is followed by `...`.
+func (fs *FuncSignature) String() string {
+	args := make([]string, len(fs.ArgKinds))
+	for i, k := range fs.ArgKinds {
+		arg := k.String()
+		switch {
+		case fs.IsVariadic() && i == fs.VariadicArgIdx:
+			arg += "..."
+		case i >= fs.MinArgs:
+			arg = "[" + arg + "]"
+		}
+		args[i] = arg
+	}
+	return fmt.Sprintf("%s(%s) %s", fs.Name, strings.Join(args, ", "), fs.ReturnKind)
+}
+
+// IsVariadic returns true if fs accepts unlimited number of args.
+func (fs *FuncSignature) IsVariadic() bool {
+	return fs.MaxArgs < 0
+}
+
+// ArgKind returns the kind for the arg at index i when the function is called with argsLen args.
+//
+// false is returned if the function doesn't accept the arg at index i when called with argsLen args.
+func (fs *FuncSignature) ArgKind(i, argsLen int) (ArgKind, bool) {
+	if i < 0 || i >= argsLen {
+		return 0, false
+	}
+	if !fs.IsVariadic() {
+		if i >= len(fs.ArgKinds) {
+			return 0, false
+		}
+		return fs.ArgKinds[i], true
+	}
+	v := fs.VariadicArgIdx
+	repeats := argsLen - len(fs.ArgKinds) + 1
+	switch {
+	case i < v:
+		return fs.ArgKinds[i], true
+	case i < v+repeats:
+		return fs.ArgKinds[v], true
+	default:
+		i -= repeats - 1
+		if i >= len(fs.ArgKinds) {
+			return 0, false
+		}
+		return fs.ArgKinds[i], true
+	}
+}
+
+// SeriesArgIdx returns the index of the first vector arg when the function is called with argsLen args.
+//
+// If argsLen is smaller than fs.MinArgs, then the index is calculated for fs.MinArgs args.
+// -1 is returned if the function has no vector args.
+func (fs *FuncSignature) SeriesArgIdx(argsLen int) int {
+	if argsLen < fs.MinArgs {
+		argsLen = fs.MinArgs
+	}
+	for i := 0; i < argsLen; i++ {
+		if k, ok := fs.ArgKind(i, argsLen); ok && k.IsVector() {
+			return i
+		}
+	}
+	return -1
+}
+
+// hasVariadicSeriesArg returns true if fs accepts unlimited number of vector args.
+func (fs *FuncSignature) hasVariadicSeriesArg() bool {
+	return fs.IsVariadic() && fs.ArgKinds[fs.VariadicArgIdx].IsVector()
+}
+
+// GetFuncSignature returns the signature for MetricsQL function with the given name.
+//
+// The name is case-insensitive. nil is returned if there is no function with the given name.
+//
+// The returned signature is a copy, so the caller may modify it.
+func GetFuncSignature(name string) *FuncSignature {
+	fs := getFuncSignature(name)
+	if fs == nil {
+		return nil
+	}
+	return fs.clone()
+}
+
+// getFuncSignature returns the shared signature for the function with the given name.
+//
+// The returned signature mustn't be modified.
+func getFuncSignature(name string) *FuncSignature {
+	return funcSignatures[strings.ToLower(name)]
+}
+
+// clone returns a deep copy of fs.
+func (fs *FuncSignature) clone() *FuncSignature {
+	fsCopy := *fs
+	if fs.ArgKinds != nil {
+		fsCopy.ArgKinds = append([]ArgKind{}, fs.ArgKinds...)
+	}
+	return &fsCopy
+}
+
+// FuncSignatures returns signatures for all the MetricsQL functions sorted by name.
+//
+// The returned signatures are copies, so the caller may modify them.
+func FuncSignatures() []*FuncSignature {
+	fss := make([]*FuncSignature, 0, len(funcSignatures))
+	for _, fs := range funcSignatures {
+		fss = append(fss, fs.clone())
+	}
+	sort.Slice(fss, func(i, j int) bool {
+		return fss[i].Name < fss[j].Name
+	})
+	return fss
+}
+
+var funcSignatures = newFuncSignatures(rollupFuncs, transformFuncs, aggrFuncs)
+
+func newFuncSignatures(fsss ...[]*FuncSignature) map[string]*FuncSignature {
+	m := make(map[string]*FuncSignature)
+	for _, fss := range fsss {
+		for _, fs := range fss {
+			if m[fs.Name] != nil {
+				panic(fmt.Errorf("BUG: duplicate signature for the function %q", fs.Name))
+			}
+			if !fs.IsVariadic() && fs.MaxArgs != len(fs.ArgKinds) {
+				panic(fmt.Errorf("BUG: MaxArgs=%d must match the number of ArgKinds=%d for the function %q", fs.MaxArgs, len(fs.ArgKinds), fs.Name))
+			}
+			if fs.IsVariadic() && fs.VariadicArgIdx >= len(fs.ArgKinds) {
+				panic(fmt.Errorf("BUG: VariadicArgIdx=%d is out of ArgKinds for the function %q", fs.VariadicArgIdx, fs.Name))
+			}
+			if fs.IsVariadic() && fs.MinArgs < len(fs.ArgKinds)-1 {
+				panic(fmt.Errorf("BUG: MinArgs=%d cannot be smaller than the number of non-repeated ArgKinds=%d for the function %q", fs.MinArgs, len(fs.ArgKinds)-1, fs.Name))
+			}
+			m[fs.Name] = fs
+		}
+	}
+	return m
+}
+
+// setFuncKind sets Kind to kind for all the fss.
+func setFuncKind(kind FuncKind, fss []*FuncSignature) []*FuncSignature {
+	for _, fs := range fss {
+		fs.Kind = kind
+	}
+	return fss
+}
+
+func isFuncKind(name string, kind FuncKind) bool {
+	fs := GetFuncSignature(name)
+	return fs != nil && fs.Kind == kind
+}
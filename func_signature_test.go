@@ -0,0 +1,192 @@
+package metricsql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetFuncSignature(t *testing.T) {
+	f := func(name string, kindExpected FuncKind, minArgsExpected, maxArgsExpected int) {
+		t.Helper()
+
+		fs := GetFuncSignature(name)
+		if fs == nil {
+			t.Fatalf("cannot find signature for %q", name)
+		}
+		if fs.Kind != kindExpected {
+			t.Fatalf("unexpected kind for %q; got %s; want %s", name, fs.Kind, kindExpected)
+		}
+		if fs.MinArgs != minArgsExpected {
+			t.Fatalf("unexpected MinArgs for %q; got %d; want %d", name, fs.MinArgs, minArgsExpected)
+		}
+		if fs.MaxArgs != maxArgsExpected {
+			t.Fatalf("unexpected MaxArgs for %q; got %d; want %d", name, fs.MaxArgs, maxArgsExpected)
+		}
+	}
+
+	f("rate", FuncKindRollup, 1, 1)
+	f("RATE", FuncKindRollup, 1, 1)
+	f("quantiles_over_time", FuncKindRollup, 3, -1)
+	f("timestamp", FuncKindRollup, 1, 1)
+	f("", FuncKindTransform, 0, -1)
+	f("time", FuncKindTransform, 0, 0)
+	f("label_replace", FuncKindTransform, 5, 5)
+	f("Histogram_Quantile", FuncKindTransform, 2, 3)
+	f("sum", FuncKindAggr, 1, -1)
+	f("topk", FuncKindAggr, 2, 3)
+
+	for _, name := range []string{"foo", "ru", "alias", "range_median"} {
+		if fs := GetFuncSignature(name); fs != nil {
+			t.Fatalf("unexpected signature for %q: %+v", name, fs)
+		}
+	}
+}
+
+func TestGetFuncSignatureCopy(t *testing.T) {
+	fs := GetFuncSignature("topk")
+	fs.MaxArgs = 10
+	fs.ArgKinds[0] = ArgKindString
+	for _, fs := range FuncSignatures() {
+		fs.ArgKinds = nil
+	}
+
+	fs = GetFuncSignature("topk")
+	if fs.MaxArgs != 3 {
+		t.Fatalf("unexpected MaxArgs; got %d; want 3", fs.MaxArgs)
+	}
+	if fs.ArgKinds[0] != ArgKindScalar {
+		t.Fatalf("unexpected ArgKinds[0]; got %s; want %s", fs.ArgKinds[0], ArgKindScalar)
+	}
+	for _, fs := range FuncSignatures() {
+		if len(fs.ArgKinds) != len(getFuncSignature(fs.Name).ArgKinds) {
+			t.Fatalf("unexpected ArgKinds for %q; got %s", fs.Name, fs.ArgKinds)
+		}
+	}
+}
+
+func TestFuncSignatures(t *testing.T) {
+	fss := FuncSignatures()
+	if len(fss) != len(rollupFuncs)+len(transformFuncs)+len(aggrFuncs) {
+		t.Fatalf("unexpected number of signatures; got %d; want %d", len(fss), len(rollupFuncs)+len(transformFuncs)+len(aggrFuncs))
+	}
+	for i, fs := range fss {
+		if i > 0 && fss[i-1].Name >= fs.Name {
+			t.Fatalf("signatures must be sorted by name; got %q before %q", fss[i-1].Name, fs.Name)
+		}
+		if fs.Description == "" {
+			t.Fatalf("missing description for %q", fs.Name)
+		}
+		if fs.IsVariadic() {
+			continue
+		}
+		for n := fs.MinArgs; n <= fs.MaxArgs; n++ {
+			for i := 0; i < n; i++ {
+				if _, ok := fs.ArgKind(i, n); !ok {
+					t.Fatalf("missing kind for arg #%d of %q called with %d args", i, fs.Name, n)
+				}
+			}
+		}
+	}
+}
+
+func TestFuncSignatureArgKind(t *testing.T) {
+	f := func(name string, argsLen int, kindsExpected []ArgKind) {
+		t.Helper()
+
+		fs := GetFuncSignature(name)
+		var kinds []ArgKind
+		for i := 0; i < argsLen; i++ {
+			k, ok := fs.ArgKind(i, argsLen)
+			if !ok {
+				t.Fatalf("missing kind for arg #%d of %q called with %d args", i, name, argsLen)
+			}
+			kinds = append(kinds, k)
+		}
+		if !reflect.DeepEqual(kinds, kindsExpected) {
+			t.Fatalf("unexpected arg kinds for %q called with %d args;\ngot\n%v\nwant\n%v", name, argsLen, kinds, kindsExpected)
+		}
+		if _, ok := fs.ArgKind(argsLen, argsLen); ok {
+			t.Fatalf("unexpected kind for arg #%d of %q called with %d args", argsLen, name, argsLen)
+		}
+	}
+
+	f("rate", 1, []ArgKind{ArgKindRangeVector})
+	f("clamp", 3, []ArgKind{ArgKindInstantVector, ArgKindScalar, ArgKindScalar})
+	f("union", 3, []ArgKind{ArgKindInstantVector, ArgKindInstantVector, ArgKindInstantVector})
+	f("quantiles_over_time", 2, []ArgKind{ArgKindLabelName, ArgKindRangeVector})
+	f("quantiles_over_time", 4, []ArgKind{ArgKindLabelName, ArgKindScalar, ArgKindScalar, ArgKindRangeVector})
+	f("label_join", 3, []ArgKind{ArgKindInstantVector, ArgKindLabelName, ArgKindString})
+	f("label_join", 5, []ArgKind{ArgKindInstantVector, ArgKindLabelName, ArgKindString, ArgKindLabelName, ArgKindLabelName})
+	f("topk", 3, []ArgKind{ArgKindScalar, ArgKindInstantVector, ArgKindLabelName})
+
+	// Too many args for non-variadic function
+	if _, ok := GetFuncSignature("abs").ArgKind(1, 2); ok {
+		t.Fatalf("unexpected kind for the second arg of abs()")
+	}
+}
+
+func TestFuncSignatureSeriesArgIdx(t *testing.T) {
+	f := func(name string, argsLen, idxExpected int) {
+		t.Helper()
+
+		idx := GetFuncSignature(name).SeriesArgIdx(argsLen)
+		if idx != idxExpected {
+			t.Fatalf("unexpected series arg index for %q called with %d args; got %d; want %d", name, argsLen, idx, idxExpected)
+		}
+	}
+
+	f("rate", 1, 0)
+	f("rate", 0, 0)
+	f("quantile_over_time", 2, 1)
+	f("quantile_over_time", 0, 1)
+	f("quantiles_over_time", 5, 4)
+	f("limit_offset", 3, 2)
+	f("histogram_quantiles", 4, 3)
+	f("count_values", 2, 1)
+	f("time", 0, -1)
+	f("vector", 1, -1)
+}
+
+func TestFuncSignatureString(t *testing.T) {
+	f := func(name, resultExpected string) {
+		t.Helper()
+
+		result := GetFuncSignature(name).String()
+		if result != resultExpected {
+			t.Fatalf("unexpected signature for %q; got %q; want %q", name, result, resultExpected)
+		}
+	}
+
+	f("time", "time() scalar")
+	f("rate", "rate(range_vector) instant_vector")
+	f("topk", "topk(scalar, instant_vector, [label_name]) instant_vector")
+	f("quantiles_over_time", "quantiles_over_time(label_name, scalar..., range_vector) instant_vector")
+}
+
+func TestGetRollupArgIdx(t *testing.T) {
+	f := func(s string, idxExpected int) {
+		t.Helper()
+
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %q: %s", s, err)
+		}
+		fe, ok := e.(*FuncExpr)
+		if !ok {
+			t.Fatalf("unexpected expression type %T; want *FuncExpr", e)
+		}
+		idx := GetRollupArgIdx(fe)
+		if idx != idxExpected {
+			t.Fatalf("unexpected rollup arg index for %q; got %d; want %d", s, idx, idxExpected)
+		}
+	}
+
+	f(`rate(foo[5m])`, 0)
+	f(`absent_over_time(foo[5m])`, 0)
+	f(`quantile_over_time(0.5, foo[5m])`, 1)
+	f(`aggr_over_time(("min_over_time", "max_over_time"), foo[5m])`, 1)
+	f(`hoeffding_bound_upper(0.9, foo)`, 1)
+	f(`quantiles_over_time("phi", 0.1, 0.9, foo[5m])`, 3)
+	f(`abs(foo)`, -1)
+	f(`time()`, -1)
+}
@@ -0,0 +1,574 @@
+package metricsql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// JSONSchemaVersion is the version of the JSON schema used by ExprToJSON and ExprFromJSON.
+//
+// The version is incremented on incompatible changes to the schema.
+const JSONSchemaVersion = 1
+
+// ExprToJSON returns JSON representation of e.
+//
+// The returned JSON has the following structure:
+//
+//	{"version": 1, "expr": <node>}
+//
+// Every node is a JSON object with the "type" field, which determines the rest of the fields.
+// Fields with zero values are omitted. Node positions aren't encoded.
+//
+//	{"type": "metric", "label_filters": [[<filter>, ...], ...]}
+//	{"type": "rollup", "expr": <node>, "window": "5m", "step": "1m", "inherit_step": true, "offset": "1h", "at": <node>}
+//	{"type": "func", "name": "rate", "args": [<node>, ...], "keep_metric_names": true}
+//	{"type": "aggr", "name": "sum", "args": [<node>, ...], "modifier": <modifier>, "limit": 10}
+//	{"type": "binary_op", "op": "+", "bool": true, "group_modifier": <modifier>, "join_modifier": <modifier>,
+//	    "join_modifier_prefix": "foo_", "keep_metric_names": true, "left": <node>, "right": <node>}
+//	{"type": "number", "value": 1.5, "text": "1.5e0"}
+//	{"type": "string", "value": "foo"}
+//	{"type": "duration", "value": "5m"}
+//
+// Every item in "label_filters" contains a group of label filters, which are joined with `or`:
+//
+//	{"label": "job", "value": "foo.+", "negative": true, "regexp": true}
+//
+// Modifiers have the following structure:
+//
+//	{"op": "by", "args": ["job", "instance"]}
+//
+// Number values, which cannot be represented in JSON, are encoded as strings: "NaN", "+Inf" and "-Inf".
+// The optional "text" field contains the original spelling of the number such as "12Ki" or "0x3b"
+// if it differs from the value.
+//
+// The returned JSON can be converted back to Expr with ExprFromJSON.
+func ExprToJSON(e Expr) ([]byte, error) {
+	je, err := newJSONExpr(e)
+	if err != nil {
+		return nil, err
+	}
+	jd := &jsonDocument{
+		Version: JSONSchemaVersion,
+		Expr:    je,
+	}
+	return json.Marshal(jd)
+}
+
+// ExprFromJSON returns Expr for the JSON representation obtained from ExprToJSON.
+//
+// The JSON is validated structurally while decoding, so an error is returned for unknown functions,
+// operators and modifiers, invalid durations, invalid regexps, modifiers which cannot be applied to the operator, etc.
+func ExprFromJSON(data []byte) (Expr, error) {
+	var jd jsonDocument
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&jd); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal JSON: %w", err)
+	}
+	if jd.Version != JSONSchemaVersion {
+		return nil, fmt.Errorf("unsupported JSON schema version %d; want %d", jd.Version, JSONSchemaVersion)
+	}
+	if jd.Expr == nil {
+		return nil, fmt.Errorf("missing expr")
+	}
+	e, err := jd.Expr.toExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSupportedFunctions(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+type jsonDocument struct {
+	Version int       `json:"version"`
+	Expr    *jsonExpr `json:"expr"`
+}
+
+type jsonExpr struct {
+	Type string `json:"type"`
+
+	LabelFilters [][]jsonLabelFilter `json:"label_filters,omitempty"`
+
+	Expr        *jsonExpr `json:"expr,omitempty"`
+	Window      string    `json:"window,omitempty"`
+	Step        string    `json:"step,omitempty"`
+	InheritStep bool      `json:"inherit_step,omitempty"`
+	Offset      string    `json:"offset,omitempty"`
+	At          *jsonExpr `json:"at,omitempty"`
+
+	Name     string        `json:"name,omitempty"`
+	Args     []*jsonExpr   `json:"args,omitempty"`
+	Modifier *jsonModifier `json:"modifier,omitempty"`
+	Limit    int           `json:"limit,omitempty"`
+
+	Op                 string        `json:"op,omitempty"`
+	Bool               bool          `json:"bool,omitempty"`
+	GroupModifier      *jsonModifier `json:"group_modifier,omitempty"`
+	JoinModifier       *jsonModifier `json:"join_modifier,omitempty"`
+	JoinModifierPrefix *string       `json:"join_modifier_prefix,omitempty"`
+	Left               *jsonExpr     `json:"left,omitempty"`
+	Right              *jsonExpr     `json:"right,omitempty"`
+
+	KeepMetricNames bool `json:"keep_metric_names,omitempty"`
+
+	Value json.RawMessage `json:"value,omitempty"`
+	Text  string          `json:"text,omitempty"`
+}
+
+type jsonLabelFilter struct {
+	Label    string `json:"label"`
+	Value    string `json:"value"`
+	Negative bool   `json:"negative,omitempty"`
+	Regexp   bool   `json:"regexp,omitempty"`
+}
+
+type jsonModifier struct {
+	Op   string   `json:"op"`
+	Args []string `json:"args"`
+}
+
+func newJSONExpr(e Expr) (*jsonExpr, error) {
+	switch t := e.(type) {
+	case *MetricExpr:
+		if len(t.labelFilterss) > 0 {
+			return nil, fmt.Errorf("BUG: MetricExpr must be already expanded with expandWithExpr")
+		}
+		je := &jsonExpr{
+			Type: "metric",
+		}
+		for _, lfs := range t.LabelFilterss {
+			jlfs := make([]jsonLabelFilter, len(lfs))
+			for i, lf := range lfs {
+				jlfs[i] = jsonLabelFilter{
+					Label:    lf.Label,
+					Value:    lf.Value,
+					Negative: lf.IsNegative,
+					Regexp:   lf.IsRegexp,
+				}
+			}
+			je.LabelFilters = append(je.LabelFilters, jlfs)
+		}
+		return je, nil
+	case *RollupExpr:
+		jeInner, err := newJSONExpr(t.Expr)
+		if err != nil {
+			return nil, err
+		}
+		je := &jsonExpr{
+			Type:        "rollup",
+			Expr:        jeInner,
+			Window:      durationString(t.Window),
+			Step:        durationString(t.Step),
+			InheritStep: t.InheritStep,
+			Offset:      durationString(t.Offset),
+		}
+		if t.At != nil {
+			jeAt, err := newJSONExpr(t.At)
+			if err != nil {
+				return nil, err
+			}
+			je.At = jeAt
+		}
+		return je, nil
+	case *FuncExpr:
+		args, err := newJSONExprs(t.Args)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonExpr{
+			Type:            "func",
+			Name:            t.Name,
+			Args:            args,
+			KeepMetricNames: t.KeepMetricNames,
+		}, nil
+	case *AggrFuncExpr:
+		args, err := newJSONExprs(t.Args)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonExpr{
+			Type:     "aggr",
+			Name:     t.Name,
+			Args:     args,
+			Modifier: newJSONModifier(&t.Modifier),
+			Limit:    t.Limit,
+		}, nil
+	case *BinaryOpExpr:
+		left, err := newJSONExpr(t.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := newJSONExpr(t.Right)
+		if err != nil {
+			return nil, err
+		}
+		je := &jsonExpr{
+			Type:            "binary_op",
+			Op:              t.Op,
+			Bool:            t.Bool,
+			GroupModifier:   newJSONModifier(&t.GroupModifier),
+			JoinModifier:    newJSONModifier(&t.JoinModifier),
+			KeepMetricNames: t.KeepMetricNames,
+			Left:            left,
+			Right:           right,
+		}
+		if t.JoinModifierPrefix != nil {
+			prefix := t.JoinModifierPrefix.S
+			je.JoinModifierPrefix = &prefix
+		}
+		return je, nil
+	case *NumberExpr:
+		je := &jsonExpr{
+			Type:  "number",
+			Value: marshalJSONNumber(t.N),
+		}
+		if t.s != strconv.FormatFloat(t.N, 'g', -1, 64) {
+			je.Text = t.s
+		}
+		return je, nil
+	case *StringExpr:
+		if len(t.tokens) > 0 {
+			return nil, fmt.Errorf("BUG: StringExpr=%q must be already expanded with expandWithExpr", t.tokens)
+		}
+		return &jsonExpr{
+			Type:  "string",
+			Value: marshalJSONString(t.S),
+		}, nil
+	case *DurationExpr:
+		if t.needsParsing {
+			return nil, fmt.Errorf("BUG: DurationExpr=%q must be already expanded with expandWithExpr", t.s)
+		}
+		return &jsonExpr{
+			Type:  "duration",
+			Value: marshalJSONString(t.s),
+		}, nil
+	default:
+		return nil, fmt.Errorf("cannot marshal %T to JSON", e)
+	}
+}
+
+func newJSONExprs(args []Expr) ([]*jsonExpr, error) {
+	var jes []*jsonExpr
+	for _, arg := range args {
+		je, err := newJSONExpr(arg)
+		if err != nil {
+			return nil, err
+		}
+		jes = append(jes, je)
+	}
+	return jes, nil
+}
+
+func newJSONModifier(me *ModifierExpr) *jsonModifier {
+	if me.Op == "" {
+		return nil
+	}
+	args := me.Args
+	if args == nil {
+		args = []string{}
+	}
+	return &jsonModifier{
+		Op:   me.Op,
+		Args: args,
+	}
+}
+
+func durationString(de *DurationExpr) string {
+	if de == nil {
+		return ""
+	}
+	return de.s
+}
+
+func marshalJSONNumber(n float64) json.RawMessage {
+	if math.IsNaN(n) || math.IsInf(n, 0) {
+		return marshalJSONString(strconv.FormatFloat(n, 'g', -1, 64))
+	}
+	return json.RawMessage(strconv.FormatFloat(n, 'g', -1, 64))
+}
+
+func marshalJSONString(s string) json.RawMessage {
+	// json.Marshal cannot fail for strings.
+	data, _ := json.Marshal(s)
+	return data
+}
+
+func (je *jsonExpr) toExpr() (Expr, error) {
+	switch je.Type {
+	case "metric":
+		me := &MetricExpr{}
+		for _, jlfs := range je.LabelFilters {
+			if len(jlfs) == 0 {
+				return nil, fmt.Errorf("metric: label filters group cannot be empty")
+			}
+			lfs := make([]LabelFilter, len(jlfs))
+			for i, jlf := range jlfs {
+				if jlf.Label == "" {
+					return nil, fmt.Errorf("metric: label name cannot be empty")
+				}
+				lf := LabelFilter{
+					Label:      jlf.Label,
+					Value:      jlf.Value,
+					IsNegative: jlf.Negative,
+					IsRegexp:   jlf.Regexp,
+				}
+				if lf.IsRegexp {
+					if _, err := CompileRegexp(lf.Value); err != nil {
+						return nil, fmt.Errorf("metric: cannot compile regexp %q for label %q: %w", lf.Value, lf.Label, err)
+					}
+				}
+				lfs[i] = lf
+			}
+			me.LabelFilterss = append(me.LabelFilterss, lfs)
+		}
+		return me, nil
+	case "rollup":
+		if je.Expr == nil {
+			return nil, fmt.Errorf("rollup: missing expr")
+		}
+		if je.Window == "" && je.Step == "" && !je.InheritStep && je.Offset == "" && je.At == nil {
+			return nil, fmt.Errorf("rollup: missing window, step, offset and at")
+		}
+		if je.InheritStep && je.Step != "" {
+			return nil, fmt.Errorf("rollup: inherit_step cannot be set together with step")
+		}
+		e, err := je.Expr.toExpr()
+		if err != nil {
+			return nil, err
+		}
+		re := &RollupExpr{
+			Expr:        e,
+			InheritStep: je.InheritStep,
+		}
+		if re.Window, err = newJSONDuration("window", je.Window, false); err != nil {
+			return nil, err
+		}
+		if re.Step, err = newJSONDuration("step", je.Step, false); err != nil {
+			return nil, err
+		}
+		if re.Offset, err = newJSONDuration("offset", je.Offset, true); err != nil {
+			return nil, err
+		}
+		if je.At != nil {
+			if re.At, err = je.At.toExpr(); err != nil {
+				return nil, err
+			}
+		}
+		return re, nil
+	case "func":
+		args, err := jsonExprsToExprs(je.Args)
+		if err != nil {
+			return nil, err
+		}
+		return &FuncExpr{
+			Name:            je.Name,
+			Args:            args,
+			KeepMetricNames: je.KeepMetricNames,
+		}, nil
+	case "aggr":
+		if je.Name == "" {
+			return nil, fmt.Errorf("aggr: missing name")
+		}
+		args, err := jsonExprsToExprs(je.Args)
+		if err != nil {
+			return nil, err
+		}
+		if je.Limit < 0 {
+			return nil, fmt.Errorf("aggr: limit cannot be negative; got %d", je.Limit)
+		}
+		ae := &AggrFuncExpr{
+			Name:  je.Name,
+			Args:  args,
+			Limit: je.Limit,
+		}
+		if err := je.Modifier.toModifierExpr(&ae.Modifier, isAggrFuncModifier); err != nil {
+			return nil, fmt.Errorf("aggr: %w", err)
+		}
+		return ae, nil
+	case "binary_op":
+		if !isBinaryOp(je.Op) {
+			return nil, fmt.Errorf("binary_op: unknown op %q", je.Op)
+		}
+		if je.Left == nil || je.Right == nil {
+			return nil, fmt.Errorf("binary_op: missing left or right operand")
+		}
+		if je.Bool && !IsBinaryOpCmp(je.Op) {
+			return nil, fmt.Errorf("binary_op: bool modifier cannot be applied to %q", je.Op)
+		}
+		if je.JoinModifier != nil {
+			if je.GroupModifier == nil {
+				return nil, fmt.Errorf("binary_op: %q modifier requires on() or ignoring() modifier", je.JoinModifier.Op)
+			}
+			if isBinaryOpLogicalSet(je.Op) {
+				return nil, fmt.Errorf("binary_op: %q modifier cannot be applied to %q", je.JoinModifier.Op, je.Op)
+			}
+		}
+		if je.JoinModifierPrefix != nil && je.JoinModifier == nil {
+			return nil, fmt.Errorf("binary_op: prefix modifier requires group_left() or group_right() modifier")
+		}
+		left, err := je.Left.toExpr()
+		if err != nil {
+			return nil, err
+		}
+		right, err := je.Right.toExpr()
+		if err != nil {
+			return nil, err
+		}
+		be := &BinaryOpExpr{
+			Op:              je.Op,
+			Bool:            je.Bool,
+			KeepMetricNames: je.KeepMetricNames,
+			Left:            left,
+			Right:           right,
+		}
+		if err := je.GroupModifier.toModifierExpr(&be.GroupModifier, isBinaryOpGroupModifier); err != nil {
+			return nil, fmt.Errorf("binary_op: %w", err)
+		}
+		if err := je.JoinModifier.toModifierExpr(&be.JoinModifier, isBinaryOpJoinModifier); err != nil {
+			return nil, fmt.Errorf("binary_op: %w", err)
+		}
+		if je.JoinModifierPrefix != nil {
+			be.JoinModifierPrefix = &StringExpr{
+				S: *je.JoinModifierPrefix,
+			}
+		}
+		return be, nil
+	case "number":
+		n, err := unmarshalJSONNumber(je.Value)
+		if err != nil {
+			return nil, fmt.Errorf("number: %w", err)
+		}
+		if je.Text != "" {
+			v, err := parsePositiveNumber(je.Text)
+			if err != nil {
+				return nil, fmt.Errorf("number: cannot parse text %q: %w", je.Text, err)
+			}
+			if v != n && !(math.IsNaN(v) && math.IsNaN(n)) {
+				return nil, fmt.Errorf("number: text %q doesn't match value %v", je.Text, n)
+			}
+		}
+		return &NumberExpr{
+			N: n,
+			s: je.Text,
+		}, nil
+	case "string":
+		var s string
+		if err := json.Unmarshal(je.Value, &s); err != nil {
+			return nil, fmt.Errorf("string: cannot unmarshal value: %w", err)
+		}
+		return &StringExpr{
+			S: s,
+		}, nil
+	case "duration":
+		var s string
+		if err := json.Unmarshal(je.Value, &s); err != nil {
+			return nil, fmt.Errorf("duration: cannot unmarshal value: %w", err)
+		}
+		de, err := newJSONDuration("duration", s, false)
+		if err != nil {
+			return nil, err
+		}
+		if de == nil {
+			return nil, fmt.Errorf("duration: missing value")
+		}
+		return de, nil
+	default:
+		return nil, fmt.Errorf("unknown node type %q", je.Type)
+	}
+}
+
+func jsonExprsToExprs(jes []*jsonExpr) ([]Expr, error) {
+	args := make([]Expr, len(jes))
+	for i, je := range jes {
+		if je == nil {
+			return nil, fmt.Errorf("arg #%d cannot be null", i+1)
+		}
+		arg, err := je.toExpr()
+		if err != nil {
+			return nil, err
+		}
+		args[i] = arg
+	}
+	return args, nil
+}
+
+func (jm *jsonModifier) toModifierExpr(dst *ModifierExpr, isValidOp func(op string) bool) error {
+	if jm == nil {
+		return nil
+	}
+	if !isValidOp(jm.Op) {
+		return fmt.Errorf("unexpected modifier %q", jm.Op)
+	}
+	for _, arg := range jm.Args {
+		if arg == "" {
+			return fmt.Errorf("%s modifier cannot contain empty label names", jm.Op)
+		}
+	}
+	*dst = ModifierExpr{
+		Op:   jm.Op,
+		Args: jm.Args,
+	}
+	return nil
+}
+
+// newJSONDuration returns DurationExpr for the given duration s.
+//
+// s must have the same syntax as durations in queries, i.e. 5m, 1h30m or 300.
+// The leading minus is allowed only if canBeNegative is set. nil is returned for empty s.
+func newJSONDuration(name, s string, canBeNegative bool) (*DurationExpr, error) {
+	if s == "" {
+		return nil, nil
+	}
+	sPositive := s
+	if canBeNegative {
+		sPositive = strings.TrimPrefix(s, "-")
+	}
+	if !isPositiveDuration(sPositive) {
+		if n, err := scanPositiveNumber(sPositive); err != nil || n != sPositive {
+			return nil, fmt.Errorf("invalid %s %q", name, s)
+		}
+	}
+	d, err := DurationValue(s, 1)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %s %q: %w", name, s, err)
+	}
+	if !canBeNegative && d < 0 {
+		return nil, fmt.Errorf("%s cannot be negative; got %q", name, s)
+	}
+	return &DurationExpr{
+		s: s,
+	}, nil
+}
+
+func unmarshalJSONNumber(data json.RawMessage) (float64, error) {
+	if len(data) == 0 {
+		return 0, fmt.Errorf("missing value")
+	}
+	if data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return 0, fmt.Errorf("cannot unmarshal value: %w", err)
+		}
+		switch s {
+		case "NaN":
+			return nan, nil
+		case "+Inf", "Inf":
+			return math.Inf(1), nil
+		case "-Inf":
+			return math.Inf(-1), nil
+		default:
+			return 0, fmt.Errorf("unexpected value %q; want number, \"NaN\", \"+Inf\" or \"-Inf\"", s)
+		}
+	}
+	var n float64
+	if err := json.Unmarshal(data, &n); err != nil {
+		return 0, fmt.Errorf("cannot unmarshal value: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,163 @@
+package metricsql
+
+import (
+	"testing"
+)
+
+func TestExprJSONRoundTrip(t *testing.T) {
+	f := func(s string) {
+		t.Helper()
+
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %s: %s", s, err)
+		}
+		data, err := ExprToJSON(e)
+		if err != nil {
+			t.Fatalf("unexpected error in ExprToJSON(%s): %s", s, err)
+		}
+		eResult, err := ExprFromJSON(data)
+		if err != nil {
+			t.Fatalf("unexpected error in ExprFromJSON(%s): %s", data, err)
+		}
+		result := string(eResult.AppendString(nil))
+		resultExpected := string(e.AppendString(nil))
+		if result != resultExpected {
+			t.Fatalf("unexpected result for %s;\ngot\n%s\nwant\n%s", data, result, resultExpected)
+		}
+	}
+
+	f(`foo`)
+	f(`{}`)
+	f(`{foo="bar",baz!~"x.+",a!="b",c=~"d"}`)
+	f(`{foo="bar" or baz="x"}`)
+	f(`foo[5m]`)
+	f(`foo[5m:1m] offset -1h @ 123`)
+	f(`rate(foo[300:1.5] offset -2h30m)`)
+	f(`rate(foo[5i] offset 1i @ end())`)
+	f(`rate(foo[1h]) keep_metric_names`)
+	f(`max_over_time(rate(foo[5m])[1h:])`)
+	f(`time()`)
+	f(`sum(rate(foo[5m])) by (job, instance) limit 10`)
+	f(`sum(foo) without ()`)
+	f(`topk(3, foo, "other")`)
+	f(`foo + on(job) group_left(instance) prefix "x_" bar`)
+	f(`foo > bool ignoring(job) bar`)
+	f(`(foo + bar) keep_metric_names`)
+	f(`foo or bar unless baz`)
+	f(`(foo, bar)`)
+	f(`1.5`)
+	f(`-0.25`)
+	f(`NaN`)
+	f(`1/0`)
+	f(`-Inf`)
+	f(`12Ki + 0x3b + 073`)
+	f(`foo @ 1e3`)
+	f(`"foo\"bar"`)
+	f(`foo + 1h`)
+	f(`label_set(foo, "a", "b")`)
+	f(`histogram_quantile(0.9, sum(rate(foo[5m])) by (le))`)
+}
+
+func TestExprToJSON(t *testing.T) {
+	f := func(s, resultExpected string) {
+		t.Helper()
+
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %s: %s", s, err)
+		}
+		data, err := ExprToJSON(e)
+		if err != nil {
+			t.Fatalf("unexpected error in ExprToJSON(%s): %s", s, err)
+		}
+		if string(data) != resultExpected {
+			t.Fatalf("unexpected JSON for %s;\ngot\n%s\nwant\n%s", s, data, resultExpected)
+		}
+	}
+
+	f(`foo{bar=~"b.+" or baz!="x"}`, `{"version":1,"expr":{"type":"metric","label_filters":[[{"label":"__name__","value":"foo"},{"label":"bar","value":"b.+","regexp":true}],[{"label":"__name__","value":"foo"},{"label":"baz","value":"x","negative":true}]]}}`)
+	f(`rate(foo[5m:1m] offset 1h @ 123) keep_metric_names`, `{"version":1,"expr":{"type":"func","name":"rate","args":[{"type":"rollup","expr":{"type":"metric","label_filters":[[{"label":"__name__","value":"foo"}]]},"window":"5m","step":"1m","offset":"1h","at":{"type":"number","value":123}}],"keep_metric_names":true}}`)
+	f(`sum(foo) by (job) limit 3`, `{"version":1,"expr":{"type":"aggr","name":"sum","args":[{"type":"metric","label_filters":[[{"label":"__name__","value":"foo"}]]}],"modifier":{"op":"by","args":["job"]},"limit":3}}`)
+	f(`a / on() group_left(x) prefix "p_" b`, `{"version":1,"expr":{"type":"binary_op","op":"/","group_modifier":{"op":"on","args":[]},"join_modifier":{"op":"group_left","args":["x"]},"join_modifier_prefix":"p_","left":{"type":"metric","label_filters":[[{"label":"__name__","value":"a"}]]},"right":{"type":"metric","label_filters":[[{"label":"__name__","value":"b"}]]}}}`)
+	f(`NaN`, `{"version":1,"expr":{"type":"number","value":"NaN"}}`)
+	f(`foo + 12Ki`, `{"version":1,"expr":{"type":"binary_op","op":"+","left":{"type":"metric","label_filters":[[{"label":"__name__","value":"foo"}]]},"right":{"type":"number","value":12288,"text":"12Ki"}}}`)
+	f(`"foo"`, `{"version":1,"expr":{"type":"string","value":"foo"}}`)
+	f(`foo[5m:]`, `{"version":1,"expr":{"type":"rollup","expr":{"type":"metric","label_filters":[[{"label":"__name__","value":"foo"}]]},"window":"5m","inherit_step":true}}`)
+}
+
+func TestExprFromJSONError(t *testing.T) {
+	f := func(data string) {
+		t.Helper()
+
+		e, err := ExprFromJSON([]byte(data))
+		if err == nil {
+			t.Fatalf("expecting non-nil error for %s; got %s", data, e.AppendString(nil))
+		}
+	}
+
+	// invalid JSON
+	f(``)
+	f(`[]`)
+	f(`{"version":1,"expr":{"type":"metric"`)
+	f(`{"version":1,"expr":{"type":"metric","foo":"bar"}}`)
+
+	// invalid version
+	f(`{"expr":{"type":"metric"}}`)
+	f(`{"version":2,"expr":{"type":"metric"}}`)
+
+	// missing expr
+	f(`{"version":1}`)
+
+	// unknown type
+	f(`{"version":1,"expr":{"type":"foo"}}`)
+	f(`{"version":1,"expr":{}}`)
+
+	// invalid label filters
+	f(`{"version":1,"expr":{"type":"metric","label_filters":[[]]}}`)
+	f(`{"version":1,"expr":{"type":"metric","label_filters":[[{"label":"","value":"x"}]]}}`)
+	f(`{"version":1,"expr":{"type":"metric","label_filters":[[{"label":"x","value":"(","regexp":true}]]}}`)
+
+	// invalid rollup
+	f(`{"version":1,"expr":{"type":"rollup"}}`)
+	f(`{"version":1,"expr":{"type":"rollup","expr":{"type":"metric"},"window":"foo"}}`)
+	f(`{"version":1,"expr":{"type":"rollup","expr":{"type":"metric"},"window":"-5m"}}`)
+	f(`{"version":1,"expr":{"type":"rollup","expr":{"type":"metric"},"offset":"1x"}}`)
+	f(`{"version":1,"expr":{"type":"rollup","expr":{"type":"metric"}}}`)
+	f(`{"version":1,"expr":{"type":"rollup","expr":{"type":"metric"},"window":"5m","step":"1m","inherit_step":true}}`)
+	f(`{"version":1,"expr":{"type":"rollup","expr":{"type":"metric"},"window":" 5m"}}`)
+	f(`{"version":1,"expr":{"type":"rollup","expr":{"type":"metric"},"window":"+5m"}}`)
+	f(`{"version":1,"expr":{"type":"rollup","expr":{"type":"metric"},"window":"2h-3h"}}`)
+	f(`{"version":1,"expr":{"type":"rollup","expr":{"type":"metric"},"step":"1Ki"}}`)
+	f(`{"version":1,"expr":{"type":"rollup","expr":{"type":"metric"},"offset":"--5m"}}`)
+
+	// invalid functions
+	f(`{"version":1,"expr":{"type":"func","name":"foobar"}}`)
+	f(`{"version":1,"expr":{"type":"func","name":"ru","args":[{"type":"number","value":1},{"type":"number","value":2}]}}`)
+	f(`{"version":1,"expr":{"type":"func","name":"rate","args":[null]}}`)
+	f(`{"version":1,"expr":{"type":"aggr","name":"foobar"}}`)
+	f(`{"version":1,"expr":{"type":"aggr"}}`)
+	f(`{"version":1,"expr":{"type":"aggr","name":"sum","modifier":{"op":"on","args":["x"]}}}`)
+	f(`{"version":1,"expr":{"type":"aggr","name":"sum","limit":-1}}`)
+
+	// invalid binary operations
+	f(`{"version":1,"expr":{"type":"binary_op","op":"foo","left":{"type":"number","value":1},"right":{"type":"number","value":2}}}`)
+	f(`{"version":1,"expr":{"type":"binary_op","op":"+","left":{"type":"number","value":1}}}`)
+	f(`{"version":1,"expr":{"type":"binary_op","op":"+","group_modifier":{"op":"by"},"left":{"type":"metric"},"right":{"type":"metric"}}}`)
+	f(`{"version":1,"expr":{"type":"binary_op","op":"+","join_modifier":{"op":"on"},"left":{"type":"metric"},"right":{"type":"metric"}}}`)
+	f(`{"version":1,"expr":{"type":"binary_op","op":"+","bool":true,"left":{"type":"metric"},"right":{"type":"metric"}}}`)
+	f(`{"version":1,"expr":{"type":"binary_op","op":"+","join_modifier":{"op":"group_left"},"left":{"type":"metric"},"right":{"type":"metric"}}}`)
+	f(`{"version":1,"expr":{"type":"binary_op","op":"and","group_modifier":{"op":"on"},"join_modifier":{"op":"group_left"},"left":{"type":"metric"},"right":{"type":"metric"}}}`)
+	f(`{"version":1,"expr":{"type":"binary_op","op":"+","group_modifier":{"op":"on"},"join_modifier_prefix":"x_","left":{"type":"metric"},"right":{"type":"metric"}}}`)
+
+	// invalid scalars
+	f(`{"version":1,"expr":{"type":"number"}}`)
+	f(`{"version":1,"expr":{"type":"number","value":"foo"}}`)
+	f(`{"version":1,"expr":{"type":"number","value":1,"text":"foo"}}`)
+	f(`{"version":1,"expr":{"type":"number","value":1,"text":"2Ki"}}`)
+	f(`{"version":1,"expr":{"type":"string","value":1}}`)
+	f(`{"version":1,"expr":{"type":"duration"}}`)
+	f(`{"version":1,"expr":{"type":"duration","value":"foo"}}`)
+	f(`{"version":1,"expr":{"type":"duration","value":"-5m"}}`)
+	f(`{"version":1,"expr":{"type":"duration","value":"5m "}}`)
+}
@@ -0,0 +1,390 @@
+package metricsql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EmptySelector describes a series selector, which cannot match any series.
+type EmptySelector struct {
+	// Expr is the series selector.
+	Expr *MetricExpr
+
+	// Reason describes why Expr cannot match any series, e.g. `job="a" contradicts job="b"`.
+	Reason string
+}
+
+// FindEmptySelectors returns series selectors in e, which cannot match any series because of contradicting label filters.
+//
+// For example, `foo{job="a",job="b"}`, `foo{env="prod",env!="prod"}` and `foo{x=~"a.*",x="b"}` cannot match any series.
+// Selectors with or-delimited groups of filters such as `{job="a",job="b" or env="prod"}` are returned
+// only if all the groups cannot match any series.
+func FindEmptySelectors(e Expr) []*EmptySelector {
+	var result []*EmptySelector
+	Apply(e, func(c *Cursor) bool {
+		me, ok := c.Node().(*MetricExpr)
+		if !ok {
+			return true
+		}
+		if reason := getEmptySelectorReason(me); reason != "" {
+			result = append(result, &EmptySelector{
+				Expr:   me,
+				Reason: reason,
+			})
+		}
+		return false
+	}, nil)
+	return result
+}
+
+// SimplifyLabelFilters returns e with simplified label filters in series selectors.
+//
+// It performs the following simplifications:
+//
+//   - Removes filters implied by other filters for the same label, e.g. `foo{x="a",x!="b"}` is converted to `foo{x="a"}`,
+//     while `foo{x=~".*",y="z"}` is converted to `foo{y="z"}`.
+//   - Removes or-delimited groups of filters, which cannot match any series,
+//     e.g. `{x="a",x="b" or y="z"}` is converted to `{y="z"}`.
+//   - Removes branches, which cannot return data, from `or`, `and`, `unless` and `default` binary operations,
+//     e.g. `foo{x="a",x="b"} or bar` is converted to `bar`. See FindEmptySelectors.
+//
+// SimplifyLabelFilters doesn't modify e.
+func SimplifyLabelFilters(e Expr) Expr {
+	return simplifyLabelFiltersInplace(Clone(e))
+}
+
+func simplifyLabelFiltersInplace(e Expr) Expr {
+	return Rewrite(e, func(e Expr) Expr {
+		switch t := e.(type) {
+		case *MetricExpr:
+			simplifyMetricExprInplace(t)
+		case *BinaryOpExpr:
+			return simplifyEmptyBinaryOpExpr(t)
+		}
+		return e
+	})
+}
+
+func canSimplifyLabelFilters(me *MetricExpr) bool {
+	for _, lfs := range me.LabelFilterss {
+		lfsSimplified, reason := simplifyLabelFilters(lfs)
+		if reason != "" || len(lfsSimplified) != len(lfs) {
+			return true
+		}
+	}
+	return false
+}
+
+func simplifyMetricExprInplace(me *MetricExpr) {
+	var lfss [][]LabelFilter
+	for _, lfs := range me.LabelFilterss {
+		lfsSimplified, reason := simplifyLabelFilters(lfs)
+		if reason == "" {
+			lfss = append(lfss, lfsSimplified)
+		}
+	}
+	if len(lfss) == 0 {
+		// Leave the selector as is, since it cannot match any series.
+		return
+	}
+	me.LabelFilterss = lfss
+}
+
+// simplifyEmptyBinaryOpExpr removes branches of be, which cannot return data.
+func simplifyEmptyBinaryOpExpr(be *BinaryOpExpr) Expr {
+	leftEmpty := isEmptyExpr(be.Left)
+	rightEmpty := isEmptyExpr(be.Right)
+	switch strings.ToLower(be.Op) {
+	case "or", "default":
+		// EMPTY or bar -> bar
+		// foo or EMPTY -> foo
+		if leftEmpty {
+			return be.Right
+		}
+		if rightEmpty {
+			return be.Left
+		}
+	case "and":
+		// EMPTY and bar -> EMPTY
+		// foo and EMPTY -> EMPTY
+		if leftEmpty {
+			return be.Left
+		}
+		if rightEmpty {
+			return be.Right
+		}
+	case "unless":
+		// EMPTY unless bar -> EMPTY
+		// foo unless EMPTY -> foo
+		if leftEmpty || rightEmpty {
+			return be.Left
+		}
+	}
+	return be
+}
+
+// isEmptyExpr returns true if e cannot return data because of series selectors, which cannot match any series.
+func isEmptyExpr(e Expr) bool {
+	switch t := e.(type) {
+	case *MetricExpr:
+		return getEmptySelectorReason(t) != ""
+	case *RollupExpr:
+		return isEmptyExpr(t.Expr)
+	case *FuncExpr:
+		name := strings.ToLower(t.Name)
+		if strings.HasPrefix(name, "absent") {
+			// absent*() functions return data for empty args.
+			return false
+		}
+		argIdx := GetRollupArgIdx(t)
+		return argIdx >= 0 && argIdx < len(t.Args) && isEmptyExpr(t.Args[argIdx])
+	case *BinaryOpExpr:
+		switch strings.ToLower(t.Op) {
+		case "or", "default":
+			return isEmptyExpr(t.Left) && isEmptyExpr(t.Right)
+		case "unless", "ifnot":
+			return isEmptyExpr(t.Left)
+		default:
+			// Arithmetic and comparison operations return nothing for empty vector operands.
+			return isEmptyExpr(t.Left) || isEmptyExpr(t.Right)
+		}
+	default:
+		return false
+	}
+}
+
+// getEmptySelectorReason returns the reason why me cannot match any series.
+//
+// Empty string is returned if me may match series.
+func getEmptySelectorReason(me *MetricExpr) string {
+	if len(me.LabelFilterss) == 0 {
+		return ""
+	}
+	var reasons []string
+	for _, lfs := range me.LabelFilterss {
+		_, reason := simplifyLabelFilters(lfs)
+		if reason == "" {
+			return ""
+		}
+		reasons = append(reasons, reason)
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// labelFilterInfo contains the analyzed LabelFilter.
+type labelFilterInfo struct {
+	lf *LabelFilter
+
+	// values contains all the values matching lf if lf is positive and matches a finite set of values.
+	values []string
+
+	// rejected contains all the values, which don't match lf, if lf is negative and rejects a finite set of values.
+	rejected []string
+
+	// prefix and suffix are literal prefix and suffix for all the values matching positive lf.
+	prefix string
+	suffix string
+
+	// matchesAll is set to true if lf matches all the values.
+	matchesAll bool
+
+	// matchesNone is set to true if lf cannot match any value.
+	matchesNone bool
+
+	// opaque is set to true if lf cannot be analyzed because of invalid regexp.
+	opaque bool
+}
+
+func newLabelFilterInfo(lf *LabelFilter) *labelFilterInfo {
+	lfi := &labelFilterInfo{
+		lf: lf,
+	}
+	var values []string
+	if lf.IsRegexp {
+		ra, err := lf.AnalyzeRegexp()
+		if err != nil {
+			lfi.opaque = true
+			return lfi
+		}
+		values = ra.Values
+		if !lf.IsNegative {
+			lfi.prefix = ra.Prefix
+			lfi.suffix = ra.Suffix
+		}
+		if lf.IsNegative {
+			lfi.matchesAll = ra.MatchesNone
+			lfi.matchesNone = ra.MatchesAll
+		} else {
+			lfi.matchesAll = ra.MatchesAll
+			lfi.matchesNone = ra.MatchesNone
+		}
+	} else {
+		values = []string{lf.Value}
+		if !lf.IsNegative {
+			lfi.prefix = lf.Value
+			lfi.suffix = lf.Value
+		}
+	}
+	if lf.IsNegative {
+		lfi.rejected = values
+	} else {
+		lfi.values = values
+	}
+	return lfi
+}
+
+// match returns true if lfi matches the given label value v.
+func (lfi *labelFilterInfo) match(v string) bool {
+	lf := lfi.lf
+	var ok bool
+	if lf.IsRegexp {
+		re, err := CompileRegexpAnchored(lf.Value)
+		if err != nil {
+			return false
+		}
+		ok = re.MatchString(v)
+	} else {
+		ok = v == lf.Value
+	}
+	return ok != lf.IsNegative
+}
+
+// implies returns true if all the values matching lfi also match other.
+func (lfi *labelFilterInfo) implies(other *labelFilterInfo) bool {
+	if lfi.opaque || other.opaque {
+		return false
+	}
+	if other.matchesAll {
+		return true
+	}
+	if lfi.values != nil {
+		for _, v := range lfi.values {
+			if !other.match(v) {
+				return false
+			}
+		}
+		return true
+	}
+	if other.rejected != nil {
+		for _, v := range other.rejected {
+			if lfi.match(v) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// contradicts returns true if lfi and other cannot match the same value.
+func (lfi *labelFilterInfo) contradicts(other *labelFilterInfo) bool {
+	if lfi.opaque || other.opaque {
+		return false
+	}
+	if lfi.values != nil {
+		for _, v := range lfi.values {
+			if other.match(v) {
+				return false
+			}
+		}
+		return true
+	}
+	if other.values != nil {
+		return other.contradicts(lfi)
+	}
+	if !lfi.lf.IsNegative && !other.lf.IsNegative {
+		// Positive filters with incompatible prefixes or suffixes cannot match the same value,
+		// e.g. `x=~"a.*"` and `x=~"b.*"`.
+		if !strings.HasPrefix(lfi.prefix, other.prefix) && !strings.HasPrefix(other.prefix, lfi.prefix) {
+			return true
+		}
+		if !strings.HasSuffix(lfi.suffix, other.suffix) && !strings.HasSuffix(other.suffix, lfi.suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// simplifyLabelFilters returns lfs without duplicate filters and filters implied by other filters for the same label.
+//
+// Non-empty reason is returned if lfs cannot match any series. lfs isn't modified.
+func simplifyLabelFilters(lfs []LabelFilter) ([]LabelFilter, string) {
+	lfs = removeDuplicateLabelFilters(append([]LabelFilter{}, lfs...))
+	lfis := make([]*labelFilterInfo, len(lfs))
+	for i := range lfs {
+		lfis[i] = newLabelFilterInfo(&lfs[i])
+	}
+
+	// Search for contradictions.
+	for i, lfi := range lfis {
+		if lfi.matchesNone {
+			return nil, fmt.Sprintf("%s cannot match any value", lfi.lf.AppendString(nil))
+		}
+		for _, other := range lfis[i+1:] {
+			if lfi.lf.Label == other.lf.Label && lfi.contradicts(other) {
+				return nil, fmt.Sprintf("%s contradicts %s", lfi.lf.AppendString(nil), other.lf.AppendString(nil))
+			}
+		}
+	}
+	for _, lfi := range lfis {
+		if lfi.values == nil || lfi.opaque {
+			continue
+		}
+		if !hasMatchingValue(lfi.values, lfi.lf.Label, lfis) {
+			return nil, fmt.Sprintf("filters for %q label cannot match any value", lfi.lf.Label)
+		}
+	}
+
+	// Remove implied filters. Less specific filters are removed first,
+	// so `x="a",x=~"a"` is converted to `x="a"` instead of `x=~"a"`.
+	removed := make([]bool, len(lfis))
+	for _, removalOrder := range []func(lf *LabelFilter) bool{
+		func(lf *LabelFilter) bool { return lf.IsRegexp && !lf.IsNegative },
+		func(lf *LabelFilter) bool { return lf.IsNegative },
+		func(lf *LabelFilter) bool { return !lf.IsRegexp && !lf.IsNegative },
+	} {
+		for i, lfi := range lfis {
+			if !removalOrder(lfi.lf) {
+				continue
+			}
+			if lfi.matchesAll && len(lfis) > 1 {
+				removed[i] = true
+				continue
+			}
+			for j, other := range lfis {
+				if i != j && !removed[j] && other.lf.Label == lfi.lf.Label && other.implies(lfi) {
+					removed[i] = true
+					break
+				}
+			}
+		}
+	}
+	var dst []LabelFilter
+	for i, lf := range lfs {
+		if !removed[i] {
+			dst = append(dst, lf)
+		}
+	}
+	if len(dst) == 0 {
+		// Leave at least a single filter, since series selectors cannot be empty.
+		dst = append(dst, lfs[0])
+	}
+	return dst, ""
+}
+
+// hasMatchingValue returns true if at least a single value from values matches all the lfis for the given label.
+func hasMatchingValue(values []string, label string, lfis []*labelFilterInfo) bool {
+	for _, v := range values {
+		matched := true
+		for _, lfi := range lfis {
+			if lfi.lf.Label == label && !lfi.opaque && !lfi.match(v) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
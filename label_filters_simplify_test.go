@@ -0,0 +1,111 @@
+package metricsql
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSimplifyLabelFilters(t *testing.T) {
+	f := func(s, resultExpected string) {
+		t.Helper()
+
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %s: %s", s, err)
+		}
+		sOrig := string(e.AppendString(nil))
+		result := string(SimplifyLabelFilters(e).AppendString(nil))
+		if result != resultExpected {
+			t.Fatalf("unexpected result for SimplifyLabelFilters(%s);\ngot\n%s\nwant\n%s", s, result, resultExpected)
+		}
+		if s := string(e.AppendString(nil)); s != sOrig {
+			t.Fatalf("the original expression has been changed;\ngot\n%s\nwant\n%s", s, sOrig)
+		}
+	}
+
+	// nothing to simplify
+	f(`foo`, `foo`)
+	f(`foo{x="a",y!="b"}`, `foo{x="a",y!="b"}`)
+	f(`foo{x=~"a.*",x!~"ab.*"}`, `foo{x=~"a.*",x!~"ab.*"}`)
+	f(`foo{x=~"a|b",x!="a"}`, `foo{x=~"a|b",x!="a"}`)
+
+	// implied filters
+	f(`foo{x="a",x="a"}`, `foo{x="a"}`)
+	f(`foo{x="a",x!="b"}`, `foo{x="a"}`)
+	f(`foo{x="a",x=~"a|b"}`, `foo{x="a"}`)
+	f(`foo{x=~"a|b",x="a"}`, `foo{x="a"}`)
+	f(`foo{x="a",x!~"b.*"}`, `foo{x="a"}`)
+	f(`foo{x=~"a",x="a"}`, `foo{x="a"}`)
+	f(`foo{x=~"a|b",x=~"b|a"}`, `foo{x=~"b|a"}`)
+	f(`foo{x!="a",x!~"a|b"}`, `foo{x!~"a|b"}`)
+	f(`foo{x=~"a.+",x!=""}`, `foo{x=~"a.+"}`)
+	f(`foo{x=~"a|b",x!="c"}`, `foo{x=~"a|b"}`)
+	f(`foo{x=~".*",y="z"}`, `foo{y="z"}`)
+	f(`foo{x!~"",x!~""}`, `foo{x!~""}`)
+	f(`{__name__="foo",__name__=~"foo|bar",x="y"}`, `foo{x="y"}`)
+
+	// or-delimited groups
+	f(`{x="a",x="b" or y="z"}`, `{y="z"}`)
+	f(`{y="z" or x="a",x!="a"}`, `{y="z"}`)
+	f(`{x="a",x!="b" or y="z",y=~"z|w"}`, `{x="a" or y="z"}`)
+
+	// selectors without matching series are left as is
+	f(`foo{x="a",x="b"}`, `foo{x="a",x="b"}`)
+	f(`{x="a",x="b" or x="c",x="d"}`, `{x="a",x="b" or x="c",x="d"}`)
+
+	// binary operations
+	f(`foo{x="a",x="b"} or bar`, `bar`)
+	f(`bar or foo{x="a",x="b"}`, `bar`)
+	f(`bar or foo{x="a",x="b"} or baz`, `bar or baz`)
+	f(`foo{x="a",x="b"} default bar`, `bar`)
+	f(`foo{x="a",x="b"} and bar`, `foo{x="a",x="b"}`)
+	f(`bar and foo{x="a",x="b"}`, `foo{x="a",x="b"}`)
+	f(`foo{x="a",x="b"} unless bar`, `foo{x="a",x="b"}`)
+	f(`bar unless foo{x="a",x="b"}`, `bar`)
+	f(`rate(foo{x="a",x="b"}[5m]) or bar`, `bar`)
+	f(`(foo{x="a",x="b"} * 2) or bar`, `bar`)
+	f(`foo{x="a",x="b"} > bar or baz`, `baz`)
+	f(`(bar unless foo{x="a",x="b"}) or baz{y="z",y!="w"}`, `bar or baz{y="z"}`)
+
+	// functions returning data for empty args
+	f(`absent(foo{x="a",x="b"}) or bar`, `absent(foo{x="a",x="b"}) or bar`)
+	f(`absent_over_time(foo{x="a",x="b"}[5m]) or bar`, `absent_over_time(foo{x="a",x="b"}[5m]) or bar`)
+	f(`sum(foo{x="a",x="b"}) or bar`, `sum(foo{x="a",x="b"}) or bar`)
+}
+
+func TestFindEmptySelectors(t *testing.T) {
+	f := func(s, resultExpected string) {
+		t.Helper()
+
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %s: %s", s, err)
+		}
+		var a []string
+		for _, es := range FindEmptySelectors(e) {
+			a = append(a, fmt.Sprintf("%s: %s", es.Expr.AppendString(nil), es.Reason))
+		}
+		result := strings.Join(a, "\n")
+		if result != resultExpected {
+			t.Fatalf("unexpected result for FindEmptySelectors(%s);\ngot\n%s\nwant\n%s", s, result, resultExpected)
+		}
+	}
+
+	f(`foo`, ``)
+	f(`foo{x="a",x!="b"}`, ``)
+	f(`{x="a",x="b" or y="z"}`, ``)
+	f(`foo{x=~"a.*",x!~"ab.*"}`, ``)
+
+	f(`foo{job="a",job="b"}`, `foo{job="a",job="b"}: job="a" contradicts job="b"`)
+	f(`foo{env="prod",env!="prod"}`, `foo{env="prod",env!="prod"}: env="prod" contradicts env!="prod"`)
+	f(`foo{x=~"a.*",x="b"}`, `foo{x=~"a.*",x="b"}: x=~"a.*" contradicts x="b"`)
+	f(`foo{x=~"a.*",x=~"b.*"}`, `foo{x=~"a.*",x=~"b.*"}: x=~"a.*" contradicts x=~"b.*"`)
+	f(`foo{x=~".*a",x=~".*b"}`, `foo{x=~".*a",x=~".*b"}: x=~".*a" contradicts x=~".*b"`)
+	f(`foo{x="",x!=""}`, `foo{x="",x!=""}: x="" contradicts x!=""`)
+	f(`foo{x!~".*"}`, `foo{x!~".*"}: x!~".*" cannot match any value`)
+	f(`foo{x=~"a|b",x=~"b|c",x!="b"}`, `foo{x=~"a|b",x=~"b|c",x!="b"}: filters for "x" label cannot match any value`)
+	f(`{__name__="foo",__name__="bar"}`, `foo{__name__="bar"}: __name__="foo" contradicts __name__="bar"`)
+	f(`{x="a",x="b" or y="c",y="d"}`, `{x="a",x="b" or y="c",y="d"}: x="a" contradicts x="b"; y="c" contradicts y="d"`)
+	f(`rate(foo{x="a",x="b"}[5m]) + bar{y="c",y="d"}`, "foo{x=\"a\",x=\"b\"}: x=\"a\" contradicts x=\"b\"\nbar{y=\"c\",y=\"d\"}: y=\"c\" contradicts y=\"d\"")
+}
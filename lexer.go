@@ -3,6 +3,7 @@ package metricsql
 import (
 	"fmt"
 	"math"
+	"sort"
 	"strconv"
 	"strings"
 	"unicode"
@@ -14,12 +15,28 @@ type lexer struct {
 	// An empty token means EOF.
 	Token string
 
-	prevTokens []string
-	nextTokens []string
+	// offset is the byte offset of Token in sOrig.
+	offset int
+
+	prevTokens  []string
+	prevOffsets []int
+	nextTokens  []string
+	nextOffsets []int
 
 	sOrig string
 	sTail string
 
+	// lineStarts contains byte offsets for the start of every line in sOrig.
+	//
+	// It is lazily initialized by Pos.
+	lineStarts []int
+
+	// tolerant is set to true if the lexer must skip invalid tokens instead of returning errors.
+	//
+	// Errors for the skipped tokens are collected in errs.
+	tolerant bool
+	errs     []*ParseError
+
 	err error
 }
 
@@ -29,8 +46,13 @@ func (lex *lexer) Context() string {
 
 func (lex *lexer) Init(s string) {
 	lex.Token = ""
+	lex.offset = 0
 	lex.prevTokens = nil
+	lex.prevOffsets = nil
 	lex.nextTokens = nil
+	lex.nextOffsets = nil
+	lex.lineStarts = nil
+	lex.errs = nil
 	lex.err = nil
 
 	lex.sOrig = s
@@ -47,86 +69,176 @@ func (lex *lexer) Next() error {
 		return lex.err
 	}
 	lex.prevTokens = append(lex.prevTokens, lex.Token)
+	lex.prevOffsets = append(lex.prevOffsets, lex.offset)
 	if len(lex.nextTokens) > 0 {
 		lex.Token = lex.nextTokens[len(lex.nextTokens)-1]
 		lex.nextTokens = lex.nextTokens[:len(lex.nextTokens)-1]
+		lex.offset = lex.nextOffsets[len(lex.nextOffsets)-1]
+		lex.nextOffsets = lex.nextOffsets[:len(lex.nextOffsets)-1]
 		return nil
 	}
 	token, err := lex.next()
+	for err != nil && lex.skipInvalidToken(err) {
+		token, err = lex.next()
+	}
 	if err != nil {
 		lex.err = err
 		return err
 	}
 	lex.Token = token
+	lex.offset = len(lex.sOrig) - len(lex.sTail) - len(token)
 	return nil
 }
 
+// prevEnd returns the byte offset in sOrig just after the previously consumed token.
+func (lex *lexer) prevEnd() int {
+	n := len(lex.prevTokens)
+	if n == 0 {
+		return 0
+	}
+	return lex.prevOffsets[n-1] + len(lex.prevTokens[n-1])
+}
+
+// Pos returns the position for the [start:end) byte range in sOrig.
+func (lex *lexer) Pos(start, end int) Pos {
+	if lex.lineStarts == nil {
+		lex.lineStarts = append(lex.lineStarts, 0)
+		for i := 0; i < len(lex.sOrig); i++ {
+			if lex.sOrig[i] == '\n' {
+				lex.lineStarts = append(lex.lineStarts, i+1)
+			}
+		}
+	}
+	if end < start {
+		end = start
+	}
+	n := sort.Search(len(lex.lineStarts), func(i int) bool {
+		return lex.lineStarts[i] > start
+	})
+	return Pos{
+		Offset: start,
+		End:    end,
+		Line:   n,
+		Column: start - lex.lineStarts[n-1] + 1,
+	}
+}
+
 func (lex *lexer) next() (string, error) {
 again:
 	// Skip whitespace
 	s := lex.sTail
-	i := 0
-	for i < len(s) && isSpaceChar(s[i]) {
-		i++
-	}
-	s = s[i:]
+	s = s[scanSpace(s):]
 	lex.sTail = s
 
 	if len(s) == 0 {
 		return "", nil
 	}
+	if n := scanComment(s); n > 0 {
+		lex.sTail = s[n:]
+		goto again
+	}
 
-	var token string
-	var err error
+	n, kind, err := scanToken(s)
+	if err != nil {
+		return "", lex.newError(kind, s[:n], err)
+	}
+	token := s[:n]
+	lex.sTail = s[n:]
+	return token, nil
+}
+
+// scanToken returns the length of the token at the beginning of s.
+//
+// s mustn't start with whitespace or comment. If the token at the beginning of s is invalid,
+// then the length of the invalid part of s is returned together with the error kind and the error.
+func scanToken(s string) (int, ParseErrorKind, error) {
 	switch s[0] {
-	case '#':
-		// Skip comment till the end of string
-		s = s[1:]
-		n := strings.IndexByte(s, '\n')
-		if n < 0 {
-			return "", nil
-		}
-		lex.sTail = s[n+1:]
-		goto again
 	case '{', '}', '[', ']', '(', ')', ',', '@':
-		token = s[:1]
-		goto tokenFoundLabel
+		return 1, 0, nil
 	}
 	if isIdentPrefix(s) {
-		token = scanIdent(s)
-		goto tokenFoundLabel
+		return len(scanIdent(s)), 0, nil
 	}
 	if isStringPrefix(s) {
-		token, err = scanString(s)
+		token, err := scanString(s)
 		if err != nil {
-			return "", err
+			return len(s), ParseErrorBadString, err
 		}
-		goto tokenFoundLabel
+		return len(token), 0, nil
 	}
 	if n := scanBinaryOpPrefix(s); n > 0 {
-		token = s[:n]
-		goto tokenFoundLabel
+		return n, 0, nil
 	}
 	if n := scanTagFilterOpPrefix(s); n > 0 {
-		token = s[:n]
-		goto tokenFoundLabel
+		return n, 0, nil
 	}
 	if n := scanDuration(s); n > 0 {
-		token = s[:n]
-		goto tokenFoundLabel
+		return n, 0, nil
 	}
 	if isPositiveNumberPrefix(s) {
-		token, err = scanPositiveNumber(s)
+		token, err := scanPositiveNumber(s)
 		if err != nil {
-			return "", err
+			return len(scanInvalidNumber(s)), ParseErrorBadNumber, err
 		}
-		goto tokenFoundLabel
+		return len(token), 0, nil
 	}
-	return "", fmt.Errorf("cannot recognize %q", s)
+	return len(firstRune(s)), ParseErrorInvalidToken, fmt.Errorf("cannot recognize %q", s)
+}
 
-tokenFoundLabel:
-	lex.sTail = s[len(token):]
-	return token, nil
+// scanSpace returns the length of whitespace at the beginning of s.
+func scanSpace(s string) int {
+	i := 0
+	for i < len(s) && isSpaceChar(s[i]) {
+		i++
+	}
+	return i
+}
+
+// scanComment returns the length of the comment at the beginning of s.
+//
+// The comment starts with '#' and ends before the next newline. Zero is returned if s doesn't start with a comment.
+func scanComment(s string) int {
+	if len(s) == 0 || s[0] != '#' {
+		return 0
+	}
+	n := strings.IndexByte(s, '\n')
+	if n < 0 {
+		return len(s)
+	}
+	return n
+}
+
+// newError returns ParseError for the given token at the beginning of sTail.
+func (lex *lexer) newError(kind ParseErrorKind, token string, err error) *ParseError {
+	offset := len(lex.sOrig) - len(lex.sTail)
+	return newParseError(kind, token, lex.Pos(offset, offset+len(token)), "%w", err)
+}
+
+// skipInvalidToken skips the invalid token at the beginning of sTail and registers err in errs if lex is tolerant.
+//
+// It returns false if lex isn't tolerant.
+func (lex *lexer) skipInvalidToken(err error) bool {
+	pe, ok := err.(*ParseError)
+	if !ok || !lex.tolerant {
+		return false
+	}
+	lex.errs = append(lex.errs, pe)
+	lex.sTail = lex.sTail[len(pe.Token):]
+	return true
+}
+
+// scanInvalidNumber returns the invalid number at the beginning of s.
+func scanInvalidNumber(s string) string {
+	n := 1
+	for n < len(s) && (isIdentChar(rune(s[n])) || s[n] == '.') {
+		n++
+	}
+	return s[:n]
+}
+
+func firstRune(s string) string {
+	_, size := utf8.DecodeRuneInString(s)
+	return s[:size]
 }
 
 func scanString(s string) (string, error) {
@@ -403,8 +515,11 @@ func appendEscapedIdent(dst []byte, s string) []byte {
 
 func (lex *lexer) Prev() {
 	lex.nextTokens = append(lex.nextTokens, lex.Token)
+	lex.nextOffsets = append(lex.nextOffsets, lex.offset)
 	lex.Token = lex.prevTokens[len(lex.prevTokens)-1]
 	lex.prevTokens = lex.prevTokens[:len(lex.prevTokens)-1]
+	lex.offset = lex.prevOffsets[len(lex.prevOffsets)-1]
+	lex.prevOffsets = lex.prevOffsets[:len(lex.prevOffsets)-1]
 }
 
 func isEOF(s string) bool {
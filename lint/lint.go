@@ -0,0 +1,278 @@
+// Package lint provides configurable rules for catching common mistakes in MetricsQL queries.
+//
+// Usage:
+//
+//	expr, err := metricsql.Parse(`rate(sum(http_requests_total)[5m:])`)
+//	if err != nil {
+//	    // parse error
+//	}
+//	findings, err := lint.Lint(expr, nil)
+//	if err != nil {
+//	    // invalid options
+//	}
+//	for _, f := range findings {
+//	    fmt.Println(f)
+//	}
+//
+// The following rules are supported:
+//
+//   - rate-non-counter: rate() or increase() is applied to a metric, which doesn't look like a counter
+//   - counter-without-rollup: a counter is used without rollup function such as rate()
+//   - histogram-quantile-le: the aggregation inside histogram_quantile() drops "le" label
+//   - rate-of-sum: rate() or increase() is applied to aggregated counters such as rate(sum(...))
+//   - subquery-window-step: the subquery window is smaller than its step
+//   - regexp-literal: the regexp filter matches a single literal value
+//   - topk-in-rate: topk() or bottomk() is applied to raw counters inside rate() or increase()
+//
+// Every rule can be disabled and its severity can be changed via Options.
+package lint
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Abhinav1299/metricsql"
+)
+
+// Severity is the severity of Finding.
+type Severity int
+
+const (
+	// SeverityInfo is for findings, which do not change the query results, such as style issues.
+	SeverityInfo Severity = iota
+
+	// SeverityWarning is for findings, which may lead to unexpected query results.
+	SeverityWarning
+
+	// SeverityError is for findings, which almost always lead to incorrect query results.
+	SeverityError
+)
+
+var severityNames = [...]string{
+	SeverityInfo:    "info",
+	SeverityWarning: "warning",
+	SeverityError:   "error",
+}
+
+// String returns string representation for s.
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityNames) {
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+	return severityNames[s]
+}
+
+// Rule is a lint rule.
+type Rule struct {
+	// ID is the unique rule id such as `rate-of-sum`.
+	ID string
+
+	// Severity is the default severity for the rule findings.
+	Severity Severity
+
+	// Description is human-readable rule description.
+	Description string
+
+	// check must call lc.addFinding for every issue found at e.
+	//
+	// parents contains parent nodes for e starting from the root node.
+	check func(lc *linter, e metricsql.Expr, parents []metricsql.Expr)
+}
+
+// Rules returns all the supported rules.
+//
+// The returned rules must not be modified.
+func Rules() []*Rule {
+	return append([]*Rule{}, rules...)
+}
+
+// GetRule returns the rule with the given id.
+//
+// nil is returned if there is no rule with the given id.
+func GetRule(id string) *Rule {
+	for _, r := range rules {
+		if r.ID == id {
+			return r
+		}
+	}
+	return nil
+}
+
+// Options contains options for Lint.
+type Options struct {
+	// Disabled contains ids for rules, which must be skipped.
+	Disabled []string
+
+	// Severities overrides the default rule severities. It maps rule ids to severities.
+	Severities map[string]Severity
+
+	// Step is the query step in milliseconds.
+	//
+	// It is used for checking subqueries with implicit step such as `foo[1m:]` and durations with `i` suffix.
+	// Such subqueries aren't checked if Step isn't set.
+	Step int64
+}
+
+// Finding is an issue found by Lint.
+type Finding struct {
+	// RuleID is the id of the rule, which found the issue.
+	RuleID string
+
+	// Severity is the severity for the issue.
+	Severity Severity
+
+	// Expr is the offending node.
+	Expr metricsql.Expr
+
+	// Pos is the position of the issue in the query.
+	//
+	// Pos may be zero if the offending node has no position in the query, e.g. if it has been created by built-in WITH template.
+	Pos metricsql.Pos
+
+	// Msg is human-readable issue description.
+	Msg string
+}
+
+// String returns human-readable representation for f.
+func (f *Finding) String() string {
+	if !f.Pos.IsValid() {
+		return fmt.Sprintf("%s: %s (%s)", f.Severity, f.Msg, f.RuleID)
+	}
+	return fmt.Sprintf("%s: %s: %s (%s)", f.Pos, f.Severity, f.Msg, f.RuleID)
+}
+
+// Lint checks e with the rules enabled in opts and returns the found issues.
+//
+// Findings are returned in the order of nodes in e, parent nodes go before their children.
+// opts may be nil, then all the rules are enabled with default severities.
+//
+// An error is returned if opts refer to unknown rules.
+func Lint(e metricsql.Expr, opts *Options) ([]*Finding, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	disabled := make(map[string]bool, len(opts.Disabled))
+	for _, id := range opts.Disabled {
+		if GetRule(id) == nil {
+			return nil, fmt.Errorf("cannot disable unknown rule %q", id)
+		}
+		disabled[id] = true
+	}
+	for id := range opts.Severities {
+		if GetRule(id) == nil {
+			return nil, fmt.Errorf("cannot set severity for unknown rule %q", id)
+		}
+	}
+
+	lc := &linter{
+		step: opts.Step,
+	}
+	for _, r := range rules {
+		if !disabled[r.ID] {
+			lc.rules = append(lc.rules, r)
+		}
+	}
+	lc.severities = opts.Severities
+	lc.walk(e, nil)
+	return lc.findings, nil
+}
+
+type linter struct {
+	rules      []*Rule
+	severities map[string]Severity
+	step       int64
+
+	// rule is the currently running rule.
+	rule *Rule
+
+	findings []*Finding
+}
+
+// walk runs all the rules for e and its children.
+func (lc *linter) walk(e metricsql.Expr, parents []metricsql.Expr) {
+	if e == nil {
+		return
+	}
+	for _, r := range lc.rules {
+		lc.rule = r
+		r.check(lc, e, parents)
+	}
+	parents = append(parents, e)
+	for _, child := range getChildren(e) {
+		lc.walk(child, parents)
+	}
+}
+
+// addFinding adds a finding for the currently running rule.
+//
+// pos is used as the finding position if it is valid. Otherwise the position of e is used.
+func (lc *linter) addFinding(e metricsql.Expr, pos metricsql.Pos, format string, args ...interface{}) {
+	if !pos.IsValid() {
+		pos = metricsql.ExprPos(e)
+	}
+	severity, ok := lc.severities[lc.rule.ID]
+	if !ok {
+		severity = lc.rule.Severity
+	}
+	lc.findings = append(lc.findings, &Finding{
+		RuleID:   lc.rule.ID,
+		Severity: severity,
+		Expr:     e,
+		Pos:      pos,
+		Msg:      fmt.Sprintf(format, args...),
+	})
+}
+
+// getChildren returns child nodes for e.
+func getChildren(e metricsql.Expr) []metricsql.Expr {
+	switch t := e.(type) {
+	case *metricsql.FuncExpr:
+		return t.Args
+	case *metricsql.AggrFuncExpr:
+		return t.Args
+	case *metricsql.BinaryOpExpr:
+		return []metricsql.Expr{t.Left, t.Right}
+	case *metricsql.RollupExpr:
+		if t.At != nil {
+			return []metricsql.Expr{t.Expr, t.At}
+		}
+		return []metricsql.Expr{t.Expr}
+	default:
+		return nil
+	}
+}
+
+// getMetricName returns metric name for me.
+//
+// An empty string is returned if me doesn't select a single metric name with `__name__="..."` filter in all its groups.
+func getMetricName(me *metricsql.MetricExpr) string {
+	name := ""
+	for _, lfs := range me.LabelFilterss {
+		if len(lfs) == 0 {
+			return ""
+		}
+		lf := &lfs[0]
+		if lf.Label != "__name__" || lf.IsNegative || lf.IsRegexp {
+			return ""
+		}
+		if name != "" && lf.Value != name {
+			return ""
+		}
+		name = lf.Value
+	}
+	return name
+}
+
+// getFuncName returns lowercase function name for e if e is a function call or aggregate function call.
+//
+// An empty string is returned otherwise.
+func getFuncName(e metricsql.Expr) string {
+	switch t := e.(type) {
+	case *metricsql.FuncExpr:
+		return strings.ToLower(t.Name)
+	case *metricsql.AggrFuncExpr:
+		return strings.ToLower(t.Name)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,219 @@
+package lint
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Abhinav1299/metricsql"
+)
+
+// lintQuery parses q, lints it with opts and returns string representations for the findings.
+func lintQuery(t *testing.T, q string, opts *Options) []string {
+	t.Helper()
+
+	e, err := metricsql.Parse(q)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", q, err)
+	}
+	fs, err := Lint(e, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var result []string
+	for _, f := range fs {
+		result = append(result, f.String())
+	}
+	return result
+}
+
+func TestLint(t *testing.T) {
+	f := func(q string, resultExpected ...string) {
+		t.Helper()
+
+		result := lintQuery(t, q, &Options{Step: 60e3})
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected findings for %q;\ngot\n%q\nwant\n%q", q, result, resultExpected)
+		}
+	}
+
+	// Valid queries
+	f(`sum(rate(http_requests_total{job="api"}[5m])) by (job)`)
+	f(`histogram_quantile(0.99, sum(rate(http_request_duration_seconds_bucket[5m])) by (le, job))`)
+	f(`histogram_quantile(0.99, sum(rate(http_request_duration_seconds_bucket[5m])) by (vmrange))`)
+	f(`histogram_quantile(0.99, sum(rate(http_request_duration_seconds_bucket[5m])) without (job))`)
+	f(`topk(5, rate(foo_total[5m]))`)
+	f(`max_over_time(rate(foo_total[5m])[1h:1m])`)
+	f(`count(foo_total) by (job) + absent(bar_count)`)
+	f(`rate(foo_total offset 1h) / rate(foo_count)`)
+	f(`foo{job=~"api|web", instance=~"host-.+"}`)
+
+	// rate-non-counter
+	f(`rate(process_resident_memory_bytes[5m])`,
+		`1:6: warning: rate() is applied to "process_resident_memory_bytes", which doesn't look like a counter; counter names must end with _total, _count, _sum, _bucket (rate-non-counter)`)
+	f(`increase(foo{job="a"})`,
+		`1:10: warning: increase() is applied to "foo", which doesn't look like a counter; counter names must end with _total, _count, _sum, _bucket (rate-non-counter)`)
+
+	// counter-without-rollup
+	f(`http_requests_total > 100`,
+		`1:1: warning: counter "http_requests_total" is used without rollup function; wrap it into rate() or increase() (counter-without-rollup)`)
+	f(`sum(foo_sum) / sum(foo_count)`,
+		`1:5: warning: counter "foo_sum" is used without rollup function; wrap it into rate() or increase() (counter-without-rollup)`,
+		`1:20: warning: counter "foo_count" is used without rollup function; wrap it into rate() or increase() (counter-without-rollup)`)
+
+	// histogram-quantile-le
+	f(`histogram_quantile(0.9, sum(rate(foo_bucket[5m])) by (job))`,
+		`1:51: error: sum() drops "le" label required by histogram_quantile(); add "le" to by(job) (histogram-quantile-le)`)
+	f(`histogram_quantile(0.9, sum(rate(foo_bucket[5m])))`,
+		`1:25: error: sum() drops "le" label required by histogram_quantile(); use sum(...) by (le) (histogram-quantile-le)`)
+	f(`histogram_quantile(0.9, max(rate(foo_bucket[5m])) without (le))`,
+		`1:51: error: max() drops "le" label required by histogram_quantile(); remove "le" from without(le) (histogram-quantile-le)`)
+
+	// rate-of-sum
+	f(`rate(sum(foo_total)[5m:])`,
+		`1:6: error: sum() must be applied after rate(), e.g. sum(rate(...)), since counter resets cannot be detected in aggregated counters (rate-of-sum)`,
+		`1:10: warning: counter "foo_total" is used without rollup function; wrap it into rate() or increase() (counter-without-rollup)`)
+	f(`increase(sum(rate(foo_total[1m])) by (job))`,
+		`1:10: error: sum() must be applied after increase(), e.g. sum(increase(...)), since counter resets cannot be detected in aggregated counters (rate-of-sum)`)
+
+	// subquery-window-step
+	f(`max_over_time(foo[1m:5m])`,
+		`1:15: warning: subquery window 1m is smaller than step 5m, so the window contains at most one point (subquery-window-step)`)
+	f(`max_over_time(foo[30s:])`,
+		`1:15: warning: subquery window 30s is smaller than query step 1m0s, so the window contains at most one point (subquery-window-step)`)
+	f(`max_over_time(foo[1i:])`)
+
+	// regexp-literal
+	f(`foo{job=~"api", instance!~"host-1"}`,
+		`1:5: info: regexp filter job=~"api" matches only "api"; use job="api" instead (regexp-literal)`,
+		`1:17: info: regexp filter instance!~"host-1" matches only "host-1"; use instance!="host-1" instead (regexp-literal)`)
+	f(`{__name__=~"foo" or job=~"a"}`,
+		`1:2: info: regexp filter __name__=~"foo" matches only "foo"; use __name__="foo" instead (regexp-literal)`,
+		`1:21: info: regexp filter job=~"a" matches only "a"; use job="a" instead (regexp-literal)`)
+
+	// topk-in-rate
+	f(`rate(topk(5, rate(foo_total[1m]))[5m:])`,
+		`1:6: warning: topk() inside rate() selects series by raw counter values; use topk(..., rate(...)) instead (topk-in-rate)`)
+}
+
+func TestLintOptions(t *testing.T) {
+	f := func(q string, opts *Options, resultExpected ...string) {
+		t.Helper()
+
+		result := lintQuery(t, q, opts)
+		if !reflect.DeepEqual(result, resultExpected) {
+			t.Fatalf("unexpected findings for %q;\ngot\n%q\nwant\n%q", q, result, resultExpected)
+		}
+	}
+
+	q := `rate(sum(foo_total)[5m:]) + bar{job=~"a"}`
+
+	// Default options
+	f(q, nil,
+		`1:6: error: sum() must be applied after rate(), e.g. sum(rate(...)), since counter resets cannot be detected in aggregated counters (rate-of-sum)`,
+		`1:10: warning: counter "foo_total" is used without rollup function; wrap it into rate() or increase() (counter-without-rollup)`,
+		`1:33: info: regexp filter job=~"a" matches only "a"; use job="a" instead (regexp-literal)`)
+
+	// Disabled rules
+	f(q, &Options{
+		Disabled: []string{"counter-without-rollup", "regexp-literal"},
+	}, `1:6: error: sum() must be applied after rate(), e.g. sum(rate(...)), since counter resets cannot be detected in aggregated counters (rate-of-sum)`)
+
+	// Severity overrides
+	f(q, &Options{
+		Disabled: []string{"rate-of-sum"},
+		Severities: map[string]Severity{
+			"counter-without-rollup": SeverityError,
+		},
+	},
+		`1:10: error: counter "foo_total" is used without rollup function; wrap it into rate() or increase() (counter-without-rollup)`,
+		`1:33: info: regexp filter job=~"a" matches only "a"; use job="a" instead (regexp-literal)`)
+
+	// Subqueries with implicit step aren't checked without Options.Step.
+	f(`max_over_time(foo[30s:])`, nil)
+}
+
+func TestLintFinding(t *testing.T) {
+	e, err := metricsql.Parse(`with (x = foo) rate(x)`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	fs, err := Lint(e, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(fs) != 1 {
+		t.Fatalf("unexpected number of findings; got %d; want 1", len(fs))
+	}
+	f := fs[0]
+	if f.RuleID != "rate-non-counter" || f.Severity != SeverityWarning {
+		t.Fatalf("unexpected finding: %s", f)
+	}
+	if s := string(f.Expr.AppendString(nil)); s != "foo" {
+		t.Fatalf("unexpected offending node; got %s; want foo", s)
+	}
+
+	// The position points to the WITH template body.
+	if f.Pos.Offset != 10 || f.Pos.End != 13 {
+		t.Fatalf("unexpected position; got %+v; want [10:13]", f.Pos)
+	}
+}
+
+func TestLintError(t *testing.T) {
+	f := func(opts *Options) {
+		t.Helper()
+
+		e, err := metricsql.Parse("foo")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		fs, err := Lint(e, opts)
+		if err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		if fs != nil {
+			t.Fatalf("expecting nil findings; got %v", fs)
+		}
+	}
+
+	f(&Options{Disabled: []string{"unknown-rule"}})
+	f(&Options{Severities: map[string]Severity{"unknown-rule": SeverityError}})
+}
+
+func TestRules(t *testing.T) {
+	rs := Rules()
+	if len(rs) != 7 {
+		t.Fatalf("unexpected number of rules; got %d; want 7", len(rs))
+	}
+	m := make(map[string]bool)
+	for _, r := range rs {
+		if m[r.ID] {
+			t.Fatalf("duplicate rule id %q", r.ID)
+		}
+		m[r.ID] = true
+		if r.Description == "" {
+			t.Fatalf("missing description for rule %q", r.ID)
+		}
+		if GetRule(r.ID) != r {
+			t.Fatalf("GetRule(%q) must return the rule from Rules()", r.ID)
+		}
+	}
+	if r := GetRule("unknown-rule"); r != nil {
+		t.Fatalf("expecting nil rule for unknown id; got %q", r.ID)
+	}
+}
+
+func TestSeverityString(t *testing.T) {
+	f := func(s Severity, resultExpected string) {
+		t.Helper()
+
+		result := s.String()
+		if result != resultExpected {
+			t.Fatalf("unexpected string for %d; got %q; want %q", int(s), result, resultExpected)
+		}
+	}
+
+	f(SeverityInfo, "info")
+	f(SeverityWarning, "warning")
+	f(SeverityError, "error")
+	f(Severity(10), "Severity(10)")
+}
@@ -0,0 +1,275 @@
+package lint
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Abhinav1299/metricsql"
+)
+
+var rules = []*Rule{
+	{
+		ID:       "rate-non-counter",
+		Severity: SeverityWarning,
+		Description: "rate() and increase() must be applied only to counters, " +
+			"which have names ending with _total, _count, _sum or _bucket",
+		check: checkRateNonCounter,
+	},
+	{
+		ID:          "counter-without-rollup",
+		Severity:    SeverityWarning,
+		Description: "counters must be wrapped into rollup functions such as rate() or increase(), since their raw values are meaningless",
+		check:       checkCounterWithoutRollup,
+	},
+	{
+		ID:          "histogram-quantile-le",
+		Severity:    SeverityError,
+		Description: `aggregation inside histogram_quantile() must keep "le" label, e.g. sum(rate(...)) by (le)`,
+		check:       checkHistogramQuantileLe,
+	},
+	{
+		ID:          "rate-of-sum",
+		Severity:    SeverityError,
+		Description: "rate() and increase() must be applied before aggregation, e.g. sum(rate(...)) instead of rate(sum(...))",
+		check:       checkRateOfSum,
+	},
+	{
+		ID:          "subquery-window-step",
+		Severity:    SeverityWarning,
+		Description: "subquery window must be bigger than its step, since otherwise the window contains at most one point",
+		check:       checkSubqueryWindowStep,
+	},
+	{
+		ID:          "regexp-literal",
+		Severity:    SeverityInfo,
+		Description: `regexp filter matching a single literal value must be replaced with plain filter, e.g. {job="api"} instead of {job=~"api"}`,
+		check:       checkRegexpLiteral,
+	},
+	{
+		ID:          "topk-in-rate",
+		Severity:    SeverityWarning,
+		Description: "topk() and bottomk() must be applied to rate() results instead of raw counters, e.g. topk(5, rate(...))",
+		check:       checkTopkInRate,
+	},
+}
+
+// counterRollupFuncs contains rollup functions, which must be applied only to counters.
+var counterRollupFuncs = map[string]bool{
+	"rate":                true,
+	"irate":               true,
+	"increase":            true,
+	"increase_prometheus": true,
+	"increase_pure":       true,
+}
+
+// counterSuffixes contains suffixes for counter names according to Prometheus naming conventions.
+var counterSuffixes = []string{"_total", "_count", "_sum", "_bucket"}
+
+func isCounterName(name string) bool {
+	for _, suffix := range counterSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// valueIndependentFuncs contains functions, which do not depend on series values, so they may be applied to raw counters.
+var valueIndependentFuncs = map[string]bool{
+	"absent":    true,
+	"count":     true,
+	"group":     true,
+	"timestamp": true,
+}
+
+// getCounterRollupFunc returns fe if e is a call of the function from counterRollupFuncs.
+func getCounterRollupFunc(e metricsql.Expr) *metricsql.FuncExpr {
+	fe, ok := e.(*metricsql.FuncExpr)
+	if !ok || !counterRollupFuncs[strings.ToLower(fe.Name)] {
+		return nil
+	}
+	return fe
+}
+
+// getRollupArg returns the rollup arg for fe without the window, offset and step.
+//
+// nil is returned if fe has no rollup arg.
+func getRollupArg(fe *metricsql.FuncExpr) metricsql.Expr {
+	idx := metricsql.GetRollupArgIdx(fe)
+	if idx < 0 || idx >= len(fe.Args) {
+		return nil
+	}
+	arg := fe.Args[idx]
+	if re, ok := arg.(*metricsql.RollupExpr); ok {
+		return re.Expr
+	}
+	return arg
+}
+
+// findAggrFuncs returns aggregate functions from e, which aren't nested into other aggregate functions or rollup functions.
+func findAggrFuncs(e metricsql.Expr) []*metricsql.AggrFuncExpr {
+	switch t := e.(type) {
+	case *metricsql.AggrFuncExpr:
+		return []*metricsql.AggrFuncExpr{t}
+	case *metricsql.FuncExpr:
+		if metricsql.IsRollupFunc(t.Name) {
+			// The rollup function is applied to raw samples, so it resets the ordering of aggregation and rollup.
+			return nil
+		}
+	}
+	var aes []*metricsql.AggrFuncExpr
+	for _, child := range getChildren(e) {
+		aes = append(aes, findAggrFuncs(child)...)
+	}
+	return aes
+}
+
+func isTopkFunc(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasPrefix(name, "topk") || strings.HasPrefix(name, "bottomk")
+}
+
+func checkRateNonCounter(lc *linter, e metricsql.Expr, _ []metricsql.Expr) {
+	fe := getCounterRollupFunc(e)
+	if fe == nil {
+		return
+	}
+	me, ok := getRollupArg(fe).(*metricsql.MetricExpr)
+	if !ok {
+		return
+	}
+	name := getMetricName(me)
+	if name == "" || isCounterName(name) {
+		return
+	}
+	lc.addFinding(me, metricsql.Pos{}, "%s() is applied to %q, which doesn't look like a counter; counter names must end with %s",
+		fe.Name, name, strings.Join(counterSuffixes, ", "))
+}
+
+func checkCounterWithoutRollup(lc *linter, e metricsql.Expr, parents []metricsql.Expr) {
+	me, ok := e.(*metricsql.MetricExpr)
+	if !ok {
+		return
+	}
+	name := getMetricName(me)
+	if name == "" || !isCounterName(name) {
+		return
+	}
+	for i := len(parents) - 1; i >= 0; i-- {
+		if _, ok := parents[i].(*metricsql.RollupExpr); ok {
+			continue
+		}
+		funcName := getFuncName(parents[i])
+		if _, ok := parents[i].(*metricsql.FuncExpr); ok && metricsql.IsRollupFunc(funcName) || valueIndependentFuncs[funcName] {
+			return
+		}
+		break
+	}
+	lc.addFinding(me, metricsql.Pos{}, "counter %q is used without rollup function; wrap it into rate() or increase()", name)
+}
+
+func checkHistogramQuantileLe(lc *linter, e metricsql.Expr, _ []metricsql.Expr) {
+	fe, ok := e.(*metricsql.FuncExpr)
+	if !ok || strings.ToLower(fe.Name) != "histogram_quantile" || len(fe.Args) < 2 {
+		return
+	}
+	ae, ok := fe.Args[1].(*metricsql.AggrFuncExpr)
+	if !ok {
+		return
+	}
+	switch strings.ToLower(ae.Modifier.Op) {
+	case "":
+		lc.addFinding(ae, metricsql.Pos{}, `%s() drops "le" label required by histogram_quantile(); use %s(...) by (le)`, ae.Name, ae.Name)
+	case "by":
+		for _, label := range ae.Modifier.Args {
+			// VictoriaMetrics histograms use "vmrange" label instead of "le".
+			if label == "le" || label == "vmrange" {
+				return
+			}
+		}
+		lc.addFinding(ae, ae.Modifier.Pos, `%s() drops "le" label required by histogram_quantile(); add "le" to %s`,
+			ae.Name, ae.Modifier.AppendString(nil))
+	case "without":
+		for _, label := range ae.Modifier.Args {
+			if label == "le" {
+				lc.addFinding(ae, ae.Modifier.Pos, `%s() drops "le" label required by histogram_quantile(); remove "le" from %s`,
+					ae.Name, ae.Modifier.AppendString(nil))
+				return
+			}
+		}
+	}
+}
+
+func checkRateOfSum(lc *linter, e metricsql.Expr, _ []metricsql.Expr) {
+	fe := getCounterRollupFunc(e)
+	if fe == nil {
+		return
+	}
+	for _, ae := range findAggrFuncs(getRollupArg(fe)) {
+		if isTopkFunc(ae.Name) {
+			// This is reported by topk-in-rate rule.
+			continue
+		}
+		lc.addFinding(ae, metricsql.Pos{}, "%s() must be applied after %s(), e.g. %s(%s(...)), since counter resets cannot be detected in aggregated counters",
+			ae.Name, fe.Name, ae.Name, fe.Name)
+	}
+}
+
+func checkSubqueryWindowStep(lc *linter, e metricsql.Expr, _ []metricsql.Expr) {
+	re, ok := e.(*metricsql.RollupExpr)
+	if !ok || !re.ForSubquery() || re.Window == nil {
+		return
+	}
+	step := lc.step
+	stepDesc := "query step " + time.Duration(step*1e6).String()
+	if re.Step != nil {
+		step = re.Step.Duration(lc.step)
+		stepDesc = "step " + string(re.Step.AppendString(nil))
+	}
+	window := re.Window.Duration(lc.step)
+	if step <= 0 || window <= 0 || window >= step {
+		return
+	}
+	lc.addFinding(re, metricsql.Pos{}, "subquery window %s is smaller than %s, so the window contains at most one point",
+		re.Window.AppendString(nil), stepDesc)
+}
+
+func checkRegexpLiteral(lc *linter, e metricsql.Expr, _ []metricsql.Expr) {
+	me, ok := e.(*metricsql.MetricExpr)
+	if !ok {
+		return
+	}
+	for _, lfs := range me.LabelFilterss {
+		for i := range lfs {
+			lf := &lfs[i]
+			if !lf.IsRegexp {
+				continue
+			}
+			ra, err := lf.AnalyzeRegexp()
+			if err != nil || len(ra.Values) != 1 {
+				continue
+			}
+			lfPlain := &metricsql.LabelFilter{
+				Label:      lf.Label,
+				Value:      ra.Values[0],
+				IsNegative: lf.IsNegative,
+			}
+			lc.addFinding(me, lf.Pos, "regexp filter %s matches only %q; use %s instead",
+				lf.AppendString(nil), ra.Values[0], lfPlain.AppendString(nil))
+		}
+	}
+}
+
+func checkTopkInRate(lc *linter, e metricsql.Expr, _ []metricsql.Expr) {
+	fe := getCounterRollupFunc(e)
+	if fe == nil {
+		return
+	}
+	for _, ae := range findAggrFuncs(getRollupArg(fe)) {
+		if !isTopkFunc(ae.Name) {
+			continue
+		}
+		lc.addFinding(ae, metricsql.Pos{}, "%s() inside %s() selects series by raw counter values; use %s(..., %s(...)) instead",
+			ae.Name, fe.Name, ae.Name, fe.Name)
+	}
+}
@@ -1,7 +1,6 @@
 package metricsql
 
 import (
-	"fmt"
 	"sort"
 	"strings"
 )
@@ -13,17 +12,24 @@ import (
 //   - Adds missing filters to `foo{filters1} op bar{filters2}`
 //     according to https://utcc.utoronto.ca/~cks/space/blog/sysadmin/PrometheusLabelNonOptimization
 //     I.e. such query is converted to `foo{filters1, filters2} op bar{filters1, filters2}`
+//   - Converts regexp filters matching a single literal value into plain filters,
+//     e.g. `foo{bar=~"baz"}` is converted to `foo{bar="baz"}`. See AnalyzeRegexp.
+//   - Drops regexp filters matching all the values such as `foo{bar=~".*"}`.
+//   - Simplifies label filters and drops branches, which cannot return data. See SimplifyLabelFilters.
 func Optimize(e Expr) Expr {
 	if !canOptimize(e) {
 		return e
 	}
 	eCopy := Clone(e)
+	eCopy = simplifyLabelFiltersInplace(eCopy)
 	optimizeInplace(eCopy)
 	return eCopy
 }
 
 func canOptimize(e Expr) bool {
 	switch t := e.(type) {
+	case *MetricExpr:
+		return canOptimizeRegexpFilters(t) || canSimplifyLabelFilters(t)
 	case *RollupExpr:
 		return canOptimize(t.Expr) || canOptimize(t.At)
 	case *FuncExpr:
@@ -44,18 +50,10 @@ func canOptimize(e Expr) bool {
 	return false
 }
 
-// Clone clones the given expression e and returns the cloned copy.
-func Clone(e Expr) Expr {
-	s := e.AppendString(nil)
-	eCopy, err := Parse(string(s))
-	if err != nil {
-		panic(fmt.Errorf("BUG: cannot parse the expression %q: %w", s, err))
-	}
-	return eCopy
-}
-
 func optimizeInplace(e Expr) {
 	switch t := e.(type) {
+	case *MetricExpr:
+		optimizeRegexpFiltersInplace(t)
 	case *RollupExpr:
 		optimizeInplace(t.Expr)
 		optimizeInplace(t.At)
@@ -75,6 +73,60 @@ func optimizeInplace(e Expr) {
 	}
 }
 
+func canOptimizeRegexpFilters(me *MetricExpr) bool {
+	for _, lfs := range me.LabelFilterss {
+		for _, lf := range lfs {
+			if !lf.IsRegexp {
+				continue
+			}
+			if lfSimplified, ok := simplifyRegexpFilter(lf); !ok || lfSimplified != lf {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func optimizeRegexpFiltersInplace(me *MetricExpr) {
+	for i, lfs := range me.LabelFilterss {
+		var dst []LabelFilter
+		for _, lf := range lfs {
+			if lfSimplified, ok := simplifyRegexpFilter(lf); ok {
+				dst = append(dst, lfSimplified)
+			}
+		}
+		if len(dst) == 0 {
+			// Leave at least a single filter, since series selectors cannot be empty.
+			continue
+		}
+		me.LabelFilterss[i] = dst
+	}
+}
+
+// simplifyRegexpFilter returns simplified lf.
+//
+// false is returned if lf matches all the values and can be dropped.
+func simplifyRegexpFilter(lf LabelFilter) (LabelFilter, bool) {
+	if !lf.IsRegexp {
+		return lf, true
+	}
+	ra, err := lf.AnalyzeRegexp()
+	if err != nil {
+		return lf, true
+	}
+	if ra.MatchesAll && !lf.IsNegative {
+		// {foo=~".*"} -> {}
+		return lf, false
+	}
+	if len(ra.Values) == 1 {
+		// {foo=~"bar"} -> {foo="bar"}
+		// {foo!~"bar"} -> {foo!="bar"}
+		lf.Value = ra.Values[0]
+		lf.IsRegexp = false
+	}
+	return lf, true
+}
+
 func getCommonLabelFilters(e Expr) []LabelFilter {
 	switch t := e.(type) {
 	case *MetricExpr:
@@ -269,10 +321,10 @@ func intersectLabelFilters(lfsA, lfsB []LabelFilter) []LabelFilter {
 	return lfs
 }
 
+// unionLabelFilters returns lfsA with the missing filters from lfsB.
+//
+// Filters from lfsB have no position in the returned slice, since they come from another selector.
 func unionLabelFilters(lfsA, lfsB []LabelFilter) []LabelFilter {
-	if len(lfsA) == 0 {
-		return lfsB
-	}
 	if len(lfsB) == 0 {
 		return lfsA
 	}
@@ -282,6 +334,7 @@ func unionLabelFilters(lfsA, lfsB []LabelFilter) []LabelFilter {
 	for _, lf := range lfsB {
 		b = lf.AppendString(b[:0])
 		if _, ok := m[string(b)]; !ok {
+			lf.Pos = Pos{}
 			lfs = append(lfs, lf)
 		}
 	}
@@ -308,7 +361,13 @@ func sortLabelFilters(lfs []LabelFilter) {
 		if a.Label != b.Label {
 			return a.Label < b.Label
 		}
-		return a.Value < b.Value
+		if a.Value != b.Value {
+			return a.Value < b.Value
+		}
+		if a.IsNegative != b.IsNegative {
+			return !a.IsNegative
+		}
+		return !a.IsRegexp && b.IsRegexp
 	})
 }
 
@@ -355,80 +414,14 @@ func getFuncArgForOptimization(funcName string, args []Expr) Expr {
 }
 
 func getFuncArgIdxForOptimization(funcName string, args []Expr) int {
-	funcName = strings.ToLower(funcName)
-	if IsRollupFunc(funcName) {
-		return getRollupArgIdxForOptimization(funcName, args)
-	}
-	if IsTransformFunc(funcName) {
-		return getTransformArgIdxForOptimization(funcName, args)
-	}
-	if IsAggrFunc(funcName) {
-		return getAggrArgIdxForOptimization(funcName, args)
-	}
-	return -1
-}
-
-func getAggrArgIdxForOptimization(funcName string, args []Expr) int {
-	switch strings.ToLower(funcName) {
-	case "bottomk", "bottomk_avg", "bottomk_max", "bottomk_median", "bottomk_last", "bottomk_min",
-		"limitk", "outliers_mad", "outliersk", "quantile",
-		"topk", "topk_avg", "topk_max", "topk_median", "topk_last", "topk_min":
-		return 1
-	case "count_values":
+	fs := getFuncSignature(funcName)
+	if fs == nil || fs.ChangesLabels {
 		return -1
-	case "quantiles":
-		return len(args) - 1
-	default:
-		return 0
 	}
-}
-
-func getRollupArgIdxForOptimization(funcName string, args []Expr) int {
-	// This must be kept in sync with GetRollupArgIdx()
-	switch strings.ToLower(funcName) {
-	case "absent_over_time":
+	if fs.Kind == FuncKindTransform && fs.hasVariadicSeriesArg() {
+		// Label filters cannot be pushed down to transform functions with multiple series args
+		// such as union(q1, ..., qN), since the function results may contain series from any of the args.
 		return -1
-	case "quantile_over_time", "aggr_over_time",
-		"hoeffding_bound_lower", "hoeffding_bound_upper":
-		return 1
-	case "quantiles_over_time":
-		return len(args) - 1
-	default:
-		return 0
-	}
-}
-
-func getTransformArgIdxForOptimization(funcName string, args []Expr) int {
-	funcName = strings.ToLower(funcName)
-	if isLabelManipulationFunc(funcName) {
-		return -1
-	}
-	switch funcName {
-	case "", "absent", "scalar", "union", "vector", "range_normalize":
-		return -1
-	case "end", "now", "pi", "ru", "start", "step", "time":
-		return -1
-	case "limit_offset":
-		return 2
-	case "buckets_limit", "histogram_quantile", "histogram_share", "range_quantile",
-		"range_trim_outliers", "range_trim_spikes", "range_trim_zscore":
-		return 1
-	case "histogram_quantiles":
-		return len(args) - 1
-	default:
-		return 0
-	}
-}
-
-func isLabelManipulationFunc(funcName string) bool {
-	switch strings.ToLower(funcName) {
-	case "alias", "drop_common_labels", "label_copy", "label_del", "label_graphite_group", "label_join", "label_keep", "label_lowercase",
-		"label_map", "label_move", "label_replace", "label_set", "label_transform", "label_uppercase":
-		return true
-	case "label_match", "label_mismatch", "label_value", "labels_equal":
-		// These functions aren't really label manipulation functions, since they do not change labels for the input series.
-		return false
-	default:
-		return false
 	}
+	return fs.SeriesArgIdx(len(args))
 }
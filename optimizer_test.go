@@ -153,7 +153,7 @@ func TestOptimize(t *testing.T) {
 	f(`foo + bar{b=~"a.*", a!="ss"}`, `foo{a!="ss",b=~"a.*"} + bar{a!="ss",b=~"a.*"}`)
 	f(`foo{bar="1"} / 234`, `foo{bar="1"} / 234`)
 	f(`foo{bar="1"} / foo{bar="1"}`, `foo{bar="1"} / foo{bar="1"}`)
-	f(`123 + foo{bar!~"xx"}`, `123 + foo{bar!~"xx"}`)
+	f(`123 + foo{bar!~"xx"}`, `123 + foo{bar!="xx"}`)
 	f(`foo or bar{x="y"}`, `foo or bar{x="y"}`)
 	f(`foo{x="y"} * on() baz{a="b"}`, `foo{x="y"} * on() baz{a="b"}`)
 	f(`foo{x="y"} * on(a) baz{a="b"}`, `foo{a="b",x="y"} * on(a) baz{a="b"}`)
@@ -166,12 +166,12 @@ func TestOptimize(t *testing.T) {
 	f(`foo{x="y"} * ignoring() group_left(foo,bar) baz{a="b"}`, `foo{a="b",x="y"} * ignoring() group_left(foo,bar) baz{a="b",x="y"}`)
 	f(`foo{x="y"} * on(a) group_left baz{a="b"}`, `foo{a="b",x="y"} * on(a) group_left() baz{a="b"}`)
 	f(`foo{x="y"} * on(a) group_right(x, y) baz{a="b"}`, `foo{a="b",x="y"} * on(a) group_right(x,y) baz{a="b"}`)
-	f(`histogram_quantile(foo, bar{baz=~"sdf"} + aa{baz=~"axx", aa="b"})`, `histogram_quantile(foo, bar{aa="b",baz=~"axx",baz=~"sdf"} + aa{aa="b",baz=~"axx",baz=~"sdf"})`)
-	f(`sum(foo, bar{baz=~"sdf"} + aa{baz=~"axx", aa="b"})`, `sum(foo, bar{aa="b",baz=~"axx",baz=~"sdf"} + aa{aa="b",baz=~"axx",baz=~"sdf"})`)
+	f(`histogram_quantile(foo, bar{baz=~"sdf"} + aa{baz=~"axx", aa="b"})`, `histogram_quantile(foo, bar{aa="b",baz="axx",baz="sdf"} + aa{aa="b",baz="axx",baz="sdf"})`)
+	f(`sum(foo, bar{baz=~"sdf"} + aa{baz=~"axx", aa="b"})`, `sum(foo, bar{aa="b",baz="axx",baz="sdf"} + aa{aa="b",baz="axx",baz="sdf"})`)
 	f(`foo AND bar{baz="aa"}`, `foo{baz="aa"} and bar{baz="aa"}`)
 	f(`{x="y",__name__="a"} + {a="b"}`, `a{a="b",x="y"} + {a="b",x="y"}`)
 	f(`{x="y",__name__=~"a|b"} + {a="b"}`, `{__name__=~"a|b",a="b",x="y"} + {a="b",x="y"}`)
-	f(`a{x="y",__name__=~"a|b"} + {a="b"}`, `a{__name__=~"a|b",a="b",x="y"} + {a="b",x="y"}`)
+	f(`a{x="y",__name__=~"a|b"} + {a="b"}`, `a{a="b",x="y"} + {a="b",x="y"}`)
 	f(`{a="b"} + ({c="d"} * on() group_left() {e="f"})`, `{a="b",c="d"} + ({c="d"} * on() group_left() {e="f"})`)
 	f(`{a="b"} + ({c="d"} * on(a) group_left() {e="f"})`, `{a="b",c="d"} + ({a="b",c="d"} * on(a) group_left() {a="b",e="f"})`)
 	f(`{a="b"} + ({c="d"} * on(c) group_left() {e="f"})`, `{a="b",c="d"} + ({c="d"} * on(c) group_left() {c="d",e="f"})`)
@@ -222,15 +222,15 @@ func TestOptimize(t *testing.T) {
 	f(`count_values("foo", bar{baz="a"}) by (bar,b) + a{b="c"}`, `count_values("foo", bar{baz="a"}) by(bar,b) + a{b="c"}`)
 
 	// transform funcs
-	f(`round(foo{bar="baz"}) + sqrt(a{z=~"c"})`, `round(foo{bar="baz",z=~"c"}) + sqrt(a{bar="baz",z=~"c"})`)
-	f(`foo{bar="baz"} + SQRT(a{z=~"c"})`, `foo{bar="baz",z=~"c"} + SQRT(a{bar="baz",z=~"c"})`)
+	f(`round(foo{bar="baz"}) + sqrt(a{z=~"c"})`, `round(foo{bar="baz",z="c"}) + sqrt(a{bar="baz",z="c"})`)
+	f(`foo{bar="baz"} + SQRT(a{z=~"c"})`, `foo{bar="baz",z="c"} + SQRT(a{bar="baz",z="c"})`)
 	f(`round({__name__="foo"}) + bar`, `round(foo) + bar`)
 	f(`round({__name__=~"foo|bar"}) + baz`, `round({__name__=~"foo|bar"}) + baz`)
 	f(`round({__name__=~"foo|bar",a="b"}) + baz`, `round({__name__=~"foo|bar",a="b"}) + baz{a="b"}`)
 	f(`round({__name__=~"foo|bar",a="b"}) + sqrt(baz)`, `round({__name__=~"foo|bar",a="b"}) + sqrt(baz{a="b"})`)
 	f(`round(foo) + {__name__="bar",x="y"}`, `round(foo{x="y"}) + bar{x="y"}`)
-	f(`absent(foo{bar="baz"}) + sqrt(a{z=~"c"})`, `absent(foo{bar="baz"}) + sqrt(a{z=~"c"})`)
-	f(`ABSENT(foo{bar="baz"}) + sqrt(a{z=~"c"})`, `ABSENT(foo{bar="baz"}) + sqrt(a{z=~"c"})`)
+	f(`absent(foo{bar="baz"}) + sqrt(a{z=~"c"})`, `absent(foo{bar="baz"}) + sqrt(a{z="c"})`)
+	f(`ABSENT(foo{bar="baz"}) + sqrt(a{z=~"c"})`, `ABSENT(foo{bar="baz"}) + sqrt(a{z="c"})`)
 	f(`label_set(foo{bar="baz"}, "xx", "y") + a{x="y"}`, `label_set(foo{bar="baz"}, "xx", "y") + a{x="y"}`)
 	f(`now() + foo{bar="baz"} + x{y="x"}`, `(now() + foo{bar="baz",y="x"}) + x{bar="baz",y="x"}`)
 	f(`limit_offset(5, 10, {x="y"}) if {a="b"}`, `limit_offset(5, 10, {a="b",x="y"}) if {a="b",x="y"}`)
@@ -257,7 +257,7 @@ func TestOptimize(t *testing.T) {
 	f(`RATE(foo[5m]) / rate(baz{a="b"}) + increase(x{y="z"} offset 5i)`, `(RATE(foo{a="b",y="z"}[5m]) / rate(baz{a="b",y="z"})) + increase(x{a="b",y="z"} offset 5i)`)
 	f(`sum(rate(foo[5m])) / rate(baz{a="b"})`, `sum(rate(foo[5m])) / rate(baz{a="b"})`)
 	f(`sum(rate(foo[5m])) by (a) / rate(baz{a="b"})`, `sum(rate(foo{a="b"}[5m])) by(a) / rate(baz{a="b"})`)
-	f(`rate({__name__="foo"}) + rate({__name__="bar",x="y"}) - rate({__name__=~"baz"})`, `(rate(foo{x="y"}) + rate(bar{x="y"})) - rate({__name__=~"baz",x="y"})`)
+	f(`rate({__name__="foo"}) + rate({__name__="bar",x="y"}) - rate({__name__=~"baz"})`, `(rate(foo{x="y"}) + rate(bar{x="y"})) - rate(baz{x="y"})`)
 	f(`rate({__name__=~"foo|bar", x="y"}) + rate(baz)`, `rate({__name__=~"foo|bar",x="y"}) + rate(baz{x="y"})`)
 	f(`absent_over_time(foo{x="y"}[5m]) + bar{a="b"}`, `absent_over_time(foo{x="y"}[5m]) + bar{a="b"}`)
 	f(`{x="y"} + quantile_over_time(0.5, {a="b"})`, `{a="b",x="y"} + quantile_over_time(0.5, {a="b",x="y"})`)
@@ -280,4 +280,60 @@ func TestOptimize(t *testing.T) {
 	f(`scalar(x) * foo / bar{baz="a"}`, `(scalar(x) * foo{baz="a"}) / bar{baz="a"}`)
 	f(`SCALAR(x) * foo / bar{baz="a"}`, `(SCALAR(x) * foo{baz="a"}) / bar{baz="a"}`)
 	f(`100 * on(foo) bar{baz="z"} + a`, `(100 * on(foo) bar{baz="z"}) + a`)
+
+	// regexp filters
+	f(`foo{bar=~"baz"}`, `foo{bar="baz"}`)
+	f(`foo{bar!~"baz"}`, `foo{bar!="baz"}`)
+	f(`foo{bar=~"(baz)"}`, `foo{bar="baz"}`)
+	f(`foo{bar=~""}`, `foo{bar=""}`)
+	f(`foo{bar=~"a.b"}`, `foo{bar=~"a.b"}`)
+	f(`foo{bar=~"a|b"}`, `foo{bar=~"a|b"}`)
+	f(`{__name__=~"foo",bar=~"baz"}`, `foo{bar="baz"}`)
+	f(`foo{bar=~".*"}`, `foo`)
+	f(`foo{bar=~".*",x="y"}`, `foo{x="y"}`)
+	f(`foo{bar=~"(.*)|x"}`, `foo`)
+	f(`foo{bar!~".*"}`, `foo{bar!~".*"}`)
+	f(`{bar=~".*"}`, `{bar=~".*"}`)
+	f(`{a=~".*" or b=~"c"}`, `{a=~".*" or b="c"}`)
+	f(`rate(foo{bar=~"baz"}[5m]) + bar{x=~".*",y=~"z"}`, `rate(foo{bar="baz",y="z"}[5m]) + bar{bar="baz",y="z"}`)
+
+	// redundant and contradicting filters
+	f(`foo{x="a",x!="b"}`, `foo{x="a"}`)
+	f(`foo{x="a",x!="b"} + bar`, `foo{x="a"} + bar{x="a"}`)
+	f(`foo{x="a",x="b"} or bar{y="z"}`, `bar{y="z"}`)
+	f(`foo{x="a",x="b"} and bar{y="z"}`, `foo{x="a",x="b"}`)
+}
+
+func TestOptimizeLabelFilterPos(t *testing.T) {
+	e, err := Parse(`foo{a="b"} + bar{c="d"}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	be := Optimize(e).(*BinaryOpExpr)
+	f := func(e Expr, label, posExpected string) {
+		t.Helper()
+
+		for _, lf := range e.(*MetricExpr).LabelFilterss[0] {
+			if lf.Label != label {
+				continue
+			}
+			pos := ""
+			if lf.Pos.IsValid() {
+				pos = lf.Pos.String()
+			}
+			if pos != posExpected {
+				t.Fatalf("unexpected position for %s filter in %s; got %q; want %q", label, e.AppendString(nil), pos, posExpected)
+			}
+			return
+		}
+		t.Fatalf("missing %s filter in %s", label, e.AppendString(nil))
+	}
+
+	// Own filters keep their positions.
+	f(be.Left, "a", "1:5")
+	f(be.Right, "c", "1:18")
+
+	// Pushed down filters have no position, since they come from another selector.
+	f(be.Left, "c", "")
+	f(be.Right, "a", "")
 }
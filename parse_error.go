@@ -0,0 +1,209 @@
+package metricsql
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ParseErrorKind is a machine-readable kind of ParseError.
+type ParseErrorKind int
+
+const (
+	// ParseErrorUnexpectedToken means that the parser met a token, which cannot be used at the given place.
+	ParseErrorUnexpectedToken ParseErrorKind = iota
+
+	// ParseErrorUnexpectedEOF means that the query ended while the parser expected more tokens.
+	ParseErrorUnexpectedEOF
+
+	// ParseErrorInvalidToken means that the query contains chars, which cannot be recognized as a token.
+	ParseErrorInvalidToken
+
+	// ParseErrorUnbalancedParens means that the query contains unclosed or unexpected closing parens or brackets.
+	ParseErrorUnbalancedParens
+
+	// ParseErrorBadNumber means that the query contains invalid number.
+	ParseErrorBadNumber
+
+	// ParseErrorBadString means that the query contains invalid string literal.
+	ParseErrorBadString
+
+	// ParseErrorBadDuration means that the query contains invalid duration.
+	ParseErrorBadDuration
+
+	// ParseErrorBadRegexp means that the query contains invalid regexp in label filter.
+	ParseErrorBadRegexp
+
+	// ParseErrorBadModifier means that the modifier cannot be applied at the given place,
+	// e.g. `bool` modifier for non-comparison binary operation.
+	ParseErrorBadModifier
+
+	// ParseErrorUnknownFunc means that the query contains unknown function.
+	ParseErrorUnknownFunc
+
+	// ParseErrorDuplicateWithArg means that WITH expression contains duplicate template names or template args.
+	ParseErrorDuplicateWithArg
+
+	// ParseErrorBadWithTemplate means that WITH template cannot be expanded,
+	// e.g. because of missing template or invalid number of args.
+	ParseErrorBadWithTemplate
+
+	// ParseErrorNotPromQL means that the query contains MetricsQL extension, which isn't supported by PromQL.
+	//
+	// Such errors are returned only if ParseOptions.PromQL is set.
+	ParseErrorNotPromQL
+)
+
+var parseErrorKindNames = [...]string{
+	ParseErrorUnexpectedToken:  "unexpected_token",
+	ParseErrorUnexpectedEOF:    "unexpected_eof",
+	ParseErrorInvalidToken:     "invalid_token",
+	ParseErrorUnbalancedParens: "unbalanced_parens",
+	ParseErrorBadNumber:        "bad_number",
+	ParseErrorBadString:        "bad_string",
+	ParseErrorBadDuration:      "bad_duration",
+	ParseErrorBadRegexp:        "bad_regexp",
+	ParseErrorBadModifier:      "bad_modifier",
+	ParseErrorUnknownFunc:      "unknown_func",
+	ParseErrorDuplicateWithArg: "duplicate_with_arg",
+	ParseErrorBadWithTemplate:  "bad_with_template",
+	ParseErrorNotPromQL:        "not_promql",
+}
+
+// String returns string representation for k.
+func (k ParseErrorKind) String() string {
+	if k < 0 || int(k) >= len(parseErrorKindNames) {
+		return fmt.Sprintf("ParseErrorKind(%d)", int(k))
+	}
+	return parseErrorKindNames[k]
+}
+
+// ParseError is an error returned from Parse.
+//
+// It can be obtained with errors.As from the errors returned by Parse, Prettify and ExpandWithExprs.
+type ParseError struct {
+	// Kind is the machine-readable kind of the error.
+	Kind ParseErrorKind
+
+	// Token is the offending token.
+	//
+	// It is empty if the error occurred at the end of the query.
+	Token string
+
+	// Pos is the position of the offending token or node in the query.
+	//
+	// Pos may be zero if the error isn't related to a particular place in the query.
+	Pos Pos
+
+	// Expected contains tokens the parser expected instead of Token.
+	//
+	// Token classes such as "ident", "string", "number" or "duration" are listed by their names.
+	Expected []string
+
+	// Msg is human-readable error description.
+	Msg string
+
+	// Err is the underlying error if any.
+	Err error
+
+	// unparsed contains the unparsed tail of the query starting at the offending token.
+	unparsed string
+}
+
+// Error implements error interface.
+func (pe *ParseError) Error() string {
+	if pe.unparsed == "" {
+		return pe.Msg
+	}
+	return fmt.Sprintf("%s; unparsed data: %q", pe.Msg, pe.unparsed)
+}
+
+// Unwrap returns the underlying error for pe.
+func (pe *ParseError) Unwrap() error {
+	return pe.Err
+}
+
+func newParseError(kind ParseErrorKind, token string, pos Pos, format string, args ...interface{}) *ParseError {
+	err := fmt.Errorf(format, args...)
+	return &ParseError{
+		Kind:  kind,
+		Token: token,
+		Pos:   pos,
+		Msg:   err.Error(),
+		Err:   errors.Unwrap(err),
+	}
+}
+
+// toParseError converts err to ParseError.
+//
+// The returned ParseError contains the full error message from err, while the remaining fields
+// are taken from the first ParseError in the err chain.
+func toParseError(err error, unparsed string) *ParseError {
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		return &ParseError{
+			Kind:     ParseErrorUnexpectedToken,
+			Msg:      err.Error(),
+			Err:      err,
+			unparsed: unparsed,
+		}
+	}
+	peNew := *pe
+	peNew.Msg = err.Error()
+	peNew.unparsed = unparsed
+	return &peNew
+}
+
+// tokenPos returns the position of the current token.
+func (p *parser) tokenPos() Pos {
+	return p.lex.Pos(p.lex.offset, p.lex.offset+len(p.lex.Token))
+}
+
+// errorf returns ParseError with the given kind for the current token.
+func (p *parser) errorf(kind ParseErrorKind, format string, args ...interface{}) *ParseError {
+	return newParseError(kind, p.lex.Token, p.tokenPos(), format, args...)
+}
+
+// unexpectedTokenError returns ParseError for the unexpected current token.
+//
+// where must contain the name of the parsed entity, while expected must contain the list of expected tokens.
+func (p *parser) unexpectedTokenError(where string, expected ...string) *ParseError {
+	kind := ParseErrorUnexpectedToken
+	if isEOF(p.lex.Token) {
+		kind = ParseErrorUnexpectedEOF
+		for _, token := range expected {
+			if isClosingParens(token) {
+				kind = ParseErrorUnbalancedParens
+				break
+			}
+		}
+	} else if isClosingParens(p.lex.Token) {
+		kind = ParseErrorUnbalancedParens
+		for _, token := range expected {
+			if token == p.lex.Token {
+				kind = ParseErrorUnexpectedToken
+				break
+			}
+		}
+	}
+	pe := p.errorf(kind, "%s: unexpected token %q; want %s", where, p.lex.Token, quoteTokens(expected))
+	pe.Expected = expected
+	return pe
+}
+
+func isClosingParens(token string) bool {
+	switch token {
+	case ")", "]", "}":
+		return true
+	default:
+		return false
+	}
+}
+
+func quoteTokens(tokens []string) string {
+	a := make([]string, len(tokens))
+	for i, token := range tokens {
+		a[i] = fmt.Sprintf("%q", token)
+	}
+	return strings.Join(a, ", ")
+}
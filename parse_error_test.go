@@ -0,0 +1,77 @@
+package metricsql
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseErrorKind(t *testing.T) {
+	f := func(s string, kindExpected ParseErrorKind, tokenExpected string, lineExpected, columnExpected int, expected []string) {
+		t.Helper()
+
+		_, err := Parse(s)
+		if err == nil {
+			t.Fatalf("expecting non-nil error when parsing %q", s)
+		}
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Fatalf("expecting ParseError when parsing %q; got %T: %s", s, err, err)
+		}
+		if pe.Kind != kindExpected {
+			t.Fatalf("unexpected error kind for %q; got %s; want %s", s, pe.Kind, kindExpected)
+		}
+		if pe.Token != tokenExpected {
+			t.Fatalf("unexpected token for %q; got %q; want %q", s, pe.Token, tokenExpected)
+		}
+		if pe.Pos.Line != lineExpected || pe.Pos.Column != columnExpected {
+			t.Fatalf("unexpected position for %q; got %s; want %d:%d", s, pe.Pos, lineExpected, columnExpected)
+		}
+		if !reflect.DeepEqual(pe.Expected, expected) {
+			t.Fatalf("unexpected expected tokens for %q; got %q; want %q", s, pe.Expected, expected)
+		}
+		if pe.Error() != err.Error() {
+			t.Fatalf("unexpected error message; got %q; want %q", pe.Error(), err.Error())
+		}
+	}
+
+	f(`foo +`, ParseErrorUnexpectedEOF, ``, 1, 6, []string{"(", "{", "-", "+"})
+	f(`foo + ]`, ParseErrorUnbalancedParens, `]`, 1, 7, []string{"(", "{", "-", "+"})
+	f(`sum(foo`, ParseErrorUnbalancedParens, ``, 1, 8, []string{",", ")"})
+	f(`rate(foo))`, ParseErrorUnbalancedParens, `)`, 1, 10, nil)
+	f("foo{\n  a=\"b\",\n  c d}", ParseErrorUnexpectedToken, `d`, 3, 5, []string{"=", "!=", "=~", "!~", ",", "or", "}"})
+	f(`foo $`, ParseErrorInvalidToken, `$`, 1, 5, nil)
+	f(`foo + "bar`, ParseErrorBadString, `"bar`, 1, 7, nil)
+	f(`foo[5k]`, ParseErrorBadDuration, `5k`, 1, 5, nil)
+	f(`foo offset 99999999999999999y`, ParseErrorBadDuration, `99999999999999999y`, 1, 12, nil)
+	f(`foo offset "5m"`, ParseErrorUnexpectedToken, `"5m"`, 1, 12, []string{"duration"})
+	f(`foo{bar=~"("}`, ParseErrorBadRegexp, `(`, 1, 10, nil)
+	f(`a + bool b`, ParseErrorBadModifier, `bool`, 1, 5, nil)
+	f(`a or on() group_left b`, ParseErrorBadModifier, `group_left`, 1, 11, nil)
+	f(`sum(x) limit a`, ParseErrorBadNumber, `a`, 1, 14, nil)
+	f(`rate(foo) + bar(baz)`, ParseErrorUnknownFunc, `bar`, 1, 13, nil)
+	f(`with (x = 1, x = 2) x`, ParseErrorDuplicateWithArg, `x`, 1, 14, nil)
+	f(`with (f(a, a) = a) f(1, 2)`, ParseErrorDuplicateWithArg, `a`, 1, 7, nil)
+	f(`with (f(a) = a) 1 + f(1, 2)`, ParseErrorBadWithTemplate, `f`, 1, 21, nil)
+	f(`with (x = m) foo{x}`, ParseErrorBadWithTemplate, `x`, 1, 18, nil)
+}
+
+func TestParseErrorUnwrap(t *testing.T) {
+	f := func(err error) {
+		t.Helper()
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Fatalf("expecting ParseError; got %T: %v", err, err)
+		}
+		if pe.Kind != ParseErrorUnexpectedEOF {
+			t.Fatalf("unexpected error kind; got %s; want %s", pe.Kind, ParseErrorUnexpectedEOF)
+		}
+	}
+
+	_, err := Parse(`foo or`)
+	f(err)
+	_, err = Prettify(`foo or`)
+	f(err)
+	_, err = ExpandWithExprs(`with (x = foo) x or`)
+	f(err)
+}
@@ -0,0 +1,240 @@
+package metricsql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ParseTolerant parses MetricsQL query s and recovers from parse errors.
+//
+// Unlike Parse, it doesn't stop at the first error. It returns the partial AST for s,
+// where the unparsed parts of the query are replaced with *BadExpr, together with
+// all the errors found in s sorted by their position in the query.
+//
+// If the returned error list is empty, then the returned Expr is equivalent to the Expr returned from Parse.
+//
+// ParseTolerant is intended for editors and linters, which need to show all the errors in the query at once.
+func ParseTolerant(s string) (Expr, []*ParseError) {
+	var p parser
+	p.tolerant = true
+	p.lex.tolerant = true
+	p.lex.Init(s)
+	if err := p.lex.Next(); err != nil {
+		// This shouldn't happen, since tolerant lexer skips invalid tokens.
+		return nil, []*ParseError{toParseError(err, "")}
+	}
+	e, err := p.parseExpr()
+	if err != nil {
+		// This shouldn't happen, since tolerant parser recovers from errors.
+		return nil, []*ParseError{toParseError(err, "")}
+	}
+	if !isEOF(p.lex.Token) {
+		kind := ParseErrorUnexpectedToken
+		if isClosingParens(p.lex.Token) {
+			kind = ParseErrorUnbalancedParens
+		}
+		p.addError(p.errorf(kind, `unparsed data left: %q`, p.lex.Context()))
+	}
+	was := getDefaultWithArgExprs()
+	e = p.expandWithExprTolerant(was, e)
+	e = removeParensExpr(e)
+	e = simplifyConstants(e)
+	for _, err := range getUnsupportedFunctionErrors(e) {
+		p.addError(err)
+	}
+	return e, p.getErrors()
+}
+
+// BadExpr is a placeholder for the part of the query, which couldn't be parsed.
+//
+// BadExpr may be returned only from ParseTolerant.
+type BadExpr struct {
+	// S contains the original text of the unparsed part of the query.
+	//
+	// S may be empty if the parser expected an expression at Pos, but didn't find it.
+	S string
+
+	// Pos is the position of the unparsed part in the query.
+	Pos Pos
+}
+
+// AppendString appends string representation of bad to dst.
+func (bad *BadExpr) AppendString(dst []byte) []byte {
+	return append(dst, bad.S...)
+}
+
+// getErrors returns all the errors collected by p and p.lex sorted by their position in the query.
+//
+// Only the first error is returned for every position, since the subsequent errors
+// at the same position are usually caused by the recovery from the first error.
+func (p *parser) getErrors() []*ParseError {
+	errs := append(p.lex.errs, p.errs...)
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Pos.Offset < errs[j].Pos.Offset
+	})
+	dst := errs[:0]
+	for _, err := range errs {
+		if len(dst) > 0 && err.Pos.IsValid() && err.Pos.Offset == dst[len(dst)-1].Pos.Offset {
+			continue
+		}
+		dst = append(dst, err)
+	}
+	return dst
+}
+
+func (p *parser) addError(err error) {
+	p.errs = append(p.errs, toParseError(err, ""))
+}
+
+// recoverError registers err and returns nil if p is tolerant. Otherwise it returns err.
+func (p *parser) recoverError(err error) error {
+	if !p.tolerant {
+		return err
+	}
+	p.addError(err)
+	return nil
+}
+
+// recoverExpr recovers from err returned when parsing expression started at the given byte offset.
+//
+// It skips tokens until the next binary operation or the end of the enclosing list
+// and returns BadExpr for the skipped part of the query if p is tolerant.
+// Otherwise it returns err.
+func (p *parser) recoverExpr(err error, start int) (Expr, error) {
+	if err := p.recoverError(err); err != nil {
+		return nil, err
+	}
+	p.skipTokens(isExprDelimiter)
+	return p.newBadExpr(p.pos(start)), nil
+}
+
+// recoverToken recovers from err returned for the current token.
+//
+// It skips tokens until the token for which isDelimiter returns true or until the end of the enclosing list
+// if p is tolerant. Otherwise it returns err.
+func (p *parser) recoverToken(err error, isDelimiter func(token string) bool) error {
+	if err := p.recoverError(err); err != nil {
+		return err
+	}
+	p.skipTokens(isDelimiter)
+	return nil
+}
+
+// skipTokens skips tokens until the token for which isDelimiter returns true,
+// the unbalanced closing parens or EOF.
+//
+// Tokens inside parens, brackets and braces are skipped together with the parens.
+func (p *parser) skipTokens(isDelimiter func(token string) bool) {
+	depth := 0
+	for !isEOF(p.lex.Token) {
+		switch p.lex.Token {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			if depth == 0 {
+				return
+			}
+			depth--
+		default:
+			if depth == 0 && isDelimiter(p.lex.Token) {
+				return
+			}
+		}
+		if err := p.lex.Next(); err != nil {
+			return
+		}
+	}
+}
+
+func (p *parser) newBadExpr(pos Pos) *BadExpr {
+	return &BadExpr{
+		S:   p.lex.sOrig[pos.Offset:pos.End],
+		Pos: pos,
+	}
+}
+
+func isExprDelimiter(token string) bool {
+	return token == "," || isBinaryOp(token)
+}
+
+func isListDelimiter(token string) bool {
+	return token == ","
+}
+
+func isLabelFiltersDelimiter(token string) bool {
+	return token == "," || strings.ToLower(token) == "or"
+}
+
+// expandWithExprTolerant expands WITH expressions in e.
+//
+// Every subexpression in e, which cannot be expanded, is replaced with BadExpr.
+func (p *parser) expandWithExprTolerant(was []*withArgExpr, e Expr) Expr {
+	for {
+		eExpanded, err := expandWithExpr(was, e)
+		if err == nil {
+			return eExpanded
+		}
+		pe := toParseError(fmt.Errorf(`cannot expand WITH expressions: %w`, err), "")
+		p.errs = append(p.errs, pe)
+		if !p.replaceWithBadExpr(&e, pe.Pos) {
+			return p.newBadExpr(ExprPos(e))
+		}
+	}
+}
+
+// replaceWithBadExpr replaces the smallest subexpression of *pe containing pos with BadExpr.
+//
+// It returns false if there is no such subexpression or if it is already BadExpr.
+func (p *parser) replaceWithBadExpr(pe *Expr, pos Pos) bool {
+	if !pos.IsValid() {
+		return false
+	}
+	for _, child := range getChildExprPtrs(*pe) {
+		childPos := ExprPos(*child)
+		if !childPos.IsValid() || childPos.Offset > pos.Offset || childPos.End < pos.End {
+			continue
+		}
+		if p.replaceWithBadExpr(child, pos) {
+			return true
+		}
+		if _, ok := (*child).(*BadExpr); ok {
+			return false
+		}
+		*child = p.newBadExpr(childPos)
+		return true
+	}
+	return false
+}
+
+// getChildExprPtrs returns pointers to child expressions of unexpanded e.
+func getChildExprPtrs(e Expr) []*Expr {
+	var children []*Expr
+	switch t := e.(type) {
+	case *BinaryOpExpr:
+		children = append(children, &t.Left, &t.Right)
+	case *FuncExpr:
+		for i := range t.Args {
+			children = append(children, &t.Args[i])
+		}
+	case *AggrFuncExpr:
+		for i := range t.Args {
+			children = append(children, &t.Args[i])
+		}
+	case *RollupExpr:
+		children = append(children, &t.Expr)
+		if t.At != nil {
+			children = append(children, &t.At)
+		}
+	case *parensExpr:
+		for i := range t.Args {
+			children = append(children, &t.Args[i])
+		}
+	case *withExpr:
+		for _, wa := range t.Was {
+			children = append(children, &wa.Expr)
+		}
+		children = append(children, &t.Expr)
+	}
+	return children
+}
@@ -0,0 +1,112 @@
+package metricsql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTolerantSuccess(t *testing.T) {
+	f := func(s string) {
+		t.Helper()
+
+		e, errs := ParseTolerant(s)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors when parsing %q: %v", s, errs)
+		}
+		eExpected, err := Parse(s)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %q: %s", s, err)
+		}
+		result := string(e.AppendString(nil))
+		resultExpected := string(eExpected.AppendString(nil))
+		if result != resultExpected {
+			t.Fatalf("unexpected result for %q;\ngot\n%s\nwant\n%s", s, result, resultExpected)
+		}
+	}
+
+	f(`foo`)
+	f(`foo{bar="baz",x=~"y" or z!="w"}[5m:1m] offset 1h @ 123`)
+	f(`sum(rate(foo[5m])) by (job) / on(job) group_left(x) prefix "y" bar keep_metric_names`)
+	f(`with (f(a) = a + 1, x = foo) f(x) + ru(a, b)`)
+	f(`(1 + 2) * 3`)
+}
+
+func TestParseTolerantError(t *testing.T) {
+	f := func(s, resultExpected string, kindsExpected []ParseErrorKind, positionsExpected []string) {
+		t.Helper()
+
+		e, errs := ParseTolerant(s)
+		if e == nil {
+			t.Fatalf("expecting non-nil Expr for %q", s)
+		}
+		result := string(e.AppendString(nil))
+		if result != resultExpected {
+			t.Fatalf("unexpected partial result for %q;\ngot\n%s\nwant\n%s", s, result, resultExpected)
+		}
+		var kinds []ParseErrorKind
+		var positions []string
+		for _, err := range errs {
+			kinds = append(kinds, err.Kind)
+			positions = append(positions, err.Pos.String())
+		}
+		if !reflect.DeepEqual(kinds, kindsExpected) {
+			t.Fatalf("unexpected error kinds for %q;\ngot\n%v\nwant\n%v\nerrors: %v", s, kinds, kindsExpected, errs)
+		}
+		if !reflect.DeepEqual(positions, positionsExpected) {
+			t.Fatalf("unexpected error positions for %q;\ngot\n%v\nwant\n%v\nerrors: %v", s, positions, positionsExpected, errs)
+		}
+	}
+
+	// empty query
+	f(``, ``, []ParseErrorKind{ParseErrorUnexpectedEOF}, []string{"1:1"})
+
+	// invalid tokens are skipped
+	f(`foo $ bar`, `foo`, []ParseErrorKind{ParseErrorInvalidToken, ParseErrorUnexpectedToken}, []string{"1:5", "1:7"})
+	f(`rate(foo[5m]) + 1e`, `rate(foo[5m]) + `, []ParseErrorKind{ParseErrorBadNumber, ParseErrorUnexpectedEOF}, []string{"1:17", "1:19"})
+
+	// label filters
+	f(`foo{`, `foo`, []ParseErrorKind{ParseErrorUnexpectedEOF}, []string{"1:5"})
+	f(`foo{a="b" c}`, `foo{a="b"}`, []ParseErrorKind{ParseErrorUnexpectedToken}, []string{"1:11"})
+	f(`foo{a="b" or c=~"(", d="x"}`, `foo{a="b" or c=~"(", d="x"}`, []ParseErrorKind{ParseErrorBadRegexp}, []string{"1:17"})
+
+	// arg lists
+	f(`sum(rate(foo[5m]) bar, baz)`, `sum(rate(foo[5m]), baz)`, []ParseErrorKind{ParseErrorUnexpectedToken}, []string{"1:19"})
+	f(`abs(x, ]) + y`, `abs(x, )`, []ParseErrorKind{ParseErrorUnbalancedParens}, []string{"1:8"})
+	f(`(a + b`, `a + b`, []ParseErrorKind{ParseErrorUnbalancedParens}, []string{"1:7"})
+
+	// binary operations
+	f(`1 + 2 +`, `3 + `, []ParseErrorKind{ParseErrorUnexpectedEOF}, []string{"1:8"})
+	f(`a + bool b`, `a + bool b`, []ParseErrorKind{ParseErrorBadModifier}, []string{"1:5"})
+
+	// multiple errors
+	f(`unknown_func(a) + other_func(b{)`, `unknown_func(a) + other_func(b)`,
+		[]ParseErrorKind{ParseErrorUnknownFunc, ParseErrorUnknownFunc, ParseErrorUnbalancedParens}, []string{"1:1", "1:19", "1:32"})
+	f("foo{a=\"b\" c}\n  + bar(\n  1 2)", "foo{a=\"b\"} + bar(1)",
+		[]ParseErrorKind{ParseErrorUnexpectedToken, ParseErrorUnknownFunc, ParseErrorUnexpectedToken}, []string{"1:11", "2:5", "3:5"})
+
+	// WITH templates
+	f(`with (x = , y = 2) y + x`, `2 + `, []ParseErrorKind{ParseErrorUnexpectedToken}, []string{"1:11"})
+	f(`with (f(a) = a) f(1, 2) + foo`, `f(1, 2) + foo`, []ParseErrorKind{ParseErrorBadWithTemplate}, []string{"1:17"})
+	f(`with (x = 1, x = 2) x`, `2`, []ParseErrorKind{ParseErrorDuplicateWithArg}, []string{"1:14"})
+	f(`with (x="a") sum(foo) by (x)`, `with (x="a") sum(foo) by (x)`, []ParseErrorKind{ParseErrorBadWithTemplate}, []string{"1:7"})
+}
+
+func TestParseTolerantBadExpr(t *testing.T) {
+	s := `sum(foo, 1 +) + bar`
+	e, errs := ParseTolerant(s)
+	if len(errs) != 1 {
+		t.Fatalf("unexpected number of errors; got %d; want 1; errors: %v", len(errs), errs)
+	}
+	var bad *BadExpr
+	VisitAll(e, func(expr Expr) {
+		if be, ok := expr.(*BadExpr); ok {
+			bad = be
+		}
+	})
+	if bad == nil {
+		t.Fatalf("cannot find BadExpr in %s", e.AppendString(nil))
+	}
+	if bad.Pos.Offset != 12 || bad.Pos.End != 12 {
+		t.Fatalf("unexpected BadExpr position; got [%d:%d]; want [12:12]", bad.Pos.Offset, bad.Pos.End)
+	}
+}
@@ -13,26 +13,58 @@ import (
 //
 // MetricsQL is backwards-compatible with PromQL.
 func Parse(s string) (Expr, error) {
+	return ParseWithOptions(s, nil)
+}
+
+// ParseWithOptions parses MetricsQL query s with the given opts.
+//
+// Parse is equivalent to ParseWithOptions with nil opts.
+func ParseWithOptions(s string, opts *ParseOptions) (Expr, error) {
+	e, err := parseWithOptionsNoSimplify(s, opts)
+	if err != nil {
+		return nil, err
+	}
+	e = simplifyConstants(e)
+	if err := checkSupportedFunctions(e); err != nil {
+		return nil, toParseError(err, "")
+	}
+	return e, nil
+}
+
+// parseWithOptionsNoSimplify parses s with opts and expands WITH templates without simplifying constant expressions.
+//
+// The returned Expr isn't checked for unsupported functions.
+func parseWithOptionsNoSimplify(s string, opts *ParseOptions) (Expr, error) {
+	was, err := opts.getWithArgExprs()
+	if err != nil {
+		return nil, toParseError(err, "")
+	}
 	var p parser
+	p.promql = opts != nil && opts.PromQL
 	p.lex.Init(s)
 	if err := p.lex.Next(); err != nil {
-		return nil, fmt.Errorf(`cannot find the first token: %s`, err)
+		return nil, toParseError(fmt.Errorf(`cannot find the first token: %w`, err), "")
 	}
 	e, err := p.parseExpr()
 	if err != nil {
-		return nil, fmt.Errorf(`%s; unparsed data: %q`, err, p.lex.Context())
+		return nil, toParseError(err, p.lex.Context())
 	}
 	if !isEOF(p.lex.Token) {
-		return nil, fmt.Errorf(`unparsed data left: %q`, p.lex.Context())
+		kind := ParseErrorUnexpectedToken
+		if isClosingParens(p.lex.Token) {
+			kind = ParseErrorUnbalancedParens
+		}
+		return nil, toParseError(p.errorf(kind, `unparsed data left: %q`, p.lex.Context()), "")
 	}
-	was := getDefaultWithArgExprs()
 	if e, err = expandWithExpr(was, e); err != nil {
-		return nil, fmt.Errorf(`cannot expand WITH expressions: %s`, err)
+		return nil, toParseError(fmt.Errorf(`cannot expand WITH expressions: %w`, err), "")
 	}
 	e = removeParensExpr(e)
-	e = simplifyConstants(e)
-	if err := checkSupportedFunctions(e); err != nil {
-		return nil, err
+	if p.promql {
+		// Check for PromQL compatibility before simplifyConstants, since it loses the original numbers.
+		if err := checkPromQL(e); err != nil {
+			return nil, toParseError(err, "")
+		}
 	}
 	return e, nil
 }
@@ -43,6 +75,199 @@ type Expr interface {
 	AppendString(dst []byte) []byte
 }
 
+// Pos describes the location of the parsed node in the original query.
+//
+// Nodes obtained from WITH templates point to the template body they came from.
+// Nodes without the location in the original query, such as nodes created
+// by built-in WITH templates, have zero Pos.
+type Pos struct {
+	// Offset is the byte offset of the first char of the node.
+	Offset int
+
+	// End is the byte offset just after the last char of the node.
+	End int
+
+	// Line is the line number for Offset starting from 1.
+	Line int
+
+	// Column is the byte offset of Offset in the Line starting from 1.
+	Column int
+}
+
+// IsValid returns true if pos points to the original query.
+func (pos Pos) IsValid() bool {
+	return pos.Line > 0
+}
+
+// String returns string representation for pos in the form `line:column`.
+func (pos Pos) String() string {
+	if !pos.IsValid() {
+		return "-"
+	}
+	return fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+}
+
+// ExprPos returns the position of e in the original query.
+//
+// Zero Pos is returned if e has no position.
+func ExprPos(e Expr) Pos {
+	switch t := e.(type) {
+	case *MetricExpr:
+		return t.Pos
+	case *FuncExpr:
+		return t.Pos
+	case *AggrFuncExpr:
+		return t.Pos
+	case *BinaryOpExpr:
+		return t.Pos
+	case *RollupExpr:
+		return t.Pos
+	case *NumberExpr:
+		return t.Pos
+	case *StringExpr:
+		return t.Pos
+	case *DurationExpr:
+		return t.Pos
+	case *ModifierExpr:
+		return t.Pos
+	case *parensExpr:
+		return t.Pos
+	case *withExpr:
+		return t.Pos
+	case *BadExpr:
+		return t.Pos
+	default:
+		return Pos{}
+	}
+}
+
+// withExprPos returns a shallow copy of e with the given pos if e has no position.
+//
+// This is used for setting call-site position on the expansion results of built-in WITH templates.
+func withExprPos(e Expr, pos Pos) Expr {
+	if ExprPos(e).IsValid() || !pos.IsValid() {
+		return e
+	}
+	switch t := e.(type) {
+	case *MetricExpr:
+		me := *t
+		me.Pos = pos
+		return &me
+	case *FuncExpr:
+		fe := *t
+		fe.Pos = pos
+		return &fe
+	case *AggrFuncExpr:
+		ae := *t
+		ae.Pos = pos
+		return &ae
+	case *BinaryOpExpr:
+		be := *t
+		be.Pos = pos
+		return &be
+	case *RollupExpr:
+		re := *t
+		re.Pos = pos
+		return &re
+	case *NumberExpr:
+		ne := *t
+		ne.Pos = pos
+		return &ne
+	case *StringExpr:
+		se := *t
+		se.Pos = pos
+		return &se
+	case *DurationExpr:
+		de := *t
+		de.Pos = pos
+		return &de
+	case *parensExpr:
+		pe := *t
+		pe.Pos = pos
+		if len(pe.Args) == 1 {
+			pe.Args = []Expr{withExprPos(pe.Args[0], pos)}
+		}
+		return &pe
+	default:
+		return e
+	}
+}
+
+// clearExprPos recursively resets positions for all the nodes in e.
+//
+// e must be owned by the caller.
+func clearExprPos(e Expr) {
+	switch t := e.(type) {
+	case *MetricExpr:
+		t.Pos = Pos{}
+		for _, lfs := range t.LabelFilterss {
+			for i := range lfs {
+				lfs[i].Pos = Pos{}
+			}
+		}
+		for _, lfes := range t.labelFilterss {
+			for _, lfe := range lfes {
+				lfe.Pos = Pos{}
+				if lfe.Value != nil {
+					clearExprPos(lfe.Value)
+				}
+			}
+		}
+	case *FuncExpr:
+		t.Pos = Pos{}
+		for _, arg := range t.Args {
+			clearExprPos(arg)
+		}
+	case *AggrFuncExpr:
+		t.Pos = Pos{}
+		t.Modifier.Pos = Pos{}
+		for _, arg := range t.Args {
+			clearExprPos(arg)
+		}
+	case *BinaryOpExpr:
+		t.Pos = Pos{}
+		t.GroupModifier.Pos = Pos{}
+		t.JoinModifier.Pos = Pos{}
+		if t.JoinModifierPrefix != nil {
+			clearExprPos(t.JoinModifierPrefix)
+		}
+		clearExprPos(t.Left)
+		clearExprPos(t.Right)
+	case *RollupExpr:
+		t.Pos = Pos{}
+		clearExprPos(t.Expr)
+		if t.Window != nil {
+			clearExprPos(t.Window)
+		}
+		if t.Offset != nil {
+			clearExprPos(t.Offset)
+		}
+		if t.Step != nil {
+			clearExprPos(t.Step)
+		}
+		if t.At != nil {
+			clearExprPos(t.At)
+		}
+	case *NumberExpr:
+		t.Pos = Pos{}
+	case *StringExpr:
+		t.Pos = Pos{}
+	case *DurationExpr:
+		t.Pos = Pos{}
+	case *parensExpr:
+		t.Pos = Pos{}
+		for _, arg := range t.Args {
+			clearExprPos(arg)
+		}
+	case *withExpr:
+		t.Pos = Pos{}
+		for _, wa := range t.Was {
+			clearExprPos(wa.Expr)
+		}
+		clearExprPos(t.Expr)
+	}
+}
+
 func getDefaultWithArgExprs() []*withArgExpr {
 	defaultWithArgExprsOnce.Do(func() {
 		defaultWithArgExprs = prepareWithArgExprs([]string{
@@ -70,7 +295,10 @@ var (
 func prepareWithArgExprs(ss []string) []*withArgExpr {
 	was := make([]*withArgExpr, len(ss))
 	for i, s := range ss {
-		was[i] = mustParseWithArgExpr(s)
+		wa := mustParseWithArgExpr(s)
+		// Built-in templates have no position in the query.
+		clearExprPos(wa.Expr)
+		was[i] = wa
 	}
 	if err := checkDuplicateWithArgNames(was); err != nil {
 		panic(fmt.Errorf("BUG: %s", err))
@@ -82,7 +310,7 @@ func checkDuplicateWithArgNames(was []*withArgExpr) error {
 	m := make(map[string]*withArgExpr, len(was))
 	for _, wa := range was {
 		if waOld := m[wa.Name]; waOld != nil {
-			return fmt.Errorf("duplicate `with` arg name for: %s; previous one: %s", wa, waOld.AppendString(nil))
+			return newParseError(ParseErrorDuplicateWithArg, wa.Name, wa.Pos, "duplicate `with` arg name for: %s; previous one: %s", wa.AppendString(nil), waOld.AppendString(nil))
 		}
 		m[wa.Name] = wa
 	}
@@ -129,17 +357,18 @@ func removeParensExpr(e Expr) Expr {
 		return fe
 	}
 	if pe, ok := e.(*parensExpr); ok {
-		args := *pe
+		args := pe.Args
 		for i, arg := range args {
 			args[i] = removeParensExpr(arg)
 		}
-		if len(*pe) == 1 {
+		if len(args) == 1 {
 			return args[0]
 		}
 		// Treat parensExpr as a function with empty name, i.e. union()
 		fe := &FuncExpr{
 			Name: "",
 			Args: args,
+			Pos:  pe.Pos,
 		}
 		return fe
 	}
@@ -160,13 +389,13 @@ func simplifyConstants(e Expr) Expr {
 	}
 	if fe, ok := e.(*FuncExpr); ok {
 		simplifyConstantsInplace(fe.Args)
-		return fe
+		return simplifyConstantFuncExpr(fe)
 	}
 	if pe, ok := e.(*parensExpr); ok {
-		if len(*pe) == 1 {
-			return simplifyConstants((*pe)[0])
+		if len(pe.Args) == 1 {
+			return simplifyConstants(pe.Args[0])
 		}
-		simplifyConstantsInplace(*pe)
+		simplifyConstantsInplace(pe.Args)
 		return pe
 	}
 	be, ok := e.(*BinaryOpExpr)
@@ -182,7 +411,8 @@ func simplifyConstants(e Expr) Expr {
 	if lok && rok {
 		n := binaryOpEvalNumber(be.Op, lne.N, rne.N, be.Bool)
 		return &NumberExpr{
-			N: n,
+			N:   n,
+			Pos: be.Pos,
 		}
 	}
 
@@ -195,7 +425,8 @@ func simplifyConstants(e Expr) Expr {
 	if be.Op == "+" {
 		// convert "foo" + "bar" to "foobar".
 		return &StringExpr{
-			S: lse.S + rse.S,
+			S:   lse.S + rse.S,
+			Pos: be.Pos,
 		}
 	}
 	if !IsBinaryOpCmp(be.Op) {
@@ -227,7 +458,28 @@ func simplifyConstants(e Expr) Expr {
 		n = nan
 	}
 	return &NumberExpr{
-		N: n,
+		N:   n,
+		Pos: be.Pos,
+	}
+}
+
+// simplifyConstantFuncExpr evaluates fe if it is a pure transform function with number args, e.g. abs(-5) or sqrt(16).
+func simplifyConstantFuncExpr(fe *FuncExpr) Expr {
+	args := make([]float64, len(fe.Args))
+	for i, arg := range fe.Args {
+		ne, ok := arg.(*NumberExpr)
+		if !ok {
+			return fe
+		}
+		args[i] = ne.N
+	}
+	n, ok := transformFuncEvalNumber(fe.Name, args)
+	if !ok {
+		return fe
+	}
+	return &NumberExpr{
+		N:   n,
+		Pos: fe.Pos,
 	}
 }
 
@@ -246,6 +498,25 @@ func simplifyConstantsInplace(args []Expr) {
 // - p.lex.Token should point to the next token after the parsed token.
 type parser struct {
 	lex lexer
+
+	// tolerant is set to true if the parser must recover from errors.
+	//
+	// See ParseTolerant.
+	tolerant bool
+
+	// errs contains errors collected in tolerant mode.
+	errs []*ParseError
+
+	// promql is set to true if the parser must reject WITH expressions.
+	//
+	// See ParseOptions.PromQL.
+	promql bool
+}
+
+// pos returns the position for the query part starting at the start offset
+// and ending at the previously consumed token.
+func (p *parser) pos(start int) Pos {
+	return p.lex.Pos(start, p.lex.prevEnd())
 }
 
 func isWith(s string) bool {
@@ -256,14 +527,18 @@ func isWith(s string) bool {
 // parseWithExpr parses `WITH (withArgExpr...) expr`.
 func (p *parser) parseWithExpr() (*withExpr, error) {
 	var we withExpr
+	start := p.lex.offset
 	if !isWith(p.lex.Token) {
-		return nil, fmt.Errorf("withExpr: unexpected token %q; want `WITH`", p.lex.Token)
+		return nil, p.unexpectedTokenError("withExpr", "WITH")
+	}
+	if p.promql {
+		return nil, p.errorf(ParseErrorNotPromQL, "WITH expressions aren't supported by PromQL")
 	}
 	if err := p.lex.Next(); err != nil {
 		return nil, err
 	}
 	if p.lex.Token != "(" {
-		return nil, fmt.Errorf(`withExpr: unexpected token %q; want "("`, p.lex.Token)
+		return nil, p.unexpectedTokenError("withExpr", "(")
 	}
 	for {
 		if err := p.lex.Next(); err != nil {
@@ -274,38 +549,52 @@ func (p *parser) parseWithExpr() (*withExpr, error) {
 		}
 		wa, err := p.parseWithArgExpr()
 		if err != nil {
-			return nil, err
+			if err := p.recoverToken(err, isListDelimiter); err != nil {
+				return nil, err
+			}
+		} else {
+			we.Was = append(we.Was, wa)
+		}
+		if p.lex.Token != "," && p.lex.Token != ")" {
+			if err := p.recoverToken(p.unexpectedTokenError("withExpr", ",", ")"), isListDelimiter); err != nil {
+				return nil, err
+			}
 		}
-		we.Was = append(we.Was, wa)
 		switch p.lex.Token {
 		case ",":
 			continue
 		case ")":
 			goto end
 		default:
-			return nil, fmt.Errorf(`withExpr: unexpected token %q; want ",", ")"`, p.lex.Token)
+			// Unclosed WITH args in tolerant mode.
+			goto noParens
 		}
 	}
 
 end:
-	if err := checkDuplicateWithArgNames(we.Was); err != nil {
-		return nil, err
-	}
 	if err := p.lex.Next(); err != nil {
 		return nil, err
 	}
+noParens:
+	if err := checkDuplicateWithArgNames(we.Was); err != nil {
+		if err := p.recoverError(err); err != nil {
+			return nil, err
+		}
+	}
 	e, err := p.parseExpr()
 	if err != nil {
 		return nil, err
 	}
 	we.Expr = e
+	we.Pos = p.pos(start)
 	return &we, nil
 }
 
 func (p *parser) parseWithArgExpr() (*withArgExpr, error) {
 	var wa withArgExpr
+	start := p.lex.offset
 	if !isIdentPrefix(p.lex.Token) {
-		return nil, fmt.Errorf(`withArgExpr: unexpected token %q; want "ident"`, p.lex.Token)
+		return nil, p.unexpectedTokenError("withArgExpr", "ident")
 	}
 	wa.Name = unescapeIdent(p.lex.Token)
 	if err := p.lex.Next(); err != nil {
@@ -315,36 +604,40 @@ func (p *parser) parseWithArgExpr() (*withArgExpr, error) {
 		// Parse func args.
 		args, err := p.parseIdentList(false)
 		if err != nil {
-			return nil, fmt.Errorf(`withArgExpr: cannot parse args for %q: %s`, wa.Name, err)
+			return nil, fmt.Errorf(`withArgExpr: cannot parse args for %q: %w`, wa.Name, err)
 		}
 		// Make sure all the args have different names
 		m := make(map[string]bool, len(args))
 		for _, arg := range args {
 			if m[arg] {
-				return nil, fmt.Errorf(`withArgExpr: duplicate func arg found in %q: %q`, wa.Name, arg)
+				return nil, newParseError(ParseErrorDuplicateWithArg, arg, p.pos(start), `withArgExpr: duplicate func arg found in %q: %q`, wa.Name, arg)
 			}
 			m[arg] = true
 		}
 		wa.Args = args
 	}
 	if p.lex.Token != "=" {
-		return nil, fmt.Errorf(`withArgExpr: unexpected token %q; want "="`, p.lex.Token)
+		return nil, p.unexpectedTokenError("withArgExpr", "=")
 	}
 	if err := p.lex.Next(); err != nil {
 		return nil, err
 	}
 	e, err := p.parseExpr()
 	if err != nil {
-		return nil, fmt.Errorf(`withArgExpr: cannot parse %q: %s`, wa.Name, err)
+		return nil, fmt.Errorf(`withArgExpr: cannot parse %q: %w`, wa.Name, err)
 	}
 	wa.Expr = e
+	wa.Pos = p.pos(start)
 	return &wa, nil
 }
 
 func (p *parser) parseExpr() (Expr, error) {
+	start := p.lex.offset
 	e, err := p.parseSingleExpr()
 	if err != nil {
-		return nil, err
+		if e, err = p.recoverExpr(err, start); err != nil {
+			return nil, err
+		}
 	}
 	for {
 		if !isBinaryOp(p.lex.Token) {
@@ -354,44 +647,21 @@ func (p *parser) parseExpr() (Expr, error) {
 		var be BinaryOpExpr
 		be.Op = strings.ToLower(p.lex.Token)
 		be.Left = e
+		opStart := p.lex.offset
 		if err := p.lex.Next(); err != nil {
 			return nil, err
 		}
-		if isBinaryOpBoolModifier(p.lex.Token) {
-			if !IsBinaryOpCmp(be.Op) {
-				return nil, fmt.Errorf(`bool modifier cannot be applied to %q`, be.Op)
-			}
-			be.Bool = true
-			if err := p.lex.Next(); err != nil {
-				return nil, err
-			}
+		rightStart := p.lex.offset
+		var e2 Expr
+		err := p.parseBinaryOpModifiers(&be)
+		if err == nil {
+			rightStart = p.lex.offset
+			e2, err = p.parseSingleExpr()
 		}
-		if isBinaryOpGroupModifier(p.lex.Token) {
-			if err := p.parseModifierExpr(&be.GroupModifier, false); err != nil {
+		if err != nil {
+			if e2, err = p.recoverExpr(err, rightStart); err != nil {
 				return nil, err
 			}
-			if isBinaryOpJoinModifier(p.lex.Token) {
-				if isBinaryOpLogicalSet(be.Op) {
-					return nil, fmt.Errorf(`modifier %q cannot be applied to %q`, p.lex.Token, be.Op)
-				}
-				if err := p.parseModifierExpr(&be.JoinModifier, true); err != nil {
-					return nil, err
-				}
-				if isPrefixModifier(p.lex.Token) {
-					if err := p.lex.Next(); err != nil {
-						return nil, fmt.Errorf("cannot read prefix for %s: %w", be.JoinModifier.AppendString(nil), err)
-					}
-					se, err := p.parseStringExpr()
-					if err != nil {
-						return nil, fmt.Errorf("cannot parse prefix for %s: %w", be.JoinModifier.AppendString(nil), err)
-					}
-					be.JoinModifierPrefix = se
-				}
-			}
-		}
-		e2, err := p.parseSingleExpr()
-		if err != nil {
-			return nil, err
 		}
 		be.Right = e2
 		if isKeepMetricNames(p.lex.Token) {
@@ -400,10 +670,50 @@ func (p *parser) parseExpr() (Expr, error) {
 				return nil, err
 			}
 		}
+		be.Pos = spanPos(ExprPos(be.Left), p.pos(opStart))
 		e = balanceBinaryOp(&be)
 	}
 }
 
+// parseBinaryOpModifiers parses optional modifiers after be.Op.
+func (p *parser) parseBinaryOpModifiers(be *BinaryOpExpr) error {
+	if isBinaryOpBoolModifier(p.lex.Token) {
+		if !IsBinaryOpCmp(be.Op) {
+			return p.errorf(ParseErrorBadModifier, `bool modifier cannot be applied to %q`, be.Op)
+		}
+		be.Bool = true
+		if err := p.lex.Next(); err != nil {
+			return err
+		}
+	}
+	if !isBinaryOpGroupModifier(p.lex.Token) {
+		return nil
+	}
+	if err := p.parseModifierExpr(&be.GroupModifier, false); err != nil {
+		return err
+	}
+	if !isBinaryOpJoinModifier(p.lex.Token) {
+		return nil
+	}
+	if isBinaryOpLogicalSet(be.Op) {
+		return p.errorf(ParseErrorBadModifier, `modifier %q cannot be applied to %q`, p.lex.Token, be.Op)
+	}
+	if err := p.parseModifierExpr(&be.JoinModifier, true); err != nil {
+		return err
+	}
+	if isPrefixModifier(p.lex.Token) {
+		if err := p.lex.Next(); err != nil {
+			return fmt.Errorf("cannot read prefix for %s: %w", be.JoinModifier.AppendString(nil), err)
+		}
+		se, err := p.parseStringExpr()
+		if err != nil {
+			return fmt.Errorf("cannot parse prefix for %s: %w", be.JoinModifier.AppendString(nil), err)
+		}
+		be.JoinModifierPrefix = se
+	}
+	return nil
+}
+
 func balanceBinaryOp(be *BinaryOpExpr) Expr {
 	bel, ok := be.Left.(*BinaryOpExpr)
 	if !ok {
@@ -418,10 +728,24 @@ func balanceBinaryOp(be *BinaryOpExpr) Expr {
 		return be
 	}
 	be.Left = bel.Right
+	be.Pos = spanPos(ExprPos(be.Left), be.Pos)
 	bel.Right = balanceBinaryOp(be)
+	bel.Pos = spanPos(bel.Pos, ExprPos(bel.Right))
 	return bel
 }
 
+// spanPos returns the position starting at start and ending at end.
+func spanPos(start, end Pos) Pos {
+	if !start.IsValid() {
+		return end
+	}
+	if !end.IsValid() {
+		return start
+	}
+	start.End = end.End
+	return start
+}
+
 // parseSingleExpr parses non-binaryOp expressions.
 func (p *parser) parseSingleExpr() (Expr, error) {
 	if isWith(p.lex.Token) {
@@ -467,9 +791,11 @@ func (p *parser) parseSingleExprWithoutRollupSuffix() (Expr, error) {
 		return p.parseMetricExpr()
 	case "-":
 		// Unary minus. Substitute `-expr` with `0 - expr`
+		start := p.lex.offset
 		if err := p.lex.Next(); err != nil {
 			return nil, err
 		}
+		minusPos := p.pos(start)
 		e, err := p.parseSingleExpr()
 		if err != nil {
 			return nil, err
@@ -477,9 +803,11 @@ func (p *parser) parseSingleExprWithoutRollupSuffix() (Expr, error) {
 		be := &BinaryOpExpr{
 			Op: "-",
 			Left: &NumberExpr{
-				N: 0,
+				N:   0,
+				Pos: minusPos,
 			},
 			Right: e,
+			Pos:   p.pos(start),
 		}
 		return be, nil
 	case "+":
@@ -489,43 +817,47 @@ func (p *parser) parseSingleExprWithoutRollupSuffix() (Expr, error) {
 		}
 		return p.parseSingleExpr()
 	default:
-		return nil, fmt.Errorf(`singleExpr: unexpected token %q; want "(", "{", "-", "+"`, p.lex.Token)
+		return nil, p.unexpectedTokenError("singleExpr", "(", "{", "-", "+")
 	}
 }
 
 func (p *parser) parsePositiveNumberExpr() (*NumberExpr, error) {
 	if !isPositiveNumberPrefix(p.lex.Token) && !isInfOrNaN(p.lex.Token) {
-		return nil, fmt.Errorf(`positiveNumberExpr: unexpected token %q; want "number"`, p.lex.Token)
+		return nil, p.unexpectedTokenError("positiveNumberExpr", "number")
 	}
 	s := p.lex.Token
+	start := p.lex.offset
 	n, err := parsePositiveNumber(s)
 	if err != nil {
-		return nil, fmt.Errorf(`positivenumberExpr: cannot parse %q: %s`, s, err)
+		return nil, p.errorf(ParseErrorBadNumber, `positivenumberExpr: cannot parse %q: %w`, s, err)
 	}
 	if err := p.lex.Next(); err != nil {
 		return nil, err
 	}
 	ne := &NumberExpr{
-		N: n,
-		s: s,
+		N:   n,
+		s:   s,
+		Pos: p.pos(start),
 	}
 	return ne, nil
 }
 
 func (p *parser) parseStringExpr() (*StringExpr, error) {
 	var se StringExpr
+	start := p.lex.offset
 
 	for {
 		switch {
 		case isStringPrefix(p.lex.Token) || isIdentPrefix(p.lex.Token):
 			se.tokens = append(se.tokens, p.lex.Token)
 		default:
-			return nil, fmt.Errorf(`StringExpr: unexpected token %q; want "string"`, p.lex.Token)
+			return nil, p.unexpectedTokenError("StringExpr", "string")
 		}
 		if err := p.lex.Next(); err != nil {
 			return nil, err
 		}
 		if p.lex.Token != "+" {
+			se.Pos = p.pos(start)
 			return &se, nil
 		}
 
@@ -540,6 +872,7 @@ func (p *parser) parseStringExpr() (*StringExpr, error) {
 		if !isIdentPrefix(p.lex.Token) {
 			// "s" + unknownToken
 			p.lex.Prev()
+			se.Pos = p.pos(start)
 			return &se, nil
 		}
 		// Look after ident
@@ -550,6 +883,7 @@ func (p *parser) parseStringExpr() (*StringExpr, error) {
 			// `"s" + m(` or `"s" + m{`
 			p.lex.Prev()
 			p.lex.Prev()
+			se.Pos = p.pos(start)
 			return &se, nil
 		}
 		// "s" + ident
@@ -559,8 +893,9 @@ func (p *parser) parseStringExpr() (*StringExpr, error) {
 
 func (p *parser) parseParensExpr() (*parensExpr, error) {
 	if p.lex.Token != "(" {
-		return nil, fmt.Errorf(`parensExpr: unexpected token %q; want "("`, p.lex.Token)
+		return nil, p.unexpectedTokenError("parensExpr", "(")
 	}
+	start := p.lex.offset
 	var exprs []Expr
 	for {
 		if err := p.lex.Next(); err != nil {
@@ -574,13 +909,22 @@ func (p *parser) parseParensExpr() (*parensExpr, error) {
 			return nil, err
 		}
 		exprs = append(exprs, expr)
+		if p.lex.Token != "," && p.lex.Token != ")" {
+			if err := p.recoverToken(p.unexpectedTokenError("parensExpr", ",", ")"), isListDelimiter); err != nil {
+				return nil, err
+			}
+		}
 		if p.lex.Token == "," {
 			continue
 		}
 		if p.lex.Token == ")" {
 			break
 		}
-		return nil, fmt.Errorf(`parensExpr: unexpected token %q; want "," or ")"`, p.lex.Token)
+		// Unclosed parens in tolerant mode.
+		return &parensExpr{
+			Args: exprs,
+			Pos:  p.pos(start),
+		}, nil
 	}
 	if err := p.lex.Next(); err != nil {
 		return nil, err
@@ -593,16 +937,20 @@ func (p *parser) parseParensExpr() (*parensExpr, error) {
 			be.KeepMetricNames = true
 		}
 	}
-	pe := parensExpr(exprs)
-	return &pe, nil
+	pe := &parensExpr{
+		Args: exprs,
+		Pos:  p.pos(start),
+	}
+	return pe, nil
 }
 
 func (p *parser) parseAggrFuncExpr() (*AggrFuncExpr, error) {
 	if !IsAggrFunc(p.lex.Token) {
-		return nil, fmt.Errorf(`AggrFuncExpr: unexpected token %q; want aggregate func`, p.lex.Token)
+		return nil, p.unexpectedTokenError("AggrFuncExpr", "aggregate func")
 	}
 
 	var ae AggrFuncExpr
+	start := p.lex.offset
 	ae.Name = strings.ToLower(unescapeIdent(p.lex.Token))
 	if err := p.lex.Next(); err != nil {
 		return nil, err
@@ -613,12 +961,12 @@ func (p *parser) parseAggrFuncExpr() (*AggrFuncExpr, error) {
 	if p.lex.Token == "(" {
 		goto funcArgsLabel
 	}
-	return nil, fmt.Errorf(`AggrFuncExpr: unexpected token %q; want "("`, p.lex.Token)
+	return nil, p.unexpectedTokenError("AggrFuncExpr", "(")
 
 funcPrefixLabel:
 	{
 		if !isAggrFuncModifier(p.lex.Token) {
-			return nil, fmt.Errorf(`AggrFuncExpr: unexpected token %q; want aggregate func modifier`, p.lex.Token)
+			return nil, p.unexpectedTokenError("AggrFuncExpr", "aggregate func modifier")
 		}
 		if err := p.parseModifierExpr(&ae.Modifier, false); err != nil {
 			return nil, err
@@ -647,13 +995,14 @@ funcArgsLabel:
 			}
 			limit, err := strconv.Atoi(p.lex.Token)
 			if err != nil {
-				return nil, fmt.Errorf("cannot parse limit %q: %s", p.lex.Token, err)
+				return nil, p.errorf(ParseErrorBadNumber, "cannot parse limit %q: %w", p.lex.Token, err)
 			}
 			if err := p.lex.Next(); err != nil {
 				return nil, err
 			}
 			ae.Limit = limit
 		}
+		ae.Pos = p.pos(start)
 		return &ae, nil
 	}
 }
@@ -685,7 +1034,8 @@ func expandWithExpr(was []*withArgExpr, e Expr) (Expr, error) {
 			}
 			se, ok := jmp.(*StringExpr)
 			if !ok {
-				return nil, fmt.Errorf("unexpected prefix for %s; want quoted string; got %s", t.JoinModifier.AppendString(nil), jmp.AppendString(nil))
+				return nil, newParseError(ParseErrorBadWithTemplate, "", ExprPos(jmp), "unexpected prefix for %s; want quoted string; got %s",
+					t.JoinModifier.AppendString(nil), jmp.AppendString(nil))
 			}
 			joinModifierPrefix = se
 		}
@@ -694,7 +1044,8 @@ func expandWithExpr(was []*withArgExpr, e Expr) (Expr, error) {
 			rse, rok := right.(*StringExpr)
 			if lok && rok {
 				se := &StringExpr{
-					S: lse.S + rse.S,
+					S:   lse.S + rse.S,
+					Pos: t.Pos,
 				}
 				return se, nil
 			}
@@ -705,8 +1056,11 @@ func expandWithExpr(was []*withArgExpr, e Expr) (Expr, error) {
 		be.GroupModifier.Args = groupModifierArgs
 		be.JoinModifier.Args = joinModifierArgs
 		be.JoinModifierPrefix = joinModifierPrefix
-		pe := parensExpr{&be}
-		return &pe, nil
+		pe := &parensExpr{
+			Args: []Expr{&be},
+			Pos:  be.Pos,
+		}
+		return pe, nil
 	case *FuncExpr:
 		args, err := expandWithArgs(was, t.Args)
 		if err != nil {
@@ -714,7 +1068,7 @@ func expandWithExpr(was []*withArgExpr, e Expr) (Expr, error) {
 		}
 		wa := getWithArgExpr(was, t.Name)
 		if wa != nil {
-			return expandWithExprExt(was, wa, args)
+			return expandWithExprExt(was, wa, args, t.Pos)
 		}
 		fe := *t
 		fe.Args = args
@@ -726,7 +1080,7 @@ func expandWithExpr(was []*withArgExpr, e Expr) (Expr, error) {
 		}
 		wa := getWithArgExpr(was, t.Name)
 		if wa != nil {
-			return expandWithExprExt(was, wa, args)
+			return expandWithExprExt(was, wa, args, t.Pos)
 		}
 		modifierArgs, err := expandModifierArgs(was, t.Modifier.Args)
 		if err != nil {
@@ -737,12 +1091,15 @@ func expandWithExpr(was []*withArgExpr, e Expr) (Expr, error) {
 		ae.Modifier.Args = modifierArgs
 		return &ae, nil
 	case *parensExpr:
-		exprs, err := expandWithArgs(was, *t)
+		exprs, err := expandWithArgs(was, t.Args)
 		if err != nil {
 			return nil, err
 		}
-		pe := parensExpr(exprs)
-		return &pe, nil
+		pe := &parensExpr{
+			Args: exprs,
+			Pos:  t.Pos,
+		}
+		return pe, nil
 	case *StringExpr:
 		if len(t.S) > 0 {
 			// Already expanded.
@@ -753,22 +1110,22 @@ func expandWithExpr(was []*withArgExpr, e Expr) (Expr, error) {
 			if isStringPrefix(token) {
 				s, err := extractStringValue(token)
 				if err != nil {
-					return nil, err
+					return nil, newParseError(ParseErrorBadString, token, t.Pos, "%w", err)
 				}
 				b = append(b, s...)
 				continue
 			}
 			wa := getWithArgExpr(was, token)
 			if wa == nil {
-				return nil, fmt.Errorf("missing %q value inside StringExpr", token)
+				return nil, newParseError(ParseErrorBadWithTemplate, token, t.Pos, "missing %q value inside StringExpr", token)
 			}
-			eNew, err := expandWithExprExt(was, wa, nil)
+			eNew, err := expandWithExprExt(was, wa, nil, t.Pos)
 			if err != nil {
 				return nil, err
 			}
 			seSrc, ok := eNew.(*StringExpr)
 			if !ok {
-				return nil, fmt.Errorf("%q must be string expression; got %q", token, eNew.AppendString(nil))
+				return nil, newParseError(ParseErrorBadWithTemplate, token, t.Pos, "%q must be string expression; got %q", token, eNew.AppendString(nil))
 			}
 			if len(seSrc.tokens) > 0 {
 				panic(fmt.Errorf("BUG: seSrc.tokens must be empty; got %q", seSrc.tokens))
@@ -776,7 +1133,8 @@ func expandWithExpr(was []*withArgExpr, e Expr) (Expr, error) {
 			b = append(b, seSrc.S...)
 		}
 		se := &StringExpr{
-			S: string(b),
+			S:   string(b),
+			Pos: t.Pos,
 		}
 		return se, nil
 	case *RollupExpr:
@@ -830,15 +1188,15 @@ func expandWithExpr(was []*withArgExpr, e Expr) (Expr, error) {
 						// Expand lfe.Label into lfsNew.
 						wa := getWithArgExpr(was, lfe.Label)
 						if wa == nil {
-							return nil, fmt.Errorf("cannot find WITH template for %q inside %q", lfe.Label, t.AppendString(nil))
+							return nil, newParseError(ParseErrorBadWithTemplate, lfe.Label, lfe.Pos, "cannot find WITH template for %q inside %q", lfe.Label, t.AppendString(nil))
 						}
-						eNew, err := expandWithExprExt(was, wa, []Expr{})
+						eNew, err := expandWithExprExt(was, wa, []Expr{}, lfe.Pos)
 						if err != nil {
 							return nil, err
 						}
 						wme, ok := eNew.(*MetricExpr)
 						if !ok || wme.getMetricName() != "" {
-							return nil, fmt.Errorf("WITH template %q inside %q must be {...}; got %q",
+							return nil, newParseError(ParseErrorBadWithTemplate, lfe.Label, lfe.Pos, "WITH template %q inside %q must be {...}; got %q",
 								lfe.Label, t.AppendString(nil), eNew.AppendString(nil))
 						}
 						if len(wme.labelFilterss) > 0 {
@@ -846,11 +1204,17 @@ func expandWithExpr(was []*withArgExpr, e Expr) (Expr, error) {
 						}
 						lfssSrc := wme.LabelFilterss
 						if len(lfssSrc) > 1 {
-							return nil, fmt.Errorf("WITH template %q at %q must be {...} without 'or'; got %s",
+							return nil, newParseError(ParseErrorBadWithTemplate, lfe.Label, lfe.Pos, "WITH template %q at %q must be {...} without 'or'; got %s",
 								lfe.Label, t.AppendString(nil), wme.AppendString(nil))
 						}
 						if len(lfssSrc) == 1 {
+							n := len(lfsNew)
 							lfsNew = append(lfsNew, lfssSrc[0]...)
+							for i := n; i < len(lfsNew); i++ {
+								if !lfsNew[i].Pos.IsValid() {
+									lfsNew[i].Pos = lfe.Pos
+								}
+							}
 						}
 						continue
 					}
@@ -865,6 +1229,7 @@ func expandWithExpr(was []*withArgExpr, e Expr) (Expr, error) {
 					lfeNew.Value = se.(*StringExpr)
 					lfeNew.IsNegative = lfe.IsNegative
 					lfeNew.IsRegexp = lfe.IsRegexp
+					lfeNew.Pos = lfe.Pos
 					lf, err := lfeNew.toLabelFilter()
 					if err != nil {
 						return nil, err
@@ -874,6 +1239,7 @@ func expandWithExpr(was []*withArgExpr, e Expr) (Expr, error) {
 				lfsNew = removeDuplicateLabelFilters(lfsNew)
 				me.LabelFilterss = append(me.LabelFilterss, lfsNew)
 			}
+			me.Pos = t.Pos
 			t = &me
 		}
 		metricName := t.getMetricName()
@@ -884,7 +1250,7 @@ func expandWithExpr(was []*withArgExpr, e Expr) (Expr, error) {
 		if wa == nil {
 			return t, nil
 		}
-		eNew, err := expandWithExprExt(was, wa, nil)
+		eNew, err := expandWithExprExt(was, wa, nil, t.Pos)
 		if err != nil {
 			return nil, err
 		}
@@ -899,7 +1265,7 @@ func expandWithExpr(was []*withArgExpr, e Expr) (Expr, error) {
 			if t.isOnlyMetricName() {
 				return eNew, nil
 			}
-			return nil, fmt.Errorf("cannot expand %q to non-metric expression %q", t.AppendString(nil), eNew.AppendString(nil))
+			return nil, newParseError(ParseErrorBadWithTemplate, metricName, t.Pos, "cannot expand %q to non-metric expression %q", t.AppendString(nil), eNew.AppendString(nil))
 		}
 		if len(wme.labelFilterss) > 0 {
 			panic(fmt.Errorf("BUG: wme.labelFilterss must be empty after WITH templates expansion; got %s", wme.AppendString(nil)))
@@ -914,7 +1280,7 @@ func expandWithExpr(was []*withArgExpr, e Expr) (Expr, error) {
 			}
 			if len(t.LabelFilterss) != 1 {
 				// {filters} contain {... or ...}. It cannot be merged with {... or ...}
-				return nil, fmt.Errorf("%q mustn't contain 'or' filters; got %s", metricName, wme.AppendString(nil))
+				return nil, newParseError(ParseErrorBadWithTemplate, metricName, t.Pos, "%q mustn't contain 'or' filters; got %s", metricName, wme.AppendString(nil))
 			}
 			// {filters} doesn't contain `or`. Merge it with {... or ...} into {...,filters or ...,filters}
 			for _, lfs := range lfssSrc {
@@ -933,8 +1299,14 @@ func expandWithExpr(was []*withArgExpr, e Expr) (Expr, error) {
 				lfssNew = append(lfssNew, lfsNew)
 			}
 		}
+		pos := t.Pos
+		if t.isOnlyMetricName() && wme.Pos.IsValid() {
+			// The metric name has been substituted with the template body.
+			pos = wme.Pos
+		}
 		me := &MetricExpr{
 			LabelFilterss: lfssNew,
+			Pos:           pos,
 		}
 		if re == nil {
 			return me, nil
@@ -968,9 +1340,9 @@ func expandDuration(was []*withArgExpr, d *DurationExpr) (*DurationExpr, error)
 	}
 	wa := getWithArgExpr(was, d.s)
 	if wa == nil {
-		return nil, fmt.Errorf("cannot find WITH template for %q", d.s)
+		return nil, newParseError(ParseErrorBadWithTemplate, d.s, d.Pos, "cannot find WITH template for %q", d.s)
 	}
-	e, err := expandWithExprExt(was, wa, []Expr{})
+	e, err := expandWithExprExt(was, wa, []Expr{}, d.Pos)
 	if err != nil {
 		return nil, err
 	}
@@ -983,11 +1355,12 @@ func expandDuration(was []*withArgExpr, d *DurationExpr) (*DurationExpr, error)
 	case *NumberExpr:
 		// Convert number of seconds to DurationExpr
 		de := &DurationExpr{
-			s: t.s,
+			s:   t.s,
+			Pos: t.Pos,
 		}
 		return de, nil
 	default:
-		return nil, fmt.Errorf("unexpected value for WITH template %q; got %s; want duration", d.s, e.AppendString(nil))
+		return nil, newParseError(ParseErrorBadDuration, d.s, d.Pos, "unexpected value for WITH template %q; got %s; want duration", d.s, e.AppendString(nil))
 	}
 }
 
@@ -1011,7 +1384,7 @@ func expandModifierArgs(was []*withArgExpr, args []string) ([]string, error) {
 		me, ok := wa.Expr.(*MetricExpr)
 		if ok {
 			if !me.isOnlyMetricName() {
-				return nil, fmt.Errorf("cannot use %q instead of %q in %s", me.AppendString(nil), arg, args)
+				return nil, newParseError(ParseErrorBadWithTemplate, arg, wa.Pos, "cannot use %q instead of %q in %s", me.AppendString(nil), arg, args)
 			}
 			metricName := me.getMetricName()
 			dstArgs = append(dstArgs, metricName)
@@ -1019,17 +1392,22 @@ func expandModifierArgs(was []*withArgExpr, args []string) ([]string, error) {
 		}
 		pe, ok := wa.Expr.(*parensExpr)
 		if ok {
-			for _, pArg := range *pe {
+			for _, pArg := range pe.Args {
 				me, ok := pArg.(*MetricExpr)
 				if !ok || !me.isOnlyMetricName() {
-					return nil, fmt.Errorf("cannot use %q instead of %q in %s", pe.AppendString(nil), arg, args)
+					return nil, newParseError(ParseErrorBadWithTemplate, arg, wa.Pos, "cannot use %q instead of %q in %s", pe.AppendString(nil), arg, args)
 				}
 				metricName := me.getMetricName()
 				dstArgs = append(dstArgs, metricName)
 			}
 			continue
 		}
-		return nil, fmt.Errorf("cannot use %q instead of %q in %s", wa.Expr.AppendString(nil), arg, args)
+		// Expand the template before printing it, since it may contain unexpanded parts such as string concatenations.
+		e, err := expandWithExprExt(was, wa, nil, wa.Pos)
+		if err != nil {
+			return nil, err
+		}
+		return nil, newParseError(ParseErrorBadWithTemplate, arg, wa.Pos, "cannot use %q instead of %q in %s", e.AppendString(nil), arg, args)
 	}
 
 	// Remove duplicate args from dstArgs
@@ -1044,15 +1422,22 @@ func expandModifierArgs(was []*withArgExpr, args []string) ([]string, error) {
 	return filteredArgs, nil
 }
 
-func expandWithExprExt(was []*withArgExpr, wa *withArgExpr, args []Expr) (Expr, error) {
+// expandWithExprExt expands wa with the given args.
+//
+// pos must contain the call-site position for wa. It is used for nodes without position,
+// which are returned from built-in templates.
+func expandWithExprExt(was []*withArgExpr, wa *withArgExpr, args []Expr, pos Pos) (Expr, error) {
 	if len(wa.Args) != len(args) {
 		if args == nil {
 			// This case is possible if metric name clashes with one of the WITH template name.
 			//
 			// In this case just return MetricExpr with the wa.Name name.
-			return newMetricExpr(wa.Name), nil
+			me := newMetricExpr(wa.Name)
+			me.Pos = pos
+			me.LabelFilterss[0][0].Pos = pos
+			return me, nil
 		}
-		return nil, fmt.Errorf("invalid number of args for %q; got %d; want %d", wa.Name, len(args), len(wa.Args))
+		return nil, newParseError(ParseErrorBadWithTemplate, wa.Name, pos, "invalid number of args for %q; got %d; want %d", wa.Name, len(args), len(wa.Args))
 	}
 	wasNew := make([]*withArgExpr, 0, len(was)+len(args))
 	for _, waTmp := range was {
@@ -1067,7 +1452,11 @@ func expandWithExprExt(was []*withArgExpr, wa *withArgExpr, args []Expr) (Expr,
 			Expr: arg,
 		})
 	}
-	return expandWithExpr(wasNew, wa.Expr)
+	e, err := expandWithExpr(wasNew, wa.Expr)
+	if err != nil {
+		return nil, err
+	}
+	return withExprPos(e, pos), nil
 }
 
 func newMetricExpr(name string) *MetricExpr {
@@ -1123,16 +1512,17 @@ func removeDuplicateLabelFilters(lfs []LabelFilter) []LabelFilter {
 
 func (p *parser) parseFuncExpr() (*FuncExpr, error) {
 	if !isIdentPrefix(p.lex.Token) {
-		return nil, fmt.Errorf(`FuncExpr: unexpected token %q; want "ident"`, p.lex.Token)
+		return nil, p.unexpectedTokenError("FuncExpr", "ident")
 	}
 
 	var fe FuncExpr
+	start := p.lex.offset
 	fe.Name = unescapeIdent(p.lex.Token)
 	if err := p.lex.Next(); err != nil {
 		return nil, err
 	}
 	if p.lex.Token != "(" {
-		return nil, fmt.Errorf(`FuncExpr; unexpected token %q; want "("`, p.lex.Token)
+		return nil, p.unexpectedTokenError("FuncExpr", "(")
 	}
 	args, err := p.parseArgListExpr()
 	if err != nil {
@@ -1145,6 +1535,7 @@ func (p *parser) parseFuncExpr() (*FuncExpr, error) {
 			return nil, err
 		}
 	}
+	fe.Pos = p.pos(start)
 	return &fe, nil
 }
 
@@ -1155,16 +1546,18 @@ func isKeepMetricNames(token string) bool {
 
 func (p *parser) parseModifierExpr(me *ModifierExpr, allowStar bool) error {
 	if !isIdentPrefix(p.lex.Token) {
-		return fmt.Errorf(`ModifierExpr: unexpected token %q; want "ident"`, p.lex.Token)
+		return p.unexpectedTokenError("ModifierExpr", "ident")
 	}
 
 	me.Op = strings.ToLower(p.lex.Token)
+	start := p.lex.offset
 
 	if err := p.lex.Next(); err != nil {
 		return err
 	}
 	if isBinaryOpJoinModifier(me.Op) && p.lex.Token != "(" {
 		// join modifier may miss ident list.
+		me.Pos = p.pos(start)
 		return nil
 	}
 	args, err := p.parseIdentList(allowStar)
@@ -1172,12 +1565,13 @@ func (p *parser) parseModifierExpr(me *ModifierExpr, allowStar bool) error {
 		return fmt.Errorf("ModifierExpr: %w", err)
 	}
 	me.Args = args
+	me.Pos = p.pos(start)
 	return nil
 }
 
 func (p *parser) parseIdentList(allowStar bool) ([]string, error) {
 	if p.lex.Token != "(" {
-		return nil, fmt.Errorf(`identList: unexpected token %q; want "("`, p.lex.Token)
+		return nil, p.unexpectedTokenError("identList", "(")
 	}
 	if err := p.lex.Next(); err != nil {
 		return nil, err
@@ -1187,7 +1581,7 @@ func (p *parser) parseIdentList(allowStar bool) ([]string, error) {
 			return nil, err
 		}
 		if p.lex.Token != ")" {
-			return nil, fmt.Errorf(`identList: unexpected token %q after "*"; want ")"`, p.lex.Token)
+			return nil, p.unexpectedTokenError("identList", ")")
 		}
 		if err := p.lex.Next(); err != nil {
 			return nil, err
@@ -1203,7 +1597,7 @@ func (p *parser) parseIdentList(allowStar bool) ([]string, error) {
 			return idents, nil
 		}
 		if !isIdentPrefix(p.lex.Token) {
-			return nil, fmt.Errorf(`identList: unexpected token %q; want "ident"`, p.lex.Token)
+			return nil, p.unexpectedTokenError("identList", "ident")
 		}
 		idents = append(idents, unescapeIdent(p.lex.Token))
 		if err := p.lex.Next(); err != nil {
@@ -1217,14 +1611,14 @@ func (p *parser) parseIdentList(allowStar bool) ([]string, error) {
 		case ")":
 			continue
 		default:
-			return nil, fmt.Errorf(`identList: unexpected token %q; want ",", ")"`, p.lex.Token)
+			return nil, p.unexpectedTokenError("identList", ",", ")")
 		}
 	}
 }
 
 func (p *parser) parseArgListExpr() ([]Expr, error) {
 	if p.lex.Token != "(" {
-		return nil, fmt.Errorf(`argList: unexpected token %q; want "("`, p.lex.Token)
+		return nil, p.unexpectedTokenError("argList", "(")
 	}
 	var args []Expr
 	for {
@@ -1239,13 +1633,19 @@ func (p *parser) parseArgListExpr() ([]Expr, error) {
 			return nil, err
 		}
 		args = append(args, expr)
+		if p.lex.Token != "," && p.lex.Token != ")" {
+			if err := p.recoverToken(p.unexpectedTokenError("argList", ",", ")"), isListDelimiter); err != nil {
+				return nil, err
+			}
+		}
 		switch p.lex.Token {
 		case ",":
 			continue
 		case ")":
 			goto closeParensLabel
 		default:
-			return nil, fmt.Errorf(`argList: unexpected token %q; want ",", ")"`, p.lex.Token)
+			// Unclosed arg list in tolerant mode.
+			return args, nil
 		}
 	}
 
@@ -1270,7 +1670,7 @@ func getWithArgExpr(was []*withArgExpr, name string) *withArgExpr {
 
 func (p *parser) parseLabelFilterss(mf *labelFilterExpr) ([][]*labelFilterExpr, error) {
 	if p.lex.Token != "{" {
-		return nil, fmt.Errorf(`labelFilters: unexpected token %q; want "{"`, p.lex.Token)
+		return nil, p.unexpectedTokenError("labelFilters", "{")
 	}
 	if err := p.lex.Next(); err != nil {
 		return nil, err
@@ -1302,6 +1702,9 @@ func (p *parser) parseLabelFilterss(mf *labelFilterExpr) ([][]*labelFilterExpr,
 			if err := p.lex.Next(); err != nil {
 				return nil, err
 			}
+		default:
+			// Unclosed label filters in tolerant mode.
+			return lfess, nil
 		}
 	}
 }
@@ -1314,9 +1717,17 @@ func (p *parser) parseLabelFilters(mf *labelFilterExpr) ([]*labelFilterExpr, err
 	for {
 		lfe, err := p.parseLabelFilterExpr()
 		if err != nil {
-			return nil, err
+			if err := p.recoverToken(err, isLabelFiltersDelimiter); err != nil {
+				return nil, err
+			}
+		} else {
+			lfes = append(lfes, lfe)
+		}
+		if !isLabelFiltersDelimiter(p.lex.Token) && p.lex.Token != "}" {
+			if err := p.recoverToken(p.unexpectedTokenError("labelFilters", ",", "or", "}"), isLabelFiltersDelimiter); err != nil {
+				return nil, err
+			}
 		}
-		lfes = append(lfes, lfe)
 		switch strings.ToLower(p.lex.Token) {
 		case ",":
 			if err := p.lex.Next(); err != nil {
@@ -1326,19 +1737,19 @@ func (p *parser) parseLabelFilters(mf *labelFilterExpr) ([]*labelFilterExpr, err
 				return lfes, nil
 			}
 			continue
-		case "or", "}":
-			return lfes, nil
 		default:
-			return nil, fmt.Errorf(`labelFilters: unexpected token %q; want ",", "or", "}"`, p.lex.Token)
+			// "or", "}" or unclosed label filters in tolerant mode.
+			return lfes, nil
 		}
 	}
 }
 
 func (p *parser) parseLabelFilterExpr() (*labelFilterExpr, error) {
 	if !isIdentPrefix(p.lex.Token) {
-		return nil, fmt.Errorf(`labelFilterExpr: unexpected token %q; want "ident"`, p.lex.Token)
+		return nil, p.unexpectedTokenError("labelFilterExpr", "ident")
 	}
 	var lfe labelFilterExpr
+	start := p.lex.offset
 	lfe.Label = unescapeIdent(p.lex.Token)
 	if err := p.lex.Next(); err != nil {
 		return nil, err
@@ -1355,9 +1766,10 @@ func (p *parser) parseLabelFilterExpr() (*labelFilterExpr, error) {
 		lfe.IsNegative = true
 		lfe.IsRegexp = true
 	case ",", "}", "or":
+		lfe.Pos = p.pos(start)
 		return &lfe, nil
 	default:
-		return nil, fmt.Errorf(`labelFilterExpr: unexpected token %q; want "=", "!=", "=~", "!~", ",", "or", "}"`, p.lex.Token)
+		return nil, p.unexpectedTokenError("labelFilterExpr", "=", "!=", "=~", "!~", ",", "or", "}")
 	}
 
 	if err := p.lex.Next(); err != nil {
@@ -1368,6 +1780,7 @@ func (p *parser) parseLabelFilterExpr() (*labelFilterExpr, error) {
 		return nil, err
 	}
 	lfe.Value = se
+	lfe.Pos = p.pos(start)
 	return &lfe, nil
 }
 
@@ -1379,6 +1792,7 @@ type labelFilterExpr struct {
 	Value      *StringExpr
 	IsRegexp   bool
 	IsNegative bool
+	Pos        Pos
 }
 
 func (lfe *labelFilterExpr) AppendString(dst []byte) []byte {
@@ -1411,20 +1825,21 @@ func (lfe *labelFilterExpr) toLabelFilter() (*LabelFilter, error) {
 	lf.Value = lfe.Value.S
 	lf.IsRegexp = lfe.IsRegexp
 	lf.IsNegative = lfe.IsNegative
+	lf.Pos = lfe.Pos
 	if !lf.IsRegexp {
 		return &lf, nil
 	}
 
 	// Verify regexp.
 	if _, err := CompileRegexpAnchored(lfe.Value.S); err != nil {
-		return nil, fmt.Errorf("invalid regexp in %s=%q: %s", lf.Label, lf.Value, err)
+		return nil, newParseError(ParseErrorBadRegexp, lf.Value, lfe.Value.Pos, "invalid regexp in %s=%q: %w", lf.Label, lf.Value, err)
 	}
 	return &lf, nil
 }
 
 func (p *parser) parseWindowAndStep() (*DurationExpr, *DurationExpr, bool, error) {
 	if p.lex.Token != "[" {
-		return nil, nil, false, fmt.Errorf(`windowAndStep: unexpected token %q; want "["`, p.lex.Token)
+		return nil, nil, false, p.unexpectedTokenError("windowAndStep", "[")
 	}
 	err := p.lex.Next()
 	if err != nil {
@@ -1442,6 +1857,7 @@ func (p *parser) parseWindowAndStep() (*DurationExpr, *DurationExpr, bool, error
 	if strings.HasPrefix(p.lex.Token, ":") {
 		// Parse step
 		p.lex.Token = p.lex.Token[1:]
+		p.lex.offset++
 		if p.lex.Token == "" {
 			if err := p.lex.Next(); err != nil {
 				return nil, nil, false, err
@@ -1458,7 +1874,7 @@ func (p *parser) parseWindowAndStep() (*DurationExpr, *DurationExpr, bool, error
 		}
 	}
 	if p.lex.Token != "]" {
-		return nil, nil, false, fmt.Errorf(`windowAndStep: unexpected token %q; want "]"`, p.lex.Token)
+		return nil, nil, false, p.unexpectedTokenError("windowAndStep", "]")
 	}
 	if err := p.lex.Next(); err != nil {
 		return nil, nil, false, err
@@ -1469,7 +1885,7 @@ func (p *parser) parseWindowAndStep() (*DurationExpr, *DurationExpr, bool, error
 
 func (p *parser) parseAtExpr() (Expr, error) {
 	if p.lex.Token != "@" {
-		return nil, fmt.Errorf(`unexpected token %q; want "@"`, p.lex.Token)
+		return nil, p.unexpectedTokenError("atExpr", "@")
 	}
 	if err := p.lex.Next(); err != nil {
 		return nil, err
@@ -1483,7 +1899,7 @@ func (p *parser) parseAtExpr() (Expr, error) {
 
 func (p *parser) parseOffset() (*DurationExpr, error) {
 	if !isOffset(p.lex.Token) {
-		return nil, fmt.Errorf(`offset: unexpected token %q; want "offset"`, p.lex.Token)
+		return nil, p.unexpectedTokenError("offset", "offset")
 	}
 	if err := p.lex.Next(); err != nil {
 		return nil, err
@@ -1496,6 +1912,7 @@ func (p *parser) parseOffset() (*DurationExpr, error) {
 }
 
 func (p *parser) parseDuration() (*DurationExpr, error) {
+	start := p.lex.offset
 	isNegative := p.lex.Token == "-"
 	if isNegative {
 		if err := p.lex.Next(); err != nil {
@@ -1508,12 +1925,14 @@ func (p *parser) parseDuration() (*DurationExpr, error) {
 	}
 	if isNegative {
 		de.s = "-" + de.s
+		de.Pos = p.pos(start)
 	}
 	return de, nil
 }
 
 func (p *parser) parsePositiveDuration() (*DurationExpr, error) {
 	s := p.lex.Token
+	start := p.lex.offset
 	if isIdentPrefix(s) {
 		n := strings.IndexByte(s, ':')
 		if n >= 0 {
@@ -1526,6 +1945,7 @@ func (p *parser) parsePositiveDuration() (*DurationExpr, error) {
 		de := &DurationExpr{
 			s:            s,
 			needsParsing: true,
+			Pos:          p.pos(start),
 		}
 		return de, nil
 	}
@@ -1535,19 +1955,20 @@ func (p *parser) parsePositiveDuration() (*DurationExpr, error) {
 		}
 	} else {
 		if !isPositiveNumberPrefix(s) {
-			return nil, fmt.Errorf(`duration: unexpected token %q; want valid duration`, s)
+			return nil, p.unexpectedTokenError("duration", "duration")
 		}
 		// Verify the duration in seconds without explicit suffix.
 		if _, err := p.parsePositiveNumberExpr(); err != nil {
-			return nil, fmt.Errorf(`duration: parse error: %s`, err)
+			return nil, newParseError(ParseErrorBadDuration, s, p.lex.Pos(start, start+len(s)), `duration: parse error: %w`, err)
 		}
 	}
 	// Verify duration value.
 	if _, err := DurationValue(s, 0); err != nil {
-		return nil, fmt.Errorf(`duration: parse value error: %q: %w`, s, err)
+		return nil, newParseError(ParseErrorBadDuration, s, p.lex.Pos(start, start+len(s)), `duration: parse value error: %q: %w`, s, err)
 	}
 	de := &DurationExpr{
-		s: s,
+		s:   s,
+		Pos: p.pos(start),
 	}
 	return de, nil
 }
@@ -1558,6 +1979,9 @@ type DurationExpr struct {
 
 	// needsParsing is set to true if s isn't parsed yet with expandWithExpr()
 	needsParsing bool
+
+	// Pos is the position of the duration in the original query.
+	Pos Pos
 }
 
 // AppendString appends string representation of de to dst and returns the result.
@@ -1630,13 +2054,14 @@ func (p *parser) parseIdentExpr() (Expr, error) {
 		p.lex.Prev()
 		return p.parseMetricExpr()
 	default:
-		return nil, fmt.Errorf(`identExpr: unexpected token %q; want "(", "{", "[", ")", "," or "@"`, p.lex.Token)
+		return nil, p.unexpectedTokenError("identExpr", "(", "{", "[", ")", ",", "@")
 	}
 }
 
 func (p *parser) parseMetricExpr() (*MetricExpr, error) {
 	var mf *labelFilterExpr
 	var me MetricExpr
+	start := p.lex.offset
 	if isIdentPrefix(p.lex.Token) {
 		mf = &labelFilterExpr{
 			Label: "__name__",
@@ -1647,8 +2072,11 @@ func (p *parser) parseMetricExpr() (*MetricExpr, error) {
 		if err := p.lex.Next(); err != nil {
 			return nil, err
 		}
+		mf.Pos = p.pos(start)
+		mf.Value.Pos = mf.Pos
 		if p.lex.Token != "{" {
 			me.labelFilterss = append(me.labelFilterss[:0], []*labelFilterExpr{mf})
+			me.Pos = p.pos(start)
 			return &me, nil
 		}
 	}
@@ -1657,12 +2085,17 @@ func (p *parser) parseMetricExpr() (*MetricExpr, error) {
 		return nil, err
 	}
 	me.labelFilterss = append(me.labelFilterss, lfess...)
+	me.Pos = p.pos(start)
 	return &me, nil
 }
 
 func (p *parser) parseRollupExpr(arg Expr) (Expr, error) {
 	var re RollupExpr
 	re.Expr = arg
+	start := p.lex.offset
+	if pos := ExprPos(arg); pos.IsValid() {
+		start = pos.Offset
+	}
 	if p.lex.Token == "[" {
 		window, step, inheritStep, err := p.parseWindowAndStep()
 		if err != nil {
@@ -1672,6 +2105,7 @@ func (p *parser) parseRollupExpr(arg Expr) (Expr, error) {
 		re.Step = step
 		re.InheritStep = inheritStep
 		if !isOffset(p.lex.Token) && p.lex.Token != "@" {
+			re.Pos = p.pos(start)
 			return &re, nil
 		}
 	}
@@ -1691,7 +2125,7 @@ func (p *parser) parseRollupExpr(arg Expr) (Expr, error) {
 	}
 	if p.lex.Token == "@" {
 		if re.At != nil {
-			return nil, fmt.Errorf("duplicate `@` token")
+			return nil, p.errorf(ParseErrorUnexpectedToken, "duplicate `@` token")
 		}
 		at, err := p.parseAtExpr()
 		if err != nil {
@@ -1699,6 +2133,7 @@ func (p *parser) parseRollupExpr(arg Expr) (Expr, error) {
 		}
 		re.At = at
 	}
+	re.Pos = p.pos(start)
 	return &re, nil
 }
 
@@ -1710,6 +2145,9 @@ type StringExpr struct {
 	// Composite string has non-empty tokens.
 	// They must be converted into S by expandWithExpr.
 	tokens []string
+
+	// Pos is the position of the string in the original query.
+	Pos Pos
 }
 
 // AppendString appends string representation of se to dst and returns the result.
@@ -1727,6 +2165,9 @@ type NumberExpr struct {
 
 	// s contains the original string representation for N.
 	s string
+
+	// Pos is the position of the number in the original query.
+	Pos Pos
 }
 
 // AppendString appends string representation of ne to dst and returns the result.
@@ -1740,11 +2181,14 @@ func (ne *NumberExpr) AppendString(dst []byte) []byte {
 // parensExpr represents `(...)`.
 //
 // It isn't exported.
-type parensExpr []Expr
+type parensExpr struct {
+	Args []Expr
+	Pos  Pos
+}
 
 // AppendString appends string representation of pe to dst and returns the result.
-func (pe parensExpr) AppendString(dst []byte) []byte {
-	return appendStringArgListExpr(dst, pe)
+func (pe *parensExpr) AppendString(dst []byte) []byte {
+	return appendStringArgListExpr(dst, pe.Args)
 }
 
 // BinaryOpExpr represents binary operation.
@@ -1775,6 +2219,9 @@ type BinaryOpExpr struct {
 
 	// Right contains right arg for the `left op right` epxression.
 	Right Expr
+
+	// Pos is the position of the binary operation in the original query.
+	Pos Pos
 }
 
 // AppendString appends string representation of be to dst and returns the result.
@@ -1884,6 +2331,9 @@ type ModifierExpr struct {
 
 	// Args contains modifier args from parens.
 	Args []string
+
+	// Pos is the position of the modifier in the original query.
+	Pos Pos
 }
 
 // AppendString appends string representation of me to dst and returns the result.
@@ -1926,6 +2376,9 @@ type FuncExpr struct {
 
 	// If KeepMetricNames is set to true, then the function should keep metric names.
 	KeepMetricNames bool
+
+	// Pos is the position of the function call in the original query.
+	Pos Pos
 }
 
 // AppendString appends string representation of fe to dst and returns the result.
@@ -1958,6 +2411,9 @@ type AggrFuncExpr struct {
 	//
 	// Example: `sum(...) by (...) limit 10` would return maximum 10 time series.
 	Limit int
+
+	// Pos is the position of the aggregate function call in the original query.
+	Pos Pos
 }
 
 // AppendString appends string representation of ae to dst and returns the result.
@@ -1985,6 +2441,7 @@ func (ae *AggrFuncExpr) appendModifiers(dst []byte) []byte {
 type withExpr struct {
 	Was  []*withArgExpr
 	Expr Expr
+	Pos  Pos
 }
 
 // AppendString appends string representation of we to dst and returns the result.
@@ -2008,6 +2465,7 @@ type withArgExpr struct {
 	Name string
 	Args []string
 	Expr Expr
+	Pos  Pos
 }
 
 // AppendString appends string representation of wa to dst and returns the result.
@@ -2058,6 +2516,9 @@ type RollupExpr struct {
 	// For example, `foo @ end()` or `bar[5m] @ 12345`
 	// See https://prometheus.io/docs/prometheus/latest/querying/basics/#modifier
 	At Expr
+
+	// Pos is the position of the rollup expression in the original query.
+	Pos Pos
 }
 
 // ForSubquery returns true if re represents subquery.
@@ -2132,6 +2593,13 @@ type LabelFilter struct {
 
 	// IsRegexp represents whether the filter is regesp, i.e. `=~` or `!~`.
 	IsRegexp bool
+
+	// Pos is the position of the filter in the original query.
+	//
+	// Pos is zero for filters without the position in the query, such as filters added by Optimize.
+	// Note that filters at distinct positions aren't equal when compared with `==` or reflect.DeepEqual,
+	// so compare Label, Value, IsNegative and IsRegexp fields if the position doesn't matter.
+	Pos Pos
 }
 
 // AppendString appends string representation of me to dst and returns the result.
@@ -2179,6 +2647,9 @@ type MetricExpr struct {
 	//
 	// labelFilters must be expanded to LabelFilters by expandWithExpr.
 	labelFilterss [][]*labelFilterExpr
+
+	// Pos is the position of the metric selector in the original query.
+	Pos Pos
 }
 
 func appendLabelFilterss(dst []byte, lfss [][]*labelFilterExpr) []byte {
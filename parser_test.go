@@ -1,6 +1,7 @@
 package metricsql
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -573,6 +574,67 @@ func TestParseSuccess(t *testing.T) {
 	another(`with (sum(a,b)=a+b) sum(c,d)`, `c + d`)
 }
 
+func TestParseSimplifyConstantFuncs(t *testing.T) {
+	f := func(s, resultExpected string) {
+		t.Helper()
+
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %s: %s", s, err)
+		}
+		result := e.AppendString(nil)
+		if string(result) != resultExpected {
+			t.Fatalf("unexpected result for %s;\ngot\n%s\nwant\n%s", s, result, resultExpected)
+		}
+	}
+
+	// pure functions over literal args
+	f(`abs(-5)`, `5`)
+	f(`ABS(-5)`, `5`)
+	f(`pi() * 2`, `6.283185307179586`)
+	f(`sqrt(16)`, `4`)
+	f(`sqrt(-1)`, `NaN`)
+	f(`clamp_max(10, 3)`, `3`)
+	f(`clamp_min(10, 30)`, `30`)
+	f(`clamp(10, 3, 5)`, `5`)
+	f(`clamp(10, 5, 3)`, `NaN`)
+	f(`round(1.234, 0.1)`, `1.2`)
+	f(`round(1.5)`, `2`)
+	f(`round(1234, 100)`, `1200`)
+	f(`deg(rad(90))`, `90`)
+	f(`ceil(1.2) + floor(1.8)`, `3`)
+	f(`sgn(-3) + exp(0) + ln(1) + log2(8) + log10(100)`, `5`)
+	f(`bitmap_and(7, 2) + bitmap_or(1, 2) + bitmap_xor(3, 1)`, `7`)
+	f(`scalar(42)`, `42`)
+	f(`year(0)`, `1970`)
+	f(`day_of_week(0)`, `4`)
+	f(`days_in_month(951782400)`, `29`)
+	f(`hour(7200) + minute(120)`, `4`)
+	f(`abs(-(1 + 2))`, `3`)
+	f(`with (x = 2) sqrt(x * 8)`, `4`)
+
+	// non-literal args
+	f(`abs(foo)`, `abs(foo)`)
+	f(`clamp_max(foo, 3)`, `clamp_max(foo, 3)`)
+	f(`clamp_max(10, abs(foo))`, `clamp_max(10, abs(foo))`)
+	f(`round(foo, abs(-0.1))`, `round(foo, 0.1)`)
+
+	// time-dependent functions
+	f(`time()`, `time()`)
+	f(`now()`, `now()`)
+	f(`rand()`, `rand()`)
+	f(`rand(1)`, `rand(1)`)
+	f(`step()`, `step()`)
+	f(`start() + end()`, `start() + end()`)
+	f(`hour()`, `hour()`)
+	f(`abs(time())`, `abs(time())`)
+
+	// functions, which aren't pure transform functions
+	f(`vector(1)`, `vector(1)`)
+	f(`absent(1)`, `absent(1)`)
+	f(`sum(1)`, `sum(1)`)
+}
+
 func TestParseError(t *testing.T) {
 	f := func(s string) {
 		t.Helper()
@@ -908,6 +970,8 @@ func TestParseError(t *testing.T) {
 	f(`with (f(x) = sum(m) by (x)) f((xx(), {foo="bar"}))`)
 	f(`with (f(x) = m + on (x) n) f(xx())`)
 	f(`with (f(x) = m + on (a) group_right (x) n) f(xx())`)
+	f(`with (x="a") sum(foo) by (x)`)
+	f(`with (x="a"+"b") foo + on(x) bar`)
 	f(`with (f(x) = m keep_metric_names)`)
 	f(`with (now)`)
 	f(`with (sum)`)
@@ -934,3 +998,67 @@ func TestParseError(t *testing.T) {
 	f(`with (x={a="b" or c="d"}) {x,d="e"}`)
 	f(`with (x={a="b" or c="d"}) {x,d="e" or z="c"}`)
 }
+
+func TestParsePos(t *testing.T) {
+	f := func(s string, partsExpected []string) {
+		t.Helper()
+
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %q: %s", s, err)
+		}
+		var parts []string
+		VisitAll(e, func(expr Expr) {
+			pos := ExprPos(expr)
+			if !pos.IsValid() {
+				return
+			}
+			parts = append(parts, s[pos.Offset:pos.End])
+		})
+		if !reflect.DeepEqual(parts, partsExpected) {
+			t.Fatalf("unexpected parts for %q;\ngot\n%q\nwant\n%q", s, parts, partsExpected)
+		}
+	}
+
+	f(`foo`, []string{`foo`})
+	f(`  foo{bar="baz"}  `, []string{`foo{bar="baz"}`})
+	f(`rate(foo[5m] offset 1h)`, []string{`foo`, `foo[5m] offset 1h`, `rate(foo[5m] offset 1h)`})
+	f(`sum(x) by (y) limit 3`, []string{`x`, `by (y)`, `sum(x) by (y) limit 3`})
+	f(`a + b * c`, []string{`a`, `b`, `c`, `b * c`, `a + b * c`})
+	f(`a * b + c`, []string{`a`, `b`, `a * b`, `c`, `a * b + c`})
+	f(`(a + b) / on(x) c`, []string{`a`, `b`, `a + b`, `c`, `on(x)`, `(a + b) / on(x) c`})
+	f(`-x`, []string{`-`, `x`, `-x`})
+	f(`(a, "b")`, []string{`a`, `"b"`, `(a, "b")`})
+	f(`x[1h:5m] @ 123`, []string{`x`, `x[1h:5m] @ 123`})
+
+	// WITH templates point to the template body, while args point to the call site.
+	f(`with (f(x) = x*2) f(foo)`, []string{`foo`, `2`, `x*2`})
+	f(`with (x = {a="b"}) x`, []string{`{a="b"}`})
+
+	// Built-in templates point to the call site.
+	f(`ru(a, b)`, []string{`b`, `a`, `b`, `ru(a, b)`})
+}
+
+func TestParsePosLineColumn(t *testing.T) {
+	s := "sum(\n  rate(foo[5m])\n)"
+	e, err := Parse(s)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ae := e.(*AggrFuncExpr)
+	if pos := ae.Pos; pos.Line != 1 || pos.Column != 1 || pos.Offset != 0 || pos.End != len(s) {
+		t.Fatalf("unexpected position for %s: %+v", ae.AppendString(nil), pos)
+	}
+	fe := ae.Args[0].(*FuncExpr)
+	if pos := fe.Pos; pos.Line != 2 || pos.Column != 3 || pos.String() != "2:3" {
+		t.Fatalf("unexpected position for %s: %+v", fe.AppendString(nil), pos)
+	}
+	re := fe.Args[0].(*RollupExpr)
+	if pos := re.Window.Pos; pos.Line != 2 || pos.Column != 12 {
+		t.Fatalf("unexpected window position: %+v", pos)
+	}
+	lf := re.Expr.(*MetricExpr).LabelFilterss[0][0]
+	if pos := lf.Pos; pos.Line != 2 || pos.Column != 8 {
+		t.Fatalf("unexpected label filter position: %+v", pos)
+	}
+}
@@ -1,5 +1,9 @@
 package metricsql
 
+import (
+	"strings"
+)
+
 // Prettify returns prettified representation of MetricsQL query q.
 func Prettify(q string) (string, error) {
 	e, err := Parse(q)
@@ -10,6 +14,59 @@ func Prettify(q string) (string, error) {
 	return string(b), nil
 }
 
+// Format returns prettified representation of MetricsQL query q with the same meaning as q.
+//
+// Unlike Prettify, Format doesn't expand the built-in WITH templates such as `ru` and `ttf`
+// and doesn't simplify constant expressions such as `365*24*3600`.
+// Explicit WITH expressions are still expanded and comments are dropped, so queries with them mustn't be formatted.
+func Format(q string) (string, error) {
+	e, err := parseWithOptionsNoSimplify(q, &ParseOptions{
+		DisableDefaultTemplates: true,
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, err := range getUnsupportedFunctionErrors(e) {
+		if !isDefaultWithTemplateName(err.Token) {
+			return "", err
+		}
+	}
+	e = foldNegativeNumbers(e)
+	b := appendPrettifiedExpr(nil, e, 0, false)
+	return string(b), nil
+}
+
+// foldNegativeNumbers converts `0 - N` expressions obtained from unary minus before number literals back to negative numbers,
+// so `-1` isn't formatted as `0 - 1`.
+func foldNegativeNumbers(e Expr) Expr {
+	return Apply(e, nil, func(c *Cursor) bool {
+		be, ok := c.Node().(*BinaryOpExpr)
+		if !ok || be.Op != "-" {
+			return true
+		}
+		lne, ok := be.Left.(*NumberExpr)
+		if !ok || lne.N != 0 || lne.s != "" {
+			// The left operand isn't the implicit zero for unary minus.
+			return true
+		}
+		rne, ok := be.Right.(*NumberExpr)
+		if !ok || rne.s == "" || strings.HasPrefix(rne.s, "-") {
+			return true
+		}
+		c.Replace(&NumberExpr{
+			N:   -rne.N,
+			s:   "-" + rne.s,
+			Pos: be.Pos,
+		})
+		return true
+	})
+}
+
+// isDefaultWithTemplateName returns true if name is the name of the built-in WITH template.
+func isDefaultWithTemplateName(name string) bool {
+	return getWithArgExpr(getDefaultWithArgExprs(), name) != nil
+}
+
 // maxPrettifiedLineLen is the maximum length of a single line returned by Prettify().
 //
 // Actual lines may exceed the maximum length in some cases.
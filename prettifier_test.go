@@ -149,3 +149,61 @@ func TestPrettifySuccess(t *testing.T) {
 	// Verify how prettifier works with very long string
 	same(`"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"`)
 }
+
+func TestFormat(t *testing.T) {
+	f := func(s, resultExpected string) {
+		t.Helper()
+
+		result, err := Format(s)
+		if err != nil {
+			t.Fatalf("unexpected error when formatting %q: %s", s, err)
+		}
+		if result != resultExpected {
+			t.Fatalf("unexpected query after formatting;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+
+		// The formatted query must have the same meaning as the original query.
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %q: %s", s, err)
+		}
+		eResult, err := Parse(result)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing formatted result %q: %s", result, err)
+		}
+		if q, qResult := string(e.AppendString(nil)), string(eResult.AppendString(nil)); q != qResult {
+			t.Fatalf("formatting changed the query;\ngot\n%s\nwant\n%s", qResult, q)
+		}
+	}
+
+	f(`sum(rate(foo[5m]))by(job)`, `sum(rate(foo[5m])) by(job)`)
+
+	// Built-in templates aren't expanded.
+	f(`ru(free, max)`, `ru(free, max)`)
+	f(`ttf(foo)`, `ttf(foo)`)
+	f(`alias(range_median(foo), "bar")`, `alias(range_median(foo), "bar")`)
+
+	// Constant expressions aren't simplified.
+	f(`foo > 365*24*3600`, `foo > ((365 * 24) * 3600)`)
+	f(`abs(-5) + -0x10`, `abs(-5) + -0x10`)
+	f(`foo @ (1+2)`, `foo @ (1 + 2)`)
+	f(`-foo`, `0 - foo`)
+}
+
+func TestFormatError(t *testing.T) {
+	f := func(s string) {
+		t.Helper()
+
+		result, err := Format(s)
+		if err == nil {
+			t.Fatalf("expecting non-nil error when formatting %q", s)
+		}
+		if result != "" {
+			t.Fatalf("expecting empty result; got %q", result)
+		}
+	}
+
+	f(`foo{`)
+	f(`unknown_func(foo)`)
+	f(`ru(`)
+}
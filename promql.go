@@ -0,0 +1,149 @@
+package metricsql
+
+import (
+	"sort"
+	"strings"
+)
+
+// checkPromQL returns an error for the first MetricsQL extension in e, which isn't supported by PromQL.
+//
+// e must be already expanded with expandWithExpr. WITH expressions are rejected by the parser itself.
+func checkPromQL(e Expr) error {
+	errs := getPromQLErrors(e)
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Pos.Offset < errs[j].Pos.Offset
+	})
+	return errs[0]
+}
+
+func getPromQLErrors(e Expr) []*ParseError {
+	var errs []*ParseError
+	addError := func(token string, pos Pos, extension string) {
+		errs = append(errs, newParseError(ParseErrorNotPromQL, token, pos, "%s isn't supported by PromQL", extension))
+	}
+	checkDuration := func(de *DurationExpr) {
+		if de == nil {
+			return
+		}
+		if strings.IndexByte(strings.ToLower(de.s), 'i') >= 0 {
+			addError(de.s, de.Pos, "step-based duration "+de.s)
+			return
+		}
+		if strings.IndexByte(de.s, '.') >= 0 {
+			addError(de.s, de.Pos, "fractional duration "+de.s)
+		}
+	}
+	VisitAll(e, func(expr Expr) {
+		switch t := expr.(type) {
+		case *MetricExpr:
+			if len(t.LabelFilterss) > 1 {
+				addError("or", t.Pos, "`or` delimiter in label filters")
+			}
+		case *NumberExpr:
+			if hasNumberSuffix(t.s) {
+				addError(t.s, t.Pos, "numeric suffix in "+t.s)
+			}
+		case *RollupExpr:
+			checkDuration(t.Window)
+			checkDuration(t.Offset)
+			checkDuration(t.Step)
+			switch at := t.At.(type) {
+			case nil:
+			case *NumberExpr:
+				errs = append(errs, getPromQLErrors(at)...)
+			case *BinaryOpExpr:
+				// PromQL allows signed numbers after `@`.
+				if ne, ok := getUnaryMinusNumber(at); ok {
+					errs = append(errs, getPromQLErrors(ne)...)
+				} else {
+					addError("@", ExprPos(at), "expression `"+string(at.AppendString(nil))+"` after `@` modifier")
+				}
+			default:
+				// PromQL allows only number, start() or end() after `@`.
+				if !isStartOrEnd(at) {
+					addError("@", ExprPos(at), "expression `"+string(at.AppendString(nil))+"` after `@` modifier")
+				}
+			}
+		case *FuncExpr:
+			if t.Name == "" {
+				addError("(", t.Pos, "union of series `(q1, ..., qN)`")
+				return
+			}
+			fs := getFuncSignature(t.Name)
+			if fs != nil && !fs.PromQL {
+				addError(t.Name, t.Pos, "MetricsQL function "+t.Name+"()")
+				return
+			}
+			if t.KeepMetricNames {
+				addError("keep_metric_names", t.Pos, "`keep_metric_names` modifier")
+			}
+			if fs == nil || t.Name == "timestamp" {
+				// PromQL timestamp() accepts instant vector.
+				return
+			}
+			for i, arg := range t.Args {
+				if k, ok := fs.ArgKind(i, len(t.Args)); ok && k == ArgKindRangeVector && isSeriesSelector(arg) {
+					addError(t.Name, ExprPos(arg), "implicit lookbehind window for "+t.Name+"()")
+				}
+			}
+		case *AggrFuncExpr:
+			if fs := getFuncSignature(t.Name); fs != nil && !fs.PromQL {
+				addError(t.Name, t.Pos, "MetricsQL aggregate function "+t.Name+"()")
+				return
+			}
+			if t.Limit > 0 {
+				addError("limit", t.Pos, "`limit` modifier for aggregate functions")
+			}
+		case *BinaryOpExpr:
+			switch op := strings.ToLower(t.Op); op {
+			case "if", "ifnot", "default":
+				addError(t.Op, t.Pos, "`"+op+"` operator")
+			}
+			if t.JoinModifierPrefix != nil {
+				addError("prefix", t.JoinModifierPrefix.Pos, "`prefix` modifier")
+			}
+			if t.KeepMetricNames {
+				addError("keep_metric_names", t.Pos, "`keep_metric_names` modifier")
+			}
+		}
+	})
+	return errs
+}
+
+// getUnaryMinusNumber returns the number from be if be is `-N` expression.
+//
+// The parser converts `-N` into `0 - N`, where the implicit zero has no original spelling.
+func getUnaryMinusNumber(be *BinaryOpExpr) (*NumberExpr, bool) {
+	if be.Op != "-" {
+		return nil, false
+	}
+	lne, ok := be.Left.(*NumberExpr)
+	if !ok || lne.N != 0 || lne.s != "" {
+		return nil, false
+	}
+	rne, ok := be.Right.(*NumberExpr)
+	return rne, ok
+}
+
+// isStartOrEnd returns true if e is `start()` or `end()`, which are allowed in PromQL only after `@`.
+func isStartOrEnd(e Expr) bool {
+	fe, ok := e.(*FuncExpr)
+	if !ok || len(fe.Args) > 0 {
+		return false
+	}
+	name := strings.ToLower(fe.Name)
+	return name == "start" || name == "end"
+}
+
+// hasNumberSuffix returns true if the number s has MetricsQL suffix such as `Ki` or `M`.
+func hasNumberSuffix(s string) bool {
+	s = strings.ToLower(strings.TrimLeft(s, "+-"))
+	if s == "" || isInfOrNaN(s) || isSpecialIntegerPrefix(s) {
+		return false
+	}
+	c := s[len(s)-1]
+	return c >= 'a' && c <= 'z'
+}
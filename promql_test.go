@@ -0,0 +1,124 @@
+package metricsql
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParsePromQLSuccess(t *testing.T) {
+	f := func(s, resultExpected string) {
+		t.Helper()
+
+		e, err := ParseWithOptions(s, &ParseOptions{PromQL: true})
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %s: %s", s, err)
+		}
+		result := string(e.AppendString(nil))
+		if result != resultExpected {
+			t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+	}
+
+	f(`foo`, `foo`)
+	f(`foo{bar="baz",x=~"y.+"}`, `foo{bar="baz",x=~"y.+"}`)
+	f(`rate(foo[5m] offset 1h)`, `rate(foo[5m] offset 1h)`)
+	f(`rate(foo[5m] @ end())`, `rate(foo[5m] @ end())`)
+	f(`foo @ 123`, `foo @ 123`)
+	f(`foo @ start()`, `foo @ start()`)
+	f(`foo @ -1`, `foo @ -1`)
+	f(`rate(foo[5m] @ -1.5)`, `rate(foo[5m] @ -1.5)`)
+	f(`max_over_time(rate(foo[5m])[1h:1m])`, `max_over_time(rate(foo[5m])[1h:1m])`)
+	f(`sum(rate(foo[5m])) by (job)`, `sum(rate(foo[5m])) by(job)`)
+	f(`topk(5, foo) without (instance)`, `topk(5, foo) without(instance)`)
+	f(`histogram_quantile(0.9, sum(rate(foo_bucket[5m])) by (le))`, `histogram_quantile(0.9, sum(rate(foo_bucket[5m])) by(le))`)
+	f(`label_replace(foo, "a", "$1", "b", "(.+)")`, `label_replace(foo, "a", "$1", "b", "(.+)")`)
+	f(`timestamp(foo)`, `timestamp(foo)`)
+	f(`foo / on (a) group_left (b) bar`, `foo / on(a) group_left(b) bar`)
+	f(`foo > bool 10`, `foo >bool 10`)
+	f(`foo and bar or baz unless x`, `(foo and bar) or (baz unless x)`)
+	f(`1e3 + 0x10 + Inf`, `+Inf`)
+	f(`2 * 3`, `6`)
+	f(`with`, `with`)
+}
+
+func TestParsePromQLError(t *testing.T) {
+	f := func(s, tokenExpected, extensionExpected string, offsetExpected int) {
+		t.Helper()
+
+		_, err := ParseWithOptions(s, &ParseOptions{PromQL: true})
+		if err == nil {
+			t.Fatalf("expecting non-nil error when parsing %s", s)
+		}
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Fatalf("expecting ParseError; got %T: %s", err, err)
+		}
+		if pe.Kind != ParseErrorNotPromQL {
+			t.Fatalf("unexpected error kind; got %s; want %s; error: %s", pe.Kind, ParseErrorNotPromQL, err)
+		}
+		if pe.Token != tokenExpected {
+			t.Fatalf("unexpected token; got %q; want %q", pe.Token, tokenExpected)
+		}
+		if !strings.Contains(pe.Msg, extensionExpected) {
+			t.Fatalf("error %q must mention %q", pe.Msg, extensionExpected)
+		}
+		if pe.Pos.Offset != offsetExpected {
+			t.Fatalf("unexpected offset; got %d; want %d", pe.Pos.Offset, offsetExpected)
+		}
+
+		// The query must be valid MetricsQL.
+		if _, err := Parse(s); err != nil {
+			t.Fatalf("unexpected error when parsing %s in MetricsQL mode: %s", s, err)
+		}
+	}
+
+	f(`with (x = foo) x`, "with", "WITH expressions", 0)
+	f(`rate(foo[5m]) + WITH (x = 1) x`, "WITH", "WITH expressions", 16)
+	f(`start()`, "start", "MetricsQL function start()", 0)
+	f(`abs(label_set(foo, "a", "b"))`, "label_set", "MetricsQL function label_set()", 4)
+	f(`sum(count_le_over_time(foo[5m], 10))`, "count_le_over_time", "MetricsQL function count_le_over_time()", 4)
+	f(`median(foo)`, "median", "MetricsQL aggregate function median()", 0)
+	f(`(foo, bar)`, "(", "union of series", 0)
+	f(`abs(foo) keep_metric_names`, "keep_metric_names", "`keep_metric_names` modifier", 0)
+	f(`foo + bar keep_metric_names`, "keep_metric_names", "`keep_metric_names` modifier", 0)
+	f(`sum(foo) by (a) limit 10`, "limit", "`limit` modifier", 0)
+	f(`foo * on (a) group_left (b) prefix "x_" bar`, "prefix", "`prefix` modifier", 35)
+	f(`foo{a="b" or c="d"}`, "or", "`or` delimiter", 0)
+	f(`foo if bar`, "if", "`if` operator", 0)
+	f(`foo IfNot bar`, "ifnot", "`ifnot` operator", 0)
+	f(`foo default 0`, "default", "`default` operator", 0)
+	f(`rate(foo)`, "rate", "implicit lookbehind window for rate()", 5)
+	f(`sum(increase(foo offset 1h))`, "increase", "implicit lookbehind window for increase()", 13)
+	f(`rate(foo[10i])`, "10i", "step-based duration 10i", 9)
+	f(`max_over_time(foo[1h:1i])`, "1i", "step-based duration 1i", 21)
+	f(`foo offset 2i`, "2i", "step-based duration 2i", 11)
+	f(`foo[1.5h]`, "1.5h", "fractional duration 1.5h", 4)
+	f(`rate(foo[5m:0.5m])`, "0.5m", "fractional duration 0.5m", 12)
+	f(`foo @ -1Ki`, "1Ki", "numeric suffix in 1Ki", 7)
+	f(`foo @ -time()`, "@", "expression `0 - time()` after `@` modifier", 6)
+	f(`foo > 2Ki`, "2Ki", "numeric suffix in 2Ki", 6)
+	f(`foo * 1.5M`, "1.5M", "numeric suffix in 1.5M", 6)
+	f(`foo @ 1Ki`, "1Ki", "numeric suffix in 1Ki", 6)
+	f(`foo @ time()`, "@", "expression `time()` after `@` modifier", 6)
+	f(`foo @ (start() + 1)`, "@", "expression `start() + 1` after `@` modifier", 7)
+
+	// The first extension in the query is reported.
+	f(`median(foo) + abs(bar) keep_metric_names`, "median", "MetricsQL aggregate function median()", 0)
+}
+
+func TestParsePromQLTemplates(t *testing.T) {
+	tl := NewTemplateLibrary()
+	if err := tl.Add("", `x = foo`); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := ParseWithOptions(`foo`, &ParseOptions{PromQL: true, Templates: tl}); err == nil {
+		t.Fatalf("expecting non-nil error for templates in PromQL mode")
+	}
+
+	// Built-in templates are disabled in PromQL mode.
+	_, err := ParseWithOptions(`ru(foo, 100)`, &ParseOptions{PromQL: true})
+	if err == nil {
+		t.Fatalf("expecting non-nil error for built-in template in PromQL mode")
+	}
+}
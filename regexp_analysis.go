@@ -0,0 +1,302 @@
+package metricsql
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"sort"
+	"strings"
+)
+
+// RegexpAnalysis contains the results of regexp analysis returned by AnalyzeRegexp.
+type RegexpAnalysis struct {
+	// Values contains the sorted list of all the values matching the regexp if the regexp matches a finite set of literal values.
+	//
+	// For example, Values contains `api`, `db` and `web` for `api|web|db`. Values is nil if the regexp matches
+	// an infinite set of values or too many values.
+	Values []string
+
+	// Prefix is the literal prefix for all the values matching the regexp.
+	//
+	// For example, Prefix is `10.0.` for `10\.0\..*`.
+	Prefix string
+
+	// Suffix is the literal suffix for all the values matching the regexp.
+	//
+	// For example, Suffix is `.example.com` for `.+\.example\.com`.
+	Suffix string
+
+	// MatchesAll is set to true if the regexp matches any value including the empty value, e.g. `.*`.
+	MatchesAll bool
+
+	// MatchesNone is set to true if the regexp cannot match any value, e.g. `[^\x00-\x{10FFFF}]`.
+	MatchesNone bool
+
+	// MatchesEmpty is set to true if the regexp matches the empty value, which is equivalent to the missing label.
+	MatchesEmpty bool
+}
+
+// maxRegexpLiteralValues is the maximum number of values in RegexpAnalysis.Values.
+const maxRegexpLiteralValues = 100
+
+// AnalyzeRegexp analyzes the regexp re from the label filter such as `{label=~"re"}`.
+//
+// The regexp is anchored to the beginning and the end of the label value in the same way as CompileRegexpAnchored does.
+// Label values are assumed to contain no newline chars, so `.*` matches all the values.
+func AnalyzeRegexp(re string) (*RegexpAnalysis, error) {
+	r, err := CompileRegexpAnchored(re)
+	if err != nil {
+		return nil, err
+	}
+	sre, err := syntax.Parse(re, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	sre = sre.Simplify()
+
+	ra := &RegexpAnalysis{
+		MatchesEmpty: r.MatchString(""),
+	}
+	ra.Prefix, _ = getRegexpLiteralPrefix(sre)
+	ra.Suffix, _ = getRegexpLiteralSuffix(sre)
+	switch {
+	case isRegexpMatchAll(sre):
+		ra.MatchesAll = true
+	case sre.Op == syntax.OpNoMatch:
+		ra.MatchesNone = true
+	default:
+		values, ok := getRegexpLiteralValues(sre)
+		if ok && len(values) == 0 {
+			// The regexp contains empty char class such as `[^\x00-\x{10FFFF}]`.
+			ra.MatchesNone = true
+		} else if ok {
+			ra.Values = sortAndDedupStrings(values)
+			ra.Prefix = getCommonPrefix(ra.Values)
+			ra.Suffix = getCommonSuffix(ra.Values)
+		}
+	}
+	return ra, nil
+}
+
+// AnalyzeRegexp analyzes the regexp for lf.
+//
+// See AnalyzeRegexp for details. An error is returned if lf isn't a regexp filter.
+func (lf *LabelFilter) AnalyzeRegexp() (*RegexpAnalysis, error) {
+	if !lf.IsRegexp {
+		return nil, fmt.Errorf("%s isn't a regexp filter", lf.AppendString(nil))
+	}
+	return AnalyzeRegexp(lf.Value)
+}
+
+func isRegexpMatchAll(sre *syntax.Regexp) bool {
+	switch sre.Op {
+	case syntax.OpStar:
+		sub := sre.Sub[0]
+		return sub.Op == syntax.OpAnyChar || sub.Op == syntax.OpAnyCharNotNL
+	case syntax.OpCapture:
+		return isRegexpMatchAll(sre.Sub[0])
+	case syntax.OpAlternate:
+		for _, sub := range sre.Sub {
+			if isRegexpMatchAll(sub) {
+				return true
+			}
+		}
+		return false
+	case syntax.OpConcat:
+		for _, sub := range sre.Sub {
+			if !isRegexpMatchAll(sub) && !isRegexpEmptyMatch(sub) {
+				return false
+			}
+		}
+		return len(sre.Sub) > 0
+	default:
+		return false
+	}
+}
+
+func isRegexpEmptyMatch(sre *syntax.Regexp) bool {
+	switch sre.Op {
+	case syntax.OpEmptyMatch, syntax.OpBeginText, syntax.OpEndText:
+		return true
+	default:
+		return false
+	}
+}
+
+// getRegexpLiteralValues returns all the values matching sre.
+//
+// false is returned if sre matches infinite set of values or more than maxRegexpLiteralValues values.
+func getRegexpLiteralValues(sre *syntax.Regexp) ([]string, bool) {
+	switch sre.Op {
+	case syntax.OpEmptyMatch:
+		return []string{""}, true
+	case syntax.OpLiteral:
+		if sre.Flags&syntax.FoldCase != 0 {
+			return nil, false
+		}
+		return []string{string(sre.Rune)}, true
+	case syntax.OpCharClass:
+		var values []string
+		for i := 0; i+1 < len(sre.Rune); i += 2 {
+			lo, hi := sre.Rune[i], sre.Rune[i+1]
+			if int(hi-lo)+1+len(values) > maxRegexpLiteralValues {
+				return nil, false
+			}
+			for r := lo; r <= hi; r++ {
+				values = append(values, string(r))
+			}
+		}
+		return values, true
+	case syntax.OpCapture:
+		return getRegexpLiteralValues(sre.Sub[0])
+	case syntax.OpQuest:
+		values, ok := getRegexpLiteralValues(sre.Sub[0])
+		if !ok {
+			return nil, false
+		}
+		return append(values, ""), true
+	case syntax.OpAlternate:
+		var values []string
+		for _, sub := range sre.Sub {
+			subValues, ok := getRegexpLiteralValues(sub)
+			if !ok || len(values)+len(subValues) > maxRegexpLiteralValues {
+				return nil, false
+			}
+			values = append(values, subValues...)
+		}
+		return values, true
+	case syntax.OpConcat:
+		subs := sre.Sub
+		// The regexp is anchored, so `^` at the beginning and `$` at the end are no-op.
+		if len(subs) > 0 && subs[0].Op == syntax.OpBeginText {
+			subs = subs[1:]
+		}
+		if len(subs) > 0 && subs[len(subs)-1].Op == syntax.OpEndText {
+			subs = subs[:len(subs)-1]
+		}
+		values := []string{""}
+		for _, sub := range subs {
+			subValues, ok := getRegexpLiteralValues(sub)
+			if !ok || len(values)*len(subValues) > maxRegexpLiteralValues {
+				return nil, false
+			}
+			var dst []string
+			for _, v := range values {
+				for _, sv := range subValues {
+					dst = append(dst, v+sv)
+				}
+			}
+			values = dst
+		}
+		return values, true
+	default:
+		return nil, false
+	}
+}
+
+// getRegexpLiteralPrefix returns the literal prefix for all the values matching sre.
+//
+// The second returned value is set to true if sre matches only the returned prefix.
+func getRegexpLiteralPrefix(sre *syntax.Regexp) (string, bool) {
+	switch sre.Op {
+	case syntax.OpLiteral:
+		if sre.Flags&syntax.FoldCase != 0 {
+			return "", false
+		}
+		return string(sre.Rune), true
+	case syntax.OpEmptyMatch, syntax.OpBeginText, syntax.OpEndText:
+		return "", true
+	case syntax.OpCapture:
+		return getRegexpLiteralPrefix(sre.Sub[0])
+	case syntax.OpConcat:
+		prefix := ""
+		for _, sub := range sre.Sub {
+			s, complete := getRegexpLiteralPrefix(sub)
+			prefix += s
+			if !complete {
+				return prefix, false
+			}
+		}
+		return prefix, true
+	case syntax.OpAlternate:
+		prefixes := make([]string, len(sre.Sub))
+		for i, sub := range sre.Sub {
+			prefixes[i], _ = getRegexpLiteralPrefix(sub)
+		}
+		return getCommonPrefix(prefixes), false
+	default:
+		return "", false
+	}
+}
+
+// getRegexpLiteralSuffix returns the literal suffix for all the values matching sre.
+//
+// The second returned value is set to true if sre matches only the returned suffix.
+func getRegexpLiteralSuffix(sre *syntax.Regexp) (string, bool) {
+	switch sre.Op {
+	case syntax.OpLiteral:
+		if sre.Flags&syntax.FoldCase != 0 {
+			return "", false
+		}
+		return string(sre.Rune), true
+	case syntax.OpEmptyMatch, syntax.OpBeginText, syntax.OpEndText:
+		return "", true
+	case syntax.OpCapture:
+		return getRegexpLiteralSuffix(sre.Sub[0])
+	case syntax.OpConcat:
+		suffix := ""
+		for i := len(sre.Sub) - 1; i >= 0; i-- {
+			s, complete := getRegexpLiteralSuffix(sre.Sub[i])
+			suffix = s + suffix
+			if !complete {
+				return suffix, false
+			}
+		}
+		return suffix, true
+	case syntax.OpAlternate:
+		suffixes := make([]string, len(sre.Sub))
+		for i, sub := range sre.Sub {
+			suffixes[i], _ = getRegexpLiteralSuffix(sub)
+		}
+		return getCommonSuffix(suffixes), false
+	default:
+		return "", false
+	}
+}
+
+func sortAndDedupStrings(a []string) []string {
+	sort.Strings(a)
+	dst := a[:0]
+	for _, s := range a {
+		if len(dst) > 0 && s == dst[len(dst)-1] {
+			continue
+		}
+		dst = append(dst, s)
+	}
+	return dst
+}
+
+func getCommonPrefix(a []string) string {
+	if len(a) == 0 {
+		return ""
+	}
+	prefix := a[0]
+	for _, s := range a[1:] {
+		for !strings.HasPrefix(s, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+func getCommonSuffix(a []string) string {
+	if len(a) == 0 {
+		return ""
+	}
+	suffix := a[0]
+	for _, s := range a[1:] {
+		for !strings.HasSuffix(s, suffix) {
+			suffix = suffix[1:]
+		}
+	}
+	return suffix
+}
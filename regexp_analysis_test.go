@@ -0,0 +1,145 @@
+package metricsql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeRegexp(t *testing.T) {
+	f := func(re string, raExpected *RegexpAnalysis) {
+		t.Helper()
+
+		ra, err := AnalyzeRegexp(re)
+		if err != nil {
+			t.Fatalf("unexpected error in AnalyzeRegexp(%q): %s", re, err)
+		}
+		if !reflect.DeepEqual(ra, raExpected) {
+			t.Fatalf("unexpected result for AnalyzeRegexp(%q);\ngot\n%+v\nwant\n%+v", re, ra, raExpected)
+		}
+	}
+
+	// literal values
+	f(``, &RegexpAnalysis{
+		Values:       []string{""},
+		MatchesEmpty: true,
+	})
+	f(`foo`, &RegexpAnalysis{
+		Values: []string{"foo"},
+		Prefix: "foo",
+		Suffix: "foo",
+	})
+	f(`a\.b`, &RegexpAnalysis{
+		Values: []string{"a.b"},
+		Prefix: "a.b",
+		Suffix: "a.b",
+	})
+	f(`^foo$`, &RegexpAnalysis{
+		Values: []string{"foo"},
+		Prefix: "foo",
+		Suffix: "foo",
+	})
+	f(`api|web|db|web`, &RegexpAnalysis{
+		Values: []string{"api", "db", "web"},
+	})
+	f(`(foo|bar)baz`, &RegexpAnalysis{
+		Values: []string{"barbaz", "foobaz"},
+		Suffix: "baz",
+	})
+	f(`foo(bar|baz)?`, &RegexpAnalysis{
+		Values: []string{"foo", "foobar", "foobaz"},
+		Prefix: "foo",
+	})
+	f(`host[1-3]`, &RegexpAnalysis{
+		Values: []string{"host1", "host2", "host3"},
+		Prefix: "host",
+	})
+	f(`foo|`, &RegexpAnalysis{
+		Values:       []string{"", "foo"},
+		MatchesEmpty: true,
+	})
+
+	// prefixes and suffixes
+	f(`10\.0\..*`, &RegexpAnalysis{
+		Prefix: "10.0.",
+	})
+	f(`.+\.example\.com`, &RegexpAnalysis{
+		Suffix: ".example.com",
+	})
+	f(`foo.+bar`, &RegexpAnalysis{
+		Prefix: "foo",
+		Suffix: "bar",
+	})
+	f(`(a.*|b.*)-x`, &RegexpAnalysis{
+		Suffix: "-x",
+	})
+	f(`foo.*|bar`, &RegexpAnalysis{})
+	f(`(?i)foo`, &RegexpAnalysis{})
+	f(`[0-9]+`, &RegexpAnalysis{})
+	f(`host[0-9]{1,3}`, &RegexpAnalysis{
+		Prefix: "host",
+	})
+
+	// match all
+	f(`.*`, &RegexpAnalysis{
+		MatchesAll:   true,
+		MatchesEmpty: true,
+	})
+	f(`(?s).*`, &RegexpAnalysis{
+		MatchesAll:   true,
+		MatchesEmpty: true,
+	})
+	f(`(.*)`, &RegexpAnalysis{
+		MatchesAll:   true,
+		MatchesEmpty: true,
+	})
+	f(`foo|.*`, &RegexpAnalysis{
+		MatchesAll:   true,
+		MatchesEmpty: true,
+	})
+	f(`^.*$`, &RegexpAnalysis{
+		MatchesAll:   true,
+		MatchesEmpty: true,
+	})
+	f(`.+`, &RegexpAnalysis{})
+
+	// match none
+	f(`[^\x00-\x{10FFFF}]`, &RegexpAnalysis{
+		MatchesNone: true,
+	})
+}
+
+func TestAnalyzeRegexpError(t *testing.T) {
+	f := func(re string) {
+		t.Helper()
+
+		ra, err := AnalyzeRegexp(re)
+		if err == nil {
+			t.Fatalf("expecting non-nil error for AnalyzeRegexp(%q); got %+v", re, ra)
+		}
+	}
+
+	f(`(`)
+	f(`[a`)
+	f(`a**`)
+	f(`\`)
+}
+
+func TestLabelFilterAnalyzeRegexp(t *testing.T) {
+	lf := &LabelFilter{
+		Label:    "job",
+		Value:    "api|web",
+		IsRegexp: true,
+	}
+	ra, err := lf.AnalyzeRegexp()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(ra.Values, []string{"api", "web"}) {
+		t.Fatalf("unexpected values; got %q", ra.Values)
+	}
+
+	lf.IsRegexp = false
+	if _, err := lf.AnalyzeRegexp(); err == nil {
+		t.Fatalf("expecting non-nil error for non-regexp filter")
+	}
+}
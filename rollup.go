@@ -1,116 +1,103 @@
 package metricsql
 
-import (
-	"strings"
-)
-
-var rollupFuncs = map[string]bool{
-	"absent_over_time":        true,
-	"aggr_over_time":          true,
-	"ascent_over_time":        true,
-	"avg_over_time":           true,
-	"changes":                 true,
-	"changes_prometheus":      true,
-	"count_eq_over_time":      true,
-	"count_gt_over_time":      true,
-	"count_le_over_time":      true,
-	"count_ne_over_time":      true,
-	"count_over_time":         true,
-	"decreases_over_time":     true,
-	"default_rollup":          true,
-	"delta":                   true,
-	"delta_prometheus":        true,
-	"deriv":                   true,
-	"deriv_fast":              true,
-	"descent_over_time":       true,
-	"distinct_over_time":      true,
-	"duration_over_time":      true,
-	"first_over_time":         true,
-	"geomean_over_time":       true,
-	"histogram_over_time":     true,
-	"hoeffding_bound_lower":   true,
-	"hoeffding_bound_upper":   true,
-	"holt_winters":            true,
-	"idelta":                  true,
-	"ideriv":                  true,
-	"increase":                true,
-	"increase_prometheus":     true,
-	"increase_pure":           true,
-	"increases_over_time":     true,
-	"integrate":               true,
-	"irate":                   true,
-	"lag":                     true,
-	"last_over_time":          true,
-	"lifetime":                true,
-	"mad_over_time":           true,
-	"max_over_time":           true,
-	"median_over_time":        true,
-	"min_over_time":           true,
-	"mode_over_time":          true,
-	"predict_linear":          true,
-	"present_over_time":       true,
-	"quantile_over_time":      true,
-	"quantiles_over_time":     true,
-	"range_over_time":         true,
-	"rate":                    true,
-	"rate_over_sum":           true,
-	"resets":                  true,
-	"rollup":                  true,
-	"rollup_candlestick":      true,
-	"rollup_delta":            true,
-	"rollup_deriv":            true,
-	"rollup_increase":         true,
-	"rollup_rate":             true,
-	"rollup_scrape_interval":  true,
-	"scrape_interval":         true,
-	"share_gt_over_time":      true,
-	"share_le_over_time":      true,
-	"share_eq_over_time":      true,
-	"stale_samples_over_time": true,
-	"stddev_over_time":        true,
-	"stdvar_over_time":        true,
-	"sum_over_time":           true,
-	"sum2_over_time":          true,
-	"tfirst_over_time":        true,
+var rollupFuncs = setFuncKind(FuncKindRollup, []*FuncSignature{
+	{Name: "absent_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, ChangesLabels: true, PromQL: true, Description: "returns 1 if the series has no raw samples on the lookbehind window"},
+	{Name: "aggr_over_time", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindString, ArgKindRangeVector}, Description: "calculates all the given rollup functions over raw samples on the lookbehind window"},
+	{Name: "ascent_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, Description: "returns the sum of positive deltas between adjacent raw samples on the lookbehind window"},
+	{Name: "avg_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, KeepMetricNames: true, PromQL: true, Description: "returns the average value over raw samples on the lookbehind window"},
+	{Name: "changes", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, PromQL: true, Description: "returns the number of times the raw samples changed on the lookbehind window"},
+	{Name: "changes_prometheus", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, Description: "returns the number of times the raw samples changed on the lookbehind window without taking into account the previous sample"},
+	{Name: "count_eq_over_time", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindRangeVector, ArgKindScalar}, Description: "returns the number of raw samples on the lookbehind window, which are equal to eq"},
+	{Name: "count_gt_over_time", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindRangeVector, ArgKindScalar}, Description: "returns the number of raw samples on the lookbehind window, which are bigger than gt"},
+	{Name: "count_le_over_time", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindRangeVector, ArgKindScalar}, Description: "returns the number of raw samples on the lookbehind window, which don't exceed le"},
+	{Name: "count_ne_over_time", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindRangeVector, ArgKindScalar}, Description: "returns the number of raw samples on the lookbehind window, which aren't equal to ne"},
+	{Name: "count_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, PromQL: true, Description: "returns the number of raw samples on the lookbehind window"},
+	{Name: "decreases_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, Description: "returns the number of decreases for raw samples on the lookbehind window"},
+	{Name: "default_rollup", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, KeepMetricNames: true, Description: "returns the last raw sample on the lookbehind window"},
+	{Name: "delta", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, PromQL: true, Description: "returns the difference between the last raw sample on the lookbehind window and the last raw sample before the window"},
+	{Name: "delta_prometheus", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, Description: "returns the difference between the first and the last raw samples on the lookbehind window"},
+	{Name: "deriv", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, PromQL: true, Description: "returns per-second derivative over raw samples on the lookbehind window using linear regression"},
+	{Name: "deriv_fast", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, Description: "returns per-second derivative using the first and the last raw samples on the lookbehind window"},
+	{Name: "descent_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, Description: "returns the sum of negative deltas between adjacent raw samples on the lookbehind window"},
+	{Name: "distinct_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, Description: "returns the number of distinct raw sample values on the lookbehind window"},
+	{Name: "duration_over_time", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindRangeVector, ArgKindScalar}, Description: "returns the duration in seconds when the series was present on the lookbehind window"},
+	{Name: "first_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, KeepMetricNames: true, Description: "returns the first raw sample on the lookbehind window"},
+	{Name: "geomean_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, KeepMetricNames: true, Description: "returns the geometric mean over raw samples on the lookbehind window"},
+	{Name: "histogram_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, Description: "calculates VictoriaMetrics histogram over raw samples on the lookbehind window"},
+	{Name: "hoeffding_bound_lower", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindScalar, ArgKindRangeVector}, KeepMetricNames: true, Description: "returns the lower Hoeffding bound for the given phi over raw samples on the lookbehind window"},
+	{Name: "hoeffding_bound_upper", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindScalar, ArgKindRangeVector}, KeepMetricNames: true, Description: "returns the upper Hoeffding bound for the given phi over raw samples on the lookbehind window"},
+	{Name: "holt_winters", MinArgs: 3, MaxArgs: 3, ArgKinds: []ArgKind{ArgKindRangeVector, ArgKindScalar, ArgKindScalar}, KeepMetricNames: true, PromQL: true, Description: "calculates the Holt-Winters value with the given smoothing and trend factors over raw samples on the lookbehind window"},
+	{Name: "idelta", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, PromQL: true, Description: "returns the difference between the last two raw samples on the lookbehind window"},
+	{Name: "ideriv", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, Description: "returns per-second derivative based on the last two raw samples on the lookbehind window"},
+	{Name: "increase", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, PromQL: true, Description: "returns the increase over the lookbehind window for counters"},
+	{Name: "increase_prometheus", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, Description: "returns the increase over the lookbehind window for counters without taking into account the last sample before the window"},
+	{Name: "increase_pure", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, Description: "works the same as increase() except that counter resets to zero are assumed at the beginning of the series"},
+	{Name: "increases_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, Description: "returns the number of increases for raw samples on the lookbehind window"},
+	{Name: "integrate", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, Description: "returns the integral over raw samples on the lookbehind window"},
+	{Name: "irate", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, PromQL: true, Description: "returns instant per-second increase rate based on the last two raw samples on the lookbehind window"},
+	{Name: "lag", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, Description: "returns the duration in seconds between the last raw sample on the lookbehind window and the current point"},
+	{Name: "last_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, KeepMetricNames: true, PromQL: true, Description: "returns the last raw sample on the lookbehind window"},
+	{Name: "lifetime", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, Description: "returns the duration in seconds between the last and the first raw samples on the lookbehind window"},
+	{Name: "mad_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, PromQL: true, Description: "returns median absolute deviation over raw samples on the lookbehind window"},
+	{Name: "max_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, KeepMetricNames: true, PromQL: true, Description: "returns the maximum raw sample on the lookbehind window"},
+	{Name: "median_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, KeepMetricNames: true, Description: "returns the median over raw samples on the lookbehind window"},
+	{Name: "min_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, KeepMetricNames: true, PromQL: true, Description: "returns the minimum raw sample on the lookbehind window"},
+	{Name: "mode_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, KeepMetricNames: true, Description: "returns the most frequently occurring raw sample value on the lookbehind window"},
+	{Name: "predict_linear", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindRangeVector, ArgKindScalar}, KeepMetricNames: true, PromQL: true, Description: "returns the predicted value after the given number of seconds using linear interpolation over raw samples on the lookbehind window"},
+	{Name: "present_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, PromQL: true, Description: "returns 1 if there is at least a single raw sample on the lookbehind window"},
+	{Name: "quantile_over_time", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindScalar, ArgKindRangeVector}, KeepMetricNames: true, PromQL: true, Description: "returns the phi-quantile over raw samples on the lookbehind window"},
+	{Name: "quantiles_over_time", MinArgs: 3, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindLabelName, ArgKindScalar, ArgKindRangeVector}, VariadicArgIdx: 1, KeepMetricNames: true, Description: "returns the given phi-quantiles over raw samples on the lookbehind window with the phi stored in the given label"},
+	{Name: "range_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, Description: "returns the difference between the maximum and the minimum raw samples on the lookbehind window"},
+	{Name: "rate", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, PromQL: true, Description: "returns the average per-second increase rate over the lookbehind window for counters"},
+	{Name: "rate_over_sum", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, Description: "returns the per-second rate over the sum of raw samples on the lookbehind window"},
+	{Name: "resets", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, PromQL: true, Description: "returns the number of counter resets over the lookbehind window"},
+	{Name: "rollup", MinArgs: 1, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindRangeVector, ArgKindString}, Description: "returns min, max and avg values over raw samples on the lookbehind window in the rollup label"},
+	{Name: "rollup_candlestick", MinArgs: 1, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindRangeVector, ArgKindString}, KeepMetricNames: true, Description: "returns open, close, low and high values over raw samples on the lookbehind window in the rollup label"},
+	{Name: "rollup_delta", MinArgs: 1, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindRangeVector, ArgKindString}, Description: "returns min, max and avg values for deltas between adjacent raw samples on the lookbehind window in the rollup label"},
+	{Name: "rollup_deriv", MinArgs: 1, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindRangeVector, ArgKindString}, Description: "returns min, max and avg values for per-second derivatives between adjacent raw samples on the lookbehind window in the rollup label"},
+	{Name: "rollup_increase", MinArgs: 1, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindRangeVector, ArgKindString}, Description: "returns min, max and avg values for increases between adjacent raw samples on the lookbehind window in the rollup label"},
+	{Name: "rollup_rate", MinArgs: 1, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindRangeVector, ArgKindString}, Description: "returns min, max and avg values for per-second rates between adjacent raw samples on the lookbehind window in the rollup label"},
+	{Name: "rollup_scrape_interval", MinArgs: 1, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindRangeVector, ArgKindString}, Description: "returns min, max and avg values for the interval in seconds between adjacent raw samples on the lookbehind window in the rollup label"},
+	{Name: "scrape_interval", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, Description: "returns the average interval in seconds between raw samples on the lookbehind window"},
+	{Name: "share_eq_over_time", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindRangeVector, ArgKindScalar}, Description: "returns the share of raw samples on the lookbehind window, which are equal to eq"},
+	{Name: "share_gt_over_time", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindRangeVector, ArgKindScalar}, Description: "returns the share of raw samples on the lookbehind window, which are bigger than gt"},
+	{Name: "share_le_over_time", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindRangeVector, ArgKindScalar}, Description: "returns the share of raw samples on the lookbehind window, which don't exceed le"},
+	{Name: "stale_samples_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, Description: "returns the number of staleness markers on the lookbehind window"},
+	{Name: "stddev_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, PromQL: true, Description: "returns standard deviation over raw samples on the lookbehind window"},
+	{Name: "stdvar_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, PromQL: true, Description: "returns standard variance over raw samples on the lookbehind window"},
+	{Name: "sum_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, PromQL: true, Description: "returns the sum of raw samples on the lookbehind window"},
+	{Name: "sum2_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, Description: "returns the sum of squares for raw samples on the lookbehind window"},
+	{Name: "tfirst_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, Description: "returns the timestamp in seconds for the first raw sample on the lookbehind window"},
 	// `timestamp` function must return timestamp for the last datapoint on the current window
 	// in order to properly handle offset and timestamps unaligned to the current step.
 	// See https://github.com/VictoriaMetrics/VictoriaMetrics/issues/415 for details.
-	"timestamp":              true,
-	"timestamp_with_name":    true,
-	"tlast_change_over_time": true,
-	"tlast_over_time":        true,
-	"tmax_over_time":         true,
-	"tmin_over_time":         true,
-	"zscore_over_time":       true,
+	{Name: "timestamp", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, PromQL: true, Description: "returns the timestamp in seconds for the last raw sample on the lookbehind window"},
+	{Name: "timestamp_with_name", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, KeepMetricNames: true, Description: "returns the timestamp in seconds for the last raw sample on the lookbehind window and keeps metric names"},
+	{Name: "tlast_change_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, Description: "returns the timestamp in seconds for the last change on the lookbehind window"},
+	{Name: "tlast_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, Description: "returns the timestamp in seconds for the last raw sample on the lookbehind window"},
+	{Name: "tmax_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, Description: "returns the timestamp in seconds for the raw sample with the maximum value on the lookbehind window"},
+	{Name: "tmin_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, Description: "returns the timestamp in seconds for the raw sample with the minimum value on the lookbehind window"},
+	{Name: "zscore_over_time", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindRangeVector}, Description: "returns z-score for raw samples on the lookbehind window"},
 	// custom functions
-	"avg_daily":                 true,
-	"median_daily":              true,
-	"avg_weekly":                true,
-	"median_weekly":             true,
-	"median_weekly_with_trends": true,
-}
+	{Name: "avg_daily", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindScalar, ArgKindRangeVector}, Description: "returns the average value at the same time of day over the given number of previous days"},
+	{Name: "median_daily", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindScalar, ArgKindRangeVector}, Description: "returns the median value at the same time of day over the given number of previous days"},
+	{Name: "avg_weekly", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindScalar, ArgKindRangeVector}, Description: "returns the average value at the same time of week over the given number of previous weeks"},
+	{Name: "median_weekly", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindScalar, ArgKindRangeVector}, Description: "returns the median value at the same time of week over the given number of previous weeks"},
+	{Name: "median_weekly_with_trends", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindScalar, ArgKindRangeVector}, Description: "returns the median value at the same time of week over the given number of previous weeks adjusted by the current trend"},
+})
 
 // IsRollupFunc returns whether funcName is known rollup function.
 func IsRollupFunc(funcName string) bool {
-	s := strings.ToLower(funcName)
-	return rollupFuncs[s]
+	return isFuncKind(funcName, FuncKindRollup)
 }
 
 // GetRollupArgIdx returns the argument index for the given fe, which accepts the rollup argument.
 //
 // -1 is returned if fe isn't a rollup function.
 func GetRollupArgIdx(fe *FuncExpr) int {
-	funcName := strings.ToLower(fe.Name)
-	if !rollupFuncs[funcName] {
+	fs := getFuncSignature(fe.Name)
+	if fs == nil || fs.Kind != FuncKindRollup {
 		return -1
 	}
-	switch funcName {
-	case "quantile_over_time", "aggr_over_time",
-		"hoeffding_bound_lower", "hoeffding_bound_upper":
-		return 1
-	case "quantiles_over_time":
-		return len(fe.Args) - 1
-	default:
-		return 0
-	}
+	return fs.SeriesArgIdx(len(fe.Args))
 }
@@ -0,0 +1,180 @@
+package metricsql
+
+import (
+	"strings"
+)
+
+// SelectorTimeRange contains a series selector with the time range for raw samples required for evaluating it.
+type SelectorTimeRange struct {
+	// Expr is the series selector.
+	Expr *MetricExpr
+
+	// MinTs and MaxTs is the time range in milliseconds for raw samples required by Expr.
+	MinTs int64
+	MaxTs int64
+}
+
+// ExtractSelectors returns all the series selectors in e with the time ranges for raw samples
+// required for evaluating e on the time range [start, end] with the given step.
+//
+// start, end and step must be in milliseconds. Selectors are returned in the order of their appearance in e.
+// The time range for every selector takes into account the following:
+//
+//   - the lookbehind window such as `rate(foo[5m])`. Series selectors without window such as `foo` use 5 minutes
+//     lookbehind window, while rollup functions without window such as `rate(foo)` use step as the window.
+//   - `offset` and `@` modifiers. `@ start()`, `@ end()` and `@` with literal timestamps are supported.
+//     Other `@` expressions are assumed to return the end of the current time range.
+//   - subqueries such as `max_over_time(rate(foo[5m])[1h:1m])`, which evaluate the inner query
+//     on the time range extended by the subquery window with the subquery step.
+//
+// Durations relative to step such as `5i` are calculated with DurationExpr.Duration for the step of the current time range.
+func ExtractSelectors(e Expr, start, end, step int64) []*SelectorTimeRange {
+	se := &selectorsExtractor{
+		queryStart: start,
+		queryEnd:   end,
+	}
+	tr := evalTimeRange{
+		start: start,
+		end:   end,
+		step:  step,
+	}
+	se.extract(e, tr)
+	return se.result
+}
+
+type selectorsExtractor struct {
+	queryStart int64
+	queryEnd   int64
+
+	result []*SelectorTimeRange
+}
+
+func (se *selectorsExtractor) extract(e Expr, tr evalTimeRange) {
+	switch t := e.(type) {
+	case *MetricExpr:
+		se.addSelector(t, tr, defaultLookbackDelta)
+	case *RollupExpr:
+		se.extractRollupExpr(t, tr, defaultLookbackDelta)
+	case *FuncExpr:
+		rollupArgIdx := GetRollupArgIdx(t)
+		for i, arg := range t.Args {
+			if i != rollupArgIdx {
+				se.extract(arg, tr)
+				continue
+			}
+			// Rollup functions without explicit window use step as the window.
+			switch t := arg.(type) {
+			case *MetricExpr:
+				se.addSelector(t, tr, tr.step)
+			case *RollupExpr:
+				se.extractRollupExpr(t, tr, tr.step)
+			default:
+				se.extract(arg, tr)
+			}
+		}
+	case *AggrFuncExpr:
+		for _, arg := range t.Args {
+			se.extract(arg, tr)
+		}
+	case *BinaryOpExpr:
+		se.extract(t.Left, tr)
+		se.extract(t.Right, tr)
+	}
+}
+
+func (se *selectorsExtractor) extractRollupExpr(re *RollupExpr, tr evalTimeRange, defaultWindow int64) {
+	if re.At != nil {
+		se.extract(re.At, tr)
+	}
+	rtr := getRollupTimeRange(re, tr, defaultWindow, se.queryStart, se.queryEnd)
+	if me, ok := re.Expr.(*MetricExpr); ok && !re.ForSubquery() {
+		se.addSelector(me, rtr.tr, rtr.window)
+		return
+	}
+	se.extract(re.Expr, rtr.innerTR)
+}
+
+func (se *selectorsExtractor) addSelector(me *MetricExpr, tr evalTimeRange, window int64) {
+	se.result = append(se.result, &SelectorTimeRange{
+		Expr:  me,
+		MinTs: tr.start - window,
+		MaxTs: tr.end,
+	})
+}
+
+// defaultLookbackDelta is the lookbehind window in milliseconds for series selectors without explicit window.
+const defaultLookbackDelta = 5 * 60 * 1000
+
+// evalTimeRange is the time range with the step for evaluating an expression.
+type evalTimeRange struct {
+	start int64
+	end   int64
+	step  int64
+}
+
+func (tr evalTimeRange) pointsLen() int64 {
+	return (tr.end-tr.start)/tr.step + 1
+}
+
+// rollupTimeRange contains time ranges for evaluating RollupExpr.
+type rollupTimeRange struct {
+	// window is the lookbehind window.
+	window int64
+
+	// tr is the time range for evaluating the rollup after applying `@` and `offset` modifiers.
+	tr evalTimeRange
+
+	// innerTR is the time range for evaluating the inner query of the subquery.
+	innerTR evalTimeRange
+}
+
+// getRollupTimeRange returns time ranges for evaluating re on tr.
+//
+// defaultWindow is used if re has no explicit window.
+// queryStart and queryEnd are used for `@ start()` and `@ end()` modifiers.
+func getRollupTimeRange(re *RollupExpr, tr evalTimeRange, defaultWindow, queryStart, queryEnd int64) *rollupTimeRange {
+	window := defaultWindow
+	if re.Window != nil {
+		window = re.Window.Duration(tr.step)
+	}
+	if re.At != nil {
+		at := getAtTimestamp(re.At, tr, queryStart, queryEnd)
+		tr.start = at
+		tr.end = at
+	}
+	offset := re.Offset.Duration(tr.step)
+	tr.start -= offset
+	tr.end -= offset
+
+	innerTR := evalTimeRange{
+		start: tr.start - window,
+		end:   tr.end,
+		step:  tr.step,
+	}
+	if step := re.Step.Duration(tr.step); step > 0 {
+		innerTR.step = step
+	}
+	return &rollupTimeRange{
+		window:  window,
+		tr:      tr,
+		innerTR: innerTR,
+	}
+}
+
+// getAtTimestamp returns the timestamp in milliseconds for the `@` modifier with the given e.
+//
+// The end of tr is returned if the timestamp cannot be determined without evaluating e.
+func getAtTimestamp(e Expr, tr evalTimeRange, queryStart, queryEnd int64) int64 {
+	switch t := e.(type) {
+	case *NumberExpr:
+		return int64(t.N * 1000)
+	case *FuncExpr:
+		switch strings.ToLower(t.Name) {
+		case "start":
+			return queryStart
+		case "end":
+			return queryEnd
+		}
+	}
+	return tr.end
+}
@@ -0,0 +1,65 @@
+package metricsql
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestExtractSelectors(t *testing.T) {
+	f := func(s string, start, end, step int64, resultExpected string) {
+		t.Helper()
+
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %s: %s", s, err)
+		}
+		strs := []string{}
+		for _, str := range ExtractSelectors(e, start, end, step) {
+			strs = append(strs, fmt.Sprintf("%s [%d, %d]", str.Expr.AppendString(nil), str.MinTs, str.MaxTs))
+		}
+		result := strings.Join(strs, "; ")
+		if result != resultExpected {
+			t.Fatalf("unexpected result for ExtractSelectors(%s);\ngot\n%s\nwant\n%s", s, result, resultExpected)
+		}
+	}
+
+	// no selectors
+	f(`1 + time()`, 1000e3, 2000e3, 10e3, ``)
+
+	// default windows
+	f(`foo`, 1000e3, 2000e3, 10e3, `foo [700000, 2000000]`)
+	f(`rate(foo)`, 1000e3, 2000e3, 10e3, `foo [990000, 2000000]`)
+	f(`rate(foo offset 100s)`, 1000e3, 2000e3, 10e3, `foo [890000, 1900000]`)
+
+	// explicit windows
+	f(`rate(foo{bar="baz"}[5m])`, 1000e3, 2000e3, 10e3, `foo{bar="baz"} [700000, 2000000]`)
+	f(`rate(foo[2i])`, 1000e3, 2000e3, 10e3, `foo [980000, 2000000]`)
+	f(`foo[1m]`, 1000e3, 2000e3, 10e3, `foo [940000, 2000000]`)
+
+	// offset
+	f(`foo offset 100s`, 1000e3, 2000e3, 10e3, `foo [600000, 1900000]`)
+	f(`rate(foo[1m] offset 1m)`, 1000e3, 2000e3, 10e3, `foo [880000, 1940000]`)
+	f(`rate(foo[1m] offset -1m)`, 1000e3, 2000e3, 10e3, `foo [1000000, 2060000]`)
+
+	// @ modifier
+	f(`rate(foo[1m] @ 500)`, 1000e3, 2000e3, 10e3, `foo [440000, 500000]`)
+	f(`rate(foo[1m] @ 500 offset 100s)`, 1000e3, 2000e3, 10e3, `foo [340000, 400000]`)
+	f(`rate(foo[1m] @ start())`, 1000e3, 2000e3, 10e3, `foo [940000, 1000000]`)
+	f(`rate(foo[1m] @ end())`, 1000e3, 2000e3, 10e3, `foo [1940000, 2000000]`)
+	f(`rate(foo[1m] @ scalar(bar))`, 1000e3, 2000e3, 10e3, `bar [700000, 2000000]; foo [1940000, 2000000]`)
+
+	// subqueries
+	f(`max_over_time(rate(foo[1m])[10m:1m])`, 1000e3, 2000e3, 10e3, `foo [340000, 2000000]`)
+	f(`max_over_time(rate(foo[1m])[10m:])`, 1000e3, 2000e3, 10e3, `foo [340000, 2000000]`)
+	f(`max_over_time(rate(foo[2i])[10m:1m])`, 1000e3, 2000e3, 10e3, `foo [280000, 2000000]`)
+	f(`max_over_time(rate(foo[1m])[10m:1m] offset 1h)`, 4600e3, 5600e3, 10e3, `foo [340000, 2000000]`)
+	f(`max_over_time(rate(foo[1m])[10m:1m] @ end())`, 1000e3, 2000e3, 10e3, `foo [1340000, 2000000]`)
+	f(`max_over_time(max_over_time(rate(foo[1m])[10m:1m])[1h:5m])`, 5000e3, 5000e3, 10e3, `foo [740000, 5000000]`)
+	f(`max_over_time(sum(foo)[10m:1m])`, 1000e3, 2000e3, 10e3, `foo [100000, 2000000]`)
+
+	// multiple selectors
+	f(`sum(rate(foo[5m])) by (x) / on(x) group_left() bar offset 1h`, 10000e3, 20000e3, 10e3, `foo [9700000, 20000000]; bar [6100000, 16400000]`)
+	f(`label_set(foo, "a", "b") or histogram_quantile(0.9, rate(bar[1m]))`, 1000e3, 1000e3, 10e3, `foo [700000, 1000000]; bar [940000, 1000000]`)
+	f(`{foo="bar" or baz="x"}`, 1000e3, 1000e3, 10e3, `{foo="bar" or baz="x"} [700000, 1000000]`)
+}
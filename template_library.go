@@ -0,0 +1,173 @@
+package metricsql
+
+import (
+	"fmt"
+	"io/ioutil"
+	"unicode/utf8"
+)
+
+// TemplateLibrary holds named WITH templates, which can be used in queries without `WITH (...)` prefix.
+//
+// Pass TemplateLibrary to ParseWithOptions via ParseOptions.Templates.
+//
+// TemplateLibrary must not be modified while it is used by ParseWithOptions.
+type TemplateLibrary struct {
+	was []*withArgExpr
+}
+
+// NewTemplateLibrary returns an empty TemplateLibrary.
+func NewTemplateLibrary() *TemplateLibrary {
+	return &TemplateLibrary{}
+}
+
+// Add adds WITH templates from s to tl.
+//
+// s must contain comma-separated template definitions in the same format as inside `WITH (...)`,
+// for example:
+//
+//	error_ratio(job) = sum(rate(errors_total{job=job}[5m])) / sum(rate(requests_total{job=job}[5m])),
+//	slo_burn(window) = error_ratio("api")[window:] / 0.001
+//
+// s may contain comments starting with `#`.
+//
+// If namespace isn't empty, then the templates from s are available under `namespace.name` names,
+// e.g. `slo.error_ratio(job)`. Templates may refer only to the built-in templates and to the templates
+// added before them, by their full names including namespace.
+//
+// An error is returned if s contains invalid templates or templates with names already present in tl.
+func (tl *TemplateLibrary) Add(namespace, s string) error {
+	if namespace != "" && !isValidTemplateNamespace(namespace) {
+		return fmt.Errorf("invalid namespace %q; it must contain only letters, digits, underscores and colons", namespace)
+	}
+	was, err := parseWithArgExprs(s)
+	if err != nil {
+		return err
+	}
+	for _, wa := range was {
+		if namespace != "" {
+			wa.Name = namespace + "." + wa.Name
+		}
+	}
+	wasNew := append(tl.was[:len(tl.was):len(tl.was)], was...)
+	if err := checkDuplicateWithArgNames(wasNew); err != nil {
+		return err
+	}
+	for _, wa := range was {
+		// Templates from the library have no position in the query.
+		wa.Pos = Pos{}
+		clearExprPos(wa.Expr)
+	}
+	tl.was = wasNew
+	return nil
+}
+
+// AddFile adds WITH templates from the file at the given path to tl.
+//
+// See Add for details on the file contents and namespace.
+func (tl *TemplateLibrary) AddFile(namespace, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read templates: %w", err)
+	}
+	if err := tl.Add(namespace, string(data)); err != nil {
+		return fmt.Errorf("cannot add templates from %q: %w", path, err)
+	}
+	return nil
+}
+
+// Names returns names for all the templates in tl in the order they were added.
+func (tl *TemplateLibrary) Names() []string {
+	names := make([]string, len(tl.was))
+	for i, wa := range tl.was {
+		names[i] = wa.Name
+	}
+	return names
+}
+
+// ParseOptions contains options for ParseWithOptions.
+type ParseOptions struct {
+	// Templates contains additional WITH templates, which may be used in the query.
+	Templates *TemplateLibrary
+
+	// DisableDefaultTemplates disables the built-in WITH templates such as `ru`, `ttf`, `range_median` and `alias`.
+	DisableDefaultTemplates bool
+
+	// PromQL enables strict PromQL mode.
+	//
+	// In this mode the query may contain only PromQL syntax and functions. MetricsQL extensions such as
+	// WITH expressions, MetricsQL-only functions, `keep_metric_names`, `limit` for aggregate functions,
+	// `prefix` for `group_left` and `group_right`, `or` delimiter in label filters, `if`, `ifnot` and `default`
+	// operators, implicit lookbehind windows, `[1i]` durations and numeric suffixes such as `Ki`
+	// are rejected with ParseErrorNotPromQL error, which names the used extension.
+	//
+	// WITH templates cannot be used in this mode, so Templates must be nil, while the built-in templates are disabled.
+	PromQL bool
+}
+
+// getWithArgExprs returns WITH templates, which must be available in the query parsed with opts.
+func (opts *ParseOptions) getWithArgExprs() ([]*withArgExpr, error) {
+	if opts == nil {
+		return getDefaultWithArgExprs(), nil
+	}
+	if opts.PromQL {
+		if opts.Templates != nil && len(opts.Templates.was) > 0 {
+			return nil, fmt.Errorf("WITH templates cannot be used in PromQL mode")
+		}
+		return nil, nil
+	}
+	var was []*withArgExpr
+	if !opts.DisableDefaultTemplates {
+		was = getDefaultWithArgExprs()
+	}
+	if opts.Templates == nil || len(opts.Templates.was) == 0 {
+		return was, nil
+	}
+	if len(was) == 0 {
+		return opts.Templates.was, nil
+	}
+	was = append(was[:len(was):len(was)], opts.Templates.was...)
+	if err := checkDuplicateWithArgNames(was); err != nil {
+		return nil, fmt.Errorf("template library clashes with the built-in templates: %w", err)
+	}
+	return was, nil
+}
+
+// parseWithArgExprs parses comma-separated WITH templates from s.
+func parseWithArgExprs(s string) ([]*withArgExpr, error) {
+	var p parser
+	p.lex.Init(s)
+	if err := p.lex.Next(); err != nil {
+		return nil, toParseError(fmt.Errorf(`cannot find the first token: %w`, err), "")
+	}
+	var was []*withArgExpr
+	for !isEOF(p.lex.Token) {
+		wa, err := p.parseWithArgExpr()
+		if err != nil {
+			return nil, toParseError(err, p.lex.Context())
+		}
+		was = append(was, wa)
+		if isEOF(p.lex.Token) {
+			break
+		}
+		if p.lex.Token != "," {
+			return nil, toParseError(p.unexpectedTokenError("templates", ","), p.lex.Context())
+		}
+		if err := p.lex.Next(); err != nil {
+			return nil, toParseError(err, p.lex.Context())
+		}
+	}
+	return was, nil
+}
+
+func isValidTemplateNamespace(s string) bool {
+	r, size := utf8.DecodeRuneInString(s)
+	if !isFirstIdentChar(r) {
+		return false
+	}
+	for _, r := range s[size:] {
+		if r == '.' || !isIdentChar(r) {
+			return false
+		}
+	}
+	return true
+}
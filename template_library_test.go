@@ -0,0 +1,192 @@
+package metricsql
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTemplateLibraryAddSuccess(t *testing.T) {
+	f := func(namespace, s string, namesExpected []string) {
+		t.Helper()
+
+		tl := NewTemplateLibrary()
+		if err := tl.Add(namespace, s); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		names := tl.Names()
+		if !reflect.DeepEqual(names, namesExpected) {
+			t.Fatalf("unexpected names;\ngot\n%q\nwant\n%q", names, namesExpected)
+		}
+	}
+
+	f("", ``, []string{})
+	f("", `# only comments`, []string{})
+	f("", `x = foo`, []string{"x"})
+	f("", `x = foo, f(a, b) = a + b,`, []string{"x", "f"})
+	f("slo", `
+		# error ratio for the given job
+		error_ratio(job) = sum(rate(errors_total{job=job}[5m])) / sum(rate(requests_total{job=job}[5m])),
+		burn(window) = slo.error_ratio("api")[window:] / 0.001
+	`, []string{"slo.error_ratio", "slo.burn"})
+}
+
+func TestTemplateLibraryAddError(t *testing.T) {
+	f := func(namespace, s string) {
+		t.Helper()
+
+		tl := NewTemplateLibrary()
+		if err := tl.Add(namespace, s); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+		if names := tl.Names(); len(names) > 0 {
+			t.Fatalf("unexpected templates added on error: %q", names)
+		}
+	}
+
+	// invalid namespace
+	f("a.b", `x = foo`)
+	f("1a", `x = foo`)
+	f("a-b", `x = foo`)
+
+	// invalid templates
+	f("", `x`)
+	f("", `x = `)
+	f("", `x = foo y = bar`)
+	f("", `f(a, a) = a`)
+	f("", `x = foo{`)
+
+	// duplicate names
+	f("", `x = foo, x = bar`)
+	f("ns", `x = foo, x = bar`)
+}
+
+func TestTemplateLibraryAddDuplicate(t *testing.T) {
+	tl := NewTemplateLibrary()
+	if err := tl.Add("", `x = foo`); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := tl.Add("", `y = bar, x = baz`); err == nil {
+		t.Fatalf("expecting non-nil error for duplicate template name")
+	}
+	if err := tl.Add("ns", `x = baz`); err != nil {
+		t.Fatalf("unexpected error for namespaced template: %s", err)
+	}
+	namesExpected := []string{"x", "ns.x"}
+	if names := tl.Names(); !reflect.DeepEqual(names, namesExpected) {
+		t.Fatalf("unexpected names;\ngot\n%q\nwant\n%q", names, namesExpected)
+	}
+}
+
+func TestTemplateLibraryAddFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metricsql")
+	if err != nil {
+		t.Fatalf("cannot create temporary dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "templates.metricsql")
+	if err := ioutil.WriteFile(path, []byte("f(x) = x * 2,\ng(x) = f(x) + 1\n"), 0600); err != nil {
+		t.Fatalf("cannot write templates file: %s", err)
+	}
+	tl := NewTemplateLibrary()
+	if err := tl.AddFile("", path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := tl.AddFile("", filepath.Join(dir, "missing")); err == nil {
+		t.Fatalf("expecting non-nil error for missing file")
+	}
+	e, err := ParseWithOptions(`g(foo)`, &ParseOptions{Templates: tl})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	result := string(e.AppendString(nil))
+	resultExpected := `(foo * 2) + 1`
+	if result != resultExpected {
+		t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+	}
+}
+
+func TestParseWithOptionsSuccess(t *testing.T) {
+	tl := NewTemplateLibrary()
+	if err := tl.Add("", `error_ratio(job) = sum(rate(errors_total{job=job}[5m])) / sum(rate(requests_total{job=job}[5m]))`); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := tl.Add("slo", `burn(window) = max_over_time(error_ratio("api")[window:]) / 0.001, used(q) = ru(q, 10)`); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	f := func(s string, opts *ParseOptions, resultExpected string) {
+		t.Helper()
+
+		e, err := ParseWithOptions(s, opts)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %q: %s", s, err)
+		}
+		result := string(e.AppendString(nil))
+		if result != resultExpected {
+			t.Fatalf("unexpected result for %q;\ngot\n%s\nwant\n%s", s, result, resultExpected)
+		}
+	}
+
+	// nil opts are equivalent to Parse
+	f(`ru(a, b)`, nil, `(clamp_min(b - clamp_min(a, 0), 0) / clamp_min(b, 0)) * 100`)
+	f(`ru(a, b)`, &ParseOptions{}, `(clamp_min(b - clamp_min(a, 0), 0) / clamp_min(b, 0)) * 100`)
+
+	// templates from library
+	f(`error_ratio("foo")`, &ParseOptions{Templates: tl}, `sum(rate(errors_total{job="foo"}[5m])) / sum(rate(requests_total{job="foo"}[5m]))`)
+	f(`slo.burn(1h) > 1`, &ParseOptions{Templates: tl},
+		`(max_over_time((sum(rate(errors_total{job="api"}[5m])) / sum(rate(requests_total{job="api"}[5m])))[1h:]) / 0.001) > 1`)
+	f(`slo.used(foo)`, &ParseOptions{Templates: tl}, `(clamp_min(10 - clamp_min(foo, 0), 0) / 10) * 100`)
+
+	// WITH expressions in the query override templates from library
+	f(`with (error_ratio(x) = x) error_ratio(foo)`, &ParseOptions{Templates: tl}, `foo`)
+
+	// disabled built-in templates
+	f(`range_median(foo)`, &ParseOptions{Templates: tl}, `range_quantile(0.5, foo)`)
+	f(`error_ratio("x")`, &ParseOptions{Templates: tl, DisableDefaultTemplates: true},
+		`sum(rate(errors_total{job="x"}[5m])) / sum(rate(requests_total{job="x"}[5m]))`)
+}
+
+func TestParseWithOptionsError(t *testing.T) {
+	f := func(s string, opts *ParseOptions, kindExpected ParseErrorKind) {
+		t.Helper()
+
+		e, err := ParseWithOptions(s, opts)
+		if err == nil {
+			t.Fatalf("expecting non-nil error when parsing %q; got %s", s, e.AppendString(nil))
+		}
+		pe, ok := err.(*ParseError)
+		if !ok {
+			t.Fatalf("unexpected error type %T; want *ParseError", err)
+		}
+		if pe.Kind != kindExpected {
+			t.Fatalf("unexpected error kind for %q; got %s; want %s", s, pe.Kind, kindExpected)
+		}
+	}
+
+	// built-in templates are disabled
+	f(`ru(a, b)`, &ParseOptions{DisableDefaultTemplates: true}, ParseErrorUnknownFunc)
+	f(`alias(foo, "bar")`, &ParseOptions{DisableDefaultTemplates: true}, ParseErrorUnknownFunc)
+
+	// unknown namespace
+	tl := NewTemplateLibrary()
+	if err := tl.Add("ns", `f(x) = x`); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	f(`f(foo)`, &ParseOptions{Templates: tl}, ParseErrorUnknownFunc)
+	f(`other.f(foo)`, &ParseOptions{Templates: tl}, ParseErrorUnknownFunc)
+	f(`ns.f(foo, bar)`, &ParseOptions{Templates: tl}, ParseErrorBadWithTemplate)
+
+	// library templates clash with built-in templates
+	tlClash := NewTemplateLibrary()
+	if err := tlClash.Add("", `ru(x) = x`); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	f(`foo`, &ParseOptions{Templates: tlClash}, ParseErrorDuplicateWithArg)
+	if _, err := ParseWithOptions(`ru(foo)`, &ParseOptions{Templates: tlClash, DisableDefaultTemplates: true}); err != nil {
+		t.Fatalf("unexpected error when built-in templates are disabled: %s", err)
+	}
+}
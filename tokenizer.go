@@ -0,0 +1,334 @@
+package metricsql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TokenKind is the kind of Token returned from Tokenize.
+type TokenKind int
+
+const (
+	// TokenWhitespace is a sequence of whitespace chars.
+	TokenWhitespace TokenKind = iota
+
+	// TokenComment is a comment starting with '#' till the end of line.
+	TokenComment
+
+	// TokenIdent is an identifier such as metric name or WITH template name.
+	TokenIdent
+
+	// TokenKeyword is a keyword such as `by`, `without`, `on`, `ignoring`, `group_left`, `group_right`, `bool`,
+	// `offset`, `limit`, `keep_metric_names`, `prefix` or `with`.
+	TokenKeyword
+
+	// TokenFunc is a function name.
+	TokenFunc
+
+	// TokenLabel is a label name in label filters or in `by`, `without`, `on`, `ignoring`, `group_left` and `group_right` modifiers.
+	TokenLabel
+
+	// TokenString is a string literal.
+	TokenString
+
+	// TokenNumber is a number such as `123`, `0x1f`, `1.5Ki`, `Inf` or `NaN`.
+	TokenNumber
+
+	// TokenDuration is a duration such as `5m`, `1h30m` or `1i`.
+	TokenDuration
+
+	// TokenOperator is a binary operator such as `+`, `==`, `and` or `or`, label filter operator such as `=~`, or `@` modifier.
+	TokenOperator
+
+	// TokenPunctuation is one of `(`, `)`, `{`, `}`, `[`, `]`, `,` or `:`.
+	TokenPunctuation
+
+	// TokenInvalid is the part of the query, which cannot be recognized as a valid token.
+	TokenInvalid
+)
+
+var tokenKindNames = [...]string{
+	TokenWhitespace:  "whitespace",
+	TokenComment:     "comment",
+	TokenIdent:       "ident",
+	TokenKeyword:     "keyword",
+	TokenFunc:        "func",
+	TokenLabel:       "label",
+	TokenString:      "string",
+	TokenNumber:      "number",
+	TokenDuration:    "duration",
+	TokenOperator:    "operator",
+	TokenPunctuation: "punctuation",
+	TokenInvalid:     "invalid",
+}
+
+// String returns string representation for k.
+func (k TokenKind) String() string {
+	if k < 0 || int(k) >= len(tokenKindNames) {
+		return fmt.Sprintf("TokenKind(%d)", int(k))
+	}
+	return tokenKindNames[k]
+}
+
+// Token is a token returned from Tokenize.
+type Token struct {
+	// Kind is the kind of the token.
+	Kind TokenKind
+
+	// S is the original text of the token.
+	S string
+
+	// Pos is the position of the token in the query.
+	Pos Pos
+}
+
+// Tokenize splits MetricsQL query s into tokens.
+//
+// The returned tokens cover the whole s including whitespace and comments, so the original query
+// can be obtained by concatenating S for all the tokens. Tokenize uses the same scanner as Parse,
+// so it recognizes hex numbers, numbers with `Ki`, `Mi` and similar suffixes, durations with `i` suffix,
+// escaped identifiers and all the string literal kinds in the same way as Parse does.
+//
+// Tokenize doesn't stop at invalid tokens. They are returned with TokenInvalid kind,
+// while the corresponding errors are returned in the error list.
+//
+// Tokenize is intended for syntax highlighting, so it doesn't parse the query. The kind of identifiers
+// is detected from the surrounding tokens, e.g. an identifier followed by `(` is a function name,
+// while an identifier followed by label filter operator inside `{...}` is a label name.
+func Tokenize(s string) ([]Token, []*ParseError) {
+	var lex lexer
+	lex.Init(s)
+
+	var tokens []Token
+	var errs []*ParseError
+	var brackets []byte
+	addToken := func(kind TokenKind, start, end int) {
+		tokens = append(tokens, Token{
+			Kind: kind,
+			S:    s[start:end],
+			Pos:  lex.Pos(start, end),
+		})
+	}
+	offset := 0
+	for offset < len(s) {
+		tail := s[offset:]
+		if n := scanSpace(tail); n > 0 {
+			addToken(TokenWhitespace, offset, offset+n)
+			offset += n
+			continue
+		}
+		if n := scanComment(tail); n > 0 {
+			addToken(TokenComment, offset, offset+n)
+			offset += n
+			continue
+		}
+		n, errKind, err := scanToken(tail)
+		if err != nil {
+			addToken(TokenInvalid, offset, offset+n)
+			errs = append(errs, newParseError(errKind, tail[:n], lex.Pos(offset, offset+n), "%w", err))
+			offset += n
+			continue
+		}
+		token := tail[:n]
+		switch token {
+		case "(", "{", "[":
+			brackets = append(brackets, token[0])
+		case ")", "}", "]":
+			if len(brackets) > 0 {
+				brackets = brackets[:len(brackets)-1]
+			}
+		}
+		if token[0] == ':' && len(brackets) > 0 && brackets[len(brackets)-1] == '[' {
+			// The lexer returns the subquery step together with the leading colon, e.g. `:1m` for `[5m:1m]`.
+			// Split it in the same way as the parser does.
+			addToken(TokenPunctuation, offset, offset+1)
+			if n > 1 {
+				kind := TokenIdent
+				if isPositiveDuration(token[1:]) {
+					kind = TokenDuration
+				}
+				addToken(kind, offset+1, offset+n)
+			}
+		} else {
+			addToken(getTokenKind(token), offset, offset+n)
+		}
+		offset += n
+	}
+	classifyIdents(tokens)
+	return tokens, errs
+}
+
+// getTokenKind returns the kind for the valid token returned from scanToken.
+//
+// TokenIdent is returned for all the identifiers. Use classifyIdents for detecting their actual kind.
+func getTokenKind(token string) TokenKind {
+	switch token[0] {
+	case '{', '}', '[', ']', '(', ')', ',':
+		return TokenPunctuation
+	case '@':
+		return TokenOperator
+	}
+	if isIdentPrefix(token) {
+		if isInfOrNaN(token) {
+			return TokenNumber
+		}
+		return TokenIdent
+	}
+	if isStringPrefix(token) {
+		return TokenString
+	}
+	if scanBinaryOpPrefix(token) == len(token) || scanTagFilterOpPrefix(token) == len(token) {
+		return TokenOperator
+	}
+	if isPositiveDuration(token) {
+		return TokenDuration
+	}
+	return TokenNumber
+}
+
+// classifyIdents detects the actual kind for TokenIdent tokens according to the surrounding tokens.
+func classifyIdents(tokens []Token) {
+	// brackets contains the open brackets for the current token.
+	var brackets []string
+
+	// labelListDepth is the depth of brackets for the label list in `by(...)`, `on(...)` and similar modifiers.
+	// It is set to -1 outside such lists.
+	labelListDepth := -1
+
+	for i := range tokens {
+		t := &tokens[i]
+		switch t.S {
+		case "(", "{", "[":
+			brackets = append(brackets, t.S)
+			continue
+		case ")", "}", "]":
+			if len(brackets) > 0 {
+				brackets = brackets[:len(brackets)-1]
+			}
+			if len(brackets) < labelListDepth {
+				labelListDepth = -1
+			}
+			continue
+		}
+		if t.Kind != TokenIdent {
+			continue
+		}
+		prev := getPrevSignificantToken(tokens, i)
+		next := getNextSignificantToken(tokens, i)
+		if labelListDepth >= 0 && len(brackets) == labelListDepth {
+			t.Kind = TokenLabel
+			continue
+		}
+		if len(brackets) > 0 && brackets[len(brackets)-1] == "{" {
+			// Label filters
+			switch {
+			case next != nil && next.Kind == TokenOperator && scanTagFilterOpPrefix(next.S) == len(next.S):
+				t.Kind = TokenLabel
+			case strings.ToLower(t.S) == "or":
+				t.Kind = TokenOperator
+			}
+			continue
+		}
+		t.Kind = getIdentKind(t.S, prev, next)
+		if t.Kind == TokenKeyword && next != nil && next.S == "(" && isLabelListModifier(t.S) {
+			labelListDepth = len(brackets) + 1
+		}
+	}
+}
+
+// getIdentKind returns the kind for the identifier s outside label filters with the given prev and next significant tokens.
+func getIdentKind(s string, prev, next *Token) TokenKind {
+	sLower := strings.ToLower(s)
+	nextIsParen := next != nil && next.S == "("
+	switch {
+	case isAggrFuncModifier(s):
+		// `sum by (job) (foo)` or `sum(foo) by (job)`
+		if nextIsParen && prev != nil && (prev.S == ")" || prev.Kind == TokenFunc && IsAggrFunc(prev.S)) {
+			return TokenKeyword
+		}
+	case isBinaryOpGroupModifier(s):
+		// `foo + on (job) bar` or `foo > bool on (job) bar`
+		if nextIsParen && prev != nil && (prev.Kind == TokenOperator || prev.Kind == TokenKeyword && isBinaryOpBoolModifier(prev.S)) {
+			return TokenKeyword
+		}
+	case sLower == "with":
+		if nextIsParen {
+			return TokenKeyword
+		}
+	case isBinaryOpJoinModifier(s):
+		if nextIsParen || prev != nil && prev.S == ")" {
+			return TokenKeyword
+		}
+	case isBinaryOpBoolModifier(s):
+		if prev != nil && prev.Kind == TokenOperator && IsBinaryOpCmp(prev.S) {
+			return TokenKeyword
+		}
+	case isOffset(s):
+		if endsOperand(prev) {
+			return TokenKeyword
+		}
+	case sLower == "limit", sLower == "keep_metric_names":
+		if prev != nil && prev.S == ")" {
+			return TokenKeyword
+		}
+	case sLower == "prefix":
+		if prev != nil && (prev.S == ")" || isBinaryOpJoinModifier(prev.S)) && next != nil && next.Kind == TokenString {
+			return TokenKeyword
+		}
+	}
+	if isBinaryOp(sLower) && endsOperand(prev) {
+		return TokenOperator
+	}
+	if nextIsParen {
+		return TokenFunc
+	}
+	if IsAggrFunc(sLower) && next != nil && isAggrFuncModifier(next.S) {
+		// Aggregate function with modifier before args, e.g. `sum by (job) (foo)`
+		return TokenFunc
+	}
+	return TokenIdent
+}
+
+// isLabelListModifier returns true if s is a modifier with the list of label names in parens.
+func isLabelListModifier(s string) bool {
+	return isAggrFuncModifier(s) || isBinaryOpGroupModifier(s) || isBinaryOpJoinModifier(s)
+}
+
+// endsOperand returns true if t may be the last token of binary operation operand.
+func endsOperand(t *Token) bool {
+	if t == nil {
+		return false
+	}
+	switch t.Kind {
+	case TokenIdent, TokenNumber, TokenDuration, TokenString:
+		return true
+	case TokenKeyword:
+		return strings.ToLower(t.S) == "keep_metric_names"
+	case TokenPunctuation:
+		return t.S == ")" || t.S == "}" || t.S == "]"
+	default:
+		return false
+	}
+}
+
+func getPrevSignificantToken(tokens []Token, i int) *Token {
+	for i--; i >= 0; i-- {
+		if isSignificantToken(&tokens[i]) {
+			return &tokens[i]
+		}
+	}
+	return nil
+}
+
+func getNextSignificantToken(tokens []Token, i int) *Token {
+	for i++; i < len(tokens); i++ {
+		if isSignificantToken(&tokens[i]) {
+			return &tokens[i]
+		}
+	}
+	return nil
+}
+
+func isSignificantToken(t *Token) bool {
+	return t.Kind != TokenWhitespace && t.Kind != TokenComment && t.Kind != TokenInvalid
+}
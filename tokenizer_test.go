@@ -0,0 +1,120 @@
+package metricsql
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	f := func(s, resultExpected string) {
+		t.Helper()
+
+		tokens, errs := Tokenize(s)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors in Tokenize(%q): %v", s, errs)
+		}
+		var a []string
+		var b []byte
+		for _, tok := range tokens {
+			b = append(b, tok.S...)
+			if tok.S != s[tok.Pos.Offset:tok.Pos.End] {
+				t.Fatalf("unexpected position %d..%d for token %q in %q", tok.Pos.Offset, tok.Pos.End, tok.S, s)
+			}
+			if tok.Kind == TokenWhitespace {
+				continue
+			}
+			a = append(a, fmt.Sprintf("%s:%s", tok.Kind, tok.S))
+		}
+		if string(b) != s {
+			t.Fatalf("tokens don't cover the original query;\ngot\n%s\nwant\n%s", b, s)
+		}
+		result := strings.Join(a, " ")
+		if result != resultExpected {
+			t.Fatalf("unexpected tokens for %q;\ngot\n%s\nwant\n%s", s, result, resultExpected)
+		}
+	}
+
+	f(``, ``)
+	f(`foo`, `ident:foo`)
+	f(`foo{job="api", instance!~"host.+"}`, `ident:foo punctuation:{ label:job operator:= string:"api" punctuation:, label:instance operator:!~ string:"host.+" punctuation:}`)
+	f(`{x="a" or y='b'}`, `punctuation:{ label:x operator:= string:"a" operator:or label:y operator:= string:'b' punctuation:}`)
+	f("{`foo`}", "punctuation:{ string:`foo` punctuation:}")
+	f(`rate(foo[5m:1i]) offset 1h @ end()`, `func:rate punctuation:( ident:foo punctuation:[ duration:5m punctuation:: duration:1i punctuation:] punctuation:) keyword:offset duration:1h operator:@ func:end punctuation:( punctuation:)`)
+	f(`foo[5m:]`, `ident:foo punctuation:[ duration:5m punctuation:: punctuation:]`)
+	f(`foo[:1m]`, `ident:foo punctuation:[ punctuation:: duration:1m punctuation:]`)
+	f(`sum by (job, le) (foo) limit 10`, `func:sum keyword:by punctuation:( label:job punctuation:, label:le punctuation:) punctuation:( ident:foo punctuation:) keyword:limit number:10`)
+	f(`sum(foo) without (instance)`, `func:sum punctuation:( ident:foo punctuation:) keyword:without punctuation:( label:instance punctuation:)`)
+	f(`a / on(x) group_left(y) prefix "p_" b`, `ident:a operator:/ keyword:on punctuation:( label:x punctuation:) keyword:group_left punctuation:( label:y punctuation:) keyword:prefix string:"p_" ident:b`)
+	f(`a * ignoring(x) group_right b`, `ident:a operator:* keyword:ignoring punctuation:( label:x punctuation:) keyword:group_right ident:b`)
+	f(`a >bool 0x1f`, `ident:a operator:> keyword:bool number:0x1f`)
+	f(`a and b or c unless d`, `ident:a operator:and ident:b operator:or ident:c operator:unless ident:d`)
+	f(`a default 1.5Ki`, `ident:a operator:default number:1.5Ki`)
+	f(`2Mi + 3Gb - 4k * Inf / nan`, `number:2Mi operator:+ number:3Gb operator:- number:4k operator:* number:Inf operator:/ number:nan`)
+	f(`-1.5e3`, `operator:- number:1.5e3`)
+	f(`1h30m`, `duration:1h30m`)
+	f(`rate(foo[5m]) keep_metric_names or bar`, `func:rate punctuation:( ident:foo punctuation:[ duration:5m punctuation:] punctuation:) keyword:keep_metric_names operator:or ident:bar`)
+	f(`foo\-bar{x\.y="z"}`, `ident:foo\-bar punctuation:{ label:x\.y operator:= string:"z" punctuation:}`)
+	f(`with (f(x) = x + 1) f(foo)`, `keyword:with punctuation:( func:f punctuation:( ident:x punctuation:) operator:= ident:x operator:+ number:1 punctuation:) func:f punctuation:( ident:foo punctuation:)`)
+	f(`with (cf = {job="a"}) foo{cf}`, `keyword:with punctuation:( ident:cf operator:= punctuation:{ label:job operator:= string:"a" punctuation:} punctuation:) ident:foo punctuation:{ ident:cf punctuation:}`)
+
+	// identifiers, which look like keywords
+	f(`offset`, `ident:offset`)
+	f(`by + on`, `ident:by operator:+ ident:on`)
+	f(`bool{x="y"}`, `ident:bool punctuation:{ label:x operator:= string:"y" punctuation:}`)
+	f(`or`, `ident:or`)
+	f(`{or="a"}`, `punctuation:{ label:or operator:= string:"a" punctuation:}`)
+	f(`foo + by(x)`, `ident:foo operator:+ func:by punctuation:( ident:x punctuation:)`)
+	f(`on(x) + foo`, `func:on punctuation:( ident:x punctuation:) operator:+ ident:foo`)
+	f(`a > bool on(x) b`, `ident:a operator:> keyword:bool keyword:on punctuation:( label:x punctuation:) ident:b`)
+
+	// comments
+	f("foo # comment\n+ bar # another", `ident:foo comment:# comment operator:+ ident:bar comment:# another`)
+}
+
+func TestTokenizePos(t *testing.T) {
+	tokens, _ := Tokenize("foo\n  + bar")
+	tok := tokens[len(tokens)-1]
+	if tok.S != "bar" {
+		t.Fatalf("unexpected last token; got %q; want %q", tok.S, "bar")
+	}
+	posExpected := Pos{
+		Offset: 8,
+		End:    11,
+		Line:   2,
+		Column: 5,
+	}
+	if tok.Pos != posExpected {
+		t.Fatalf("unexpected position for %q; got %+v; want %+v", tok.S, tok.Pos, posExpected)
+	}
+}
+
+func TestTokenizeInvalid(t *testing.T) {
+	f := func(s, resultExpected string, errKindsExpected ...ParseErrorKind) {
+		t.Helper()
+
+		tokens, errs := Tokenize(s)
+		var a []string
+		for _, tok := range tokens {
+			if tok.Kind != TokenWhitespace {
+				a = append(a, fmt.Sprintf("%s:%s", tok.Kind, tok.S))
+			}
+		}
+		result := strings.Join(a, " ")
+		if result != resultExpected {
+			t.Fatalf("unexpected tokens for %q;\ngot\n%s\nwant\n%s", s, result, resultExpected)
+		}
+		if len(errs) != len(errKindsExpected) {
+			t.Fatalf("unexpected number of errors for %q; got %d; want %d; errors: %v", s, len(errs), len(errKindsExpected), errs)
+		}
+		for i, err := range errs {
+			if err.Kind != errKindsExpected[i] {
+				t.Fatalf("unexpected error kind #%d for %q; got %s; want %s", i, s, err.Kind, errKindsExpected[i])
+			}
+		}
+	}
+
+	f(`foo $ bar`, `ident:foo invalid:$ ident:bar`, ParseErrorInvalidToken)
+	f(`foo{x="bar}`, `ident:foo punctuation:{ label:x operator:= invalid:"bar}`, ParseErrorBadString)
+	f(`1e + foo`, `invalid:1e operator:+ ident:foo`, ParseErrorBadNumber)
+}
@@ -1,126 +1,296 @@
 package metricsql
 
 import (
+	"math"
+	"strconv"
 	"strings"
+	"time"
 )
 
-var transformFuncs = map[string]bool{
-	"":                           true, // empty func is a synonym to union
-	"abs":                        true,
-	"absent":                     true,
-	"acos":                       true,
-	"acosh":                      true,
-	"asin":                       true,
-	"asinh":                      true,
-	"atan":                       true,
-	"atanh":                      true,
-	"bitmap_and":                 true,
-	"bitmap_or":                  true,
-	"bitmap_xor":                 true,
-	"buckets_limit":              true,
-	"ceil":                       true,
-	"clamp":                      true,
-	"clamp_max":                  true,
-	"clamp_min":                  true,
-	"cos":                        true,
-	"cosh":                       true,
-	"day_of_month":               true,
-	"day_of_week":                true,
-	"days_in_month":              true,
-	"deg":                        true,
-	"drop_common_labels":         true,
-	"drop_empty_series":          true,
-	"end":                        true,
-	"exp":                        true,
-	"floor":                      true,
-	"histogram_avg":              true,
-	"histogram_quantile":         true,
-	"histogram_quantiles":        true,
-	"histogram_share":            true,
-	"histogram_stddev":           true,
-	"histogram_stdvar":           true,
-	"hour":                       true,
-	"interpolate":                true,
-	"keep_last_value":            true,
-	"keep_next_value":            true,
-	"label_copy":                 true,
-	"label_del":                  true,
-	"label_graphite_group":       true,
-	"label_join":                 true,
-	"label_keep":                 true,
-	"label_lowercase":            true,
-	"label_map":                  true,
-	"label_match":                true,
-	"label_mismatch":             true,
-	"label_move":                 true,
-	"label_replace":              true,
-	"label_set":                  true,
-	"label_transform":            true,
-	"label_uppercase":            true,
-	"label_value":                true,
-	"labels_equal":               true,
-	"limit_offset":               true,
-	"ln":                         true,
-	"log2":                       true,
-	"log10":                      true,
-	"minute":                     true,
-	"month":                      true,
-	"now":                        true,
-	"pi":                         true,
-	"prometheus_buckets":         true,
-	"rad":                        true,
-	"rand":                       true,
-	"rand_exponential":           true,
-	"rand_normal":                true,
-	"range_avg":                  true,
-	"range_first":                true,
-	"range_last":                 true,
-	"range_linear_regression":    true,
-	"range_mad":                  true,
-	"range_max":                  true,
-	"range_min":                  true,
-	"range_normalize":            true,
-	"range_quantile":             true,
-	"range_stddev":               true,
-	"range_stdvar":               true,
-	"range_sum":                  true,
-	"range_trim_outliers":        true,
-	"range_trim_spikes":          true,
-	"range_trim_zscore":          true,
-	"range_zscore":               true,
-	"remove_resets":              true,
-	"round":                      true,
-	"running_avg":                true,
-	"running_max":                true,
-	"running_min":                true,
-	"running_sum":                true,
-	"scalar":                     true,
-	"sgn":                        true,
-	"sin":                        true,
-	"sinh":                       true,
-	"smooth_exponential":         true,
-	"sort":                       true,
-	"sort_by_label":              true,
-	"sort_by_label_desc":         true,
-	"sort_by_label_numeric":      true,
-	"sort_by_label_numeric_desc": true,
-	"sort_desc":                  true,
-	"sqrt":                       true,
-	"start":                      true,
-	"step":                       true,
-	"tan":                        true,
-	"tanh":                       true,
-	"time":                       true,
+var transformFuncs = setFuncKind(FuncKindTransform, []*FuncSignature{
+	{Name: "", MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector}, KeepMetricNames: true, Description: "returns the union of the given series; it is a synonym to union()"},
+	{Name: "abs", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns the absolute value for every point of every series"},
+	{Name: "absent", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, ChangesLabels: true, PromQL: true, Description: "returns 1 if the given series is empty"},
+	{Name: "acos", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns inverse cosine for every point of every series"},
+	{Name: "acosh", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns inverse hyperbolic cosine for every point of every series"},
+	{Name: "asin", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns inverse sine for every point of every series"},
+	{Name: "asinh", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns inverse hyperbolic sine for every point of every series"},
+	{Name: "atan", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns inverse tangent for every point of every series"},
+	{Name: "atanh", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns inverse hyperbolic tangent for every point of every series"},
+	{Name: "bitmap_and", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindInstantVector, ArgKindScalar}, Description: "returns bitwise AND of every point of every series and the given mask"},
+	{Name: "bitmap_or", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindInstantVector, ArgKindScalar}, Description: "returns bitwise OR of every point of every series and the given mask"},
+	{Name: "bitmap_xor", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindInstantVector, ArgKindScalar}, Description: "returns bitwise XOR of every point of every series and the given mask"},
+	{Name: "buckets_limit", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindScalar, ArgKindInstantVector}, KeepMetricNames: true, Description: "limits the number of histogram buckets to the given limit"},
+	{Name: "ceil", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, KeepMetricNames: true, PromQL: true, Description: "rounds every point of every series to the upper nearest integer"},
+	{Name: "clamp", MinArgs: 3, MaxArgs: 3, ArgKinds: []ArgKind{ArgKindInstantVector, ArgKindScalar, ArgKindScalar}, KeepMetricNames: true, PromQL: true, Description: "clamps every point of every series to the given min and max values"},
+	{Name: "clamp_max", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindInstantVector, ArgKindScalar}, KeepMetricNames: true, PromQL: true, Description: "clamps every point of every series to the given max value"},
+	{Name: "clamp_min", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindInstantVector, ArgKindScalar}, KeepMetricNames: true, PromQL: true, Description: "clamps every point of every series to the given min value"},
+	{Name: "cos", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns cosine for every point of every series"},
+	{Name: "cosh", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns hyperbolic cosine for every point of every series"},
+	{Name: "day_of_month", MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns the day of month for every point of every series or for the current time"},
+	{Name: "day_of_week", MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns the day of week for every point of every series or for the current time"},
+	{Name: "days_in_month", MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns the number of days in the month for every point of every series or for the current time"},
+	{Name: "deg", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "converts radians to degrees for every point of every series"},
+	{Name: "drop_common_labels", MinArgs: 1, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector}, KeepMetricNames: true, ChangesLabels: true, Description: "drops labels with identical values across all the given series"},
+	{Name: "drop_empty_series", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, KeepMetricNames: true, Description: "drops series without points"},
+	{Name: "end", ReturnKind: ArgKindScalar, Description: "returns the end timestamp of the selected time range in seconds"},
+	{Name: "exp", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns the exponent for every point of every series"},
+	{Name: "floor", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, KeepMetricNames: true, PromQL: true, Description: "rounds every point of every series to the lower nearest integer"},
+	{Name: "histogram_avg", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, Description: "returns the average value for the given histogram buckets"},
+	{Name: "histogram_quantile", MinArgs: 2, MaxArgs: 3, ArgKinds: []ArgKind{ArgKindScalar, ArgKindInstantVector, ArgKindLabelName}, PromQL: true, Description: "returns the phi-quantile for the given histogram buckets"},
+	{Name: "histogram_quantiles", MinArgs: 3, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindLabelName, ArgKindScalar, ArgKindInstantVector}, VariadicArgIdx: 1, Description: "returns the given phi-quantiles for the given histogram buckets with the phi stored in the given label"},
+	{Name: "histogram_share", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindScalar, ArgKindInstantVector}, Description: "returns the share of histogram bucket values, which don't exceed le"},
+	{Name: "histogram_stddev", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, Description: "returns standard deviation for the given histogram buckets"},
+	{Name: "histogram_stdvar", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, Description: "returns standard variance for the given histogram buckets"},
+	{Name: "hour", MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns the hour for every point of every series or for the current time"},
+	{Name: "interpolate", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, KeepMetricNames: true, Description: "fills gaps in every series with linearly interpolated values"},
+	{Name: "keep_last_value", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, KeepMetricNames: true, Description: "fills gaps in every series with the previous non-empty value"},
+	{Name: "keep_next_value", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, KeepMetricNames: true, Description: "fills gaps in every series with the next non-empty value"},
+	{Name: "label_copy", MinArgs: 1, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector, ArgKindLabelName}, VariadicArgIdx: 1, KeepMetricNames: true, ChangesLabels: true, Description: "copies values for the given src labels to the given dst labels"},
+	{Name: "label_del", MinArgs: 1, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector, ArgKindLabelName}, VariadicArgIdx: 1, KeepMetricNames: true, ChangesLabels: true, Description: "deletes the given labels"},
+	{Name: "label_graphite_group", MinArgs: 1, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector, ArgKindScalar}, VariadicArgIdx: 1, KeepMetricNames: true, ChangesLabels: true, Description: "replaces metric names with the given groups from Graphite metric names"},
+	{Name: "label_join", MinArgs: 3, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector, ArgKindLabelName, ArgKindString, ArgKindLabelName}, VariadicArgIdx: 3, KeepMetricNames: true, ChangesLabels: true, PromQL: true, Description: "joins the given src labels with the given separator and stores the result in the dst label"},
+	{Name: "label_keep", MinArgs: 1, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector, ArgKindLabelName}, VariadicArgIdx: 1, KeepMetricNames: true, ChangesLabels: true, Description: "keeps only the given labels"},
+	{Name: "label_lowercase", MinArgs: 1, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector, ArgKindLabelName}, VariadicArgIdx: 1, KeepMetricNames: true, ChangesLabels: true, Description: "converts values for the given labels to lowercase"},
+	{Name: "label_map", MinArgs: 2, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector, ArgKindLabelName, ArgKindString}, VariadicArgIdx: 2, KeepMetricNames: true, ChangesLabels: true, Description: "maps values for the given label from src to dst pairs"},
+	{Name: "label_match", MinArgs: 3, MaxArgs: 3, ArgKinds: []ArgKind{ArgKindInstantVector, ArgKindLabelName, ArgKindString}, KeepMetricNames: true, Description: "drops series with the given label value not matching the given regexp"},
+	{Name: "label_mismatch", MinArgs: 3, MaxArgs: 3, ArgKinds: []ArgKind{ArgKindInstantVector, ArgKindLabelName, ArgKindString}, KeepMetricNames: true, Description: "drops series with the given label value matching the given regexp"},
+	{Name: "label_move", MinArgs: 1, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector, ArgKindLabelName}, VariadicArgIdx: 1, KeepMetricNames: true, ChangesLabels: true, Description: "moves the given src labels to the given dst labels"},
+	{Name: "label_replace", MinArgs: 5, MaxArgs: 5, ArgKinds: []ArgKind{ArgKindInstantVector, ArgKindLabelName, ArgKindString, ArgKindLabelName, ArgKindString}, KeepMetricNames: true, ChangesLabels: true, PromQL: true, Description: "applies the given regexp to the src label and stores the replacement in the dst label"},
+	{Name: "label_set", MinArgs: 1, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector, ArgKindString}, VariadicArgIdx: 1, KeepMetricNames: true, ChangesLabels: true, Description: "sets the given labels to the given values"},
+	{Name: "label_transform", MinArgs: 4, MaxArgs: 4, ArgKinds: []ArgKind{ArgKindInstantVector, ArgKindLabelName, ArgKindString, ArgKindString}, KeepMetricNames: true, ChangesLabels: true, Description: "replaces all the substrings matching the given regexp in the given label with the replacement"},
+	{Name: "label_uppercase", MinArgs: 1, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector, ArgKindLabelName}, VariadicArgIdx: 1, KeepMetricNames: true, ChangesLabels: true, Description: "converts values for the given labels to uppercase"},
+	{Name: "label_value", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindInstantVector, ArgKindLabelName}, Description: "returns numeric values for the given label"},
+	{Name: "labels_equal", MinArgs: 3, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector, ArgKindLabelName}, VariadicArgIdx: 1, KeepMetricNames: true, Description: "returns series with identical values for all the given labels"},
+	{Name: "limit_offset", MinArgs: 3, MaxArgs: 3, ArgKinds: []ArgKind{ArgKindScalar, ArgKindScalar, ArgKindInstantVector}, KeepMetricNames: true, Description: "skips the given number of series and returns up to the given limit of the remaining series"},
+	{Name: "ln", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns natural logarithm for every point of every series"},
+	{Name: "log2", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns binary logarithm for every point of every series"},
+	{Name: "log10", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns decimal logarithm for every point of every series"},
+	{Name: "minute", MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns the minute for every point of every series or for the current time"},
+	{Name: "month", MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns the month for every point of every series or for the current time"},
+	{Name: "now", ReturnKind: ArgKindScalar, Description: "returns the current timestamp in seconds"},
+	{Name: "pi", ReturnKind: ArgKindScalar, PromQL: true, Description: "returns Pi number"},
+	{Name: "prometheus_buckets", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, KeepMetricNames: true, Description: "converts VictoriaMetrics histogram buckets to Prometheus buckets with le label"},
+	{Name: "rad", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "converts degrees to radians for every point of every series"},
+	{Name: "rand", MaxArgs: 1, ArgKinds: []ArgKind{ArgKindScalar}, Description: "returns pseudo-random numbers in the range [0...1) with uniform distribution"},
+	{Name: "rand_exponential", MaxArgs: 1, ArgKinds: []ArgKind{ArgKindScalar}, Description: "returns pseudo-random numbers with exponential distribution"},
+	{Name: "rand_normal", MaxArgs: 1, ArgKinds: []ArgKind{ArgKindScalar}, Description: "returns pseudo-random numbers with normal distribution"},
+	{Name: "range_avg", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, KeepMetricNames: true, Description: "returns the average value across points of every series"},
+	{Name: "range_first", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, KeepMetricNames: true, Description: "returns the value for the first point of every series"},
+	{Name: "range_last", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, KeepMetricNames: true, Description: "returns the value for the last point of every series"},
+	{Name: "range_linear_regression", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, KeepMetricNames: true, Description: "calculates simple linear regression over the selected time range for every series"},
+	{Name: "range_mad", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, KeepMetricNames: true, Description: "returns median absolute deviation across points of every series"},
+	{Name: "range_max", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, KeepMetricNames: true, Description: "returns the maximum value across points of every series"},
+	{Name: "range_min", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, KeepMetricNames: true, Description: "returns the minimum value across points of every series"},
+	{Name: "range_normalize", MinArgs: 1, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector}, KeepMetricNames: true, Description: "normalizes every series into [0...1] range"},
+	{Name: "range_quantile", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindScalar, ArgKindInstantVector}, KeepMetricNames: true, Description: "returns the phi-quantile across points of every series"},
+	{Name: "range_stddev", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, KeepMetricNames: true, Description: "returns standard deviation across points of every series"},
+	{Name: "range_stdvar", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, KeepMetricNames: true, Description: "returns standard variance across points of every series"},
+	{Name: "range_sum", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, KeepMetricNames: true, Description: "returns the sum of points of every series"},
+	{Name: "range_trim_outliers", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindScalar, ArgKindInstantVector}, KeepMetricNames: true, Description: "drops points located farther than k*range_mad() from range_median() of every series"},
+	{Name: "range_trim_spikes", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindScalar, ArgKindInstantVector}, KeepMetricNames: true, Description: "drops phi percent of biggest spikes from every series"},
+	{Name: "range_trim_zscore", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindScalar, ArgKindInstantVector}, KeepMetricNames: true, Description: "drops points located farther than z*range_stddev() from range_avg() of every series"},
+	{Name: "range_zscore", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, KeepMetricNames: true, Description: "returns z-score for points of every series"},
+	{Name: "remove_resets", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, Description: "removes counter resets from every series"},
+	{Name: "round", MinArgs: 1, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindInstantVector, ArgKindScalar}, PromQL: true, Description: "rounds every point of every series to the given nearest multiple"},
+	{Name: "running_avg", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, KeepMetricNames: true, Description: "returns the running average for every series"},
+	{Name: "running_max", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, KeepMetricNames: true, Description: "returns the running maximum for every series"},
+	{Name: "running_min", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, KeepMetricNames: true, Description: "returns the running minimum for every series"},
+	{Name: "running_sum", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, Description: "returns the running sum for every series"},
+	{Name: "scalar", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, ReturnKind: ArgKindScalar, ChangesLabels: true, PromQL: true, Description: "returns the given series as scalar if it contains a single series"},
+	{Name: "sgn", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns the sign for every point of every series"},
+	{Name: "sin", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns sine for every point of every series"},
+	{Name: "sinh", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns hyperbolic sine for every point of every series"},
+	{Name: "smooth_exponential", MinArgs: 2, MaxArgs: 2, ArgKinds: []ArgKind{ArgKindInstantVector, ArgKindScalar}, KeepMetricNames: true, Description: "smooths points of every series using exponential moving average with the given smoothing factor"},
+	{Name: "sort", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, KeepMetricNames: true, PromQL: true, Description: "sorts series in ascending order by the last point"},
+	{Name: "sort_by_label", MinArgs: 2, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector, ArgKindLabelName}, VariadicArgIdx: 1, KeepMetricNames: true, Description: "sorts series in ascending order by the given labels"},
+	{Name: "sort_by_label_desc", MinArgs: 2, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector, ArgKindLabelName}, VariadicArgIdx: 1, KeepMetricNames: true, Description: "sorts series in descending order by the given labels"},
+	{Name: "sort_by_label_numeric", MinArgs: 2, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector, ArgKindLabelName}, VariadicArgIdx: 1, KeepMetricNames: true, Description: "sorts series in ascending order by the given labels using numeric sort"},
+	{Name: "sort_by_label_numeric_desc", MinArgs: 2, MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector, ArgKindLabelName}, VariadicArgIdx: 1, KeepMetricNames: true, Description: "sorts series in descending order by the given labels using numeric sort"},
+	{Name: "sort_desc", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, KeepMetricNames: true, PromQL: true, Description: "sorts series in descending order by the last point"},
+	{Name: "sqrt", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns the square root for every point of every series"},
+	{Name: "start", ReturnKind: ArgKindScalar, Description: "returns the start timestamp of the selected time range in seconds"},
+	{Name: "step", ReturnKind: ArgKindScalar, Description: "returns the step in seconds between the returned points"},
+	{Name: "tan", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns tangent for every point of every series"},
+	{Name: "tanh", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns hyperbolic tangent for every point of every series"},
+	{Name: "time", ReturnKind: ArgKindScalar, PromQL: true, Description: "returns the timestamp in seconds for every returned point"},
 	// "timestamp" has been moved to rollup funcs. See https://github.com/VictoriaMetrics/VictoriaMetrics/issues/415
-	"timezone_offset": true,
-	"union":           true,
-	"vector":          true,
-	"year":            true,
-}
+	{Name: "timezone_offset", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindString}, ReturnKind: ArgKindScalar, Description: "returns the offset in seconds for the given timezone relative to UTC"},
+	{Name: "union", MaxArgs: -1, ArgKinds: []ArgKind{ArgKindInstantVector}, KeepMetricNames: true, Description: "returns the union of the given series"},
+	{Name: "vector", MinArgs: 1, MaxArgs: 1, ArgKinds: []ArgKind{ArgKindScalar}, PromQL: true, Description: "converts the given scalar to a series without labels"},
+	{Name: "year", MaxArgs: 1, ArgKinds: []ArgKind{ArgKindInstantVector}, PromQL: true, Description: "returns the year for every point of every series or for the current time"},
+})
 
 // IsTransformFunc returns whether funcName is known transform function.
 func IsTransformFunc(funcName string) bool {
-	s := strings.ToLower(funcName)
-	return transformFuncs[s]
+	return isFuncKind(funcName, FuncKindTransform)
+}
+
+// transformFuncsPure contains pure transform functions, which may be evaluated at parse time if all their args are numbers.
+//
+// Every function must return NaN if it cannot be evaluated for the given args.
+// Functions, which depend on the current time such as time(), now() or rand(), mustn't be added here.
+var transformFuncsPure = map[string]func(args []float64) float64{
+	"abs":           newTransformFuncPureOneArg(math.Abs),
+	"acos":          newTransformFuncPureOneArg(math.Acos),
+	"acosh":         newTransformFuncPureOneArg(math.Acosh),
+	"asin":          newTransformFuncPureOneArg(math.Asin),
+	"asinh":         newTransformFuncPureOneArg(math.Asinh),
+	"atan":          newTransformFuncPureOneArg(math.Atan),
+	"atanh":         newTransformFuncPureOneArg(math.Atanh),
+	"bitmap_and":    newTransformFuncPureBitmap(func(a, b uint64) uint64 { return a & b }),
+	"bitmap_or":     newTransformFuncPureBitmap(func(a, b uint64) uint64 { return a | b }),
+	"bitmap_xor":    newTransformFuncPureBitmap(func(a, b uint64) uint64 { return a ^ b }),
+	"ceil":          newTransformFuncPureOneArg(math.Ceil),
+	"clamp":         transformPureClamp,
+	"clamp_max":     transformPureClampMax,
+	"clamp_min":     transformPureClampMin,
+	"cos":           newTransformFuncPureOneArg(math.Cos),
+	"cosh":          newTransformFuncPureOneArg(math.Cosh),
+	"day_of_month":  newTransformFuncPureDateTime(func(t time.Time) int { return t.Day() }),
+	"day_of_week":   newTransformFuncPureDateTime(func(t time.Time) int { return int(t.Weekday()) }),
+	"days_in_month": newTransformFuncPureDateTime(daysInMonth),
+	"deg":           newTransformFuncPureOneArg(func(v float64) float64 { return v * 180 / math.Pi }),
+	"exp":           newTransformFuncPureOneArg(math.Exp),
+	"floor":         newTransformFuncPureOneArg(math.Floor),
+	"hour":          newTransformFuncPureDateTime(func(t time.Time) int { return t.Hour() }),
+	"ln":            newTransformFuncPureOneArg(math.Log),
+	"log10":         newTransformFuncPureOneArg(math.Log10),
+	"log2":          newTransformFuncPureOneArg(math.Log2),
+	"minute":        newTransformFuncPureDateTime(func(t time.Time) int { return t.Minute() }),
+	"month":         newTransformFuncPureDateTime(func(t time.Time) int { return int(t.Month()) }),
+	"pi":            transformPurePi,
+	"rad":           newTransformFuncPureOneArg(func(v float64) float64 { return v * math.Pi / 180 }),
+	"round":         transformPureRound,
+	"scalar":        newTransformFuncPureOneArg(func(v float64) float64 { return v }),
+	"sgn":           newTransformFuncPureOneArg(sgn),
+	"sin":           newTransformFuncPureOneArg(math.Sin),
+	"sinh":          newTransformFuncPureOneArg(math.Sinh),
+	"sqrt":          newTransformFuncPureOneArg(math.Sqrt),
+	"tan":           newTransformFuncPureOneArg(math.Tan),
+	"tanh":          newTransformFuncPureOneArg(math.Tanh),
+	"year":          newTransformFuncPureDateTime(func(t time.Time) int { return t.Year() }),
+}
+
+// transformFuncEvalNumber evaluates the transform function funcName over the given args.
+//
+// false is returned if funcName cannot be evaluated at parse time or if the number of args is invalid.
+func transformFuncEvalNumber(funcName string, args []float64) (float64, bool) {
+	funcName = strings.ToLower(funcName)
+	f := transformFuncsPure[funcName]
+	if f == nil {
+		return 0, false
+	}
+	fs := getFuncSignature(funcName)
+	if fs == nil || fs.Kind != FuncKindTransform || len(args) < fs.MinArgs || !fs.IsVariadic() && len(args) > fs.MaxArgs {
+		return 0, false
+	}
+	if len(args) == 0 && fs.MaxArgs > 0 {
+		// Functions such as hour() return values for the current time when called without args.
+		return 0, false
+	}
+	return f(args), true
+}
+
+func newTransformFuncPureOneArg(f func(v float64) float64) func(args []float64) float64 {
+	return func(args []float64) float64 {
+		return f(args[0])
+	}
+}
+
+func newTransformFuncPureBitmap(f func(a, b uint64) uint64) func(args []float64) float64 {
+	return func(args []float64) float64 {
+		v, mask := args[0], args[1]
+		if math.IsNaN(v) || math.IsNaN(mask) {
+			return nan
+		}
+		return float64(f(uint64(v), uint64(mask)))
+	}
+}
+
+func newTransformFuncPureDateTime(f func(t time.Time) int) func(args []float64) float64 {
+	return func(args []float64) float64 {
+		v := args[0]
+		if math.IsNaN(v) {
+			return nan
+		}
+		t := time.Unix(int64(v), 0).UTC()
+		return float64(f(t))
+	}
+}
+
+func daysInMonth(t time.Time) int {
+	m := t.Month()
+	if m == 2 && isLeapYear(t.Year()) {
+		return 29
+	}
+	return daysInMonths[m-1]
+}
+
+func isLeapYear(y int) bool {
+	if y%4 != 0 {
+		return false
+	}
+	if y%100 != 0 {
+		return true
+	}
+	return y%400 == 0
+}
+
+var daysInMonths = [...]int{31, 28, 31, 30, 31, 30, 31, 31, 30, 31, 30, 31}
+
+func sgn(v float64) float64 {
+	switch {
+	case v < 0:
+		return -1
+	case v > 0:
+		return 1
+	default:
+		return v
+	}
+}
+
+func transformPurePi(_ []float64) float64 {
+	return math.Pi
+}
+
+func transformPureClamp(args []float64) float64 {
+	v, min, max := args[0], args[1], args[2]
+	if min > max {
+		return nan
+	}
+	return math.Max(min, math.Min(max, v))
+}
+
+func transformPureClampMax(args []float64) float64 {
+	return math.Min(args[0], args[1])
+}
+
+func transformPureClampMin(args []float64) float64 {
+	return math.Max(args[0], args[1])
+}
 
+func transformPureRound(args []float64) float64 {
+	v := args[0]
+	if len(args) == 1 {
+		return math.Floor(v + 0.5)
+	}
+	nearest := args[1]
+	v = math.Floor(v/nearest+0.5) * nearest
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return v
+	}
+	// Drop floating point noise such as 1.2000000000000002 for round(1.234, 0.1)
+	// by limiting the number of decimal digits to the number of decimal digits in nearest.
+	s := strconv.FormatFloat(nearest, 'f', -1, 64)
+	n := strings.IndexByte(s, '.')
+	if n < 0 {
+		return v
+	}
+	s = strconv.FormatFloat(v, 'f', len(s)-n-1, 64)
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nan
+	}
+	return v
 }
@@ -0,0 +1,367 @@
+package metricsql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TranspileOptions contains options for TranspileToPromQL.
+type TranspileOptions struct {
+	// Window is the lookbehind window for rollup functions without explicit window such as `rate(foo)`.
+	//
+	// It is also used as subquery window for rollup functions applied to non-selectors such as `rate(sum(foo))`.
+	// Window must be a PromQL duration such as `5m`. By default `5m` is used.
+	Window string
+
+	// Step is the query step in milliseconds, which is used for converting durations such as `[10i]` to PromQL.
+	//
+	// Durations with `i` suffix cannot be transpiled if Step isn't set.
+	Step int64
+}
+
+// TranspileError is returned from TranspileToPromQL if the query contains MetricsQL extensions without PromQL equivalent.
+type TranspileError struct {
+	// Errs contains errors for all the untranslatable nodes ordered by their position in the query.
+	Errs []*ParseError
+}
+
+// Error implements error interface.
+func (te *TranspileError) Error() string {
+	a := make([]string, len(te.Errs))
+	for i, pe := range te.Errs {
+		if pe.Pos.IsValid() {
+			a[i] = fmt.Sprintf("%s: %s", pe.Pos, pe.Msg)
+		} else {
+			a[i] = pe.Msg
+		}
+	}
+	return fmt.Sprintf("cannot transpile the query to PromQL because of unsupported nodes: %s", strings.Join(a, "; "))
+}
+
+// TranspileToPromQL converts MetricsQL expression e to the closest equivalent PromQL expression.
+//
+// The following conversions are performed:
+//
+//   - Implicit lookbehind windows are filled with opts.Window, e.g. `rate(foo)` is converted to `rate(foo[5m])`.
+//   - Rollup functions over non-selectors are converted to subqueries, e.g. `rate(sum(foo))` is converted to `rate(sum(foo)[5m:])`.
+//   - Label filters with `or` are converted to `or` of selectors, e.g. `foo{a="b" or c="d"}` is converted to `foo{a="b"} or foo{c="d"}`.
+//   - `q default N` is converted to `q or vector(N)`.
+//   - Unions such as `(q1, q2)` are converted to `q1 or q2`.
+//   - `range_over_time(m)` is converted to `max_over_time(m) - min_over_time(m)`.
+//   - `median_over_time(m)` and `median(q)` are converted to `quantile_over_time(0.5, m)` and `quantile(0.5, q)`.
+//   - `default_rollup(m)` is converted to `last_over_time(m)`.
+//   - `*_prometheus` rollup functions such as `increase_prometheus(m)` are converted to their PromQL names.
+//   - Numbers with suffixes such as `1Ki` are converted to plain numbers.
+//   - Durations with `i` suffix are converted to PromQL durations if opts.Step is set.
+//
+// TranspileError is returned if the resulting expression still contains MetricsQL extensions.
+//
+// e isn't modified, while the returned expression may share unchanged nodes with e.
+// opts may be nil.
+func TranspileToPromQL(e Expr, opts *TranspileOptions) (Expr, error) {
+	var t transpiler
+	window := "5m"
+	if opts != nil {
+		if opts.Window != "" {
+			window = opts.Window
+		}
+		t.step = opts.Step
+	}
+	if !isPositiveDuration(window) || strings.ContainsAny(window, "iI") {
+		return nil, fmt.Errorf("invalid window %q; it must be a positive PromQL duration such as 5m", window)
+	}
+	t.window = window
+	e = t.transpile(e)
+	errs := getPromQLErrors(e)
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool {
+			return errs[i].Pos.Offset < errs[j].Pos.Offset
+		})
+		return nil, &TranspileError{
+			Errs: errs,
+		}
+	}
+	return e, nil
+}
+
+type transpiler struct {
+	window string
+	step   int64
+}
+
+func (t *transpiler) transpile(e Expr) Expr {
+	// Range vectors cannot be joined with `or`, so they are split at the rollup function level.
+	if re, ok := e.(*RollupExpr); !ok || re.Window == nil {
+		if es := splitOrSelector(e); es != nil {
+			return t.transpileOr(es, ExprPos(e))
+		}
+	}
+	switch t2 := e.(type) {
+	case *NumberExpr:
+		if hasNumberSuffix(t2.s) {
+			return &NumberExpr{
+				N:   t2.N,
+				Pos: t2.Pos,
+			}
+		}
+		return t2
+	case *DurationExpr:
+		return t.transpileDuration(t2)
+	case *RollupExpr:
+		re := *t2
+		if _, ok := re.Expr.(*MetricExpr); !ok || re.Window == nil || re.ForSubquery() {
+			// Series selectors with window are left as is, since `or` cannot be applied to range vectors.
+			re.Expr = t.transpile(re.Expr)
+		}
+		re.Window = t.transpileDuration(re.Window)
+		re.Offset = t.transpileDuration(re.Offset)
+		re.Step = t.transpileDuration(re.Step)
+		if re.At != nil {
+			re.At = t.transpile(re.At)
+		}
+		return &re
+	case *FuncExpr:
+		return t.transpileFuncExpr(t2)
+	case *AggrFuncExpr:
+		ae := *t2
+		ae.Args = t.transpileArgs(ae.Args)
+		if strings.ToLower(ae.Name) == "median" && len(ae.Args) == 1 {
+			ae.Name = "quantile"
+			ae.Args = []Expr{newNumberExpr(0.5), ae.Args[0]}
+		}
+		return &ae
+	case *BinaryOpExpr:
+		be := *t2
+		be.Left = t.transpile(be.Left)
+		be.Right = t.transpile(be.Right)
+		if strings.ToLower(be.Op) == "default" && be.JoinModifier.Op == "" {
+			if k, ok := ExprType(be.Right); ok && k == ArgKindScalar {
+				be.Op = "or"
+				be.Right = &FuncExpr{
+					Name: "vector",
+					Args: []Expr{be.Right},
+					Pos:  ExprPos(be.Right),
+				}
+			}
+		}
+		return &be
+	default:
+		return e
+	}
+}
+
+func (t *transpiler) transpileArgs(args []Expr) []Expr {
+	argsNew := make([]Expr, len(args))
+	for i, arg := range args {
+		argsNew[i] = t.transpile(arg)
+	}
+	return argsNew
+}
+
+func (t *transpiler) transpileOr(es []Expr, pos Pos) Expr {
+	e := t.transpile(es[0])
+	for _, e2 := range es[1:] {
+		e = &BinaryOpExpr{
+			Op:    "or",
+			Left:  e,
+			Right: t.transpile(e2),
+			Pos:   pos,
+		}
+	}
+	return e
+}
+
+func (t *transpiler) transpileFuncExpr(fe *FuncExpr) Expr {
+	if fe.Name == "" {
+		if len(fe.Args) < 2 || isStringUnion(fe) {
+			return fe
+		}
+		return t.transpileOr(fe.Args, fe.Pos)
+	}
+	fs := getFuncSignature(fe.Name)
+	if fs == nil {
+		return fe
+	}
+
+	// Split `f(foo{a="b" or c="d"})` into `f(foo{a="b"}) or f(foo{c="d"})`, since rollup functions are applied to every series individually.
+	if fs.Kind == FuncKindRollup {
+		for i, arg := range fe.Args {
+			es := splitOrSelector(arg)
+			if es == nil {
+				continue
+			}
+			for j, e := range es {
+				feCopy := *fe
+				feCopy.Args = append([]Expr{}, fe.Args...)
+				feCopy.Args[i] = e
+				es[j] = &feCopy
+			}
+			return t.transpileOr(es, fe.Pos)
+		}
+	}
+
+	feNew := t.transpileFuncCall(fe, fs)
+	switch feNew.Name {
+	case "range_over_time":
+		if len(feNew.Args) != 1 {
+			return feNew
+		}
+		feMin := t.transpileFuncCall(fe, fs)
+		feMin.Name = "min_over_time"
+		feNew.Name = "max_over_time"
+		return &BinaryOpExpr{
+			Op:    "-",
+			Left:  feNew,
+			Right: feMin,
+			Pos:   fe.Pos,
+		}
+	case "median_over_time":
+		if len(feNew.Args) == 1 {
+			feNew.Name = "quantile_over_time"
+			feNew.Args = []Expr{newNumberExpr(0.5), feNew.Args[0]}
+		}
+	case "default_rollup":
+		feNew.Name = "last_over_time"
+	default:
+		feNew.Name = strings.TrimSuffix(feNew.Name, "_prometheus")
+	}
+	return feNew
+}
+
+// transpileFuncCall returns a copy of fe with transpiled args.
+//
+// Range vector args of rollup functions are converted to range vectors with explicit window.
+func (t *transpiler) transpileFuncCall(fe *FuncExpr, fs *FuncSignature) *FuncExpr {
+	feNew := *fe
+	feNew.Name = strings.ToLower(fe.Name)
+	feNew.Args = t.transpileArgs(fe.Args)
+	if fs.Kind != FuncKindRollup || feNew.Name == "timestamp" {
+		// PromQL timestamp() accepts instant vector.
+		return &feNew
+	}
+	for i, arg := range feNew.Args {
+		if k, ok := fs.ArgKind(i, len(feNew.Args)); ok && k == ArgKindRangeVector {
+			feNew.Args[i] = t.toRangeVector(arg)
+		}
+	}
+	return &feNew
+}
+
+// toRangeVector converts arg to range vector by adding t.window to series selector or by converting arg to subquery.
+func (t *transpiler) toRangeVector(arg Expr) Expr {
+	switch a := arg.(type) {
+	case *MetricExpr:
+		return &RollupExpr{
+			Expr:   a,
+			Window: t.newWindow(a.Pos),
+			Pos:    a.Pos,
+		}
+	case *RollupExpr:
+		if a.Window != nil || a.ForSubquery() {
+			return a
+		}
+		if isSeriesSelector(a) {
+			re := *a
+			re.Window = t.newWindow(a.Pos)
+			return &re
+		}
+	}
+	if k, ok := ExprType(arg); !ok || k != ArgKindInstantVector {
+		return arg
+	}
+	pos := ExprPos(arg)
+	return &RollupExpr{
+		Expr:        arg,
+		Window:      t.newWindow(pos),
+		InheritStep: true,
+		Pos:         pos,
+	}
+}
+
+func (t *transpiler) newWindow(pos Pos) *DurationExpr {
+	return &DurationExpr{
+		s:   t.window,
+		Pos: pos,
+	}
+}
+
+func (t *transpiler) transpileDuration(de *DurationExpr) *DurationExpr {
+	if de == nil || t.step <= 0 || strings.IndexByte(strings.ToLower(de.s), 'i') < 0 {
+		return de
+	}
+	return &DurationExpr{
+		s:   formatPromQLDuration(de.Duration(t.step)),
+		Pos: de.Pos,
+	}
+}
+
+// splitOrSelector splits series selector e with `or` label filters into series selectors with a single group of label filters.
+//
+// nil is returned if e isn't a series selector with `or` label filters.
+func splitOrSelector(e Expr) []Expr {
+	switch t := e.(type) {
+	case *MetricExpr:
+		if len(t.LabelFilterss) < 2 {
+			return nil
+		}
+		es := make([]Expr, len(t.LabelFilterss))
+		for i, lfs := range t.LabelFilterss {
+			es[i] = &MetricExpr{
+				LabelFilterss: [][]LabelFilter{lfs},
+				Pos:           t.Pos,
+			}
+		}
+		return es
+	case *RollupExpr:
+		if t.ForSubquery() {
+			return nil
+		}
+		es := splitOrSelector(t.Expr)
+		for i, e := range es {
+			re := *t
+			re.Expr = e
+			es[i] = &re
+		}
+		return es
+	default:
+		return nil
+	}
+}
+
+func newNumberExpr(n float64) *NumberExpr {
+	return &NumberExpr{
+		N: n,
+	}
+}
+
+// formatPromQLDuration formats d milliseconds as PromQL duration such as `1h30m`.
+func formatPromQLDuration(d int64) string {
+	if d == 0 {
+		return "0s"
+	}
+	var dst []byte
+	if d < 0 {
+		dst = append(dst, '-')
+		d = -d
+	}
+	units := []struct {
+		suffix string
+		ms     int64
+	}{
+		{"y", 365 * 24 * 3600 * 1000},
+		{"w", 7 * 24 * 3600 * 1000},
+		{"d", 24 * 3600 * 1000},
+		{"h", 3600 * 1000},
+		{"m", 60 * 1000},
+		{"s", 1000},
+		{"ms", 1},
+	}
+	for _, u := range units {
+		if d >= u.ms {
+			dst = append(dst, fmt.Sprintf("%d%s", d/u.ms, u.suffix)...)
+			d %= u.ms
+		}
+	}
+	return string(dst)
+}
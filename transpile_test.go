@@ -0,0 +1,125 @@
+package metricsql
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTranspileToPromQLSuccess(t *testing.T) {
+	f := func(s string, opts *TranspileOptions, resultExpected string) {
+		t.Helper()
+
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %s: %s", s, err)
+		}
+		eOrig := string(e.AppendString(nil))
+		ePromQL, err := TranspileToPromQL(e, opts)
+		if err != nil {
+			t.Fatalf("unexpected error when transpiling %s: %s", s, err)
+		}
+		result := string(ePromQL.AppendString(nil))
+		if result != resultExpected {
+			t.Fatalf("unexpected result;\ngot\n%s\nwant\n%s", result, resultExpected)
+		}
+		if s := string(e.AppendString(nil)); s != eOrig {
+			t.Fatalf("the original expression has been modified;\ngot\n%s\nwant\n%s", s, eOrig)
+		}
+
+		// The result must be valid PromQL.
+		if _, err := ParseWithOptions(result, &ParseOptions{PromQL: true}); err != nil {
+			t.Fatalf("cannot parse the result %s in PromQL mode: %s", result, err)
+		}
+	}
+
+	// PromQL queries are left as is
+	f(`foo`, nil, `foo`)
+	f(`sum(rate(foo{bar="baz"}[5m] offset 1h)) by (job)`, nil, `sum(rate(foo{bar="baz"}[5m] offset 1h)) by(job)`)
+	f(`histogram_quantile(0.9, rate(foo_bucket[1m]))`, nil, `histogram_quantile(0.9, rate(foo_bucket[1m]))`)
+	f(`timestamp(foo)`, nil, `timestamp(foo)`)
+
+	// implicit windows
+	f(`rate(foo)`, nil, `rate(foo[5m])`)
+	f(`rate(foo offset 1h)`, nil, `rate(foo[5m] offset 1h)`)
+	f(`sum(increase(foo{a="b"})) by (a)`, &TranspileOptions{Window: "1h"}, `sum(increase(foo{a="b"}[1h])) by(a)`)
+	f(`quantile_over_time(0.5, foo)`, nil, `quantile_over_time(0.5, foo[5m])`)
+	f(`rate(sum(foo))`, nil, `rate(sum(foo)[5m:])`)
+	f(`max_over_time(rate(foo[1m])[1h:])`, nil, `max_over_time(rate(foo[1m])[1h:])`)
+	f(`count_over_time({a="b" or c="d"}[5m:])`, nil, `count_over_time(({a="b"} or {c="d"})[5m:])`)
+
+	// or filters
+	f(`foo{a="b" or c="d"}`, nil, `foo{a="b"} or foo{c="d"}`)
+	f(`{a="b" or c="d" or e="f"} + 1`, nil, `(({a="b"} or {c="d"}) or {e="f"}) + 1`)
+	f(`rate(foo{a="b" or c="d"}[5m])`, nil, `rate(foo{a="b"}[5m]) or rate(foo{c="d"}[5m])`)
+	f(`sum(rate(foo{a="b" or c="d"}))`, nil, `sum(rate(foo{a="b"}[5m]) or rate(foo{c="d"}[5m]))`)
+	f(`foo{a="b" or c="d"} offset 1h`, nil, `(foo{a="b"} offset 1h) or (foo{c="d"} offset 1h)`)
+
+	// default
+	f(`foo default 0`, nil, `foo or vector(0)`)
+	f(`sum(foo) default time()`, nil, `sum(foo) or vector(time())`)
+
+	// union
+	f(`(foo, bar)`, nil, `foo or bar`)
+
+	// functions
+	f(`range_over_time(foo[5m])`, nil, `max_over_time(foo[5m]) - min_over_time(foo[5m])`)
+	f(`range_over_time(foo)`, &TranspileOptions{Window: "10m"}, `max_over_time(foo[10m]) - min_over_time(foo[10m])`)
+	f(`median_over_time(foo[5m])`, nil, `quantile_over_time(0.5, foo[5m])`)
+	f(`median(foo) by (a)`, nil, `quantile(0.5, foo) by(a)`)
+	f(`default_rollup(foo[5m])`, nil, `last_over_time(foo[5m])`)
+	f(`increase_prometheus(foo[5m])`, nil, `increase(foo[5m])`)
+	f(`delta_prometheus(foo)`, nil, `delta(foo[5m])`)
+	f(`changes_prometheus(foo[1h])`, nil, `changes(foo[1h])`)
+
+	// numbers and durations
+	f(`foo > 2Ki`, nil, `foo > 2048`)
+	f(`rate(foo[10i])`, &TranspileOptions{Step: 30000}, `rate(foo[5m])`)
+	f(`max_over_time(foo[1h:3i])`, &TranspileOptions{Step: 30000}, `max_over_time(foo[1h:1m30s])`)
+}
+
+func TestTranspileToPromQLError(t *testing.T) {
+	f := func(s string, tokensExpected []string) {
+		t.Helper()
+
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %s: %s", s, err)
+		}
+		_, err = TranspileToPromQL(e, nil)
+		if err == nil {
+			t.Fatalf("expecting non-nil error when transpiling %s", s)
+		}
+		var te *TranspileError
+		if !errors.As(err, &te) {
+			t.Fatalf("expecting TranspileError; got %T: %s", err, err)
+		}
+		if len(te.Errs) != len(tokensExpected) {
+			t.Fatalf("unexpected number of errors; got %d; want %d; error: %s", len(te.Errs), len(tokensExpected), err)
+		}
+		for i, pe := range te.Errs {
+			if pe.Token != tokensExpected[i] {
+				t.Fatalf("unexpected token for error #%d; got %q; want %q; error: %s", i, pe.Token, tokensExpected[i], err)
+			}
+		}
+	}
+
+	f(`label_set(foo, "a", "b")`, []string{"label_set"})
+	f(`abs(foo) keep_metric_names + sum(bar) limit 5`, []string{"keep_metric_names", "limit"})
+	f(`foo if bar`, []string{"if"})
+	f(`foo default bar`, []string{"default"})
+	f(`foo * on (a) group_left (b) prefix "x_" bar`, []string{"prefix"})
+	f(`rate(foo[10i])`, []string{"10i"})
+	f(`{a="b" or c="d"}[5m]`, []string{"or"})
+}
+
+func TestTranspileToPromQLInvalidWindow(t *testing.T) {
+	e, err := Parse(`rate(foo)`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, window := range []string{"foo", "-5m", "5i"} {
+		if _, err := TranspileToPromQL(e, &TranspileOptions{Window: window}); err == nil {
+			t.Fatalf("expecting non-nil error for window %q", window)
+		}
+	}
+}
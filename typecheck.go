@@ -0,0 +1,313 @@
+package metricsql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TypeErrorKind is a machine-readable kind of TypeError.
+type TypeErrorKind int
+
+const (
+	// TypeErrorBadArg means that the function arg has unexpected kind.
+	TypeErrorBadArg TypeErrorKind = iota
+
+	// TypeErrorBadArity means that the function is called with invalid number of args.
+	TypeErrorBadArity
+
+	// TypeErrorBadOperand means that the operand of binary operation or rollup has unexpected kind.
+	TypeErrorBadOperand
+
+	// TypeErrorBadModifier means that the modifier cannot be applied to the binary operation.
+	TypeErrorBadModifier
+
+	// TypeErrorUnknownFunc means that the function is unknown.
+	TypeErrorUnknownFunc
+)
+
+var typeErrorKindNames = [...]string{
+	TypeErrorBadArg:      "bad_arg",
+	TypeErrorBadArity:    "bad_arity",
+	TypeErrorBadOperand:  "bad_operand",
+	TypeErrorBadModifier: "bad_modifier",
+	TypeErrorUnknownFunc: "unknown_func",
+}
+
+// String returns string representation for k.
+func (k TypeErrorKind) String() string {
+	if k < 0 || int(k) >= len(typeErrorKindNames) {
+		return fmt.Sprintf("TypeErrorKind(%d)", int(k))
+	}
+	return typeErrorKindNames[k]
+}
+
+// TypeError is an error returned from TypeCheck.
+type TypeError struct {
+	// Kind is the machine-readable kind of the error.
+	Kind TypeErrorKind
+
+	// Expr is the offending expression.
+	//
+	// For TypeErrorBadArg it points to the offending function arg.
+	Expr Expr
+
+	// Pos is the position of Expr in the query.
+	//
+	// Pos may be zero if Expr has no position in the query, e.g. if it has been created by WITH template.
+	Pos Pos
+
+	// Func is the function name for the offending function call.
+	//
+	// It is empty if the error isn't related to function call.
+	Func string
+
+	// ArgIdx is the index of the offending function arg starting from 0.
+	//
+	// It is -1 if the error isn't related to a particular function arg.
+	ArgIdx int
+
+	// Expected is the expected kind for Expr. It is valid only for TypeErrorBadArg and TypeErrorBadOperand.
+	Expected ArgKind
+
+	// Got is the inferred kind for Expr. It is valid only for TypeErrorBadArg and TypeErrorBadOperand.
+	Got ArgKind
+
+	// Msg is human-readable error description.
+	Msg string
+}
+
+// Error implements error interface.
+func (te *TypeError) Error() string {
+	if !te.Pos.IsValid() {
+		return te.Msg
+	}
+	return fmt.Sprintf("%s: %s", te.Pos, te.Msg)
+}
+
+// TypeCheck checks types for all the nodes in e and returns the found errors.
+//
+// It verifies the following:
+//
+//   - Functions are called with valid number of args.
+//   - Function args have expected kinds. For example, `histogram_quantile("a", x)` is rejected,
+//     since the first arg must be scalar.
+//   - Args of rollup functions are range vectors or series selectors. For example, `rate(sum(x))`
+//     is rejected, since sum(x) must be converted to range vector with subquery such as `sum(x)[5m:]`.
+//   - Binary operations are applied to instant vectors and scalars, `bool` modifier is applied only
+//     to comparison operations, while set operations and vector matching modifiers aren't applied to scalars.
+//
+// Scalars may be passed to instant vector args, while instant vectors may be passed to scalar args,
+// since MetricsQL converts them automatically.
+//
+// Nil is returned if e has no type errors.
+func TypeCheck(e Expr) []*TypeError {
+	var tc typeChecker
+	tc.check(e)
+	return tc.errs
+}
+
+// ExprType returns the inferred kind for the result of e.
+//
+// false is returned if the kind cannot be inferred, e.g. because of unknown function or BadExpr.
+// ExprType doesn't check e for type errors - use TypeCheck for this.
+func ExprType(e Expr) (ArgKind, bool) {
+	var tc typeChecker
+	return tc.check(e)
+}
+
+type typeChecker struct {
+	errs []*TypeError
+}
+
+func (tc *typeChecker) addError(kind TypeErrorKind, e Expr, funcName string, argIdx int, format string, args ...interface{}) *TypeError {
+	te := &TypeError{
+		Kind:   kind,
+		Expr:   e,
+		Pos:    ExprPos(e),
+		Func:   funcName,
+		ArgIdx: argIdx,
+		Msg:    fmt.Sprintf(format, args...),
+	}
+	tc.errs = append(tc.errs, te)
+	return te
+}
+
+func (tc *typeChecker) check(e Expr) (ArgKind, bool) {
+	switch t := e.(type) {
+	case *MetricExpr:
+		return ArgKindInstantVector, true
+	case *NumberExpr, *DurationExpr:
+		return ArgKindScalar, true
+	case *StringExpr:
+		return ArgKindString, true
+	case *RollupExpr:
+		return tc.checkRollupExpr(t)
+	case *FuncExpr:
+		if isStringUnion(t) {
+			return ArgKindString, true
+		}
+		return tc.checkFuncCall(t, t.Name, t.Args)
+	case *AggrFuncExpr:
+		return tc.checkFuncCall(t, t.Name, t.Args)
+	case *BinaryOpExpr:
+		return tc.checkBinaryOpExpr(t)
+	default:
+		return 0, false
+	}
+}
+
+func (tc *typeChecker) checkRollupExpr(re *RollupExpr) (ArgKind, bool) {
+	k, ok := tc.check(re.Expr)
+	if ok && (k == ArgKindRangeVector || k == ArgKindString) {
+		te := tc.addError(TypeErrorBadOperand, re.Expr, "", -1, "cannot apply window, offset or @ modifier to %s %s", k, re.Expr.AppendString(nil))
+		te.Expected = ArgKindInstantVector
+		te.Got = k
+		ok = false
+	}
+	if re.At != nil {
+		if kAt, okAt := tc.check(re.At); okAt && (kAt == ArgKindRangeVector || kAt == ArgKindString) {
+			te := tc.addError(TypeErrorBadOperand, re.At, "", -1, "@ modifier must contain scalar; got %s %s", kAt, re.At.AppendString(nil))
+			te.Expected = ArgKindScalar
+			te.Got = kAt
+		}
+	}
+	if re.Window != nil || re.ForSubquery() {
+		return ArgKindRangeVector, true
+	}
+	return k, ok
+}
+
+func (tc *typeChecker) checkFuncCall(e Expr, funcName string, args []Expr) (ArgKind, bool) {
+	kinds := make([]ArgKind, len(args))
+	oks := make([]bool, len(args))
+	for i, arg := range args {
+		kinds[i], oks[i] = tc.check(arg)
+	}
+
+	fs := getFuncSignature(funcName)
+	if fs == nil {
+		tc.addError(TypeErrorUnknownFunc, e, funcName, -1, "unknown function %q", funcName)
+		return 0, false
+	}
+	if len(args) < fs.MinArgs || !fs.IsVariadic() && len(args) > fs.MaxArgs {
+		tc.addError(TypeErrorBadArity, e, funcName, -1, "invalid number of args for %s(); got %d; want %s", funcName, len(args), formatArity(fs))
+		return fs.ReturnKind, true
+	}
+	for i, arg := range args {
+		if !oks[i] {
+			continue
+		}
+		expected, _ := fs.ArgKind(i, len(args))
+		if isCompatibleArg(arg, kinds[i], expected) {
+			continue
+		}
+		msg := fmt.Sprintf("arg #%d of %s() must be %s; got %s %s", i+1, funcName, expected, kinds[i], arg.AppendString(nil))
+		if expected == ArgKindRangeVector && kinds[i] == ArgKindInstantVector {
+			msg += fmt.Sprintf("; use subquery such as %s[5m:]", arg.AppendString(nil))
+		}
+		te := tc.addError(TypeErrorBadArg, arg, funcName, i, "%s", msg)
+		te.Expected = expected
+		te.Got = kinds[i]
+	}
+	return fs.ReturnKind, true
+}
+
+func (tc *typeChecker) checkBinaryOpExpr(be *BinaryOpExpr) (ArgKind, bool) {
+	op := strings.ToLower(be.Op)
+	kLeft, okLeft := tc.check(be.Left)
+	kRight, okRight := tc.check(be.Right)
+	ok := true
+	checkOperand := func(arg Expr, k ArgKind) {
+		if k == ArgKindInstantVector || k == ArgKindScalar {
+			return
+		}
+		if k == ArgKindString && (op == "+" || IsBinaryOpCmp(op)) && kLeft == kRight {
+			// MetricsQL supports string concatenation and string comparison.
+			return
+		}
+		te := tc.addError(TypeErrorBadOperand, arg, "", -1, "binary operation %q cannot be applied to %s %s", be.Op, k, arg.AppendString(nil))
+		te.Expected = ArgKindInstantVector
+		te.Got = k
+		ok = false
+	}
+	if okLeft {
+		checkOperand(be.Left, kLeft)
+	}
+	if okRight {
+		checkOperand(be.Right, kRight)
+	}
+	if be.Bool && !IsBinaryOpCmp(op) {
+		tc.addError(TypeErrorBadModifier, be, "", -1, "bool modifier cannot be applied to %q", be.Op)
+	}
+	hasScalar := okLeft && kLeft == ArgKindScalar || okRight && kRight == ArgKindScalar
+	if hasScalar && isBinaryOpLogicalSet(op) {
+		tc.addError(TypeErrorBadOperand, be, "", -1, "set operation %q cannot be applied to scalars", be.Op)
+	}
+	if hasScalar && (be.GroupModifier.Op != "" || be.JoinModifier.Op != "") {
+		tc.addError(TypeErrorBadModifier, be, "", -1, "vector matching modifiers cannot be applied to scalars in %q operation", be.Op)
+	}
+	if !okLeft || !okRight || !ok {
+		return ArgKindInstantVector, okLeft && okRight
+	}
+	if kLeft == ArgKindString && kRight == ArgKindString {
+		if op == "+" {
+			return ArgKindString, true
+		}
+		return ArgKindScalar, true
+	}
+	if kLeft == ArgKindScalar && kRight == ArgKindScalar {
+		return ArgKindScalar, true
+	}
+	return ArgKindInstantVector, true
+}
+
+// isCompatibleArg returns true if arg with the inferred kind k can be passed to function arg with the expected kind.
+func isCompatibleArg(arg Expr, k, expected ArgKind) bool {
+	switch expected {
+	case ArgKindInstantVector, ArgKindScalar:
+		return k == ArgKindInstantVector || k == ArgKindScalar
+	case ArgKindRangeVector:
+		// MetricsQL automatically selects the lookbehind window for series selectors passed to rollup functions.
+		return k == ArgKindRangeVector || isSeriesSelector(arg)
+	case ArgKindString, ArgKindLabelName:
+		return k == ArgKindString
+	default:
+		return false
+	}
+}
+
+// isSeriesSelector returns true if e is a series selector with optional offset and @ modifiers.
+func isSeriesSelector(e Expr) bool {
+	switch t := e.(type) {
+	case *MetricExpr:
+		return true
+	case *RollupExpr:
+		return t.Window == nil && !t.ForSubquery() && isSeriesSelector(t.Expr)
+	default:
+		return false
+	}
+}
+
+// isStringUnion returns true if fe is a union of strings such as `("foo", "bar")`.
+func isStringUnion(fe *FuncExpr) bool {
+	if fe.Name != "" || len(fe.Args) == 0 {
+		return false
+	}
+	for _, arg := range fe.Args {
+		if _, ok := arg.(*StringExpr); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func formatArity(fs *FuncSignature) string {
+	switch {
+	case fs.IsVariadic():
+		return fmt.Sprintf("at least %d", fs.MinArgs)
+	case fs.MinArgs == fs.MaxArgs:
+		return fmt.Sprintf("%d", fs.MinArgs)
+	default:
+		return fmt.Sprintf("from %d to %d", fs.MinArgs, fs.MaxArgs)
+	}
+}
@@ -0,0 +1,148 @@
+package metricsql
+
+import (
+	"testing"
+)
+
+func TestTypeCheckSuccess(t *testing.T) {
+	f := func(s string, kindExpected ArgKind) {
+		t.Helper()
+
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %q: %s", s, err)
+		}
+		if errs := TypeCheck(e); len(errs) > 0 {
+			t.Fatalf("unexpected type errors for %q: %v", s, errs)
+		}
+		k, ok := ExprType(e)
+		if !ok {
+			t.Fatalf("cannot infer type for %q", s)
+		}
+		if k != kindExpected {
+			t.Fatalf("unexpected type for %q; got %s; want %s", s, k, kindExpected)
+		}
+	}
+
+	// series selectors
+	f(`foo`, ArgKindInstantVector)
+	f(`foo{bar="baz"} offset 5m`, ArgKindInstantVector)
+	f(`foo @ end()`, ArgKindInstantVector)
+	f(`foo[5m]`, ArgKindRangeVector)
+	f(`sum(foo)[1h:5m]`, ArgKindRangeVector)
+	f(`sum(foo)[1h:]`, ArgKindRangeVector)
+
+	// literals
+	f(`1.5`, ArgKindScalar)
+	f(`5m`, ArgKindScalar)
+	f(`"foo"`, ArgKindString)
+	f(`"foo" + "bar"`, ArgKindString)
+
+	// functions
+	f(`rate(foo[5m])`, ArgKindInstantVector)
+	f(`rate(foo)`, ArgKindInstantVector)
+	f(`rate(foo offset 1h)`, ArgKindInstantVector)
+	f(`rate(sum(foo)[5m:])`, ArgKindInstantVector)
+	f(`quantile_over_time(0.5, foo[5m])`, ArgKindInstantVector)
+	f(`quantiles_over_time("phi", 0.1, 0.9, foo[5m])`, ArgKindInstantVector)
+	f(`aggr_over_time(("min_over_time", "max_over_time"), foo[5m])`, ArgKindInstantVector)
+	f(`histogram_quantile(0.9, sum(rate(foo[5m])) by (le))`, ArgKindInstantVector)
+	f(`histogram_quantile(scalar(bar), foo)`, ArgKindInstantVector)
+	f(`label_replace(foo, "dst", "$1", "src", "(.+)")`, ArgKindInstantVector)
+	f(`label_set(foo, "a", "b", "c", "d")`, ArgKindInstantVector)
+	f(`abs(time())`, ArgKindInstantVector)
+	f(`union(foo, bar, 1)`, ArgKindInstantVector)
+	f(`time()`, ArgKindScalar)
+	f(`scalar(foo)`, ArgKindScalar)
+	f(`hour()`, ArgKindInstantVector)
+	f(`ru(foo, bar)`, ArgKindInstantVector)
+
+	// aggregate functions
+	f(`sum(rate(foo[5m])) by (job)`, ArgKindInstantVector)
+	f(`topk(3, foo, "other")`, ArgKindInstantVector)
+	f(`count_values("value", foo)`, ArgKindInstantVector)
+
+	// binary operations
+	f(`foo + bar`, ArgKindInstantVector)
+	f(`foo * 2`, ArgKindInstantVector)
+	f(`time() - 3600`, ArgKindScalar)
+	f(`foo > bool 10`, ArgKindInstantVector)
+	f(`foo and on(job) bar`, ArgKindInstantVector)
+	f(`foo / on(job) group_left(x) bar`, ArgKindInstantVector)
+	f(`foo default 0`, ArgKindInstantVector)
+}
+
+func TestTypeCheckError(t *testing.T) {
+	f := func(s string, kindExpected TypeErrorKind, funcExpected string, argIdxExpected int, posExpected string) {
+		t.Helper()
+
+		e, err := Parse(s)
+		if err != nil {
+			t.Fatalf("unexpected error when parsing %q: %s", s, err)
+		}
+		errs := TypeCheck(e)
+		if len(errs) != 1 {
+			t.Fatalf("expecting a single type error for %q; got %d errors: %v", s, len(errs), errs)
+		}
+		te := errs[0]
+		if te.Kind != kindExpected {
+			t.Fatalf("unexpected error kind for %q; got %s; want %s", s, te.Kind, kindExpected)
+		}
+		if te.Func != funcExpected {
+			t.Fatalf("unexpected func for %q; got %q; want %q", s, te.Func, funcExpected)
+		}
+		if te.ArgIdx != argIdxExpected {
+			t.Fatalf("unexpected arg index for %q; got %d; want %d", s, te.ArgIdx, argIdxExpected)
+		}
+		if pos := te.Pos.String(); pos != posExpected {
+			t.Fatalf("unexpected error position for %q; got %s; want %s", s, pos, posExpected)
+		}
+	}
+
+	// mismatched args
+	f(`rate(sum(x))`, TypeErrorBadArg, "rate", 0, "1:6")
+	f(`histogram_quantile("a", x)`, TypeErrorBadArg, "histogram_quantile", 0, "1:20")
+	f(`label_replace(x, "dst", "$1", 1, "(.+)")`, TypeErrorBadArg, "label_replace", 3, "1:31")
+	f(`abs(x[5m])`, TypeErrorBadArg, "abs", 0, "1:5")
+	f(`sum(x) + topk("3", x)`, TypeErrorBadArg, "topk", 0, "1:15")
+	f(`quantiles_over_time("phi", 0.1, "0.9", x[5m])`, TypeErrorBadArg, "quantiles_over_time", 2, "1:33")
+	f(`with (f(a) = rate(a)) f(sum(x))`, TypeErrorBadArg, "rate", 0, "1:25")
+
+	// wrong arity
+	f(`label_replace(x, 1, 2)`, TypeErrorBadArity, "label_replace", -1, "1:1")
+	f(`abs()`, TypeErrorBadArity, "abs", -1, "1:1")
+	f(`1 + clamp(x, 1)`, TypeErrorBadArity, "clamp", -1, "1:5")
+	f(`topk(1, x, "a", "b")`, TypeErrorBadArity, "topk", -1, "1:1")
+
+	// binary operations
+	f(`x[5m] + 1`, TypeErrorBadOperand, "", -1, "1:1")
+	f(`x or 1`, TypeErrorBadOperand, "", -1, "1:1")
+	f(`time() unless x`, TypeErrorBadOperand, "", -1, "1:1")
+	f(`x + on(a) 1`, TypeErrorBadModifier, "", -1, "1:1")
+
+	// rollups
+	f(`(x[5m])[10m:]`, TypeErrorBadOperand, "", -1, "1:2")
+}
+
+func TestTypeCheckManualExpr(t *testing.T) {
+	// bool modifier and unknown functions cannot be obtained via Parse, so construct them manually.
+	be := &BinaryOpExpr{
+		Op:    "+",
+		Bool:  true,
+		Left:  &NumberExpr{N: 1},
+		Right: &FuncExpr{Name: "foo"},
+	}
+	errs := TypeCheck(be)
+	if len(errs) != 2 {
+		t.Fatalf("expecting 2 type errors; got %d: %v", len(errs), errs)
+	}
+	if errs[0].Kind != TypeErrorUnknownFunc {
+		t.Fatalf("unexpected first error kind; got %s; want %s", errs[0].Kind, TypeErrorUnknownFunc)
+	}
+	if errs[1].Kind != TypeErrorBadModifier {
+		t.Fatalf("unexpected second error kind; got %s; want %s", errs[1].Kind, TypeErrorBadModifier)
+	}
+	if _, ok := ExprType(be); ok {
+		t.Fatalf("expecting unknown type for expression with unknown function")
+	}
+}
@@ -1,7 +1,6 @@
 package metricsql
 
 import (
-	"fmt"
 	"strings"
 )
 
@@ -58,21 +57,26 @@ func IsSupportedFunction(funcName string) bool {
 }
 
 func checkSupportedFunctions(e Expr) error {
-	var err error
+	errs := getUnsupportedFunctionErrors(e)
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+func getUnsupportedFunctionErrors(e Expr) []*ParseError {
+	var errs []*ParseError
 	VisitAll(e, func(expr Expr) {
-		if err != nil {
-			return
-		}
 		switch t := expr.(type) {
 		case *FuncExpr:
 			if !IsRollupFunc(t.Name) && !IsTransformFunc(t.Name) {
-				err = fmt.Errorf("unsupported function %q", t.Name)
+				errs = append(errs, newParseError(ParseErrorUnknownFunc, t.Name, t.Pos, "unsupported function %q", t.Name))
 			}
 		case *AggrFuncExpr:
 			if !IsAggrFunc(t.Name) {
-				err = fmt.Errorf("unsupported aggregate function %q", t.Name)
+				errs = append(errs, newParseError(ParseErrorUnknownFunc, t.Name, t.Pos, "unsupported aggregate function %q", t.Name))
 			}
 		}
 	})
-	return err
+	return errs
 }